@@ -25,10 +25,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/skytells-research/DNA/network/node/cmd"
 	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/datasize"
 	"github.com/skytells-research/DNA/network/node/metadata"
 	"github.com/skytells-research/DNA/network/node/services/noop"
 	"github.com/skytells-research/DNA/network/node/services/openvpn"
@@ -48,6 +50,9 @@ const serviceHelp = `service <action> [args]
 	status	<ServiceID>
 	list
 	sessions
+	enable	<ServiceID>
+	disable	<ServiceID>
+	drain	<ServiceID> [deadline]
 
 	example: service start 0x7d5ee3557775aed0b85d691b036769c17349db23 openvpn --openvpn.port=1194 --openvpn.proto=UDP`
 
@@ -61,6 +66,7 @@ func NewCommand() *cli.Command {
 			cmdCLI := &cliApp{
 				historyFile: filepath.Join(nodeOptions.Directories.Data, ".cli_history"),
 				tequilapi:   tequilapi_client.NewClient(nodeOptions.TequilapiAddress, nodeOptions.TequilapiPort),
+				services:    newServiceClient(nodeOptions.TequilapiAddress, nodeOptions.TequilapiPort),
 			}
 			cmd.RegisterSignalCallback(utils.SoftKiller(cmdCLI.Kill))
 
@@ -73,6 +79,7 @@ func NewCommand() *cli.Command {
 type cliApp struct {
 	historyFile      string
 	tequilapi        *tequilapi_client.Client
+	services         *serviceClient
 	fetchedProposals []tequilapi_client.ProposalDTO
 	completer        *readline.PrefixCompleter
 	reader           *readline.Instance
@@ -209,6 +216,18 @@ func (c *cliApp) service(argsString string) {
 		c.serviceList()
 	case "sessions":
 		c.serviceSessions()
+	case "enable", "disable":
+		if len(args) < 2 {
+			fmt.Println(serviceHelp)
+			return
+		}
+		c.serviceSetEnabled(args[1], action == "enable")
+	case "drain":
+		if len(args) < 2 {
+			fmt.Println(serviceHelp)
+			return
+		}
+		c.serviceDrain(args[1], args[2:]...)
 	default:
 		info(fmt.Sprintf("Unknown action provided: %s", action))
 		fmt.Println(serviceHelp)
@@ -269,6 +288,71 @@ func (c *cliApp) serviceSessions() {
 	for _, session := range sessions.Sessions {
 		status("ID: "+session.ID, "ConsumerID: "+session.ConsumerID)
 	}
+
+	history, err := c.services.SessionHistory()
+	if err != nil {
+		info("Failed to get session history: ", err)
+		return
+	}
+
+	status("Session history", len(history.Sessions))
+	for _, session := range history.Sessions {
+		ended := session.Ended
+		if ended == "" {
+			ended = "active"
+		}
+		status("ID: "+session.ID,
+			"Service: "+session.ServiceID,
+			"Type: "+session.ServiceType,
+			"ConsumerID: "+session.ConsumerID,
+			"Endpoint: "+session.PeerEndpoint,
+			"In: "+(datasize.BitSize(session.BytesIn)*datasize.B).String(),
+			"Out: "+(datasize.BitSize(session.BytesOut)*datasize.B).String(),
+			"Duration: "+(time.Duration(session.Duration)*time.Second).String(),
+			"Upload limit: "+limitString(datasize.BitSize(session.UploadLimit), "/s"),
+			"Download limit: "+limitString(datasize.BitSize(session.DownloadLimit), "/s"),
+			"Quota: "+limitString(datasize.BitSize(session.Quota)*datasize.B, ""),
+			"Quota exceeded: "+strconv.FormatBool(session.QuotaExceeded),
+			"Ended: "+ended)
+	}
+}
+
+// limitString formats the limit of a session, zero means no limit
+func limitString(limit datasize.BitSize, unit string) string {
+	if limit == 0 {
+		return "none"
+	}
+	return limit.String() + unit
+}
+
+func (c *cliApp) serviceSetEnabled(id string, enabled bool) {
+	if err := c.services.SetEnabled(id, enabled); err != nil {
+		info("Failed to update service: ", err)
+		return
+	}
+
+	if enabled {
+		success("Service enabled, it is started again after node restart: ", id)
+	} else {
+		success("Service disabled, it is not started after node restart: ", id)
+	}
+}
+
+func (c *cliApp) serviceDrain(id string, args ...string) {
+	var deadline time.Duration
+	if len(args) > 0 {
+		var err error
+		if deadline, err = time.ParseDuration(args[0]); err != nil {
+			info("Failed to parse drain deadline: ", err)
+			return
+		}
+	}
+
+	if err := c.services.Drain(id, deadline); err != nil {
+		info("Failed to drain service: ", err)
+		return
+	}
+	success("Service is draining, it stops once its sessions end: ", id)
 }
 
 func (c *cliApp) serviceGet(id string) {
@@ -659,6 +743,9 @@ func newAutocompleter(tequilapi *tequilapi_client.Client, proposals []tequilapi_
 			readline.PcItem("list"),
 			readline.PcItem("status"),
 			readline.PcItem("sessions"),
+			readline.PcItem("enable"),
+			readline.PcItem("disable"),
+			readline.PcItem("drain"),
 		),
 		readline.PcItem(
 			"identities",
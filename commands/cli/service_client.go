@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/requests"
+	"github.com/skytells-research/DNA/network/node/tequilapi/endpoints"
+)
+
+// serviceClient calls Tequilapi endpoints managing persisted and running services
+type serviceClient struct {
+	apiURI     string
+	httpClient *http.Client
+}
+
+func newServiceClient(address string, port int) *serviceClient {
+	return &serviceClient{
+		apiURI:     fmt.Sprintf("http://%s:%d", address, port),
+		httpClient: &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+// SetEnabled marks persisted service as enabled or disabled
+func (sc *serviceClient) SetEnabled(id string, enabled bool) error {
+	req, err := requests.NewPutRequest(sc.apiURI, "services/"+id+"/enabled", endpoints.EnabledDTO{Enabled: enabled})
+	if err != nil {
+		return err
+	}
+	return sc.do(req, nil)
+}
+
+// Drain stops service once its sessions end or the deadline passes
+func (sc *serviceClient) Drain(id string, deadline time.Duration) error {
+	req, err := requests.NewPutRequest(sc.apiURI, "services/"+id+"/drain", endpoints.DrainDTO{Deadline: deadline.String()})
+	if err != nil {
+		return err
+	}
+	return sc.do(req, nil)
+}
+
+// SessionHistory returns sessions served by the provider, latest first
+func (sc *serviceClient) SessionHistory() (endpoints.SessionHistoryDTO, error) {
+	var history endpoints.SessionHistoryDTO
+	req, err := requests.NewGetRequest(sc.apiURI, "service-sessions/history", nil)
+	if err != nil {
+		return history, err
+	}
+	err = sc.do(req, &history)
+	return history, err
+}
+
+func (sc *serviceClient) do(req *http.Request, result interface{}) error {
+	resp, err := sc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var message struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&message); err != nil || message.Message == "" {
+			return fmt.Errorf("server response invalid: %s", resp.Status)
+		}
+		return errors.New(message.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -8,9 +8,12 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"gopkg.in/gcfg.v1"
 )
@@ -27,11 +30,21 @@ type VPNState struct {
 		RecvThreads int
 		SendThreads int
 
+		// policy routing: table and metric for routes,
+		// rules by fwmark and/or source which select the table
+		RouteTable   int
+		RouteMetric  int
+		FwMark       int
+		RuleFrom     []string
+		RulePriority int
+
 		// filled by readConfig
-		bcastIP [4]byte
-		main    PacketEncrypter
-		alt     PacketEncrypter
-		local   string
+		bcastIP  [4]byte
+		main     PacketEncrypter
+		alt      PacketEncrypter
+		local    string
+		locIP    string
+		ruleFrom []*net.IPNet
 	}
 	Remote map[string]*struct {
 		ExtIP string
@@ -47,9 +60,34 @@ var (
 	configfile = flag.String("config", "/etc/sdna.conf", "Config file")
 	local      = flag.String("local", "",
 		"ID from \"remotes\" which idtenify this host [default: autodetect]")
+	watch      = flag.Bool("watch", true, "Reload config automatically when config file is changed")
+	watchDelay = flag.Duration("watchdelay", time.Second,
+		"Time to wait for config file changes to settle before reload")
 	config atomic.Value
+
+	// reloadLock serializes reloads triggered by HUP and by file watcher
+	reloadLock sync.Mutex
+	// lastReload keeps result of the latest reload attempt
+	lastReload atomic.Value
 )
 
+// reloadStatus describes result of the latest config reload
+type reloadStatus struct {
+	Time time.Time
+	Err  error
+	Diff configDiff
+}
+
+// configDiff is a summary of changes between two configs
+type configDiff struct {
+	RemotesAdded   []string
+	RemotesRemoved []string
+	RoutesAdded    []string
+	RoutesRemoved  []string
+	// RoutesChanged contains routes which now go via another remote
+	RoutesChanged []string
+}
+
 func getLocalIPsMap() map[string]bool {
 	result := map[string]bool{}
 
@@ -67,39 +105,40 @@ func getLocalIPsMap() map[string]bool {
 	return result
 }
 
-func readConfig() error {
+// parseConfig reads and validates config file without touching current state
+func parseConfig() (VPNState, error) {
 	var newConfig VPNState
 
 	err := gcfg.ReadFileInto(&newConfig, *configfile)
 	if nil != err {
-		return fmt.Errorf("Error reading config \"%s\" %s", *configfile, err)
+		return newConfig, fmt.Errorf("Error reading config \"%s\" %s", *configfile, err)
 	}
 	if newConfig.Main.Port < 1 || newConfig.Main.Port > 65535 {
-		return errors.New("main.port is invalid in config")
+		return newConfig, errors.New("main.port is invalid in config")
 	}
 	if newConfig.Main.NetCIDR < 8 || newConfig.Main.NetCIDR > 30 {
-		return errors.New("netCIDR can't be less than 8 or greater than 30")
+		return newConfig, errors.New("netCIDR can't be less than 8 or greater than 30")
 	}
 
 	if "" == newConfig.Main.Encryption {
-		return errors.New("main.encryption is empty")
+		return newConfig, errors.New("main.encryption is empty")
 	}
 	newEFunc, ok := registredEncrypters[strings.ToLower(newConfig.Main.Encryption)]
 	if !ok {
-		return fmt.Errorf(
+		return newConfig, fmt.Errorf(
 			"main.encryption type \"%s\" is unknown",
 			newConfig.Main.Encryption)
 	}
 
 	newConfig.Main.main, err = newEFunc(newConfig.Main.MainKey)
 	if nil != err {
-		return fmt.Errorf("main.mainkey error: %s", err.Error())
+		return newConfig, fmt.Errorf("main.mainkey error: %s", err.Error())
 	}
 
 	if "" != newConfig.Main.AltKey {
 		newConfig.Main.alt, err = newEFunc(newConfig.Main.AltKey)
 		if nil != err {
-			return fmt.Errorf("main.altkey error: %s", err.Error())
+			return newConfig, fmt.Errorf("main.altkey error: %s", err.Error())
 		}
 	}
 
@@ -107,7 +146,7 @@ func readConfig() error {
 	if "" != *local {
 		host, ok := newConfig.Remote[*local]
 		if !ok {
-			return fmt.Errorf(
+			return newConfig, fmt.Errorf(
 				"Remote with id \"%s\" not found in %s",
 				*local, *configfile)
 		}
@@ -128,10 +167,16 @@ func readConfig() error {
 			}
 		}
 		if "" == newConfig.Main.local {
-			return errors.New("Local ip can't be detected")
+			return newConfig, errors.New("Local ip can't be detected")
 		}
 	}
 
+	lIP, _, err := net.ParseCIDR(newConfig.Main.local)
+	if nil != err {
+		return newConfig, fmt.Errorf("Invalid local ip %s", newConfig.Main.local)
+	}
+	newConfig.Main.locIP = lIP.String()
+
 	newConfig.remotes = make(map[[4]byte]*net.UDPAddr, len(newConfig.Remote))
 	newConfig.routes = map[*net.IPNet]*net.UDPAddr{}
 
@@ -140,12 +185,12 @@ func readConfig() error {
 		rmtAddr, err := net.ResolveUDPAddr("udp",
 			fmt.Sprintf("%s:%d", r.ExtIP, newConfig.Main.Port))
 		if nil != err {
-			return err
+			return newConfig, err
 		}
 
 		tIP := net.ParseIP(r.LocIP)
 		if nil == tIP {
-			log.Fatalln("Invalid local ip", r.LocIP, "for server", name)
+			return newConfig, fmt.Errorf("Invalid local ip %s for server %s", r.LocIP, name)
 		}
 
 		newConfig.remotes[[4]byte{tIP[12], tIP[13], tIP[14], tIP[15]}] = rmtAddr
@@ -153,7 +198,7 @@ func readConfig() error {
 		for _, routestr := range r.Route {
 			_, route, err := net.ParseCIDR(routestr)
 			if nil != err {
-				return fmt.Errorf("Invalid route %s for %s", routestr, name)
+				return newConfig, fmt.Errorf("Invalid route %s for %s", routestr, name)
 			}
 			newConfig.routes[route] = rmtAddr
 		}
@@ -172,11 +217,167 @@ func readConfig() error {
 		newConfig.Main.SendThreads = 1
 	}
 
+	if newConfig.Main.RouteTable < 0 || newConfig.Main.RouteTable == syscall.RT_TABLE_LOCAL {
+		return newConfig, errors.New("main.routetable is invalid in config")
+	}
+	if newConfig.Main.RouteMetric < 0 {
+		return newConfig, errors.New("main.routemetric can't be negative")
+	}
+	if newConfig.Main.FwMark < 0 || newConfig.Main.RulePriority < 0 {
+		return newConfig, errors.New("main.fwmark and main.rulepriority can't be negative")
+	}
+	for _, fromstr := range newConfig.Main.RuleFrom {
+		_, from, err := net.ParseCIDR(fromstr)
+		if nil != err {
+			return newConfig, fmt.Errorf("Invalid main.rulefrom %s", fromstr)
+		}
+		newConfig.Main.ruleFrom = append(newConfig.Main.ruleFrom, from)
+	}
+	if (0 != newConfig.Main.FwMark || 0 != len(newConfig.Main.ruleFrom)) &&
+		(0 == newConfig.Main.RouteTable || syscall.RT_TABLE_MAIN == newConfig.Main.RouteTable) {
+		return newConfig, errors.New("main.fwmark and main.rulefrom need separate main.routetable")
+	}
+
+	return newConfig, nil
+}
+
+// readConfig loads config and replaces current one only if it's valid
+func readConfig() error {
+	newConfig, err := parseConfig()
+	if nil != err {
+		return err
+	}
+
 	config.Store(newConfig)
 
 	return nil
 }
 
+// Empty reports whether configs are the same in remotes and routes
+func (d configDiff) Empty() bool {
+	return 0 == len(d.RemotesAdded)+len(d.RemotesRemoved)+
+		len(d.RoutesAdded)+len(d.RoutesRemoved)+len(d.RoutesChanged)
+}
+
+func (d configDiff) String() string {
+	if d.Empty() {
+		return "no changes in remotes and routes"
+	}
+
+	parts := []string{}
+	add := func(name string, list []string) {
+		if 0 != len(list) {
+			parts = append(parts, fmt.Sprintf("%s: %s", name, strings.Join(list, ", ")))
+		}
+	}
+	add("remotes added", d.RemotesAdded)
+	add("remotes removed", d.RemotesRemoved)
+	add("routes added", d.RoutesAdded)
+	add("routes removed", d.RoutesRemoved)
+	add("routes changed", d.RoutesChanged)
+
+	return strings.Join(parts, "; ")
+}
+
+// routesMap returns routes as "cidr" => "remote address" strings
+func routesMap(c VPNState) map[string]string {
+	result := make(map[string]string, len(c.routes))
+	for r, addr := range c.routes {
+		result[r.String()] = addr.String()
+	}
+	return result
+}
+
+func diffConfig(oldConfig, newConfig VPNState) configDiff {
+	var d configDiff
+
+	for name := range newConfig.Remote {
+		if _, ok := oldConfig.Remote[name]; !ok {
+			d.RemotesAdded = append(d.RemotesAdded, name)
+		}
+	}
+	for name := range oldConfig.Remote {
+		if _, ok := newConfig.Remote[name]; !ok {
+			d.RemotesRemoved = append(d.RemotesRemoved, name)
+		}
+	}
+
+	oldRoutes := routesMap(oldConfig)
+	newRoutes := routesMap(newConfig)
+	for r, addr := range newRoutes {
+		oldAddr, ok := oldRoutes[r]
+		if !ok {
+			d.RoutesAdded = append(d.RoutesAdded, r)
+		} else if oldAddr != addr {
+			d.RoutesChanged = append(d.RoutesChanged,
+				fmt.Sprintf("%s (%s -> %s)", r, oldAddr, addr))
+		}
+	}
+	for r := range oldRoutes {
+		if _, ok := newRoutes[r]; !ok {
+			d.RoutesRemoved = append(d.RoutesRemoved, r)
+		}
+	}
+
+	// maps have random order, keep output stable
+	for _, list := range [][]string{d.RemotesAdded, d.RemotesRemoved,
+		d.RoutesAdded, d.RoutesRemoved, d.RoutesChanged} {
+		sort.Strings(list)
+	}
+
+	return d
+}
+
+// lastReloadStatus returns result of the latest reload attempt,
+// failed reload keeps error here while old config is still in use
+func lastReloadStatus() (reloadStatus, bool) {
+	status, ok := lastReload.Load().(reloadStatus)
+	return status, ok
+}
+
+func (s reloadStatus) String() string {
+	when := s.Time.Format(time.RFC3339)
+	if nil != s.Err {
+		return fmt.Sprintf("failed at %s, previous config is in use: %s", when, s.Err)
+	}
+	return fmt.Sprintf("succeeded at %s: %s", when, s.Diff)
+}
+
+// logReloadStatus reports result of the latest reload attempt
+func logReloadStatus() {
+	status, ok := lastReloadStatus()
+	if !ok {
+		log.Println("Config was not reloaded since start")
+		return
+	}
+	log.Println("Last config reload", status)
+}
+
+// reloadConfig replaces current config only if new one is valid
+func reloadConfig(routeReload chan bool, reason string) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	newConfig, err := parseConfig()
+	if nil != err {
+		lastReload.Store(reloadStatus{Time: time.Now(), Err: err})
+		log.Printf("Config reload (%s) failed, keeping previous config: %s\n", reason, err)
+		return
+	}
+
+	diff := diffConfig(config.Load().(VPNState), newConfig)
+	config.Store(newConfig)
+	lastReload.Store(reloadStatus{Time: time.Now(), Diff: diff})
+
+	log.Printf("Config reloaded (%s): %s\n", reason, diff)
+
+	// routes thread re-reads whole config, so one pending signal is enough
+	select {
+	case routeReload <- true:
+	default:
+	}
+}
+
 func initConfig(routeReload chan bool) {
 	err := readConfig()
 	if nil != err {
@@ -189,13 +390,25 @@ func initConfig(routeReload chan bool) {
 	signal.Notify(c, syscall.SIGHUP)
 	go func() {
 		for range c {
-			err := readConfig()
-			if nil != err {
-				log.Println("Config reload failed:", err)
-			} else {
-				log.Println("Config reloaded")
-				routeReload <- true
-			}
+			reloadConfig(routeReload, "HUP signal")
+		}
+	}()
+
+	// report result of the latest reload on USR1 signal
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, syscall.SIGUSR1)
+	go func() {
+		for range s {
+			logReloadStatus()
 		}
 	}()
+
+	if *watch {
+		err := watchConfig(*configfile, *watchDelay, func() {
+			reloadConfig(routeReload, "config file changed")
+		})
+		if nil != err {
+			log.Println("Config file watching disabled:", err)
+		}
+	}
 }
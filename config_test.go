@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testState(remotes map[string]string, routes map[string]string) VPNState {
+	var c VPNState
+	c.Remote = map[string]*struct {
+		ExtIP string
+		LocIP string
+		Route []string
+	}{}
+	c.routes = map[*net.IPNet]*net.UDPAddr{}
+
+	for name, extIP := range remotes {
+		c.Remote[name] = &struct {
+			ExtIP string
+			LocIP string
+			Route []string
+		}{ExtIP: extIP}
+	}
+	for route, extIP := range routes {
+		_, n, _ := net.ParseCIDR(route)
+		c.routes[n] = &net.UDPAddr{IP: net.ParseIP(extIP), Port: 23456}
+	}
+
+	return c
+}
+
+func TestDiffConfig(t *testing.T) {
+	base := testState(
+		map[string]string{"prague": "10.0.0.1", "berlin": "10.0.0.2"},
+		map[string]string{"192.168.10.0/24": "10.0.0.1", "192.168.11.0/24": "10.0.0.2"},
+	)
+
+	tests := []struct {
+		name      string
+		newConfig VPNState
+		want      configDiff
+		wantStr   string
+	}{
+		{
+			name:      "same",
+			newConfig: base,
+			want:      configDiff{},
+			wantStr:   "no changes in remotes and routes",
+		},
+		{
+			name: "remote added with route",
+			newConfig: testState(
+				map[string]string{"prague": "10.0.0.1", "berlin": "10.0.0.2", "kiev": "10.0.0.3"},
+				map[string]string{"192.168.10.0/24": "10.0.0.1", "192.168.11.0/24": "10.0.0.2",
+					"192.168.12.0/24": "10.0.0.3"},
+			),
+			want: configDiff{
+				RemotesAdded: []string{"kiev"},
+				RoutesAdded:  []string{"192.168.12.0/24"},
+			},
+			wantStr: "remotes added: kiev; routes added: 192.168.12.0/24",
+		},
+		{
+			name: "remote removed",
+			newConfig: testState(
+				map[string]string{"prague": "10.0.0.1"},
+				map[string]string{"192.168.10.0/24": "10.0.0.1"},
+			),
+			want: configDiff{
+				RemotesRemoved: []string{"berlin"},
+				RoutesRemoved:  []string{"192.168.11.0/24"},
+			},
+			wantStr: "remotes removed: berlin; routes removed: 192.168.11.0/24",
+		},
+		{
+			name: "route moved",
+			newConfig: testState(
+				map[string]string{"prague": "10.0.0.1", "berlin": "10.0.0.2"},
+				map[string]string{"192.168.10.0/24": "10.0.0.1", "192.168.11.0/24": "10.0.0.1"},
+			),
+			want: configDiff{
+				RoutesChanged: []string{"192.168.11.0/24 (10.0.0.2:23456 -> 10.0.0.1:23456)"},
+			},
+			wantStr: "routes changed: 192.168.11.0/24 (10.0.0.2:23456 -> 10.0.0.1:23456)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffConfig(base, tt.newConfig)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffConfig() = %+v, want %+v", got, tt.want)
+			}
+			if got.String() != tt.wantStr {
+				t.Errorf("configDiff.String() = %q, want %q", got.String(), tt.wantStr)
+			}
+		})
+	}
+}
+
+func TestReloadConfig_FailureKeepsPreviousConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdna")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sdna.conf")
+	if err := ioutil.WriteFile(path, []byte("[main]\nport = 0\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	oldConfigfile := *configfile
+	*configfile = path
+	defer func() { *configfile = oldConfigfile }()
+
+	base := testState(
+		map[string]string{"prague": "10.0.0.1"},
+		map[string]string{"192.168.10.0/24": "10.0.0.1"},
+	)
+	config.Store(base)
+
+	routeReload := make(chan bool, 1)
+	reloadConfig(routeReload, "test")
+
+	if got := config.Load().(VPNState); !reflect.DeepEqual(got, base) {
+		t.Errorf("config = %+v, want previous %+v", got, base)
+	}
+	select {
+	case <-routeReload:
+		t.Error("routes reloaded after failed config reload")
+	default:
+	}
+
+	status, ok := lastReloadStatus()
+	if !ok || nil == status.Err {
+		t.Fatalf("lastReloadStatus() = %+v, %v, want error", status, ok)
+	}
+	if !strings.Contains(status.String(), "previous config is in use") {
+		t.Errorf("reloadStatus.String() = %q", status.String())
+	}
+}
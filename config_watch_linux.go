@@ -0,0 +1,96 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchConfig calls reload each time config file is changed on disk.
+// Directory is watched instead of file itself, so files replaced by
+// rename (as most editors and config management tools do) are tracked too.
+// Events are debounced: reload is called once changes settle for delay.
+func watchConfig(path string, delay time.Duration, reload func()) error {
+	absPath, err := filepath.Abs(path)
+	if nil != err {
+		return err
+	}
+	dir, name := filepath.Split(absPath)
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if nil != err {
+		return err
+	}
+
+	_, err = syscall.InotifyAddWatch(fd, dir,
+		syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE|syscall.IN_MODIFY)
+	if nil != err {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+
+		var timer *time.Timer
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+		for {
+			n, err := syscall.Read(fd, buf)
+			if nil != err {
+				if err == syscall.EINTR {
+					continue
+				}
+				log.Println("Config watcher stopped:", err)
+				return
+			}
+
+			if !inotifyHasName(buf[:n], name) {
+				continue
+			}
+
+			if nil == timer {
+				timer = time.AfterFunc(delay, reload)
+			} else {
+				timer.Reset(delay)
+			}
+		}
+	}()
+
+	log.Println("Watching config file", absPath, "for changes")
+
+	return nil
+}
+
+// inotifyHasName checks if any of events in buffer is about file with name
+func inotifyHasName(buf []byte, name string) bool {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		nameEnd := nameStart + int(event.Len)
+		if nameEnd > len(buf) {
+			return false
+		}
+
+		// name is padded with zero bytes
+		evName := buf[nameStart:nameEnd]
+		for i, b := range evName {
+			if 0 == b {
+				evName = evName[:i]
+				break
+			}
+		}
+		if string(evName) == name {
+			return true
+		}
+
+		offset = nameEnd
+	}
+
+	return false
+}
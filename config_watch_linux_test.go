@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfig_Debounce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdna")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sdna.conf")
+	reloads := make(chan bool, 10)
+
+	err = watchConfig(path, 100*time.Millisecond, func() { reloads <- true })
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// other files in the same directory are ignored
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.conf"), []byte("x"), 0644); nil != err {
+		t.Fatal(err)
+	}
+
+	// several writes in a row cause single reload
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(path, []byte("[main]"), 0644); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+
+	select {
+	case <-reloads:
+		t.Fatal("unexpected second reload")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// replace via rename as editors do
+	tmp := filepath.Join(dir, ".sdna.conf.swp")
+	if err := ioutil.WriteFile(tmp, []byte("[main]"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); nil != err {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("config was not reloaded after rename")
+	}
+}
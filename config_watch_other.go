@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// watchConfig is supported only on Linux (inotify), use HUP signal instead
+func watchConfig(path string, delay time.Duration, reload func()) error {
+	return errors.New("config file watching is not supported on this platform")
+}
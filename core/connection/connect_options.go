@@ -18,6 +18,12 @@
 package connection
 
 import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/dns"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/session"
@@ -27,6 +33,121 @@ import (
 type ConnectParams struct {
 	// kill switch option restricting communication only through VPN
 	DisableKillSwitch bool
+	// networks in CIDR notation reachable outside of VPN while kill switch is enabled, i.e. LAN
+	AllowedNetworks []string
+	// resolvers used while connected
+	DNS DNSOption
+	// destinations routed through and around the tunnel
+	SplitTunnel SplitTunnelParams
+	// reconnect policy applied when an established connection is lost
+	Reconnect ReconnectParams
+	// monitoring of latency, loss and handshake staleness of an established connection
+	Quality QualityParams
+	// limits of connection establishment steps
+	Timeout TimeoutParams
+}
+
+// TimeoutParams limits how long connection establishment steps may take, expiry fails Connect with ErrConnectionTimeout
+type TimeoutParams struct {
+	// Session limits session creation with provider, 30 seconds by default
+	Session time.Duration
+	// Handshake limits transport start until it reports Connected state, 1 minute by default
+	Handshake time.Duration
+}
+
+const (
+	defaultSessionTimeout   = 30 * time.Second
+	defaultHandshakeTimeout = time.Minute
+)
+
+func (p TimeoutParams) session() time.Duration {
+	if p.Session == 0 {
+		return defaultSessionTimeout
+	}
+	return p.Session
+}
+
+func (p TimeoutParams) handshake() time.Duration {
+	if p.Handshake == 0 {
+		return defaultHandshakeTimeout
+	}
+	return p.Handshake
+}
+
+// SplitTunnelParams lists destinations as CIDRs, IP addresses or domains
+type SplitTunnelParams struct {
+	// Include is routed through the tunnel, all the traffic goes through the tunnel if empty
+	Include []string
+	// Exclude is routed outside of the tunnel
+	Exclude []string
+	// RefreshInterval is how often domains are resolved again, 5 minutes by default
+	RefreshInterval time.Duration
+}
+
+// ReconnectParams describes how connection manager re-establishes a lost connection
+type ReconnectParams struct {
+	// Enabled turns on automatic reconnect, connection is closed on loss otherwise
+	Enabled bool
+	// MaxAttempts limits number of reconnect attempts, zero means no limit
+	MaxAttempts int
+	// MaxDuration limits total time spent on reconnecting, zero means no limit
+	MaxDuration time.Duration
+	// InitialBackoff is the base delay before the first attempt, doubled after each failed attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+}
+
+// QualityParams describes how connection quality is checked while connected
+type QualityParams struct {
+	// Disabled turns off quality monitoring
+	Disabled bool
+	// Interval between checks, 30 seconds by default
+	Interval time.Duration
+	// ProbeTarget is host:port dialed over TCP through the tunnel to measure latency and loss.
+	// Nothing is probed by default, quality is judged by handshakes with provider only.
+	ProbeTarget string
+	// ProbeCount is the number of probes sent on each check, 5 by default
+	ProbeCount int
+	// ProbeTimeout is how long a probe waits before it is considered lost, 5 seconds by default
+	ProbeTimeout time.Duration
+	// MaxLoss is the ratio of lost probes, from 0 to 1, above which connection is degraded, nil means 0.5
+	MaxLoss *float64
+	// StaleHandshake is the age of the last handshake above which connection is degraded, 3 minutes by default
+	StaleHandshake time.Duration
+	// ReconnectOnDegraded handles degraded connection as a lost one, it is closed if reconnect is disabled
+	ReconnectOnDegraded bool
+}
+
+// DNSOption selects resolvers used while connected
+type DNSOption string
+
+const (
+	// DNSOptionProvider uses resolvers advertised by provider, it is the default one
+	DNSOptionProvider = DNSOption("provider")
+	// DNSOptionSystem keeps system resolvers untouched
+	DNSOptionSystem = DNSOption("system")
+)
+
+// Servers returns resolvers to be configured for the tunnel, nil means system resolvers are kept.
+// Option other than "provider" or "system" is a comma separated list of resolver IPs.
+func (o DNSOption) Servers(providerServers []net.IP) ([]net.IP, error) {
+	switch o {
+	case "", DNSOptionProvider:
+		return providerServers, nil
+	case DNSOptionSystem:
+		return nil, nil
+	}
+
+	var servers []net.IP
+	for _, server := range strings.Split(string(o), ",") {
+		ip := net.ParseIP(strings.TrimSpace(server))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid DNS server: %q", server)
+		}
+		servers = append(servers, ip)
+	}
+	return servers, nil
 }
 
 // ConnectOptions represents the params we need to ensure a successful connection
@@ -36,4 +157,8 @@ type ConnectOptions struct {
 	Proposal      market.ServiceProposal
 	SessionID     session.ID
 	SessionConfig []byte
+	DNS           DNSOption
+	Routes        Routes
+	// DNSConfigurator is shared by all connections, so that crash cleanup of the manager reverts what they configured
+	DNSConfigurator dns.Configurator
 }
@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSOptionServers(t *testing.T) {
+	providerServers := []net.IP{net.ParseIP("10.182.0.1")}
+
+	servers, err := DNSOption("").Servers(providerServers)
+	assert.NoError(t, err)
+	assert.Equal(t, providerServers, servers)
+
+	servers, err = DNSOptionProvider.Servers(providerServers)
+	assert.NoError(t, err)
+	assert.Equal(t, providerServers, servers)
+
+	servers, err = DNSOptionSystem.Servers(providerServers)
+	assert.NoError(t, err)
+	assert.Nil(t, servers)
+
+	servers, err = DNSOption("1.1.1.1, 2606:4700:4700::1111").Servers(providerServers)
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("2606:4700:4700::1111")}, servers)
+
+	_, err = DNSOption("1.1.1.1,localhost").Servers(providerServers)
+	assert.Error(t, err)
+}
@@ -17,6 +17,8 @@
 
 package connection
 
+import "github.com/skytells-research/DNA/network/node/consumer"
+
 // Topic represents the different topics a consumer can subscribe to
 const (
 	// StateEventTopic represents the connection state change topic
@@ -25,12 +27,23 @@ const (
 	StatisticsEventTopic = "Statistics"
 	// SessionEventTopic represents the session event
 	SessionEventTopic = "Session"
+	// QualityEventTopic represents the connection quality check topic
+	QualityEventTopic = "Quality"
 )
 
 // StateEvent is the struct we'll emit on a StateEvent topic event
 type StateEvent struct {
-	State       State
-	SessionInfo SessionInfo
+	ConnectionID ID
+	State        State
+	SessionInfo  SessionInfo
+	// Attempt is the number of reconnect attempt, zero for regular state changes
+	Attempt int
+}
+
+// StatisticsEvent is emitted on a StatisticsEventTopic with traffic of the connection
+type StatisticsEvent struct {
+	ConnectionID ID
+	Stats        consumer.SessionStatistics
 }
 
 const (
@@ -42,6 +55,13 @@ const (
 
 // SessionEvent represents a session related event
 type SessionEvent struct {
-	Status      string
-	SessionInfo SessionInfo
+	ConnectionID ID
+	Status       string
+	SessionInfo  SessionInfo
+}
+
+// QualityEvent represents the result of connection quality check
+type QualityEvent struct {
+	ConnectionID ID
+	Quality      Quality
 }
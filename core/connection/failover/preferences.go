@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package failover
+
+import (
+	"sort"
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/market"
+)
+
+// Preferences describe which proposals are acceptable and in which order they are tried
+type Preferences struct {
+	// ServiceType limits candidates to given service type, any type is accepted if empty
+	ServiceType string
+	// Country makes proposals from given country go first
+	Country string
+	// MaxPrice skips proposals which are more expensive, zero means no limit
+	MaxPrice uint64
+	// PreferLowLatency orders candidates by measured latency before price
+	PreferLowLatency bool
+}
+
+// candidate is a proposal which passed preference filters
+type candidate struct {
+	proposal market.ServiceProposal
+	latency  time.Duration
+	// reachable is false when latency could not be measured
+	reachable bool
+}
+
+func (prefs Preferences) accepts(proposal market.ServiceProposal) bool {
+	if prefs.ServiceType != "" && proposal.ServiceType != prefs.ServiceType {
+		return false
+	}
+	if prefs.MaxPrice > 0 && price(proposal) > prefs.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// order sorts candidates: preferred country first, then by latency if requested, then by price
+func (prefs Preferences) order(candidates []candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		if prefs.Country != "" {
+			aCountry, bCountry := country(a.proposal) == prefs.Country, country(b.proposal) == prefs.Country
+			if aCountry != bCountry {
+				return aCountry
+			}
+		}
+
+		if prefs.PreferLowLatency {
+			if a.reachable != b.reachable {
+				return a.reachable
+			}
+			if a.latency != b.latency {
+				return a.latency < b.latency
+			}
+		}
+
+		return price(a.proposal) < price(b.proposal)
+	})
+}
+
+func price(proposal market.ServiceProposal) uint64 {
+	if proposal.PaymentMethod == nil {
+		return 0
+	}
+	return proposal.PaymentMethod.GetPrice().Amount
+}
+
+func country(proposal market.ServiceProposal) string {
+	if proposal.ServiceDefinition == nil {
+		return ""
+	}
+	return proposal.ServiceDefinition.GetLocation().Country
+}
@@ -0,0 +1,376 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/core/connection"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+)
+
+const logPrefix = "[connection-failover] "
+
+// SwitchTopic is used in event bus to announce that strategy moved to another provider
+const SwitchTopic = "Provider switch"
+
+const (
+	defaultCheckInterval = 10 * time.Second
+	defaultMaxFailures   = 3
+)
+
+var (
+	// ErrNoCandidates indicates that none of the given proposals matches preferences
+	ErrNoCandidates = errors.New("no proposals match preferences")
+	// ErrAllCandidatesFailed indicates that connection to every candidate has failed
+	ErrAllCandidatesFailed = errors.New("connection to all candidates has failed")
+
+	errConnectionLost = errors.New("connection is lost")
+)
+
+// LatencyMeter measures round trip time to the provider of given proposal
+type LatencyMeter func(proposal market.ServiceProposal) (time.Duration, error)
+
+// HealthChecker checks whether the given connection still carries traffic
+type HealthChecker func(id connection.ID) error
+
+// SwitchEvent is the struct we'll emit on a SwitchTopic event
+type SwitchEvent struct {
+	FromProviderID string
+	ToProviderID   string
+	ConnectionID   connection.ID
+	Reason         string
+}
+
+// Options configure health checking of the active connection
+type Options struct {
+	// CheckInterval is the period between health checks
+	CheckInterval time.Duration
+	// MaxFailures is the number of consecutive failed checks after which strategy switches provider
+	MaxFailures int
+}
+
+// Strategy connects to the best of given proposals and fails over to the next one when the active provider degrades
+type Strategy struct {
+	manager        connection.Manager
+	measureLatency LatencyMeter
+	checkHealth    HealthChecker
+	eventPublisher connection.Publisher
+	options        Options
+
+	lock       sync.Mutex
+	consumerID identity.Identity
+	params     connection.ConnectParams
+	candidates []candidate
+	active     int
+	activeID   connection.ID
+	stop       chan struct{}
+}
+
+// NewStrategy creates failover strategy on top of given connection manager.
+// Latency meter and health checker are optional.
+func NewStrategy(
+	manager connection.Manager,
+	measureLatency LatencyMeter,
+	checkHealth HealthChecker,
+	eventPublisher connection.Publisher,
+	options Options,
+) *Strategy {
+	if options.CheckInterval <= 0 {
+		options.CheckInterval = defaultCheckInterval
+	}
+	if options.MaxFailures <= 0 {
+		options.MaxFailures = defaultMaxFailures
+	}
+
+	return &Strategy{
+		manager:        manager,
+		measureLatency: measureLatency,
+		checkHealth:    checkHealth,
+		eventPublisher: eventPublisher,
+		options:        options,
+	}
+}
+
+// Connect orders proposals by preferences and connects to the first candidate which succeeds.
+// Once connected, the active provider is health-checked until Disconnect is called.
+// Context limits connecting to candidates, it does not affect failover of established connection.
+func (strategy *Strategy) Connect(
+	ctx context.Context,
+	consumerID identity.Identity,
+	proposals []market.ServiceProposal,
+	prefs Preferences,
+	params connection.ConnectParams,
+) (connection.ID, error) {
+	strategy.lock.Lock()
+	if strategy.stop != nil {
+		strategy.lock.Unlock()
+		return "", connection.ErrAlreadyExists
+	}
+	stop := make(chan struct{})
+	strategy.stop = stop
+	strategy.lock.Unlock()
+
+	candidates := strategy.rank(proposals, prefs)
+	if len(candidates) == 0 {
+		strategy.finish(stop)
+		return "", ErrNoCandidates
+	}
+
+	index, id, err := strategy.connectFrom(ctx, consumerID, candidates, params, 0, stop)
+	if err != nil {
+		strategy.finish(stop)
+		return "", err
+	}
+
+	strategy.lock.Lock()
+	defer strategy.lock.Unlock()
+
+	if isClosed(stop) {
+		logDisconnectError(strategy.manager.Disconnect(id))
+		return "", connection.ErrConnectionCancelled
+	}
+
+	strategy.consumerID = consumerID
+	strategy.params = params
+	strategy.candidates = candidates
+	strategy.active = index
+	strategy.activeID = id
+
+	go strategy.monitor(stop)
+	return id, nil
+}
+
+// Active returns id and proposal of the connection currently held by strategy
+func (strategy *Strategy) Active() (connection.ID, market.ServiceProposal, bool) {
+	strategy.lock.Lock()
+	defer strategy.lock.Unlock()
+
+	if strategy.activeID == "" {
+		return "", market.ServiceProposal{}, false
+	}
+	return strategy.activeID, strategy.candidates[strategy.active].proposal, true
+}
+
+// Disconnect stops health checking and closes the active connection
+func (strategy *Strategy) Disconnect() error {
+	strategy.lock.Lock()
+	if strategy.stop == nil {
+		strategy.lock.Unlock()
+		return connection.ErrNoConnection
+	}
+	close(strategy.stop)
+	strategy.stop = nil
+	id := strategy.activeID
+	strategy.activeID = ""
+	strategy.lock.Unlock()
+
+	// connection may be already gone if strategy was switching providers
+	err := strategy.manager.Disconnect(id)
+	if err == connection.ErrNoConnection {
+		return nil
+	}
+	return err
+}
+
+// finish marks strategy as stopped unless Disconnect already did it
+func (strategy *Strategy) finish(stop chan struct{}) {
+	strategy.lock.Lock()
+	defer strategy.lock.Unlock()
+
+	if strategy.stop == stop {
+		close(stop)
+		strategy.stop = nil
+		strategy.activeID = ""
+	}
+}
+
+func (strategy *Strategy) rank(proposals []market.ServiceProposal, prefs Preferences) []candidate {
+	candidates := make([]candidate, 0, len(proposals))
+	for _, proposal := range proposals {
+		if prefs.accepts(proposal) {
+			candidates = append(candidates, candidate{proposal: proposal})
+		}
+	}
+
+	if prefs.PreferLowLatency && strategy.measureLatency != nil {
+		var wg sync.WaitGroup
+		for i := range candidates {
+			wg.Add(1)
+			go func(c *candidate) {
+				defer wg.Done()
+				latency, err := strategy.measureLatency(c.proposal)
+				if err != nil {
+					log.Debug(logPrefix, "Latency to ", c.proposal.ProviderID, " is unknown: ", err)
+					return
+				}
+				c.latency, c.reachable = latency, true
+			}(&candidates[i])
+		}
+		wg.Wait()
+	}
+
+	prefs.order(candidates)
+	return candidates
+}
+
+// connectFrom tries candidates in order starting at given index and wrapping around,
+// it falls back to the next candidate on any failure except cancellation or expiry of given context
+func (strategy *Strategy) connectFrom(
+	ctx context.Context,
+	consumerID identity.Identity,
+	candidates []candidate,
+	params connection.ConnectParams,
+	start int,
+	stop <-chan struct{},
+) (int, connection.ID, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for n := 0; n < len(candidates); n++ {
+		if isClosed(stop) {
+			return 0, "", connection.ErrConnectionCancelled
+		}
+
+		index := (start + n) % len(candidates)
+		proposal := candidates[index].proposal
+		id, err := strategy.manager.Connect(ctx, consumerID, proposal, params)
+		if err == nil {
+			log.Info(logPrefix, "Connected to provider ", proposal.ProviderID)
+			return index, id, nil
+		}
+		if err == connection.ErrConnectionCancelled || ctx.Err() != nil {
+			return 0, "", err
+		}
+		log.Warn(logPrefix, "Connection to provider ", proposal.ProviderID, " failed: ", err)
+	}
+	return 0, "", ErrAllCandidatesFailed
+}
+
+func (strategy *Strategy) monitor(stop chan struct{}) {
+	ticker := time.NewTicker(strategy.options.CheckInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := strategy.check()
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		log.Warn(logPrefix, "Health check failed (", failures, "/", strategy.options.MaxFailures, "): ", err)
+		if err != errConnectionLost && failures < strategy.options.MaxFailures {
+			continue
+		}
+
+		if !strategy.switchProvider(stop, err.Error()) {
+			return
+		}
+		failures = 0
+	}
+}
+
+func (strategy *Strategy) check() error {
+	strategy.lock.Lock()
+	id := strategy.activeID
+	strategy.lock.Unlock()
+
+	switch state := strategy.manager.Status(id).State; state {
+	case connection.NotConnected:
+		return errConnectionLost
+	case connection.Connected:
+		if strategy.checkHealth != nil {
+			return strategy.checkHealth(id)
+		}
+		return nil
+	default:
+		return fmt.Errorf("connection is %s", state)
+	}
+}
+
+// switchProvider drops the active connection and connects to the next candidate, the dropped one is tried last.
+// It returns false if strategy has stopped.
+func (strategy *Strategy) switchProvider(stop chan struct{}, reason string) bool {
+	strategy.lock.Lock()
+	from, fromID := strategy.active, strategy.activeID
+	consumerID, params, candidates := strategy.consumerID, strategy.params, strategy.candidates
+	strategy.lock.Unlock()
+
+	log.Info(logPrefix, "Switching from provider ", candidates[from].proposal.ProviderID, ": ", reason)
+	logDisconnectError(strategy.manager.Disconnect(fromID))
+
+	index, id, err := strategy.connectFrom(context.Background(), consumerID, candidates, params, from+1, stop)
+	if err != nil {
+		log.Error(logPrefix, "Failover failed: ", err)
+		strategy.finish(stop)
+		return false
+	}
+
+	strategy.lock.Lock()
+	if isClosed(stop) {
+		strategy.lock.Unlock()
+		logDisconnectError(strategy.manager.Disconnect(id))
+		return false
+	}
+	strategy.active, strategy.activeID = index, id
+	strategy.lock.Unlock()
+
+	strategy.eventPublisher.Publish(SwitchTopic, SwitchEvent{
+		FromProviderID: candidates[from].proposal.ProviderID,
+		ToProviderID:   candidates[index].proposal.ProviderID,
+		ConnectionID:   id,
+		Reason:         reason,
+	})
+	return true
+}
+
+func isClosed(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func logDisconnectError(err error) {
+	if err != nil && err != connection.ErrNoConnection {
+		log.Error(logPrefix, "Disconnect error: ", err)
+	}
+}
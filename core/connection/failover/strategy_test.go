@@ -0,0 +1,296 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package failover
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/consumer"
+	"github.com/skytells-research/DNA/network/node/core/connection"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+	"github.com/skytells-research/DNA/network/node/money"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	consumerID = identity.FromAddress("consumer")
+	proposalLT = newProposal("provider-lt", "LT", 10)
+	proposalUS = newProposal("provider-us", "US", 5)
+	proposalDE = newProposal("provider-de", "DE", 20)
+)
+
+func TestPreferencesOrderCandidates(t *testing.T) {
+	latencies := map[string]time.Duration{
+		"provider-lt": 30 * time.Millisecond,
+		"provider-us": 90 * time.Millisecond,
+	}
+
+	tests := []struct {
+		name     string
+		prefs    Preferences
+		expected []string
+	}{
+		{"by price", Preferences{}, []string{"provider-us", "provider-lt", "provider-de"}},
+		{"country first", Preferences{Country: "DE"}, []string{"provider-de", "provider-us", "provider-lt"}},
+		{"by latency", Preferences{PreferLowLatency: true}, []string{"provider-lt", "provider-us", "provider-de"}},
+		{"price limit", Preferences{MaxPrice: 10}, []string{"provider-us", "provider-lt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := NewStrategy(newManagerFake(), latencyFake(latencies), nil, &publisherFake{}, Options{})
+
+			var providers []string
+			for _, c := range strategy.rank([]market.ServiceProposal{proposalLT, proposalUS, proposalDE}, tt.prefs) {
+				providers = append(providers, c.proposal.ProviderID)
+			}
+			assert.Equal(t, tt.expected, providers)
+		})
+	}
+}
+
+func TestConnectFallsBackToNextCandidate(t *testing.T) {
+	manager := newManagerFake()
+	manager.failing["provider-us"] = connection.ErrConnectionFailed
+	strategy := NewStrategy(manager, nil, nil, &publisherFake{}, Options{CheckInterval: time.Hour})
+
+	id, err := strategy.Connect(context.Background(), consumerID, []market.ServiceProposal{proposalLT, proposalUS}, Preferences{}, connection.ConnectParams{})
+	assert.NoError(t, err)
+
+	activeID, proposal, ok := strategy.Active()
+	assert.True(t, ok)
+	assert.Equal(t, id, activeID)
+	assert.Equal(t, "provider-lt", proposal.ProviderID)
+	assert.Equal(t, []string{"provider-us", "provider-lt"}, manager.attempts())
+
+	assert.NoError(t, strategy.Disconnect())
+	assert.Empty(t, manager.List())
+}
+
+func TestConnectFailsWhenNoCandidateConnects(t *testing.T) {
+	manager := newManagerFake()
+	manager.failing["provider-us"] = connection.ErrConnectionFailed
+	manager.failing["provider-lt"] = errors.New("dialog failed")
+	strategy := NewStrategy(manager, nil, nil, &publisherFake{}, Options{})
+
+	_, err := strategy.Connect(context.Background(), consumerID, []market.ServiceProposal{proposalLT, proposalUS}, Preferences{}, connection.ConnectParams{})
+	assert.Equal(t, ErrAllCandidatesFailed, err)
+
+	_, err = strategy.Connect(context.Background(), consumerID, nil, Preferences{}, connection.ConnectParams{})
+	assert.Equal(t, ErrNoCandidates, err)
+	assert.Equal(t, connection.ErrNoConnection, strategy.Disconnect())
+}
+
+func TestStrategySwitchesProviderWhenHealthChecksFail(t *testing.T) {
+	manager := newManagerFake()
+	publisher := &publisherFake{}
+	var checks sync.Map
+	checkHealth := func(id connection.ID) error {
+		if manager.provider(id) == "provider-us" {
+			checks.Store(id, true)
+			return errors.New("no traffic")
+		}
+		return nil
+	}
+	strategy := NewStrategy(manager, nil, checkHealth, publisher, Options{CheckInterval: time.Millisecond, MaxFailures: 2})
+
+	_, err := strategy.Connect(context.Background(), consumerID, []market.ServiceProposal{proposalLT, proposalUS}, Preferences{}, connection.ConnectParams{})
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+
+	id, proposal, ok := strategy.Active()
+	assert.True(t, ok)
+	assert.Equal(t, "provider-lt", proposal.ProviderID)
+	assert.Len(t, manager.List(), 1)
+	assert.Equal(t, []SwitchEvent{{
+		FromProviderID: "provider-us",
+		ToProviderID:   "provider-lt",
+		ConnectionID:   id,
+		Reason:         "no traffic",
+	}}, publisher.events())
+
+	assert.NoError(t, strategy.Disconnect())
+}
+
+func TestStrategySwitchesProviderWhenConnectionIsLost(t *testing.T) {
+	manager := newManagerFake()
+	strategy := NewStrategy(manager, nil, nil, &publisherFake{}, Options{CheckInterval: time.Millisecond, MaxFailures: 10})
+
+	id, err := strategy.Connect(context.Background(), consumerID, []market.ServiceProposal{proposalLT, proposalUS}, Preferences{}, connection.ConnectParams{})
+	assert.NoError(t, err)
+	assert.NoError(t, manager.Disconnect(id))
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, proposal, ok := strategy.Active()
+	assert.True(t, ok)
+	assert.Equal(t, "provider-lt", proposal.ProviderID)
+	assert.NoError(t, strategy.Disconnect())
+}
+
+func newProposal(providerID, country string, price uint64) market.ServiceProposal {
+	return market.ServiceProposal{
+		ProviderID:        providerID,
+		ServiceType:       "fake-service",
+		ServiceDefinition: fakeServiceDefinition{country},
+		PaymentMethod:     fakePaymentMethod{price},
+	}
+}
+
+type fakeServiceDefinition struct {
+	country string
+}
+
+func (fs fakeServiceDefinition) GetLocation() market.Location {
+	return market.Location{Country: fs.country}
+}
+
+type fakePaymentMethod struct {
+	price uint64
+}
+
+func (fp fakePaymentMethod) GetPrice() money.Money {
+	return money.Money{Amount: fp.price, Currency: money.CurrencyMyst}
+}
+
+func latencyFake(latencies map[string]time.Duration) LatencyMeter {
+	return func(proposal market.ServiceProposal) (time.Duration, error) {
+		latency, ok := latencies[proposal.ProviderID]
+		if !ok {
+			return 0, errors.New("unreachable")
+		}
+		return latency, nil
+	}
+}
+
+type publisherFake struct {
+	published []SwitchEvent
+	lock      sync.Mutex
+}
+
+func (pf *publisherFake) Publish(topic string, args ...interface{}) {
+	pf.lock.Lock()
+	defer pf.lock.Unlock()
+	pf.published = append(pf.published, args[0].(SwitchEvent))
+}
+
+func (pf *publisherFake) events() []SwitchEvent {
+	pf.lock.Lock()
+	defer pf.lock.Unlock()
+	return pf.published
+}
+
+type managerFake struct {
+	failing     map[string]error
+	connections map[connection.ID]string
+	history     []string
+	lastID      int
+	lock        sync.Mutex
+}
+
+func newManagerFake() *managerFake {
+	return &managerFake{
+		failing:     make(map[string]error),
+		connections: make(map[connection.ID]string),
+	}
+}
+
+func (mf *managerFake) Connect(ctx context.Context, consumerID identity.Identity, proposal market.ServiceProposal, params connection.ConnectParams) (connection.ID, error) {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+
+	mf.history = append(mf.history, proposal.ProviderID)
+	if err := mf.failing[proposal.ProviderID]; err != nil {
+		return "", err
+	}
+	mf.lastID++
+	id := connection.ID(strconv.Itoa(mf.lastID))
+	mf.connections[id] = proposal.ProviderID
+	return id, nil
+}
+
+func (mf *managerFake) Status(id connection.ID) connection.Status {
+	if mf.provider(id) == "" {
+		return connection.Status{State: connection.NotConnected}
+	}
+	return connection.Status{State: connection.Connected}
+}
+
+func (mf *managerFake) Statistics(id connection.ID) (consumer.SessionStatistics, error) {
+	return consumer.SessionStatistics{}, nil
+}
+
+func (mf *managerFake) List() map[connection.ID]connection.Status {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+
+	list := make(map[connection.ID]connection.Status)
+	for id := range mf.connections {
+		list[id] = connection.Status{State: connection.Connected}
+	}
+	return list
+}
+
+func (mf *managerFake) Disconnect(id connection.ID) error {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+
+	if _, ok := mf.connections[id]; !ok {
+		return connection.ErrNoConnection
+	}
+	delete(mf.connections, id)
+	return nil
+}
+
+func (mf *managerFake) DisconnectAll() error {
+	for id := range mf.List() {
+		mf.Disconnect(id)
+	}
+	return nil
+}
+
+func (mf *managerFake) Restore(ctx context.Context) error {
+	return nil
+}
+
+func (mf *managerFake) Cleanup() error {
+	return nil
+}
+
+func (mf *managerFake) Close() error {
+	return mf.DisconnectAll()
+}
+
+func (mf *managerFake) provider(id connection.ID) string {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+	return mf.connections[id]
+}
+
+func (mf *managerFake) attempts() []string {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+	return mf.history
+}
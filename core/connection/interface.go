@@ -18,8 +18,11 @@
 package connection
 
 import (
+	"context"
+
 	"github.com/skytells-research/DNA/network/node/communication"
 	"github.com/skytells-research/DNA/network/node/consumer"
+	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/market"
 )
@@ -32,12 +35,19 @@ type ConsumerConfig interface{}
 
 // Connection represents a connection
 type Connection interface {
-	Start(ConnectOptions) error
+	// Start establishes the tunnel, it gives up once context is done
+	Start(context.Context, ConnectOptions) error
 	Wait() error
 	Stop()
 	GetConfig() (ConsumerConfig, error)
 }
 
+// TunnelDescriber is implemented by connections which are able to tell
+// which traffic should stay allowed while kill switch is enabled
+type TunnelDescriber interface {
+	Tunnel() firewall.Tunnel
+}
+
 // StateChannel is the channel we receive state change events on
 type StateChannel chan State
 
@@ -56,10 +66,24 @@ type PromiseIssuerCreator func(issuerID identity.Identity, dialog communication.
 
 // Manager interface provides methods to manage connection
 type Manager interface {
-	// Connect creates new connection from given consumer to provider, reports error if connection already exists
-	Connect(consumerID identity.Identity, proposal market.ServiceProposal, params ConnectParams) error
-	// Status queries current status of connection
-	Status() Status
-	// Disconnect closes established connection, reports error if no connection
-	Disconnect() error
+	// Connect creates new connection from given consumer to provider and returns its id,
+	// reports error if connection to the same provider already exists.
+	// Context limits connection establishment only, established connection lives until Disconnect.
+	Connect(ctx context.Context, consumerID identity.Identity, proposal market.ServiceProposal, params ConnectParams) (ID, error)
+	// Status queries current status of given connection
+	Status(id ID) Status
+	// Statistics returns latest statistics of given connection
+	Statistics(id ID) (consumer.SessionStatistics, error)
+	// List returns statuses of all connections
+	List() map[ID]Status
+	// Disconnect closes given connection, reports error if no connection
+	Disconnect(id ID) error
+	// DisconnectAll closes all connections
+	DisconnectAll() error
+	// Restore reconnects connections which were active when node was stopped
+	Restore(ctx context.Context) error
+	// Cleanup removes kill switch rules and DNS configuration left by crashed process
+	Cleanup() error
+	// Close closes all connections, keeping them to be restored on the next start
+	Close() error
 }
@@ -20,13 +20,16 @@ package connection
 import (
 	"context"
 	"errors"
+	"net"
 	"sync"
 	"time"
 
 	log "github.com/cihub/seelog"
+	"github.com/gofrs/uuid"
 	"github.com/skytells-research/DNA/network/node/communication"
 	"github.com/skytells-research/DNA/network/node/consumer"
 	"github.com/skytells-research/DNA/network/node/core/ip"
+	"github.com/skytells-research/DNA/network/node/dns"
 	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/market"
@@ -42,16 +45,21 @@ const managerLogPrefix = "[connection-manager] "
 var (
 	// ErrNoConnection error indicates that action applied to manager expects active connection (i.e. disconnect)
 	ErrNoConnection = errors.New("no connection exists")
-	// ErrAlreadyExists error indicates that action applied to manager expects no active connection (i.e. connect)
+	// ErrAlreadyExists error indicates that connection to the same provider and service type already exists
 	ErrAlreadyExists = errors.New("connection already exists")
 	// ErrConnectionCancelled indicates that connection in progress was cancelled by request of api user
 	ErrConnectionCancelled = errors.New("connection was cancelled")
 	// ErrConnectionFailed indicates that Connect method didn't reach "Connected" phase due to connection error
 	ErrConnectionFailed = errors.New("connection has failed")
+	// ErrConnectionTimeout indicates that Connect method didn't reach "Connected" phase in time
+	ErrConnectionTimeout = errors.New("connection has timed out")
 	// ErrUnsupportedServiceType indicates that target proposal contains unsupported service type
 	ErrUnsupportedServiceType = errors.New("unsupported service type in proposal")
 )
 
+// ID represents connection id type
+type ID string
+
 // Creator creates new connection by given options and uses state channel to report state changes
 type Creator func(serviceType string, stateChannel StateChannel, statisticsChannel StatisticsChannel) (Connection, error)
 
@@ -88,54 +96,262 @@ type connectionManager struct {
 	newConnection        Creator
 	eventPublisher       Publisher
 	resolver             ip.Resolver
+	storage              Storage
+	killSwitch           firewall.KillSwitch
+	dnsConfigurator      dns.Configurator
+	lookupHost           hostLookup
+	probe                prober
+	// controlEndpoints are broker and discovery addresses, kill switch keeps them reachable while reconnecting
+	controlEndpoints []string
+	systemResolvers  func() ([]net.IP, error)
+
+	connections     map[ID]*connectionInstance
+	connectionsLock sync.RWMutex
+}
 
-	//these are populated by Connect at runtime
-	ctx         context.Context
-	status      Status
-	statusLock  sync.RWMutex
-	sessionInfo SessionInfo
-	cleanup     []func() error
-	cancel      func()
-
+// connectionInstance holds runtime state of a single connection
+type connectionInstance struct {
+	id      ID
+	manager *connectionManager
+	request connectRequest
+
+	ctx            context.Context
+	status         Status
+	statusLock     sync.RWMutex
+	sessionInfo    SessionInfo
+	statistics     consumer.SessionStatistics
+	cleanup        []func() error
+	cleanupLock    sync.Mutex
+	cancel         func()
+	lifetime       context.Context
+	cancelLifetime func()
+
+	allowedNetworks []net.IPNet
+	tunnel          *firewall.Tunnel
+	// controlNetworks are addresses of control endpoints by their hosts, pinned while the tunnel is up
+	controlNetworks map[string][]net.IPNet
+	tunnelLock      sync.Mutex
+
+	// stored is set once connection is kept in storage
+	stored    bool
 	discoLock sync.Mutex
 }
 
-// NewManager creates connection manager with given dependencies
+// connectRequest holds the arguments of Connect, which are reused on reconnect
+type connectRequest struct {
+	consumerID identity.Identity
+	proposal   market.ServiceProposal
+	params     ConnectParams
+}
+
+// NewManager creates connection manager with given dependencies.
+// Control endpoints are URLs or host:port addresses of broker and discovery, which reconnect relies on.
 func NewManager(
 	dialogCreator DialogCreator,
 	paymentIssuerFactory PaymentIssuerFactory,
 	connectionCreator Creator,
 	eventPublisher Publisher,
 	resolver ip.Resolver,
+	storage Storage,
+	controlEndpoints []string,
 ) *connectionManager {
 	return &connectionManager{
 		newDialog:            dialogCreator,
 		paymentIssuerFactory: paymentIssuerFactory,
 		newConnection:        connectionCreator,
-		status:               statusNotConnected(),
 		eventPublisher:       eventPublisher,
-		cleanup:              make([]func() error, 0),
 		resolver:             resolver,
+		storage:              storage,
+		killSwitch:           firewall.NewKillSwitch(),
+		dnsConfigurator:      dns.NewConfigurator(),
+		lookupHost:           net.LookupIP,
+		probe:                tcpProbe,
+		controlEndpoints:     controlEndpoints,
+		systemResolvers:      dns.SystemResolvers,
+		connections:          make(map[ID]*connectionInstance),
 	}
 }
 
-func (manager *connectionManager) Connect(consumerID identity.Identity, proposal market.ServiceProposal, params ConnectParams) (err error) {
-	if manager.Status().State != NotConnected {
-		return ErrAlreadyExists
+func (manager *connectionManager) Connect(ctx context.Context, consumerID identity.Identity, proposal market.ServiceProposal, params ConnectParams) (ID, error) {
+	allowedNetworks, err := parseNetworks(params.AllowedNetworks)
+	if err != nil {
+		return ID(""), err
+	}
+	if _, err := params.DNS.Servers(nil); err != nil {
+		return ID(""), err
+	}
+	if err := params.SplitTunnel.validate(); err != nil {
+		return ID(""), err
+	}
+	if err := params.Quality.validate(); err != nil {
+		return ID(""), err
+	}
+	if params.Timeout.Session < 0 || params.Timeout.Handshake < 0 {
+		return ID(""), errors.New("connection timeouts can not be negative")
 	}
 
-	manager.ctx, manager.cancel = context.WithCancel(context.Background())
+	id, err := generateID()
+	if err != nil {
+		return id, err
+	}
+
+	instance := &connectionInstance{
+		id:      id,
+		manager: manager,
+		request: connectRequest{
+			consumerID: consumerID,
+			proposal:   proposal,
+			params:     params,
+		},
+		status:          statusConnecting(),
+		cleanup:         make([]func() error, 0),
+		allowedNetworks: allowedNetworks,
+	}
+	instance.lifetime, instance.cancelLifetime = context.WithCancel(context.Background())
+	instance.ctx, instance.cancel = context.WithCancel(instance.lifetime)
+
+	if err := manager.add(instance); err != nil {
+		instance.cancelLifetime()
+		return id, err
+	}
+
+	if err := instance.start(ctx); err != nil {
+		return id, err
+	}
+
+	instance.persist()
+	return id, nil
+}
 
-	manager.setStatus(statusConnecting())
-	defer func() {
+func parseNetworks(cidrs []string) ([]net.IPNet, error) {
+	networks := make([]net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
 		if err != nil {
-			manager.setStatus(statusNotConnected())
+			return nil, err
 		}
-	}()
+		networks = append(networks, *network)
+	}
+	return networks, nil
+}
+
+func generateID() (ID, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return ID(""), err
+	}
+	return ID(uid.String()), nil
+}
+
+// add registers new connection unless the same consumer is already connected to the same provider and service type
+func (manager *connectionManager) add(instance *connectionInstance) error {
+	manager.connectionsLock.Lock()
+	defer manager.connectionsLock.Unlock()
+
+	for _, existing := range manager.connections {
+		if existing.request.consumerID == instance.request.consumerID &&
+			existing.request.proposal.ProviderID == instance.request.proposal.ProviderID &&
+			existing.request.proposal.ServiceType == instance.request.proposal.ServiceType {
+			return ErrAlreadyExists
+		}
+	}
+
+	manager.connections[instance.id] = instance
+	return nil
+}
+
+func (manager *connectionManager) remove(instance *connectionInstance) {
+	manager.connectionsLock.Lock()
+	defer manager.connectionsLock.Unlock()
 
+	if manager.connections[instance.id] == instance {
+		delete(manager.connections, instance.id)
+	}
+}
+
+func (manager *connectionManager) get(id ID) *connectionInstance {
+	manager.connectionsLock.RLock()
+	defer manager.connectionsLock.RUnlock()
+
+	return manager.connections[id]
+}
+
+func (manager *connectionManager) Status(id ID) Status {
+	instance := manager.get(id)
+	if instance == nil {
+		return statusNotConnected()
+	}
+
+	return instance.Status()
+}
+
+func (manager *connectionManager) Statistics(id ID) (consumer.SessionStatistics, error) {
+	instance := manager.get(id)
+	if instance == nil {
+		return consumer.SessionStatistics{}, ErrNoConnection
+	}
+
+	return instance.getStatistics(), nil
+}
+
+func (manager *connectionManager) List() map[ID]Status {
+	manager.connectionsLock.RLock()
+	defer manager.connectionsLock.RUnlock()
+
+	list := make(map[ID]Status, len(manager.connections))
+	for id, instance := range manager.connections {
+		list[id] = instance.Status()
+	}
+	return list
+}
+
+func (manager *connectionManager) Disconnect(id ID) error {
+	instance := manager.get(id)
+	if instance == nil {
+		return ErrNoConnection
+	}
+
+	return instance.Disconnect()
+}
+
+func (manager *connectionManager) DisconnectAll() error {
+	var lastErr error
+	for id := range manager.List() {
+		err := manager.Disconnect(id)
+		if err != nil && err != ErrNoConnection {
+			logDisconnectError(err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// start establishes the connection, everything is cleaned up if it fails
+func (instance *connectionInstance) start(ctx context.Context) error {
+	err := instance.connect(ctx, instance.request)
+	if err != nil {
+		log.Info(managerLogPrefix, "Cancelling connection initiation: ", err)
+		logDisconnectError(instance.Disconnect())
+	}
+	switch err {
+	case context.Canceled:
+		return ErrConnectionCancelled
+	case context.DeadlineExceeded:
+		return ErrConnectionTimeout
+	}
+	return err
+}
+
+// connect goes through all the steps of connection establishment for the given request,
+// until it is done or given context or the context of the connection is done
+func (instance *connectionInstance) connect(ctx context.Context, request connectRequest) error {
+	ctx, cancel := mergeContext(instance.ctx, ctx)
+	defer cancel()
+
+	consumerID, proposal := request.consumerID, request.proposal
 	providerID := identity.FromAddress(proposal.ProviderID)
 
-	dialog, err := manager.createDialog(consumerID, providerID, proposal.ProviderContacts[0])
+	dialog, err := instance.createDialog(consumerID, providerID, proposal.ProviderContacts[0])
 	if err != nil {
 		return err
 	}
@@ -143,29 +359,48 @@ func (manager *connectionManager) Connect(consumerID identity.Identity, proposal
 	stateChannel := make(chan State, 10)
 	statisticsChannel := make(chan consumer.SessionStatistics, 10)
 
-	connection, err := manager.newConnection(proposal.ServiceType, stateChannel, statisticsChannel)
+	connection, err := instance.manager.newConnection(proposal.ServiceType, stateChannel, statisticsChannel)
 	if err != nil {
 		return err
 	}
 
-	sessionDTO, paymentInfo, err := manager.createSession(connection, dialog, consumerID, proposal)
+	sessionDTO, paymentInfo, err := instance.createSession(ctx, request.params.Timeout.session(), connection, dialog, consumerID, proposal)
 	if err != nil {
 		return err
 	}
 
-	err = manager.launchPayments(paymentInfo, dialog, consumerID, providerID)
+	err = instance.launchPayments(paymentInfo, dialog, consumerID, providerID)
 	if err != nil {
 		return err
 	}
 
-	err = manager.startConnection(connection, consumerID, proposal, params, sessionDTO, stateChannel, statisticsChannel)
-	if err == context.Canceled {
-		return ErrConnectionCancelled
+	return instance.startConnection(ctx, connection, consumerID, proposal, request.params, sessionDTO, stateChannel, statisticsChannel)
+}
+
+// mergeContext returns context of the connection, which is also done once the other context is done
+func mergeContext(ctx, other context.Context) (context.Context, context.CancelFunc) {
+	var merged context.Context
+	var cancel context.CancelFunc
+	if deadline, ok := other.Deadline(); ok {
+		merged, cancel = context.WithDeadline(ctx, deadline)
+	} else {
+		merged, cancel = context.WithCancel(ctx)
 	}
-	return err
+
+	go func() {
+		select {
+		case <-other.Done():
+			// expired deadline is reported by merged context itself
+			if other.Err() != context.DeadlineExceeded {
+				cancel()
+			}
+		case <-merged.Done():
+		}
+	}()
+	return merged, cancel
 }
 
-func (manager *connectionManager) launchPayments(paymentInfo *promise.PaymentInfo, dialog communication.Dialog, consumerID, providerID identity.Identity) error {
+func (instance *connectionInstance) launchPayments(paymentInfo *promise.PaymentInfo, dialog communication.Dialog, consumerID, providerID identity.Identity) error {
 	var promiseState promise.PaymentInfo
 	if paymentInfo != nil {
 		promiseState.FreeCredit = paymentInfo.FreeCredit
@@ -183,42 +418,62 @@ func (manager *connectionManager) launchPayments(paymentInfo *promise.PaymentInf
 		Duration: time.Minute,
 	}
 
-	payments, err := manager.paymentIssuerFactory(promiseState, payment, messageChan, dialog, consumerID, providerID)
+	payments, err := instance.manager.paymentIssuerFactory(promiseState, payment, messageChan, dialog, consumerID, providerID)
 	if err != nil {
 		return err
 	}
 
-	manager.cleanup = append(manager.cleanup, func() error {
+	instance.addCleanup(func() error {
 		payments.Stop()
 		return nil
 	})
 
-	go manager.payForService(payments)
+	go instance.payForService(payments)
 	return nil
 }
 
-func (manager *connectionManager) cleanConnection() {
-	manager.cancel()
-	for i := len(manager.cleanup) - 1; i > 0; i-- {
-		err := manager.cleanup[i]()
+// addCleanup registers a step which undoes part of the connection, steps are undone in reverse order
+func (instance *connectionInstance) addCleanup(cleanup func() error) {
+	instance.cleanupLock.Lock()
+	defer instance.cleanupLock.Unlock()
+
+	instance.cleanup = append(instance.cleanup, cleanup)
+}
+
+func (instance *connectionInstance) cleanConnection() {
+	instance.cancel()
+
+	instance.cleanupLock.Lock()
+	cleanup := instance.cleanup
+	instance.cleanup = make([]func() error, 0)
+	instance.cleanupLock.Unlock()
+
+	for i := len(cleanup) - 1; i >= 0; i-- {
+		err := cleanup[i]()
 		if err != nil {
 			log.Warn(managerLogPrefix, "cleanup error:", err)
 		}
 	}
-	manager.cleanup = make([]func() error, 0)
 }
 
-func (manager *connectionManager) createDialog(consumerID, providerID identity.Identity, contact market.Contact) (communication.Dialog, error) {
-	dialog, err := manager.newDialog(consumerID, providerID, contact)
+func (instance *connectionInstance) createDialog(consumerID, providerID identity.Identity, contact market.Contact) (communication.Dialog, error) {
+	dialog, err := instance.manager.newDialog(consumerID, providerID, contact)
 	if err != nil {
 		return nil, err
 	}
 
-	manager.cleanup = append(manager.cleanup, dialog.Close)
+	instance.addCleanup(dialog.Close)
 	return dialog, err
 }
 
-func (manager *connectionManager) createSession(c Connection, dialog communication.Dialog, consumerID identity.Identity, proposal market.ServiceProposal) (session.SessionDto, *promise.PaymentInfo, error) {
+func (instance *connectionInstance) createSession(
+	ctx context.Context,
+	timeout time.Duration,
+	c Connection,
+	dialog communication.Dialog,
+	consumerID identity.Identity,
+	proposal market.ServiceProposal) (session.SessionDto, *promise.PaymentInfo, error) {
+
 	sessionCreateConfig, err := c.GetConfig()
 	if err != nil {
 		return session.SessionDto{}, nil, err
@@ -229,29 +484,54 @@ func (manager *connectionManager) createSession(c Connection, dialog communicati
 		IssuerID: consumerID,
 	}
 
-	s, paymentInfo, err := session.RequestSessionCreate(dialog, proposal.ID, sessionCreateConfig, consumerInfo)
-	if err != nil {
-		return session.SessionDto{}, nil, err
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type sessionCreated struct {
+		dto         session.SessionDto
+		paymentInfo *promise.PaymentInfo
+		err         error
 	}
+	created := make(chan sessionCreated, 1)
+	go func() {
+		s, paymentInfo, err := session.RequestSessionCreate(dialog, proposal.ID, sessionCreateConfig, consumerInfo)
+		created <- sessionCreated{s, paymentInfo, err}
+	}()
 
-	manager.cleanup = append(manager.cleanup, func() error { return session.RequestSessionDestroy(dialog, s.ID) })
+	var s session.SessionDto
+	var paymentInfo *promise.PaymentInfo
+	select {
+	case result := <-created:
+		if result.err != nil {
+			return session.SessionDto{}, nil, result.err
+		}
+		s, paymentInfo = result.dto, result.paymentInfo
+	case <-ctx.Done():
+		// request is abandoned, provider drops the session once it is not used
+		return session.SessionDto{}, nil, ctx.Err()
+	}
+
+	instance.addCleanup(func() error { return session.RequestSessionDestroy(dialog, s.ID) })
 
 	// set the session info for future use
-	manager.sessionInfo = SessionInfo{
+	sessionInfo := SessionInfo{
 		SessionID:  s.ID,
 		ConsumerID: consumerID,
 		Proposal:   proposal,
 	}
+	instance.setSessionInfo(sessionInfo)
 
-	manager.eventPublisher.Publish(SessionEventTopic, SessionEvent{
-		Status:      SessionCreatedStatus,
-		SessionInfo: manager.sessionInfo,
+	instance.manager.eventPublisher.Publish(SessionEventTopic, SessionEvent{
+		ConnectionID: instance.id,
+		Status:       SessionCreatedStatus,
+		SessionInfo:  sessionInfo,
 	})
 
-	manager.cleanup = append(manager.cleanup, func() error {
-		manager.eventPublisher.Publish(SessionEventTopic, SessionEvent{
-			Status:      SessionEndedStatus,
-			SessionInfo: manager.sessionInfo,
+	instance.addCleanup(func() error {
+		instance.manager.eventPublisher.Publish(SessionEventTopic, SessionEvent{
+			ConnectionID: instance.id,
+			Status:       SessionEndedStatus,
+			SessionInfo:  sessionInfo,
 		})
 		return nil
 	})
@@ -259,21 +539,20 @@ func (manager *connectionManager) createSession(c Connection, dialog communicati
 	return s, paymentInfo, nil
 }
 
-func (manager *connectionManager) startConnection(
+func (instance *connectionInstance) startConnection(
+	ctx context.Context,
 	connection Connection,
 	consumerID identity.Identity,
 	proposal market.ServiceProposal,
 	params ConnectParams,
 	sessionDTO session.SessionDto,
 	stateChannel chan State,
-	statisticsChannel chan consumer.SessionStatistics) (err error) {
+	statisticsChannel chan consumer.SessionStatistics) error {
 
-	defer func() {
-		if err != nil {
-			log.Info(managerLogPrefix, "Cancelling connection initiation: ", err)
-			logDisconnectError(manager.Disconnect())
-		}
-	}()
+	routes, err := resolveRoutes(params.SplitTunnel, instance.manager.lookupHost)
+	if err != nil {
+		return err
+	}
 
 	connectOptions := ConnectOptions{
 		SessionID:     sessionDTO.ID,
@@ -281,67 +560,223 @@ func (manager *connectionManager) startConnection(
 		ConsumerID:    consumerID,
 		ProviderID:    identity.FromAddress(proposal.ProviderID),
 		Proposal:      proposal,
+		DNS:           params.DNS,
+		Routes:        routes,
+
+		DNSConfigurator: instance.manager.dnsConfigurator,
 	}
 
-	if err = connection.Start(connectOptions); err != nil {
+	ctx, cancel := context.WithTimeout(ctx, params.Timeout.handshake())
+	defer cancel()
+
+	if err := connection.Start(ctx, connectOptions); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
-	manager.cleanup = append(manager.cleanup, func() error {
+	instance.addCleanup(func() error {
 		connection.Stop()
 		return nil
 	})
 
 	//consume statistics right after start - openvpn3 will publish them even before connected state
-	go manager.consumeStats(statisticsChannel)
-	err = manager.waitForConnectedState(stateChannel, sessionDTO.ID)
+	go instance.consumeStats(statisticsChannel)
+	err = instance.waitForConnectedState(ctx, stateChannel, sessionDTO.ID)
 	if err != nil {
 		return err
 	}
 
 	if !params.DisableKillSwitch {
-		// TODO: Implement fw based kill switch for respective OS
-		// we may need to wait for tun device setup to be finished
-		firewall.NewKillSwitch().Enable()
+		if err := instance.enableKillSwitch(connection, routes); err != nil {
+			return err
+		}
+	}
+	if params.SplitTunnel.hasDomains() {
+		go instance.refreshRoutes(instance.ctx, connection, routes)
+	}
+	if !params.Quality.Disabled {
+		go instance.monitorQuality(instance.ctx, connection, params.Quality)
 	}
 
-	go manager.consumeConnectionStates(stateChannel)
-	go manager.connectionWaiter(connection)
+	go instance.consumeConnectionStates(instance.ctx, stateChannel)
+	go instance.connectionWaiter(instance.ctx, connection)
 	return nil
 }
 
-func (manager *connectionManager) Status() Status {
-	manager.statusLock.RLock()
-	defer manager.statusLock.RUnlock()
+// enableKillSwitch blocks traffic outside of the tunnel of given connection, except the excluded routes and control endpoints.
+// If only included routes go through the tunnel, only they are blocked outside of it.
+// Kill switch stays enabled until Disconnect, so that nothing leaks while reconnecting,
+// rules of the previous tunnel are replaced once the new one is up.
+func (instance *connectionInstance) enableKillSwitch(connection Connection, routes Routes) error {
+	describer, ok := connection.(TunnelDescriber)
+	if !ok {
+		log.Warn(managerLogPrefix, "Kill switch is not supported by connection of service type: ", instance.request.proposal.ServiceType)
+		return nil
+	}
+
+	tunnel := describer.Tunnel()
+	tunnel.AllowedNetworks = append(append([]net.IPNet{}, instance.allowedNetworks...), routes.Exclude...)
+	tunnel.RoutedNetworks = routes.Include
+
+	instance.tunnelLock.Lock()
+	defer instance.tunnelLock.Unlock()
 
-	return manager.status
+	tunnel.AllowedNetworks = append(tunnel.AllowedNetworks, instance.pinControlEndpoints()...)
+
+	if err := instance.manager.killSwitch.Enable(tunnel); err != nil {
+		return err
+	}
+
+	previous := instance.tunnel
+	instance.tunnel = &tunnel
+	if previous != nil && !sameTunnel(*previous, tunnel) {
+		if err := instance.manager.killSwitch.Disable(*previous); err != nil {
+			log.Warn(managerLogPrefix, "Failed to disable kill switch of previous tunnel: ", err)
+		}
+	}
+	return nil
 }
 
-func (manager *connectionManager) setStatus(cs Status) {
-	manager.statusLock.Lock()
-	manager.status = cs
-	manager.statusLock.Unlock()
+func (instance *connectionInstance) disableKillSwitch() {
+	instance.tunnelLock.Lock()
+	defer instance.tunnelLock.Unlock()
+
+	if instance.tunnel == nil {
+		return
+	}
+
+	if err := instance.manager.killSwitch.Disable(*instance.tunnel); err != nil {
+		log.Error(managerLogPrefix, "Failed to disable kill switch: ", err)
+	}
+	instance.tunnel = nil
 }
 
-func (manager *connectionManager) Disconnect() error {
-	manager.discoLock.Lock()
-	defer manager.discoLock.Unlock()
+func sameTunnel(a, b firewall.Tunnel) bool {
+	return a.Interface == b.Interface && a.ProviderIP.Equal(b.ProviderIP)
+}
 
-	if manager.Status().State == NotConnected {
-		return ErrNoConnection
+func (instance *connectionInstance) Status() Status {
+	instance.statusLock.RLock()
+	defer instance.statusLock.RUnlock()
+
+	return instance.status
+}
+
+func (instance *connectionInstance) setStatus(cs Status) {
+	instance.statusLock.Lock()
+	instance.status = cs
+	instance.statusLock.Unlock()
+}
+
+// setQuality attaches quality check result to the status of established connection
+func (instance *connectionInstance) setQuality(quality Quality) {
+	instance.statusLock.Lock()
+	if instance.status.State == Connected {
+		instance.status.Quality = &quality
 	}
+	instance.statusLock.Unlock()
+}
+
+func (instance *connectionInstance) getSessionInfo() SessionInfo {
+	instance.statusLock.RLock()
+	defer instance.statusLock.RUnlock()
+
+	return instance.sessionInfo
+}
+
+func (instance *connectionInstance) setSessionInfo(sessionInfo SessionInfo) {
+	instance.statusLock.Lock()
+	instance.sessionInfo = sessionInfo
+	instance.statusLock.Unlock()
+}
+
+func (instance *connectionInstance) getStatistics() consumer.SessionStatistics {
+	instance.statusLock.RLock()
+	defer instance.statusLock.RUnlock()
+
+	return instance.statistics
+}
+
+func (instance *connectionInstance) setStatistics(stats consumer.SessionStatistics) {
+	instance.statusLock.Lock()
+	instance.statistics = stats
+	instance.statusLock.Unlock()
+}
+
+func (instance *connectionInstance) Disconnect() error {
+	return instance.close(true)
+}
 
-	manager.setStatus(statusDisconnecting())
-	manager.cleanConnection()
-	manager.setStatus(statusNotConnected())
+// close closes the connection, it is removed from storage unless it has to be restored on the next start
+func (instance *connectionInstance) close(forget bool) error {
+	instance.discoLock.Lock()
+	defer instance.discoLock.Unlock()
 
+	if instance.Status().State == NotConnected {
+		return ErrNoConnection
+	}
+
+	instance.disconnect(forget)
 	return nil
 }
 
-func (manager *connectionManager) payForService(payments PaymentIssuer) {
+func (instance *connectionInstance) disconnect(forget bool) {
+	instance.cancelLifetime()
+	instance.setStatus(statusDisconnecting())
+	instance.cleanConnection()
+	instance.disableKillSwitch()
+	instance.setStatus(statusNotConnected())
+	instance.manager.remove(instance)
+	if instance.stored && forget {
+		instance.manager.forget(instance.id)
+		instance.stored = false
+	}
+}
+
+// persist stores established connection, unless it was disconnected meanwhile
+func (instance *connectionInstance) persist() {
+	instance.discoLock.Lock()
+	defer instance.discoLock.Unlock()
+
+	if instance.lifetime.Err() != nil {
+		return
+	}
+	if err := instance.manager.store(instance); err != nil {
+		log.Warn(managerLogPrefix, "Failed to store connection ", instance.id, ": ", err)
+		return
+	}
+	instance.stored = true
+}
+
+// connectionLost is called once established connection goes down without being asked to.
+// Depending on reconnect params it either closes the connection or starts reconnecting.
+func (instance *connectionInstance) connectionLost(ctx context.Context) {
+	instance.discoLock.Lock()
+	defer instance.discoLock.Unlock()
+
+	// connection was already cleaned up by Disconnect or by previous call
+	if ctx.Err() != nil {
+		return
+	}
+
+	if !instance.request.params.Reconnect.Enabled {
+		instance.disconnect(true)
+		return
+	}
+
+	log.Info(managerLogPrefix, "Connection ", instance.id, " lost, reconnecting")
+	instance.setStatus(statusReconnecting())
+	instance.cleanConnection()
+	instance.allowReconnect()
+	go instance.reconnect(instance.request.params.Reconnect)
+}
+
+func (instance *connectionInstance) payForService(payments PaymentIssuer) {
 	err := payments.Start()
 	if err != nil {
 		log.Error(managerLogPrefix, "payment error: ", err)
-		err = manager.Disconnect()
+		err = instance.Disconnect()
 		if err != nil {
 			log.Error(managerLogPrefix, "could not disconnect gracefully:", err)
 		}
@@ -352,7 +787,7 @@ func warnOnClean() {
 	log.Warn(managerLogPrefix, "Trying to close when there is nothing to close. Possible bug or race condition")
 }
 
-func (manager *connectionManager) connectionWaiter(connection Connection) {
+func (instance *connectionInstance) connectionWaiter(ctx context.Context, connection Connection) {
 	err := connection.Wait()
 	if err != nil {
 		log.Warn(managerLogPrefix, "Connection exited with error: ", err)
@@ -360,10 +795,10 @@ func (manager *connectionManager) connectionWaiter(connection Connection) {
 		log.Info(managerLogPrefix, "Connection exited")
 	}
 
-	logDisconnectError(manager.Disconnect())
+	instance.connectionLost(ctx)
 }
 
-func (manager *connectionManager) waitForConnectedState(stateChannel <-chan State, sessionID session.ID) error {
+func (instance *connectionInstance) waitForConnectedState(ctx context.Context, stateChannel <-chan State, sessionID session.ID) error {
 	for {
 		select {
 		case state, more := <-stateChannel:
@@ -373,43 +808,49 @@ func (manager *connectionManager) waitForConnectedState(stateChannel <-chan Stat
 
 			switch state {
 			case Connected:
-				manager.onStateChanged(state)
+				instance.onStateChanged(state)
 				return nil
 			default:
-				manager.onStateChanged(state)
+				instance.onStateChanged(state)
 			}
-		case <-manager.ctx.Done():
-			return manager.ctx.Err()
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
 
-func (manager *connectionManager) consumeConnectionStates(stateChannel <-chan State) {
+func (instance *connectionInstance) consumeConnectionStates(ctx context.Context, stateChannel <-chan State) {
 	for state := range stateChannel {
-		manager.onStateChanged(state)
+		instance.onStateChanged(state)
 	}
 
 	log.Debug(managerLogPrefix, "State updater stopCalled")
-	logDisconnectError(manager.Disconnect())
+	instance.connectionLost(ctx)
 }
 
-func (manager *connectionManager) consumeStats(statisticsChannel <-chan consumer.SessionStatistics) {
+func (instance *connectionInstance) consumeStats(statisticsChannel <-chan consumer.SessionStatistics) {
 	for stats := range statisticsChannel {
-		manager.eventPublisher.Publish(StatisticsEventTopic, stats)
+		instance.setStatistics(stats)
+		instance.manager.eventPublisher.Publish(StatisticsEventTopic, StatisticsEvent{
+			ConnectionID: instance.id,
+			Stats:        stats,
+		})
 	}
 }
 
-func (manager *connectionManager) onStateChanged(state State) {
-	manager.eventPublisher.Publish(StateEventTopic, StateEvent{
-		State:       state,
-		SessionInfo: manager.sessionInfo,
+func (instance *connectionInstance) onStateChanged(state State) {
+	sessionInfo := instance.getSessionInfo()
+	instance.manager.eventPublisher.Publish(StateEventTopic, StateEvent{
+		ConnectionID: instance.id,
+		State:        state,
+		SessionInfo:  sessionInfo,
 	})
 
 	switch state {
 	case Connected:
-		manager.setStatus(statusConnected(manager.sessionInfo.SessionID, manager.sessionInfo.Proposal))
+		instance.setStatus(statusConnected(sessionInfo.SessionID, sessionInfo.Proposal))
 	case Reconnecting:
-		manager.setStatus(statusReconnecting())
+		instance.setStatus(statusReconnecting())
 	}
 }
 
@@ -18,7 +18,9 @@
 package connection
 
 import (
+	"context"
 	"errors"
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -26,6 +28,7 @@ import (
 	"github.com/skytells-research/DNA/network/node/communication"
 	"github.com/skytells-research/DNA/network/node/consumer"
 	"github.com/skytells-research/DNA/network/node/core/ip"
+	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/services/openvpn/discovery/dto"
@@ -41,10 +44,13 @@ type testContext struct {
 	fakeConnectionFactory *connectionFactoryFake
 	connManager           *connectionManager
 	mockDialog            *mockDialog
+	dialogs               []*mockDialog
 	MockPaymentIssuer     *MockPaymentIssuer
 	stubPublisher         *StubPublisher
 	mockStatistics        consumer.SessionStatistics
 	fakeResolver          ip.Resolver
+	fakeKillSwitch        *killSwitchFake
+	fakeStorage           *storageFake
 	sync.RWMutex
 }
 
@@ -68,6 +74,8 @@ func (tc *testContext) SetupTest() {
 	defer tc.Unlock()
 
 	tc.stubPublisher = NewStubPublisher()
+	tc.dialogs = nil
+	tc.fakeStorage = newStorageFake()
 	dialogCreator := func(consumer, provider identity.Identity, contact market.Contact) (communication.Dialog, error) {
 		tc.Lock()
 		defer tc.Unlock()
@@ -75,6 +83,7 @@ func (tc *testContext) SetupTest() {
 			sessionID:   establishedSessionID,
 			paymentInfo: paymentInfo,
 		}
+		tc.dialogs = append(tc.dialogs, tc.mockDialog)
 		return tc.mockDialog, nil
 	}
 
@@ -126,37 +135,46 @@ func (tc *testContext) SetupTest() {
 		tc.fakeConnectionFactory.CreateConnection,
 		tc.stubPublisher,
 		ip.NewResolverMock("1.1.1.1"),
+		tc.fakeStorage,
+		nil,
 	)
+	tc.fakeKillSwitch = newKillSwitchFake()
+	tc.connManager.killSwitch = tc.fakeKillSwitch
+	tc.connManager.dnsConfigurator = &dnsConfiguratorFake{}
+	tc.connManager.probe = probeFake(time.Millisecond, nil)
 }
 
 func (tc *testContext) TestWhenNoConnectionIsMadeStatusIsNotConnected() {
-	assert.Exactly(tc.T(), statusNotConnected(), tc.connManager.Status())
+	assert.Exactly(tc.T(), statusNotConnected(), tc.connManager.Status(ID("unknown")))
+	assert.Empty(tc.T(), tc.connManager.List())
 }
 
 func (tc *testContext) TestOnConnectErrorStatusIsNotConnected() {
 	tc.fakeConnectionFactory.mockError = errors.New("fatal connection error")
 
-	assert.Error(tc.T(), tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
-	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status())
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.Error(tc.T(), err)
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
 }
 
 func (tc *testContext) TestWhenManagerMadeConnectionStatusReturnsConnectedStateAndSessionId() {
-	err := tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	assert.NoError(tc.T(), err)
-	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status())
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
 }
 
 func (tc *testContext) TestStatusReportsConnectingWhenConnectionIsInProgress() {
 	tc.fakeConnectionFactory.mockConnection.onStartReportStates = []fakeState{}
 
 	go func() {
-		tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+		tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	}()
 
 	waitABit()
 
-	assert.Equal(tc.T(), statusConnecting(), tc.connManager.Status())
-	tc.connManager.Disconnect()
+	id := tc.onlyConnectionID()
+	assert.Equal(tc.T(), statusConnecting(), tc.connManager.Status(id))
+	tc.connManager.Disconnect(id)
 }
 
 func (tc *testContext) TestStatusReportsNotConnected() {
@@ -166,16 +184,16 @@ func (tc *testContext) TestStatusReportsNotConnected() {
 		tc.fakeConnectionFactory.mockConnection.stopBlock = nil
 	}()
 
-	err := tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	assert.NoError(tc.T(), err)
-	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status())
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
 
 	go func() {
-		assert.NoError(tc.T(), tc.connManager.Disconnect())
+		assert.NoError(tc.T(), tc.connManager.Disconnect(id))
 	}()
 
 	waitABit()
-	assert.Equal(tc.T(), statusDisconnecting(), tc.connManager.Status())
+	assert.Equal(tc.T(), statusDisconnecting(), tc.connManager.Status(id))
 
 	tc.fakeConnectionFactory.mockConnection.stopBlock <- struct{}{}
 
@@ -183,57 +201,64 @@ func (tc *testContext) TestStatusReportsNotConnected() {
 	tc.fakeConnectionFactory.mockConnection.reportState(processExited)
 
 	waitABit()
-	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status())
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
 }
 
 func (tc *testContext) TestConnectResultsInAlreadyConnectedErrorWhenConnectionExists() {
-	assert.NoError(tc.T(), tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
-	assert.Equal(tc.T(), ErrAlreadyExists, tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	_, err = tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.Equal(tc.T(), ErrAlreadyExists, err)
 }
 
 func (tc *testContext) TestDisconnectReturnsErrorWhenNoConnectionExists() {
-	assert.Equal(tc.T(), ErrNoConnection, tc.connManager.Disconnect())
+	assert.Equal(tc.T(), ErrNoConnection, tc.connManager.Disconnect(ID("unknown")))
 }
 
 func (tc *testContext) TestReconnectingStatusIsReportedWhenOpenVpnGoesIntoReconnectingState() {
-	assert.NoError(tc.T(), tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
 	tc.fakeConnectionFactory.mockConnection.reportState(reconnectingState)
 	waitABit()
-	assert.Equal(tc.T(), statusReconnecting(), tc.connManager.Status())
+	assert.Equal(tc.T(), statusReconnecting(), tc.connManager.Status(id))
 }
 
 func (tc *testContext) TestDoubleDisconnectResultsInError() {
-	assert.NoError(tc.T(), tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
-	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status())
-	assert.NoError(tc.T(), tc.connManager.Disconnect())
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
 	waitABit()
-	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status())
-	assert.Equal(tc.T(), ErrNoConnection, tc.connManager.Disconnect())
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
+	assert.Equal(tc.T(), ErrNoConnection, tc.connManager.Disconnect(id))
 }
 
 func (tc *testContext) TestTwoConnectDisconnectCyclesReturnNoError() {
-	assert.NoError(tc.T(), tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
-	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status())
-	assert.NoError(tc.T(), tc.connManager.Disconnect())
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
 	waitABit()
-	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status())
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
 
-	assert.NoError(tc.T(), tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
-	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status())
-	assert.NoError(tc.T(), tc.connManager.Disconnect())
+	id, err = tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
 	waitABit()
-	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status())
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
 
 }
 
 func (tc *testContext) TestConnectFailsIfConnectionFactoryReturnsError() {
 	tc.fakeConnectionFactory.mockError = errors.New("failed to create connection instance")
-	assert.Error(tc.T(), tc.connManager.Connect(consumerID, activeProposal, ConnectParams{}))
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.Error(tc.T(), err)
 }
 
 func (tc *testContext) TestStatusIsConnectedWhenConnectCommandReturnsWithoutError() {
-	tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
-	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status())
+	id, _ := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
 }
 
 func (tc *testContext) TestConnectingInProgressCanBeCanceled() {
@@ -245,18 +270,68 @@ func (tc *testContext) TestConnectingInProgressCanBeCanceled() {
 	var err error
 	go func() {
 		defer connectWaiter.Done()
-		err = tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+		_, err = tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	}()
 
 	waitABit()
-	assert.Equal(tc.T(), statusConnecting(), tc.connManager.Status())
-	assert.NoError(tc.T(), tc.connManager.Disconnect())
+	id := tc.onlyConnectionID()
+	assert.Equal(tc.T(), statusConnecting(), tc.connManager.Status(id))
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
 
 	connectWaiter.Wait()
 
 	assert.Equal(tc.T(), ErrConnectionCancelled, err)
 }
 
+func (tc *testContext) TestConnectingCanBeCanceledByContext() {
+	tc.fakeConnectionFactory.mockConnection.onStartReportStates = []fakeState{}
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		waitABit()
+		cancel()
+	}()
+	id, err := tc.connManager.Connect(ctx, consumerID, activeProposal, ConnectParams{})
+
+	assert.Equal(tc.T(), ErrConnectionCancelled, err)
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
+}
+
+func (tc *testContext) TestConnectTimesOutWhenHandshakeTakesTooLong() {
+	tc.fakeConnectionFactory.mockConnection.onStartReportStates = []fakeState{}
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+
+	params := ConnectParams{Timeout: TimeoutParams{Handshake: time.Millisecond}}
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+
+	assert.Equal(tc.T(), ErrConnectionTimeout, err)
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
+}
+
+func (tc *testContext) TestConnectTimesOutWhenContextDeadlineExpires() {
+	tc.fakeConnectionFactory.mockConnection.onStartReportStates = []fakeState{}
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := tc.connManager.Connect(ctx, consumerID, activeProposal, ConnectParams{})
+
+	assert.Equal(tc.T(), ErrConnectionTimeout, err)
+	assert.Empty(tc.T(), tc.connManager.List())
+}
+
+func (tc *testContext) TestEstablishedConnectionOutlivesConnectContext() {
+	ctx, cancel := context.WithCancel(context.Background())
+	id, err := tc.connManager.Connect(ctx, consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+
+	cancel()
+	waitABit()
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+}
+
 func (tc *testContext) TestConnectMethodReturnsErrorIfConnectionExitsDuringConnect() {
 	tc.fakeConnectionFactory.mockConnection.onStartReportStates = []fakeState{}
 	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
@@ -266,7 +341,7 @@ func (tc *testContext) TestConnectMethodReturnsErrorIfConnectionExitsDuringConne
 	var err error
 	go func() {
 		defer connectWaiter.Done()
-		err = tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+		_, err = tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	}()
 	waitABit()
 	tc.fakeConnectionFactory.mockConnection.reportState(processExited)
@@ -275,7 +350,7 @@ func (tc *testContext) TestConnectMethodReturnsErrorIfConnectionExitsDuringConne
 }
 
 func (tc *testContext) Test_PaymentManager_WhenManagerMadeConnectionIsStarted() {
-	err := tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	waitABit()
 	assert.NoError(tc.T(), err)
 	assert.True(tc.T(), tc.MockPaymentIssuer.StartCalled())
@@ -283,7 +358,7 @@ func (tc *testContext) Test_PaymentManager_WhenManagerMadeConnectionIsStarted()
 
 func (tc *testContext) Test_PaymentManager_OnConnectErrorIsStopped() {
 	tc.fakeConnectionFactory.mockConnection.onStartReturnError = errors.New("fatal connection error")
-	err := tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	assert.Error(tc.T(), err)
 	assert.True(tc.T(), tc.MockPaymentIssuer.StopCalled())
 }
@@ -292,7 +367,7 @@ func (tc *testContext) Test_SessionEndPublished_OnConnectError() {
 	tc.stubPublisher.Clear()
 
 	tc.fakeConnectionFactory.mockConnection.onStartReturnError = errors.New("fatal connection error")
-	err := tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	assert.Error(tc.T(), err)
 
 	history := tc.stubPublisher.GetEventHistory()
@@ -328,7 +403,7 @@ func (tc *testContext) Test_ManagerSetsPaymentInfo() {
 		},
 		FreeCredit: 100,
 	}
-	err := tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	assert.Nil(tc.T(), err)
 	assert.Exactly(tc.T(), *paymentInfo, tc.MockPaymentIssuer.initialState)
 }
@@ -340,7 +415,7 @@ func (tc *testContext) Test_ManagerPublishesEvents() {
 		connectedState,
 	}
 
-	err := tc.connManager.Connect(consumerID, activeProposal, ConnectParams{})
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
 	assert.NoError(tc.T(), err)
 
 	waitABit()
@@ -350,12 +425,14 @@ func (tc *testContext) Test_ManagerPublishesEvents() {
 
 	for _, v := range history {
 		if v.calledWithTopic == StatisticsEventTopic {
-			event := v.calledWithArgs[0].(consumer.SessionStatistics)
-			assert.True(tc.T(), event.BytesReceived == tc.mockStatistics.BytesReceived)
-			assert.True(tc.T(), event.BytesSent == tc.mockStatistics.BytesSent)
+			event := v.calledWithArgs[0].(StatisticsEvent)
+			assert.Equal(tc.T(), id, event.ConnectionID)
+			assert.True(tc.T(), event.Stats.BytesReceived == tc.mockStatistics.BytesReceived)
+			assert.True(tc.T(), event.Stats.BytesSent == tc.mockStatistics.BytesSent)
 		}
 		if v.calledWithTopic == StateEventTopic {
 			event := v.calledWithArgs[0].(StateEvent)
+			assert.Equal(tc.T(), id, event.ConnectionID)
 			assert.Equal(tc.T(), Connected, event.State)
 			assert.Equal(tc.T(), consumerID, event.SessionInfo.ConsumerID)
 			assert.Equal(tc.T(), establishedSessionID, event.SessionInfo.SessionID)
@@ -364,6 +441,7 @@ func (tc *testContext) Test_ManagerPublishesEvents() {
 		}
 		if v.calledWithTopic == SessionEventTopic {
 			event := v.calledWithArgs[0].(SessionEvent)
+			assert.Equal(tc.T(), id, event.ConnectionID)
 			assert.Equal(tc.T(), SessionCreatedStatus, event.Status)
 			assert.Equal(tc.T(), consumerID, event.SessionInfo.ConsumerID)
 			assert.Equal(tc.T(), establishedSessionID, event.SessionInfo.SessionID)
@@ -373,6 +451,340 @@ func (tc *testContext) Test_ManagerPublishesEvents() {
 	}
 }
 
+func (tc *testContext) TestConnectionsToDifferentProvidersAreTrackedSeparately() {
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+	otherProposal := activeProposal
+	otherProposal.ProviderID = "fake-node-2"
+
+	firstID, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	secondID, err := tc.connManager.Connect(context.Background(), consumerID, otherProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	assert.NotEqual(tc.T(), firstID, secondID)
+
+	assert.Equal(
+		tc.T(),
+		map[ID]Status{
+			firstID:  statusConnected(establishedSessionID, activeProposal),
+			secondID: statusConnected(establishedSessionID, otherProposal),
+		},
+		tc.connManager.List(),
+	)
+
+	assert.NoError(tc.T(), tc.connManager.Disconnect(firstID))
+	waitABit()
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(firstID))
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, otherProposal), tc.connManager.Status(secondID))
+
+	_, err = tc.connManager.Statistics(firstID)
+	assert.Equal(tc.T(), ErrNoConnection, err)
+	stats, err := tc.connManager.Statistics(secondID)
+	assert.NoError(tc.T(), err)
+	assert.Equal(tc.T(), tc.mockStatistics, stats)
+
+	assert.NoError(tc.T(), tc.connManager.DisconnectAll())
+	assert.Empty(tc.T(), tc.connManager.List())
+}
+
+func (tc *testContext) TestConnectionIsReestablishedWhenReconnectIsEnabled() {
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+	params := ConnectParams{Reconnect: ReconnectParams{Enabled: true, InitialBackoff: time.Millisecond}}
+
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+	tc.stubPublisher.Clear()
+
+	tc.fakeConnectionFactory.mockConnection.reportState(processExited)
+	waitABit()
+
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
+	assert.Equal(tc.T(), []int{1}, reconnectAttempts(tc.stubPublisher))
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+}
+
+func (tc *testContext) TestDialogIsClosedOnDisconnect() {
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	assert.False(tc.T(), tc.createdDialogs()[0].isClosed())
+
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+	assert.True(tc.T(), tc.createdDialogs()[0].isClosed())
+}
+
+func (tc *testContext) TestDialogOfLostConnectionIsClosedOnReconnect() {
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+	params := ConnectParams{Reconnect: ReconnectParams{Enabled: true, InitialBackoff: time.Millisecond}}
+
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+
+	tc.fakeConnectionFactory.mockConnection.reportState(processExited)
+	waitABit()
+
+	dialogs := tc.createdDialogs()
+	if assert.Len(tc.T(), dialogs, 2) {
+		assert.True(tc.T(), dialogs[0].isClosed())
+		assert.False(tc.T(), dialogs[1].isClosed())
+	}
+
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+	for _, dialog := range tc.createdDialogs() {
+		assert.True(tc.T(), dialog.isClosed())
+	}
+}
+
+func (tc *testContext) createdDialogs() []*mockDialog {
+	tc.RLock()
+	defer tc.RUnlock()
+	return append([]*mockDialog{}, tc.dialogs...)
+}
+
+func (tc *testContext) TestReconnectGivesUpAfterMaxAttempts() {
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+	params := ConnectParams{Reconnect: ReconnectParams{
+		Enabled:        true,
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}}
+
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+	tc.stubPublisher.Clear()
+
+	tc.fakeConnectionFactory.mockError = errors.New("provider is gone")
+	tc.fakeConnectionFactory.mockConnection.reportState(processExited)
+	waitABit()
+
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
+	assert.Equal(tc.T(), []int{1, 2}, reconnectAttempts(tc.stubPublisher))
+}
+
+func (tc *testContext) TestDisconnectStopsReconnecting() {
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+	params := ConnectParams{Reconnect: ReconnectParams{Enabled: true, InitialBackoff: time.Hour}}
+
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+	tc.stubPublisher.Clear()
+
+	tc.fakeConnectionFactory.mockConnection.reportState(processExited)
+	waitABit()
+	assert.Equal(tc.T(), statusReconnecting(), tc.connManager.Status(id))
+
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+	waitABit()
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
+	assert.Empty(tc.T(), reconnectAttempts(tc.stubPublisher))
+}
+
+func (tc *testContext) TestKillSwitchIsEnabledUntilDisconnect() {
+	params := ConnectParams{AllowedNetworks: []string{"192.168.0.0/16"}}
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+
+	_, lan, _ := net.ParseCIDR("192.168.0.0/16")
+	assert.Equal(
+		tc.T(),
+		[]firewall.Tunnel{{
+			Interface:       "fake0",
+			ProviderIP:      net.ParseIP("1.2.3.4"),
+			AllowedNetworks: []net.IPNet{*lan},
+		}},
+		tc.fakeKillSwitch.Enabled(),
+	)
+
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+	assert.Empty(tc.T(), tc.fakeKillSwitch.Enabled())
+}
+
+func (tc *testContext) TestKillSwitchKeepsControlEndpointsReachableWhileReconnecting() {
+	tc.connManager.controlEndpoints = []string{"nats://broker.example:4222", "10.0.0.1:8080"}
+	tc.connManager.lookupHost = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("5.6.7.8")}, nil
+	}
+	tc.connManager.systemResolvers = func() ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.168.1.1")}, nil
+	}
+	tc.fakeConnectionFactory.mockConnection.onStopReportStates = []fakeState{}
+	params := ConnectParams{Reconnect: ReconnectParams{Enabled: true, InitialBackoff: time.Hour}}
+
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+
+	enabled := tc.fakeKillSwitch.Enabled()
+	if assert.Len(tc.T(), enabled, 1) {
+		assert.Equal(tc.T(), []string{"5.6.7.8/32", "10.0.0.1/32"}, networkStrings(enabled[0].AllowedNetworks))
+		assert.Empty(tc.T(), enabled[0].AllowedResolvers)
+	}
+
+	tc.fakeConnectionFactory.mockConnection.reportState(processExited)
+	waitABit()
+	assert.Equal(tc.T(), statusReconnecting(), tc.connManager.Status(id))
+
+	enabled = tc.fakeKillSwitch.Enabled()
+	if assert.Len(tc.T(), enabled, 1) {
+		assert.Equal(tc.T(), []string{"5.6.7.8/32", "10.0.0.1/32"}, networkStrings(enabled[0].AllowedNetworks))
+		assert.Equal(tc.T(), []net.IP{net.ParseIP("192.168.1.1")}, enabled[0].AllowedResolvers)
+	}
+
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+	assert.Empty(tc.T(), tc.fakeKillSwitch.Enabled())
+}
+
+func (tc *testContext) TestKillSwitchIsNotEnabledWhenDisabledInParams() {
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{DisableKillSwitch: true})
+	assert.NoError(tc.T(), err)
+	assert.Empty(tc.T(), tc.fakeKillSwitch.Enabled())
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+}
+
+func (tc *testContext) TestConnectFailsWhenKillSwitchCannotBeEnabled() {
+	tc.fakeKillSwitch.enableError = errors.New("iptables failed")
+
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.Error(tc.T(), err)
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
+}
+
+func (tc *testContext) TestConnectFailsOnInvalidAllowedNetwork() {
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{AllowedNetworks: []string{"192.168.0.1"}})
+	assert.Error(tc.T(), err)
+	assert.Empty(tc.T(), tc.connManager.List())
+}
+
+func (tc *testContext) TestExcludedRoutesAreAllowedByKillSwitch() {
+	params := ConnectParams{SplitTunnel: SplitTunnelParams{Exclude: []string{"1.1.1.1"}}}
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+
+	enabled := tc.fakeKillSwitch.Enabled()
+	assert.Len(tc.T(), enabled, 1)
+	assert.Equal(tc.T(), []string{"1.1.1.1/32"}, networkStrings(enabled[0].AllowedNetworks))
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+}
+
+func (tc *testContext) TestOnlyIncludedRoutesAreBlockedByKillSwitch() {
+	params := ConnectParams{SplitTunnel: SplitTunnelParams{Include: []string{"10.0.0.0/8"}}}
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+
+	enabled := tc.fakeKillSwitch.Enabled()
+	if assert.Len(tc.T(), enabled, 1) {
+		assert.Equal(tc.T(), []string{"10.0.0.0/8"}, networkStrings(enabled[0].RoutedNetworks))
+	}
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+}
+
+func (tc *testContext) TestQualityIsPublishedAndReportedInStatus() {
+	params := ConnectParams{Quality: QualityParams{Interval: time.Millisecond, ProbeTarget: "10.0.0.1:443", ProbeCount: 2}}
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+	waitABit()
+
+	quality := tc.connManager.Status(id).Quality
+	if assert.NotNil(tc.T(), quality) {
+		assert.Equal(tc.T(), time.Millisecond, quality.Latency)
+		assert.Zero(tc.T(), quality.Loss)
+		assert.False(tc.T(), quality.Degraded)
+	}
+
+	var events []QualityEvent
+	for _, v := range tc.stubPublisher.GetEventHistory() {
+		if v.calledWithTopic == QualityEventTopic {
+			events = append(events, v.calledWithArgs[0].(QualityEvent))
+		}
+	}
+	if assert.NotEmpty(tc.T(), events) {
+		assert.Equal(tc.T(), id, events[0].ConnectionID)
+	}
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+}
+
+func (tc *testContext) TestDegradedConnectionIsClosedWhenAskedTo() {
+	tc.connManager.probe = probeFake(0, errors.New("timeout"))
+	params := ConnectParams{Quality: QualityParams{Interval: time.Millisecond, ProbeTarget: "10.0.0.1:443", ProbeCount: 1, ReconnectOnDegraded: true}}
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+	waitABit()
+
+	assert.Equal(tc.T(), statusNotConnected(), tc.connManager.Status(id))
+}
+
+func (tc *testContext) TestConnectFailsOnInvalidQualityParams() {
+	maxLoss := 2.0
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{Quality: QualityParams{MaxLoss: &maxLoss}})
+	assert.Error(tc.T(), err)
+	assert.Empty(tc.T(), tc.connManager.List())
+}
+
+func (tc *testContext) TestEstablishedConnectionIsStoredUntilDisconnect() {
+	params := ConnectParams{DNS: DNSOptionSystem}
+	id, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+
+	stored := tc.fakeStorage.Stored()
+	if assert.Len(tc.T(), stored, 1) {
+		assert.Equal(tc.T(), id, stored[0].ID)
+		assert.Equal(tc.T(), consumerID, stored[0].ConsumerID)
+		assert.Equal(tc.T(), activeProposal, stored[0].Proposal)
+		assert.Equal(tc.T(), params, stored[0].Params)
+	}
+
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+	assert.Empty(tc.T(), tc.fakeStorage.Stored())
+}
+
+func (tc *testContext) TestFailedConnectionIsNotStored() {
+	tc.fakeConnectionFactory.mockError = errors.New("fatal connection error")
+
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.Error(tc.T(), err)
+	assert.Empty(tc.T(), tc.fakeStorage.Stored())
+}
+
+func (tc *testContext) TestClosedConnectionIsRestored() {
+	params := ConnectParams{DNS: DNSOptionSystem}
+	oldID, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, params)
+	assert.NoError(tc.T(), err)
+
+	assert.NoError(tc.T(), tc.connManager.Close())
+	assert.Empty(tc.T(), tc.connManager.List())
+	assert.Len(tc.T(), tc.fakeStorage.Stored(), 1)
+
+	assert.NoError(tc.T(), tc.connManager.Restore(context.Background()))
+	id := tc.onlyConnectionID()
+	assert.NotEqual(tc.T(), oldID, id)
+	assert.Equal(tc.T(), statusConnected(establishedSessionID, activeProposal), tc.connManager.Status(id))
+
+	stored := tc.fakeStorage.Stored()
+	if assert.Len(tc.T(), stored, 1) {
+		assert.Equal(tc.T(), id, stored[0].ID)
+		assert.Equal(tc.T(), params, stored[0].Params)
+	}
+	assert.NoError(tc.T(), tc.connManager.Disconnect(id))
+}
+
+func (tc *testContext) TestConnectionIsKeptStoredWhenRestoreFails() {
+	_, err := tc.connManager.Connect(context.Background(), consumerID, activeProposal, ConnectParams{})
+	assert.NoError(tc.T(), err)
+	assert.NoError(tc.T(), tc.connManager.Close())
+
+	tc.fakeConnectionFactory.mockError = errors.New("provider is gone")
+	assert.Error(tc.T(), tc.connManager.Restore(context.Background()))
+	assert.Empty(tc.T(), tc.connManager.List())
+	assert.Len(tc.T(), tc.fakeStorage.Stored(), 1)
+}
+
+func (tc *testContext) TestCleanupRemovesLeftovers() {
+	dnsConfigurator := &dnsConfiguratorFake{}
+	tc.connManager.dnsConfigurator = dnsConfigurator
+
+	assert.NoError(tc.T(), tc.connManager.Cleanup())
+	assert.True(tc.T(), tc.fakeKillSwitch.cleanupCalled)
+	assert.True(tc.T(), dnsConfigurator.cleanupCalled)
+}
+
 func TestConnectionManagerSuite(t *testing.T) {
 	suite.Run(t, new(testContext))
 }
@@ -383,6 +795,28 @@ func waitABit() {
 	time.Sleep(10 * time.Millisecond)
 }
 
+func (tc *testContext) onlyConnectionID() ID {
+	list := tc.connManager.List()
+	assert.Len(tc.T(), list, 1)
+	for id := range list {
+		return id
+	}
+	return ID("")
+}
+
+func reconnectAttempts(publisher *StubPublisher) []int {
+	var attempts []int
+	for _, v := range publisher.GetEventHistory() {
+		if v.calledWithTopic != StateEventTopic {
+			continue
+		}
+		if event := v.calledWithArgs[0].(StateEvent); event.Attempt > 0 {
+			attempts = append(attempts, event.Attempt)
+		}
+	}
+	return attempts
+}
+
 type fakeServiceDefinition struct{}
 
 func (fs *fakeServiceDefinition) GetLocation() market.Location { return market.Location{} }
@@ -0,0 +1,213 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	defaultQualityInterval = 30 * time.Second
+	defaultProbeCount      = 5
+	defaultProbeTimeout    = 5 * time.Second
+	defaultMaxLoss         = 0.5
+	defaultStaleHandshake  = 3 * time.Minute
+)
+
+// Quality holds the result of connection quality check
+type Quality struct {
+	// Latency is the average round trip time of successful probes
+	Latency time.Duration
+	// Jitter is the average difference between round trip times of consecutive successful probes
+	Jitter time.Duration
+	// Loss is the ratio of lost probes, from 0 to 1
+	Loss float64
+	// LastHandshake is zero unless connection reports handshakes with provider
+	LastHandshake time.Time
+	// HandshakeStale means that provider did not respond to handshakes for too long
+	HandshakeStale bool
+	// Degraded means that loss is too high or handshake is stale
+	Degraded bool
+	// Checked is the time of the check
+	Checked time.Time
+}
+
+// HandshakeReporter is implemented by connections which are able to tell the time of the last handshake with provider
+type HandshakeReporter interface {
+	LastHandshake() (time.Time, error)
+}
+
+// prober measures round trip time to the given target
+type prober func(ctx context.Context, target string, timeout time.Duration) (time.Duration, error)
+
+// tcpProbe measures how long it takes to establish TCP connection, which is a single round trip
+func tcpProbe(ctx context.Context, target string, timeout time.Duration) (time.Duration, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	started := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(started)
+	return rtt, conn.Close()
+}
+
+func (p QualityParams) validate() error {
+	if p.MaxLoss != nil && (*p.MaxLoss < 0 || *p.MaxLoss > 1) {
+		return errors.New("max loss has to be between 0 and 1")
+	}
+	if p.Interval < 0 || p.ProbeTimeout < 0 || p.StaleHandshake < 0 || p.ProbeCount < 0 {
+		return errors.New("quality params can not be negative")
+	}
+	if p.ProbeTarget != "" {
+		if _, _, err := net.SplitHostPort(p.ProbeTarget); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p QualityParams) interval() time.Duration {
+	if p.Interval == 0 {
+		return defaultQualityInterval
+	}
+	return p.Interval
+}
+
+func (p QualityParams) probeCount() int {
+	if p.ProbeCount == 0 {
+		return defaultProbeCount
+	}
+	return p.ProbeCount
+}
+
+func (p QualityParams) probeTimeout() time.Duration {
+	if p.ProbeTimeout == 0 {
+		return defaultProbeTimeout
+	}
+	return p.ProbeTimeout
+}
+
+func (p QualityParams) maxLoss() float64 {
+	if p.MaxLoss == nil {
+		return defaultMaxLoss
+	}
+	return *p.MaxLoss
+}
+
+func (p QualityParams) staleHandshake() time.Duration {
+	if p.StaleHandshake == 0 {
+		return defaultStaleHandshake
+	}
+	return p.StaleHandshake
+}
+
+// monitorQuality checks connection quality periodically until context is cancelled,
+// degraded connection is handled as a lost one if params ask for it
+func (instance *connectionInstance) monitorQuality(ctx context.Context, connection Connection, params QualityParams) {
+	ticker := time.NewTicker(params.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		quality := checkQuality(ctx, connection, params, instance.manager.probe)
+		if ctx.Err() != nil {
+			return
+		}
+
+		instance.setQuality(quality)
+		instance.manager.eventPublisher.Publish(QualityEventTopic, QualityEvent{
+			ConnectionID: instance.id,
+			Quality:      quality,
+		})
+
+		if quality.Degraded && params.ReconnectOnDegraded {
+			log.Warn(managerLogPrefix, "Connection ", instance.id, " degraded, loss: ", quality.Loss, ", stale handshake: ", quality.HandshakeStale)
+			instance.connectionLost(ctx)
+			return
+		}
+	}
+}
+
+// checkQuality probes the target of params, if it is given, and checks the last handshake of the connection, if it reports one.
+// Nothing is probed by default, so that no traffic is sent to third parties.
+func checkQuality(ctx context.Context, connection Connection, params QualityParams, probe prober) Quality {
+	quality := Quality{Checked: time.Now()}
+
+	if params.ProbeTarget != "" {
+		count := params.probeCount()
+		rtts := make([]time.Duration, 0, count)
+		for i := 0; i < count && ctx.Err() == nil; i++ {
+			rtt, err := probe(ctx, params.ProbeTarget, params.probeTimeout())
+			if err != nil {
+				log.Debug(managerLogPrefix, "Quality probe failed: ", err)
+				continue
+			}
+			rtts = append(rtts, rtt)
+		}
+		quality.Loss = float64(count-len(rtts)) / float64(count)
+		quality.Latency, quality.Jitter = latencyAndJitter(rtts)
+	}
+
+	if reporter, ok := connection.(HandshakeReporter); ok {
+		lastHandshake, err := reporter.LastHandshake()
+		if err != nil {
+			log.Warn(managerLogPrefix, "Failed to get last handshake: ", err)
+		} else {
+			quality.LastHandshake = lastHandshake
+			quality.HandshakeStale = !lastHandshake.IsZero() && quality.Checked.Sub(lastHandshake) > params.staleHandshake()
+		}
+	}
+
+	quality.Degraded = quality.Loss > params.maxLoss() || quality.HandshakeStale
+	return quality
+}
+
+func latencyAndJitter(rtts []time.Duration) (latency, jitter time.Duration) {
+	if len(rtts) == 0 {
+		return 0, 0
+	}
+
+	var total, deviation time.Duration
+	for i, rtt := range rtts {
+		total += rtt
+		if i > 0 {
+			diff := rtt - rtts[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			deviation += diff
+		}
+	}
+
+	latency = total / time.Duration(len(rtts))
+	if len(rtts) > 1 {
+		jitter = deviation / time.Duration(len(rtts)-1)
+	}
+	return latency, jitter
+}
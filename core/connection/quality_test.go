@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probeFake(rtt time.Duration, err error) prober {
+	return func(ctx context.Context, target string, timeout time.Duration) (time.Duration, error) {
+		return rtt, err
+	}
+}
+
+type handshakeConnection struct {
+	connectionMock
+	lastHandshake time.Time
+}
+
+func (hc *handshakeConnection) LastHandshake() (time.Time, error) {
+	return hc.lastHandshake, nil
+}
+
+func TestLatencyAndJitter(t *testing.T) {
+	latency, jitter := latencyAndJitter([]time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 15 * time.Millisecond})
+	assert.Equal(t, 15*time.Millisecond, latency)
+	assert.Equal(t, 7500*time.Microsecond, jitter)
+
+	latency, jitter = latencyAndJitter(nil)
+	assert.Zero(t, latency)
+	assert.Zero(t, jitter)
+}
+
+func TestCheckQualityCountsLostProbes(t *testing.T) {
+	probes := 0
+	probe := func(ctx context.Context, target string, timeout time.Duration) (time.Duration, error) {
+		assert.Equal(t, "10.0.0.1:443", target)
+		probes++
+		if probes%2 == 0 {
+			return 0, errors.New("timeout")
+		}
+		return 10 * time.Millisecond, nil
+	}
+
+	quality := checkQuality(context.Background(), &connectionMock{}, QualityParams{ProbeTarget: "10.0.0.1:443", ProbeCount: 4}, probe)
+	assert.Equal(t, 4, probes)
+	assert.Equal(t, 0.5, quality.Loss)
+	assert.Equal(t, 10*time.Millisecond, quality.Latency)
+	assert.False(t, quality.Degraded)
+
+	maxLoss := 0.25
+	quality = checkQuality(context.Background(), &connectionMock{}, QualityParams{ProbeTarget: "10.0.0.1:443", ProbeCount: 4, MaxLoss: &maxLoss}, probe)
+	assert.True(t, quality.Degraded)
+}
+
+func TestCheckQualityWithoutToleratedLoss(t *testing.T) {
+	probes := 0
+	probe := func(ctx context.Context, target string, timeout time.Duration) (time.Duration, error) {
+		probes++
+		if probes == 1 {
+			return 0, errors.New("timeout")
+		}
+		return 10 * time.Millisecond, nil
+	}
+
+	noLoss := 0.0
+	quality := checkQuality(context.Background(), &connectionMock{}, QualityParams{ProbeTarget: "10.0.0.1:443", ProbeCount: 5, MaxLoss: &noLoss}, probe)
+	assert.Equal(t, 0.2, quality.Loss)
+	assert.True(t, quality.Degraded)
+}
+
+func TestCheckQualityDetectsStaleHandshake(t *testing.T) {
+	connection := &handshakeConnection{lastHandshake: time.Now().Add(-time.Hour)}
+	quality := checkQuality(context.Background(), connection, QualityParams{}, probeFake(time.Millisecond, nil))
+	assert.True(t, quality.HandshakeStale)
+	assert.True(t, quality.Degraded)
+
+	connection.lastHandshake = time.Now()
+	quality = checkQuality(context.Background(), connection, QualityParams{}, probeFake(time.Millisecond, nil))
+	assert.False(t, quality.HandshakeStale)
+	assert.False(t, quality.Degraded)
+}
+
+func TestQualityParamsValidate(t *testing.T) {
+	assert.NoError(t, QualityParams{}.validate())
+	maxLoss, negativeLoss := 1.0, -0.1
+	assert.NoError(t, QualityParams{ProbeTarget: "1.1.1.1:443", MaxLoss: &maxLoss}.validate())
+	assert.Error(t, QualityParams{ProbeTarget: "1.1.1.1"}.validate())
+	assert.Error(t, QualityParams{MaxLoss: &negativeLoss}.validate())
+	assert.Error(t, QualityParams{Interval: -time.Second}.validate())
+}
+
+func TestCheckQualityDoesNotProbeWithoutTarget(t *testing.T) {
+	probe := func(ctx context.Context, target string, timeout time.Duration) (time.Duration, error) {
+		assert.Fail(t, "Nothing should be probed without target")
+		return 0, nil
+	}
+
+	quality := checkQuality(context.Background(), &connectionMock{}, QualityParams{}, probe)
+	assert.Zero(t, quality.Loss)
+	assert.Zero(t, quality.Latency)
+	assert.False(t, quality.Degraded)
+}
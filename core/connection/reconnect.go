@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// backoff calculates exponentially growing delays with random jitter
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func newBackoff(params ReconnectParams) *backoff {
+	b := &backoff{
+		initial: params.InitialBackoff,
+		max:     params.MaxBackoff,
+	}
+	if b.initial <= 0 {
+		b.initial = defaultInitialBackoff
+	}
+	if b.max <= 0 {
+		b.max = defaultMaxBackoff
+	}
+	if b.max < b.initial {
+		b.max = b.initial
+	}
+	return b
+}
+
+// next returns the delay before the next attempt.
+// Jitter keeps at least half of the delay, so that consumers which lost connection at the same time spread out.
+func (b *backoff) next() time.Duration {
+	delay := b.max
+	if b.attempt < 32 && b.initial<<b.attempt < b.max {
+		delay = b.initial << b.attempt
+	}
+	b.attempt++
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// reconnect re-establishes lost connection with the same consumer, proposal and params,
+// until it succeeds, runs out of attempts or time, or Disconnect is called
+func (instance *connectionInstance) reconnect(params ReconnectParams) {
+	lifetime := instance.lifetime
+	started := time.Now()
+	delays := newBackoff(params)
+
+	for attempt := 1; params.MaxAttempts <= 0 || attempt <= params.MaxAttempts; attempt++ {
+		delay := delays.next()
+		if params.MaxDuration > 0 && time.Since(started)+delay > params.MaxDuration {
+			log.Warn(managerLogPrefix, "Reconnect duration limit reached")
+			break
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-lifetime.Done():
+			return
+		}
+
+		instance.manager.eventPublisher.Publish(StateEventTopic, StateEvent{
+			ConnectionID: instance.id,
+			State:        Reconnecting,
+			SessionInfo:  instance.getSessionInfo(),
+			Attempt:      attempt,
+		})
+
+		err := instance.reconnectAttempt()
+		if err == nil {
+			log.Info(managerLogPrefix, "Connection ", instance.id, " reconnected on attempt ", attempt)
+			return
+		}
+		if lifetime.Err() != nil {
+			return
+		}
+		log.Warn(managerLogPrefix, "Reconnect attempt ", attempt, " failed: ", err)
+	}
+
+	log.Error(managerLogPrefix, "Giving up reconnecting")
+	logDisconnectError(instance.Disconnect())
+}
+
+func (instance *connectionInstance) reconnectAttempt() error {
+	instance.discoLock.Lock()
+	if instance.lifetime.Err() != nil {
+		instance.discoLock.Unlock()
+		return ErrConnectionCancelled
+	}
+	instance.ctx, instance.cancel = context.WithCancel(instance.lifetime)
+	instance.discoLock.Unlock()
+
+	err := instance.connect(context.Background(), instance.request)
+	if err != nil {
+		// also cleans up whatever was started after a concurrent Disconnect
+		instance.discoLock.Lock()
+		instance.cleanConnection()
+		instance.discoLock.Unlock()
+	}
+	return err
+}
+
+// allowReconnect lets DNS queries reach system resolvers while the tunnel is down, so that reconnect attempts
+// are able to resolve control endpoints. Addresses of the endpoints are allowed by the kill switch already.
+func (instance *connectionInstance) allowReconnect() {
+	instance.tunnelLock.Lock()
+	defer instance.tunnelLock.Unlock()
+
+	if instance.tunnel == nil || len(instance.manager.controlEndpoints) == 0 {
+		return
+	}
+
+	resolvers, err := instance.manager.systemResolvers()
+	if err != nil {
+		log.Warn(managerLogPrefix, "Failed to get system resolvers, control endpoints may not resolve while reconnecting: ", err)
+		return
+	}
+
+	tunnel := *instance.tunnel
+	tunnel.AllowedResolvers = resolvers
+	if err := instance.manager.killSwitch.Enable(tunnel); err != nil {
+		log.Warn(managerLogPrefix, "Failed to allow system resolvers while reconnecting: ", err)
+		return
+	}
+	instance.tunnel = &tunnel
+}
+
+// pinControlEndpoints resolves control endpoints, endpoints which fail to resolve keep previously pinned addresses.
+// It is called with tunnel lock held.
+func (instance *connectionInstance) pinControlEndpoints() []net.IPNet {
+	if instance.controlNetworks == nil {
+		instance.controlNetworks = make(map[string][]net.IPNet)
+	}
+
+	var networks []net.IPNet
+	for _, endpoint := range instance.manager.controlEndpoints {
+		host := endpointHost(endpoint)
+		if network := parseNetwork(host); network != nil {
+			networks = append(networks, *network)
+			continue
+		}
+
+		ips, err := instance.manager.lookupHost(host)
+		if err != nil {
+			log.Warn(managerLogPrefix, "Failed to resolve control endpoint ", endpoint, ": ", err)
+		} else {
+			pinned := make([]net.IPNet, 0, len(ips))
+			for _, ip := range ips {
+				pinned = append(pinned, hostNetwork(ip))
+			}
+			instance.controlNetworks[host] = pinned
+		}
+		networks = append(networks, instance.controlNetworks[host]...)
+	}
+	return networks
+}
+
+// endpointHost returns host of the endpoint given as URL, host:port or plain host
+func endpointHost(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		if u, err := url.Parse(endpoint); err == nil {
+			return u.Hostname()
+		}
+	}
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return host
+	}
+	return endpoint
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffGrowsExponentiallyUpToMax(t *testing.T) {
+	b := newBackoff(ReconnectParams{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second})
+
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		delay := b.next()
+		assert.True(t, delay >= expected/2, "delay %v is shorter than half of %v", delay, expected)
+		assert.True(t, delay <= expected, "delay %v is longer than %v", delay, expected)
+	}
+}
+
+func TestBackoffUsesDefaults(t *testing.T) {
+	b := newBackoff(ReconnectParams{})
+
+	assert.Equal(t, defaultInitialBackoff, b.initial)
+	assert.Equal(t, defaultMaxBackoff, b.max)
+}
+
+func TestEndpointHost(t *testing.T) {
+	assert.Equal(t, "broker.example", endpointHost("nats://broker.example:4222"))
+	assert.Equal(t, "discovery.example", endpointHost("https://discovery.example/v1"))
+	assert.Equal(t, "10.0.0.1", endpointHost("10.0.0.1:4222"))
+	assert.Equal(t, "::1", endpointHost("[::1]:4222"))
+	assert.Equal(t, "broker.example", endpointHost("broker.example"))
+}
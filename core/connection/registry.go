@@ -17,11 +17,18 @@
 
 package connection
 
+import log "github.com/cihub/seelog"
+
 // Factory represents a connection constructor
 type Factory interface {
 	Create(stateChannel StateChannel, statisticsChannel StatisticsChannel) (Connection, error)
 }
 
+// Cleaner is implemented by factories which are able to remove resources left by crashed process, i.e. network interfaces
+type Cleaner interface {
+	Cleanup() error
+}
+
 // Registry holds of all plugable connections
 type Registry struct {
 	creators map[string]Factory
@@ -48,3 +55,19 @@ func (registry *Registry) CreateConnection(serviceType string, stateChannel Stat
 
 	return factory.Create(stateChannel, statisticsChannel)
 }
+
+// Cleanup removes resources left by crashed process of all plugable connections which support it
+func (registry *Registry) Cleanup() error {
+	var lastErr error
+	for serviceType, factory := range registry.creators {
+		cleaner, ok := factory.(Cleaner)
+		if !ok {
+			continue
+		}
+		if err := cleaner.Cleanup(); err != nil {
+			log.Error(managerLogPrefix, "Failed to clean up leftovers of ", serviceType, " connection: ", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -76,3 +76,26 @@ func TestRegistry_CreateConnection_Existing(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, mock, connection)
 }
+
+type cleaningFactoryMock struct {
+	factoryMock
+	cleanupCalled bool
+}
+
+func (cfm *cleaningFactoryMock) Cleanup() error {
+	cfm.cleanupCalled = true
+	return nil
+}
+
+func TestRegistry_Cleanup(t *testing.T) {
+	cleaning := &cleaningFactoryMock{}
+	registry := Registry{
+		creators: map[string]Factory{
+			"fake-service":     &factoryMock{},
+			"cleaning-service": cleaning,
+		},
+	}
+
+	assert.NoError(t, registry.Cleanup())
+	assert.True(t, cleaning.cleanupCalled)
+}
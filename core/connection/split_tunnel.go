@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const defaultRoutesRefreshInterval = 5 * time.Minute
+
+// ErrNoIncludedRoutes indicates that none of included destinations could be resolved
+var ErrNoIncludedRoutes = errors.New("none of included destinations could be resolved")
+
+// Routes are destinations routed through and around the tunnel
+type Routes struct {
+	// Include is routed through the tunnel, all the traffic goes through the tunnel if empty
+	Include []net.IPNet
+	// Exclude is routed outside of the tunnel
+	Exclude []net.IPNet
+}
+
+// RoutesUpdater is implemented by connections which are able to change routes while connected
+type RoutesUpdater interface {
+	UpdateRoutes(routes Routes) error
+}
+
+// hostLookup resolves domain to IP addresses
+type hostLookup func(host string) ([]net.IP, error)
+
+func (p SplitTunnelParams) validate() error {
+	for _, destination := range p.destinations() {
+		if parseNetwork(destination) != nil {
+			continue
+		}
+		if destination == "" || strings.ContainsAny(destination, " \t/") {
+			return errors.New("invalid split tunnel destination: " + destination)
+		}
+	}
+	return nil
+}
+
+func (p SplitTunnelParams) destinations() []string {
+	destinations := make([]string, 0, len(p.Include)+len(p.Exclude))
+	destinations = append(destinations, p.Include...)
+	return append(destinations, p.Exclude...)
+}
+
+func (p SplitTunnelParams) hasDomains() bool {
+	for _, destination := range p.destinations() {
+		if parseNetwork(destination) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p SplitTunnelParams) refreshInterval() time.Duration {
+	if p.RefreshInterval <= 0 {
+		return defaultRoutesRefreshInterval
+	}
+	return p.RefreshInterval
+}
+
+// resolveRoutes resolves domains of split tunnel params, domains which fail to resolve are skipped
+func resolveRoutes(params SplitTunnelParams, lookup hostLookup) (Routes, error) {
+	routes := Routes{
+		Include: resolveDestinations(params.Include, lookup),
+		Exclude: resolveDestinations(params.Exclude, lookup),
+	}
+	if len(params.Include) > 0 && len(routes.Include) == 0 {
+		// routing everything instead of nothing would be the opposite of what was asked
+		return Routes{}, ErrNoIncludedRoutes
+	}
+	return routes, nil
+}
+
+func resolveDestinations(destinations []string, lookup hostLookup) []net.IPNet {
+	var networks []net.IPNet
+	for _, destination := range destinations {
+		if network := parseNetwork(destination); network != nil {
+			networks = append(networks, *network)
+			continue
+		}
+
+		ips, err := lookup(destination)
+		if err != nil {
+			log.Warn(managerLogPrefix, "Failed to resolve split tunnel destination ", destination, ": ", err)
+			continue
+		}
+		for _, ip := range ips {
+			networks = append(networks, hostNetwork(ip))
+		}
+	}
+	return networks
+}
+
+// parseNetwork parses CIDR or IP address, nil is returned for domains
+func parseNetwork(destination string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(destination); err == nil {
+		return network
+	}
+	if ip := net.ParseIP(destination); ip != nil {
+		network := hostNetwork(ip)
+		return &network
+	}
+	return nil
+}
+
+func hostNetwork(ip net.IP) net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func sameRoutes(a, b Routes) bool {
+	return sameNetworks(a.Include, b.Include) && sameNetworks(a.Exclude, b.Exclude)
+}
+
+func sameNetworks(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshRoutes periodically resolves domains again and updates routes of the connection until ctx is done
+func (instance *connectionInstance) refreshRoutes(ctx context.Context, connection Connection, routes Routes) {
+	updater, ok := connection.(RoutesUpdater)
+	if !ok {
+		log.Warn(managerLogPrefix, "Routes of split tunnel domains are not refreshed for service type: ", instance.request.proposal.ServiceType)
+		return
+	}
+
+	params := instance.request.params
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(params.SplitTunnel.refreshInterval()):
+		}
+
+		refreshed, err := resolveRoutes(params.SplitTunnel, instance.manager.lookupHost)
+		if err != nil {
+			log.Warn(managerLogPrefix, "Failed to refresh split tunnel routes: ", err)
+			continue
+		}
+		if sameRoutes(routes, refreshed) {
+			continue
+		}
+
+		if err := updater.UpdateRoutes(refreshed); err != nil {
+			log.Warn(managerLogPrefix, "Failed to update split tunnel routes: ", err)
+			continue
+		}
+		routes = refreshed
+
+		if !params.DisableKillSwitch {
+			if err := instance.enableKillSwitch(connection, routes); err != nil {
+				log.Warn(managerLogPrefix, "Failed to update kill switch with split tunnel routes: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lookupFake(host string) ([]net.IP, error) {
+	if host == "example.com" {
+		return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("2606:2800:220:1::1")}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestResolveRoutes(t *testing.T) {
+	routes, err := resolveRoutes(SplitTunnelParams{
+		Include: []string{"10.0.0.0/8", "example.com", "unknown.example"},
+		Exclude: []string{"1.1.1.1"},
+	}, lookupFake)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "93.184.216.34/32", "2606:2800:220:1::1/128"}, networkStrings(routes.Include))
+	assert.Equal(t, []string{"1.1.1.1/32"}, networkStrings(routes.Exclude))
+}
+
+func TestResolveRoutesFailsWhenNoIncludedDestinationIsResolved(t *testing.T) {
+	_, err := resolveRoutes(SplitTunnelParams{Include: []string{"unknown.example"}}, lookupFake)
+	assert.Equal(t, ErrNoIncludedRoutes, err)
+
+	routes, err := resolveRoutes(SplitTunnelParams{Exclude: []string{"unknown.example"}}, lookupFake)
+	assert.NoError(t, err)
+	assert.Empty(t, routes.Include)
+	assert.Empty(t, routes.Exclude)
+}
+
+func TestSplitTunnelParamsValidate(t *testing.T) {
+	assert.NoError(t, SplitTunnelParams{Include: []string{"10.0.0.0/8", "example.com"}, Exclude: []string{"fd00::1"}}.validate())
+	assert.Error(t, SplitTunnelParams{Include: []string{"10.0.0.0/33"}}.validate())
+	assert.Error(t, SplitTunnelParams{Exclude: []string{""}}.validate())
+	assert.Error(t, SplitTunnelParams{Exclude: []string{"example .com"}}.validate())
+}
+
+func TestSplitTunnelParamsHasDomains(t *testing.T) {
+	assert.False(t, SplitTunnelParams{Include: []string{"10.0.0.0/8"}, Exclude: []string{"1.1.1.1"}}.hasDomains())
+	assert.True(t, SplitTunnelParams{Exclude: []string{"example.com"}}.hasDomains())
+}
+
+func networkStrings(networks []net.IPNet) []string {
+	var result []string
+	for _, network := range networks {
+		result = append(result, network.String())
+	}
+	return result
+}
@@ -45,6 +45,8 @@ type Status struct {
 	State     State
 	SessionID session.ID
 	Proposal  market.ServiceProposal
+	// Quality is the result of the latest quality check, nil until connection is checked
+	Quality *Quality
 }
 
 func statusConnecting() Status {
@@ -52,7 +54,7 @@ func statusConnecting() Status {
 }
 
 func statusConnected(sessionID session.ID, proposal market.ServiceProposal) Status {
-	return Status{Connected, sessionID, proposal}
+	return Status{State: Connected, SessionID: sessionID, Proposal: proposal}
 }
 
 func statusNotConnected() Status {
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package connection
+
+import (
+	"context"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+)
+
+const activeConnectionsBucket = "active-connections"
+
+// Storage keeps active connections, so that they can be restored after restart
+type Storage interface {
+	Store(bucket string, data interface{}) error
+	GetAllFrom(bucket string, data interface{}) error
+	Delete(bucket string, data interface{}) error
+}
+
+// StoredConnection is the request of the connection which was active when node was stopped
+type StoredConnection struct {
+	ID         ID `storm:"id"`
+	ConsumerID identity.Identity
+	Proposal   market.ServiceProposal
+	Params     ConnectParams
+	Created    time.Time
+}
+
+// Restore reconnects connections which were active when node was stopped.
+// Connections which fail to reconnect are kept in storage and tried again on the next restore.
+func (manager *connectionManager) Restore(ctx context.Context) error {
+	var stored []StoredConnection
+	if err := manager.storage.GetAllFrom(activeConnectionsBucket, &stored); err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, connection := range stored {
+		log.Info(managerLogPrefix, "Restoring connection to provider: ", connection.Proposal.ProviderID)
+		id, err := manager.Connect(ctx, connection.ConsumerID, connection.Proposal, connection.Params)
+		if err != nil {
+			log.Error(managerLogPrefix, "Failed to restore connection to provider ", connection.Proposal.ProviderID, ": ", err)
+			lastErr = err
+			continue
+		}
+
+		log.Info(managerLogPrefix, "Connection ", connection.ID, " restored as ", id)
+		manager.forget(connection.ID)
+	}
+	return lastErr
+}
+
+// Cleanup removes kill switch rules and DNS configuration left by crashed process,
+// it has to be called before any connection is made
+func (manager *connectionManager) Cleanup() error {
+	var lastErr error
+	if err := manager.killSwitch.Cleanup(); err != nil {
+		log.Error(managerLogPrefix, "Failed to clean up kill switch: ", err)
+		lastErr = err
+	}
+	if err := manager.dnsConfigurator.Cleanup(); err != nil {
+		log.Error(managerLogPrefix, "Failed to clean up DNS configuration: ", err)
+		lastErr = err
+	}
+	return lastErr
+}
+
+// Close closes all connections, they are kept in storage to be restored on the next start
+func (manager *connectionManager) Close() error {
+	var lastErr error
+	for id := range manager.List() {
+		instance := manager.get(id)
+		if instance == nil {
+			continue
+		}
+
+		err := instance.close(false)
+		if err != nil && err != ErrNoConnection {
+			logDisconnectError(err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (manager *connectionManager) store(instance *connectionInstance) error {
+	connection := StoredConnection{
+		ID:         instance.id,
+		ConsumerID: instance.request.consumerID,
+		Proposal:   instance.request.proposal,
+		Params:     instance.request.params,
+		Created:    time.Now().UTC(),
+	}
+	return manager.storage.Store(activeConnectionsBucket, &connection)
+}
+
+func (manager *connectionManager) forget(id ID) {
+	if err := manager.storage.Delete(activeConnectionsBucket, &StoredConnection{ID: id}); err != nil {
+		log.Warn(managerLogPrefix, "Failed to remove stored connection ", id, ": ", err)
+	}
+}
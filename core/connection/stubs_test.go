@@ -18,12 +18,15 @@
 package connection
 
 import (
+	"context"
 	"errors"
+	"net"
 	"sync"
 
 	log "github.com/cihub/seelog"
 	"github.com/skytells-research/DNA/network/node/communication"
 	"github.com/skytells-research/DNA/network/node/consumer"
+	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/session"
 	"github.com/skytells-research/DNA/network/node/session/promise"
@@ -171,7 +174,7 @@ func (foc *connectionMock) GetConfig() (ConsumerConfig, error) {
 	return nil, nil
 }
 
-func (foc *connectionMock) Start(connectionParams ConnectOptions) error {
+func (foc *connectionMock) Start(ctx context.Context, connectionParams ConnectOptions) error {
 	foc.RLock()
 	defer foc.RUnlock()
 
@@ -201,6 +204,13 @@ func (foc *connectionMock) Stop() {
 	foc.fakeProcess.Done()
 }
 
+func (foc *connectionMock) Tunnel() firewall.Tunnel {
+	return firewall.Tunnel{
+		Interface:  "fake0",
+		ProviderIP: net.ParseIP("1.2.3.4"),
+	}
+}
+
 func (foc *connectionMock) reportState(state fakeState) {
 	foc.RLock()
 	defer foc.RUnlock()
@@ -241,6 +251,12 @@ func (md *mockDialog) assertNotClosed() {
 	}
 }
 
+func (md *mockDialog) isClosed() bool {
+	md.RLock()
+	defer md.RUnlock()
+	return md.closed
+}
+
 func (md *mockDialog) Close() error {
 	md.Lock()
 	defer md.Unlock()
@@ -291,3 +307,117 @@ func (md *mockDialog) Request(producer communication.RequestProducer) (responseP
 	}
 	return nil, ErrUnknownRequest
 }
+
+type killSwitchFake struct {
+	enableError   error
+	enabled       map[string]firewall.Tunnel
+	cleanupCalled bool
+	sync.Mutex
+}
+
+func newKillSwitchFake() *killSwitchFake {
+	return &killSwitchFake{enabled: make(map[string]firewall.Tunnel)}
+}
+
+func (ksf *killSwitchFake) Enable(tunnel firewall.Tunnel) error {
+	ksf.Lock()
+	defer ksf.Unlock()
+
+	if ksf.enableError != nil {
+		return ksf.enableError
+	}
+	ksf.enabled[tunnel.Interface] = tunnel
+	return nil
+}
+
+func (ksf *killSwitchFake) Disable(tunnel firewall.Tunnel) error {
+	ksf.Lock()
+	defer ksf.Unlock()
+
+	delete(ksf.enabled, tunnel.Interface)
+	return nil
+}
+
+func (ksf *killSwitchFake) Cleanup() error {
+	ksf.Lock()
+	defer ksf.Unlock()
+
+	ksf.enabled = make(map[string]firewall.Tunnel)
+	ksf.cleanupCalled = true
+	return nil
+}
+
+func (ksf *killSwitchFake) Enabled() []firewall.Tunnel {
+	ksf.Lock()
+	defer ksf.Unlock()
+
+	tunnels := make([]firewall.Tunnel, 0, len(ksf.enabled))
+	for _, tunnel := range ksf.enabled {
+		tunnels = append(tunnels, tunnel)
+	}
+	return tunnels
+}
+
+type storageFake struct {
+	connections map[ID]StoredConnection
+	storeError  error
+	sync.Mutex
+}
+
+func newStorageFake() *storageFake {
+	return &storageFake{connections: make(map[ID]StoredConnection)}
+}
+
+func (sf *storageFake) Store(bucket string, data interface{}) error {
+	sf.Lock()
+	defer sf.Unlock()
+
+	if sf.storeError != nil {
+		return sf.storeError
+	}
+	connection := data.(*StoredConnection)
+	sf.connections[connection.ID] = *connection
+	return nil
+}
+
+func (sf *storageFake) GetAllFrom(bucket string, data interface{}) error {
+	sf.Lock()
+	defer sf.Unlock()
+
+	connections := data.(*[]StoredConnection)
+	for _, connection := range sf.connections {
+		*connections = append(*connections, connection)
+	}
+	return nil
+}
+
+func (sf *storageFake) Delete(bucket string, data interface{}) error {
+	sf.Lock()
+	defer sf.Unlock()
+
+	delete(sf.connections, data.(*StoredConnection).ID)
+	return nil
+}
+
+func (sf *storageFake) Stored() []StoredConnection {
+	var connections []StoredConnection
+	_ = sf.GetAllFrom(activeConnectionsBucket, &connections)
+	return connections
+}
+
+type dnsConfiguratorFake struct {
+	cleanupCalled bool
+}
+
+func (dcf *dnsConfiguratorFake) Set(iface string, servers []net.IP) error {
+	return nil
+}
+
+func (dcf *dnsConfiguratorFake) Restore(iface string) error {
+	return nil
+}
+
+func (dcf *dnsConfiguratorFake) Cleanup() error {
+	dcf.cleanupCalled = true
+	return nil
+}
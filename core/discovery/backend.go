@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package discovery
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+)
+
+const (
+	// TypeAPI registers and lists proposals with the central discovery API
+	TypeAPI = "api"
+	// TypeMulticast announces and browses proposals on the local network
+	TypeMulticast = "multicast"
+	// TypeFile keeps proposals in the static JSON file
+	TypeFile = "file"
+)
+
+// NewBackend creates local discovery backend of the given type, proposals announced on the local network are signed by the signers.
+// Nil backend is returned for the central discovery API, which is the default.
+func NewBackend(backendType, multicastAddress, file string, signerFactory identity.SignerFactory) (*Backend, error) {
+	switch backendType {
+	case "", TypeAPI:
+		return nil, nil
+	case TypeMulticast:
+		if multicastAddress == "" {
+			multicastAddress = DefaultMulticastAddress
+		}
+		browser := NewMulticastBrowser(multicastAddress)
+		return &Backend{
+			finder:  browser,
+			factory: NewMulticastDiscoveryFactory(multicastAddress, DefaultAnnounceInterval, signerFactory),
+			browser: browser,
+		}, nil
+	case TypeFile:
+		if file == "" {
+			return nil, errors.New("discovery file is not given")
+		}
+		staticFile := NewStaticFile(file)
+		return &Backend{finder: staticFile, factory: staticFile.DiscoveryFactory()}, nil
+	}
+	return nil, fmt.Errorf("unknown discovery type: %q", backendType)
+}
+
+// Backend registers proposals of local services and lists proposals available without the central discovery API
+type Backend struct {
+	finder  Finder
+	factory service.DiscoveryFactory
+	browser *MulticastBrowser
+}
+
+// Start starts browsing proposals, if backend has to listen for them
+func (b *Backend) Start() error {
+	if b.browser == nil {
+		return nil
+	}
+	return b.browser.Start()
+}
+
+// Stop stops browsing proposals
+func (b *Backend) Stop() {
+	if b.browser != nil {
+		b.browser.Stop()
+	}
+}
+
+// DiscoveryFactory creates discoveries which register proposals of local services with the backend
+func (b *Backend) DiscoveryFactory() service.DiscoveryFactory {
+	return b.factory
+}
+
+// FindProposals lists proposals of the given provider and service type, empty values match all of them.
+// It lists proposals the same way as the central discovery API, so that consumers can use either.
+func (b *Backend) FindProposals(providerID string, serviceType string) ([]market.ServiceProposal, error) {
+	proposals, err := b.finder.Proposals()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]market.ServiceProposal, 0, len(proposals))
+	for _, proposal := range proposals {
+		if providerID != "" && proposal.ProviderID != providerID {
+			continue
+		}
+		if serviceType != "" && proposal.ServiceType != serviceType {
+			continue
+		}
+		found = append(found, proposal)
+	}
+	return found, nil
+}
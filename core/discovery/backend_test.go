@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skytells-research/DNA/network/node/market"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackend_SelectsBackendByType(t *testing.T) {
+	backend, err := NewBackend("", "", "", signerFactory)
+	assert.NoError(t, err)
+	assert.Nil(t, backend)
+
+	backend, err = NewBackend(TypeAPI, "", "", signerFactory)
+	assert.NoError(t, err)
+	assert.Nil(t, backend)
+
+	backend, err = NewBackend(TypeMulticast, "", "", signerFactory)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultMulticastAddress, backend.browser.address)
+	assert.NotNil(t, backend.DiscoveryFactory())
+
+	_, err = NewBackend(TypeFile, "", "", signerFactory)
+	assert.Error(t, err)
+
+	_, err = NewBackend("mdns", "", "", signerFactory)
+	assert.EqualError(t, err, `unknown discovery type: "mdns"`)
+}
+
+func TestBackend_FindProposalsRegisteredInFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static-discovery")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	backend, err := NewBackend(TypeFile, "", filepath.Join(dir, "proposals.json"), signerFactory)
+	assert.NoError(t, err)
+	assert.NoError(t, backend.Start())
+	defer backend.Stop()
+
+	wireguard := market.ServiceProposal{ProviderID: "0x1", ServiceType: "wireguard"}
+	openvpn := market.ServiceProposal{ProviderID: "0x2", ServiceType: "openvpn"}
+	backend.DiscoveryFactory()().Start(identityFake, wireguard)
+	backend.DiscoveryFactory()().Start(identityFake, openvpn)
+
+	proposals, err := backend.FindProposals("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{wireguard, openvpn}, proposals)
+
+	proposals, err = backend.FindProposals("0x2", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{openvpn}, proposals)
+
+	proposals, err = backend.FindProposals("", "wireguard")
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{wireguard}, proposals)
+
+	proposals, err = backend.FindProposals("0x2", "wireguard")
+	assert.NoError(t, err)
+	assert.Empty(t, proposals)
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package discovery
+
+import (
+	"sort"
+
+	"github.com/skytells-research/DNA/network/node/market"
+)
+
+const logPrefix = "[discovery-local] "
+
+// Finder lists proposals of services available without the central discovery API
+type Finder interface {
+	Proposals() ([]market.ServiceProposal, error)
+}
+
+func proposalKey(proposal market.ServiceProposal) string {
+	return proposal.ProviderID + "|" + proposal.ServiceType
+}
+
+func sortProposals(proposals []market.ServiceProposal) {
+	sort.Slice(proposals, func(i, j int) bool {
+		return proposalKey(proposals[i]) < proposalKey(proposals[j])
+	})
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+)
+
+const (
+	// DefaultMulticastAddress is the group which proposals are announced to on the local network
+	DefaultMulticastAddress = "239.255.77.77:47777"
+	// DefaultAnnounceInterval is the period of proposal announcements
+	DefaultAnnounceInterval = 10 * time.Second
+
+	maxAnnouncementSize = 65507
+)
+
+// announcement is the message sent to the multicast group
+type announcement struct {
+	Proposal market.ServiceProposal `json:"proposal"`
+	// TTL in seconds, proposal is forgotten if it is not announced again meanwhile
+	TTL int `json:"ttl"`
+	// Removed is set once the service is stopped
+	Removed bool `json:"removed,omitempty"`
+}
+
+// signedAnnouncement is the announcement signed by the provider identity, so that browsers can tell who sent it
+type signedAnnouncement struct {
+	Announcement json.RawMessage `json:"announcement"`
+	Signature    string          `json:"signature"`
+}
+
+// NewMulticastDiscoveryFactory creates discoveries which announce proposals to the multicast group
+func NewMulticastDiscoveryFactory(address string, interval time.Duration, signerFactory identity.SignerFactory) service.DiscoveryFactory {
+	return func() service.Discovery {
+		return NewMulticastAnnouncer(address, interval, signerFactory)
+	}
+}
+
+// NewMulticastAnnouncer creates discovery which announces proposal to the multicast group periodically,
+// announcements are signed by the provider identity.
+func NewMulticastAnnouncer(address string, interval time.Duration, signerFactory identity.SignerFactory) *MulticastAnnouncer {
+	if interval <= 0 {
+		interval = DefaultAnnounceInterval
+	}
+	return &MulticastAnnouncer{
+		address:       address,
+		interval:      interval,
+		signerFactory: signerFactory,
+		stop:          make(chan struct{}),
+	}
+}
+
+// MulticastAnnouncer announces service proposal on the local network
+type MulticastAnnouncer struct {
+	address       string
+	interval      time.Duration
+	signerFactory identity.SignerFactory
+	stop          chan struct{}
+	stopOnce      sync.Once
+	wg            sync.WaitGroup
+}
+
+// Start starts announcing the proposal until Stop is called
+func (ma *MulticastAnnouncer) Start(ownIdentity identity.Identity, proposal market.ServiceProposal) {
+	ma.wg.Add(1)
+	go ma.announce(ma.signerFactory(ownIdentity), proposal)
+}
+
+// Stop announces removal of the proposal and stops announcing it
+func (ma *MulticastAnnouncer) Stop() {
+	ma.stopOnce.Do(func() {
+		close(ma.stop)
+	})
+}
+
+// Wait blocks until announcing is stopped
+func (ma *MulticastAnnouncer) Wait() {
+	ma.wg.Wait()
+}
+
+func (ma *MulticastAnnouncer) announce(signer identity.Signer, proposal market.ServiceProposal) {
+	defer ma.wg.Done()
+
+	addr, err := net.ResolveUDPAddr("udp4", ma.address)
+	if err != nil {
+		log.Error(logPrefix, "Invalid multicast address: ", err)
+		return
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		log.Error(logPrefix, "Failed to open multicast connection: ", err)
+		return
+	}
+	defer conn.Close()
+
+	// proposal stays listed for a few missed announcements
+	ttl := int((3 * ma.interval).Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+	ticker := time.NewTicker(ma.interval)
+	defer ticker.Stop()
+
+	for {
+		ma.send(conn, signer, announcement{Proposal: proposal, TTL: ttl})
+
+		select {
+		case <-ticker.C:
+		case <-ma.stop:
+			ma.send(conn, signer, announcement{Proposal: proposal, Removed: true})
+			return
+		}
+	}
+}
+
+func (ma *MulticastAnnouncer) send(conn *net.UDPConn, signer identity.Signer, message announcement) {
+	data, err := signAnnouncement(signer, message)
+	if err != nil {
+		log.Error(logPrefix, "Failed to sign proposal announcement: ", err)
+		return
+	}
+	if _, err := conn.Write(data); err != nil {
+		log.Warn(logPrefix, "Failed to announce proposal: ", err)
+	}
+}
+
+func signAnnouncement(signer identity.Signer, message announcement) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(signedAnnouncement{Announcement: data, Signature: signature.Base64()})
+}
+
+// NewMulticastBrowser creates finder of proposals announced to the multicast group
+func NewMulticastBrowser(address string) *MulticastBrowser {
+	return &MulticastBrowser{
+		address:   address,
+		extractor: identity.NewExtractor(),
+		proposals: make(map[string]browsedProposal),
+		now:       time.Now,
+	}
+}
+
+// MulticastBrowser collects proposals announced on the local network.
+// Only announcements signed by the provider of the proposal are accepted.
+type MulticastBrowser struct {
+	address   string
+	extractor identity.Extractor
+	conn      *net.UDPConn
+	proposals map[string]browsedProposal
+	now       func() time.Time
+	lock      sync.Mutex
+}
+
+type browsedProposal struct {
+	proposal market.ServiceProposal
+	expires  time.Time
+}
+
+// Start joins the multicast group and collects announced proposals until Stop is called
+func (mb *MulticastBrowser) Start() error {
+	addr, err := net.ResolveUDPAddr("udp4", mb.address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		return err
+	}
+
+	mb.lock.Lock()
+	mb.conn = conn
+	mb.lock.Unlock()
+
+	go mb.serve(conn)
+	return nil
+}
+
+// Stop leaves the multicast group
+func (mb *MulticastBrowser) Stop() {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	if mb.conn != nil {
+		mb.conn.Close()
+		mb.conn = nil
+	}
+}
+
+// Proposals returns proposals which were announced recently and not removed
+func (mb *MulticastBrowser) Proposals() ([]market.ServiceProposal, error) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	now := mb.now()
+	proposals := make([]market.ServiceProposal, 0, len(mb.proposals))
+	for key, browsed := range mb.proposals {
+		if now.After(browsed.expires) {
+			delete(mb.proposals, key)
+			continue
+		}
+		proposals = append(proposals, browsed.proposal)
+	}
+	sortProposals(proposals)
+	return proposals, nil
+}
+
+func (mb *MulticastBrowser) serve(conn *net.UDPConn) {
+	buffer := make([]byte, maxAnnouncementSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			log.Info(logPrefix, "Stopped browsing proposals: ", err)
+			return
+		}
+		mb.handle(buffer[:n])
+	}
+}
+
+func (mb *MulticastBrowser) handle(data []byte) {
+	var signed signedAnnouncement
+	if err := json.Unmarshal(data, &signed); err != nil {
+		log.Warn(logPrefix, "Skipping invalid announcement: ", err)
+		return
+	}
+	var message announcement
+	if err := json.Unmarshal(signed.Announcement, &message); err != nil {
+		log.Warn(logPrefix, "Skipping invalid announcement: ", err)
+		return
+	}
+
+	signer, err := mb.extractor.Extract(signed.Announcement, identity.SignatureBase64(signed.Signature))
+	if err != nil {
+		log.Warn(logPrefix, "Skipping announcement with invalid signature: ", err)
+		return
+	}
+	if signer != identity.FromAddress(message.Proposal.ProviderID) {
+		log.Warn(logPrefix, "Skipping announcement of provider ", message.Proposal.ProviderID, " signed by ", signer.Address)
+		return
+	}
+
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	key := proposalKey(message.Proposal)
+	if message.Removed || message.TTL <= 0 {
+		delete(mb.proposals, key)
+		return
+	}
+	mb.proposals[key] = browsedProposal{
+		proposal: message.Proposal,
+		expires:  mb.now().Add(time.Duration(message.TTL) * time.Second),
+	}
+}
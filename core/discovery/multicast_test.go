@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package discovery
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+	"github.com/stretchr/testify/assert"
+)
+
+// keySigner signs messages the same way as identity keystore does
+type keySigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func newKeySigner(t *testing.T) *keySigner {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	return &keySigner{key: key}
+}
+
+func (ks *keySigner) Sign(message []byte) (identity.Signature, error) {
+	signature, err := crypto.Sign(crypto.Keccak256(message), ks.key)
+	return identity.SignatureBytes(signature), err
+}
+
+func (ks *keySigner) address() string {
+	return identity.FromAddress(crypto.PubkeyToAddress(ks.key.PublicKey).Hex()).Address
+}
+
+var signerFactory = func(id identity.Identity) identity.Signer {
+	return &identity.SignerFake{}
+}
+
+func announce(t *testing.T, browser *MulticastBrowser, signer identity.Signer, message announcement) {
+	data, err := signAnnouncement(signer, message)
+	assert.NoError(t, err)
+	browser.handle(data)
+}
+
+func TestMulticastBrowser_CollectsAnnouncedProposals(t *testing.T) {
+	now := time.Now()
+	browser := NewMulticastBrowser(DefaultMulticastAddress)
+	browser.now = func() time.Time { return now }
+
+	provider := newKeySigner(t)
+	wireguard := market.ServiceProposal{ProviderID: provider.address(), ServiceType: "wireguard"}
+	openvpn := market.ServiceProposal{ProviderID: provider.address(), ServiceType: "openvpn"}
+	announce(t, browser, provider, announcement{Proposal: wireguard, TTL: 30})
+	announce(t, browser, provider, announcement{Proposal: openvpn, TTL: 60})
+	announce(t, browser, provider, announcement{Proposal: wireguard, TTL: 30})
+	browser.handle([]byte("garbage"))
+
+	proposals, err := browser.Proposals()
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{openvpn, wireguard}, proposals)
+
+	now = now.Add(45 * time.Second)
+	proposals, err = browser.Proposals()
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{openvpn}, proposals)
+
+	announce(t, browser, provider, announcement{Proposal: openvpn, Removed: true})
+	proposals, err = browser.Proposals()
+	assert.NoError(t, err)
+	assert.Empty(t, proposals)
+}
+
+func TestMulticastBrowser_SkipsAnnouncementsNotSignedByProvider(t *testing.T) {
+	browser := NewMulticastBrowser(DefaultMulticastAddress)
+
+	provider := newKeySigner(t)
+	wireguard := market.ServiceProposal{ProviderID: provider.address(), ServiceType: "wireguard"}
+	announce(t, browser, provider, announcement{Proposal: wireguard, TTL: 30})
+
+	intruder := newKeySigner(t)
+	announce(t, browser, intruder, announcement{Proposal: wireguard, Removed: true})
+	forged := market.ServiceProposal{ProviderID: provider.address(), ServiceType: "openvpn"}
+	announce(t, browser, intruder, announcement{Proposal: forged, TTL: 30})
+	announce(t, browser, &identity.SignerFake{}, announcement{Proposal: forged, TTL: 30})
+
+	unsigned, err := json.Marshal(signedAnnouncement{Announcement: json.RawMessage(`{"proposal":{},"removed":true}`)})
+	assert.NoError(t, err)
+	browser.handle(unsigned)
+
+	proposals, err := browser.Proposals()
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{wireguard}, proposals)
+}
+
+func TestMulticastAnnouncer_StopsAnnouncing(t *testing.T) {
+	announcer := NewMulticastAnnouncer("127.0.0.1:47777", time.Millisecond, signerFactory)
+	announcer.Start(identityFake, market.ServiceProposal{ProviderID: "0x1", ServiceType: "noop"})
+
+	announcer.Stop()
+	announcer.Stop()
+	announcer.Wait()
+}
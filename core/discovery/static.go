@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package discovery
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+)
+
+// NewStaticFile creates discovery backend which keeps proposals in JSON file, i.e. on a shared drive or distributed by hand
+func NewStaticFile(path string) *StaticFile {
+	return &StaticFile{path: path}
+}
+
+// StaticFile lists proposals from JSON file and registers proposals of local services in it
+type StaticFile struct {
+	path string
+	lock sync.Mutex
+}
+
+// Proposals returns proposals listed in the file, missing file has no proposals
+func (sf *StaticFile) Proposals() ([]market.ServiceProposal, error) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	proposals, err := sf.read()
+	sortProposals(proposals)
+	return proposals, err
+}
+
+// DiscoveryFactory creates discoveries which register proposals in the file
+func (sf *StaticFile) DiscoveryFactory() service.DiscoveryFactory {
+	return func() service.Discovery {
+		return &staticDiscovery{file: sf, stopped: make(chan struct{})}
+	}
+}
+
+func (sf *StaticFile) update(proposal market.ServiceProposal, removed bool) error {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	proposals, err := sf.read()
+	if err != nil {
+		return err
+	}
+
+	key := proposalKey(proposal)
+	updated := make([]market.ServiceProposal, 0, len(proposals)+1)
+	for _, listed := range proposals {
+		if proposalKey(listed) != key {
+			updated = append(updated, listed)
+		}
+	}
+	if !removed {
+		updated = append(updated, proposal)
+	}
+	sortProposals(updated)
+
+	data, err := json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sf.path, data, 0644)
+}
+
+func (sf *StaticFile) read() ([]market.ServiceProposal, error) {
+	data, err := ioutil.ReadFile(sf.path)
+	if os.IsNotExist(err) {
+		return []market.ServiceProposal{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var proposals []market.ServiceProposal
+	err = json.Unmarshal(data, &proposals)
+	return proposals, err
+}
+
+// staticDiscovery keeps proposal of the running service in the file
+type staticDiscovery struct {
+	file     *StaticFile
+	proposal market.ServiceProposal
+	stopped  chan struct{}
+	stopOnce sync.Once
+	lock     sync.Mutex
+}
+
+func (sd *staticDiscovery) Start(ownIdentity identity.Identity, proposal market.ServiceProposal) {
+	sd.lock.Lock()
+	sd.proposal = proposal
+	sd.lock.Unlock()
+
+	if err := sd.file.update(proposal, false); err != nil {
+		log.Error(logPrefix, "Failed to register proposal in file: ", err)
+	}
+}
+
+func (sd *staticDiscovery) Stop() {
+	sd.stopOnce.Do(func() {
+		sd.lock.Lock()
+		proposal := sd.proposal
+		sd.lock.Unlock()
+
+		if err := sd.file.update(proposal, true); err != nil {
+			log.Error(logPrefix, "Failed to unregister proposal from file: ", err)
+		}
+		close(sd.stopped)
+	})
+}
+
+func (sd *staticDiscovery) Wait() {
+	<-sd.stopped
+}
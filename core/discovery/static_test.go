@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+	"github.com/stretchr/testify/assert"
+)
+
+var identityFake = identity.FromAddress("0x1")
+
+func TestStaticFile_RegistersProposalsOfRunningServices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static-discovery")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := NewStaticFile(filepath.Join(dir, "proposals.json"))
+	proposals, err := file.Proposals()
+	assert.NoError(t, err)
+	assert.Empty(t, proposals)
+
+	wireguard := market.ServiceProposal{ProviderID: "0x1", ServiceType: "wireguard"}
+	openvpn := market.ServiceProposal{ProviderID: "0x1", ServiceType: "openvpn"}
+	wireguardDiscovery := file.DiscoveryFactory()()
+	wireguardDiscovery.Start(identityFake, wireguard)
+	openvpnDiscovery := file.DiscoveryFactory()()
+	openvpnDiscovery.Start(identityFake, openvpn)
+
+	proposals, err = file.Proposals()
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{openvpn, wireguard}, proposals)
+
+	wireguardDiscovery.Stop()
+	wireguardDiscovery.Wait()
+	proposals, err = NewStaticFile(filepath.Join(dir, "proposals.json")).Proposals()
+	assert.NoError(t, err)
+	assert.Equal(t, []market.ServiceProposal{openvpn}, proposals)
+}
+
+func TestStaticFile_ProposalsFailsOnInvalidFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "static-discovery")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("not a json")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	_, err = NewStaticFile(file.Name()).Proposals()
+	assert.Error(t, err)
+}
@@ -18,8 +18,11 @@
 package node
 
 import (
+	"context"
+
 	log "github.com/cihub/seelog"
 	"github.com/skytells-research/DNA/network/node/core/connection"
+	"github.com/skytells-research/DNA/network/node/core/discovery"
 	"github.com/skytells-research/DNA/network/node/core/location"
 	"github.com/skytells-research/DNA/network/node/metrics"
 	"github.com/skytells-research/DNA/network/node/tequilapi"
@@ -38,6 +41,9 @@ func NewNode(
 	originalLocationCache location.Cache,
 	metricsSender *metrics.Sender,
 	natPinger NatPinger,
+	connectionCleaner connection.Cleaner,
+	connectionOptions OptionsConnection,
+	discoveryBackend *discovery.Backend,
 ) *Node {
 	return &Node{
 		connectionManager:     connectionManager,
@@ -45,6 +51,9 @@ func NewNode(
 		originalLocationCache: originalLocationCache,
 		metricsSender:         metricsSender,
 		natPinger:             natPinger,
+		connectionCleaner:     connectionCleaner,
+		connectionOptions:     connectionOptions,
+		discoveryBackend:      discoveryBackend,
 	}
 }
 
@@ -55,10 +64,16 @@ type Node struct {
 	originalLocationCache location.Cache
 	metricsSender         *metrics.Sender
 	natPinger             NatPinger
+	connectionCleaner     connection.Cleaner
+	connectionOptions     OptionsConnection
+	// discoveryBackend lists proposals without the central discovery API, nil if it is used
+	discoveryBackend *discovery.Backend
 }
 
 // Start starts sdna node (Tequilapi service, fetches location)
 func (node *Node) Start() error {
+	node.cleanupConnections()
+
 	go func() {
 		err := node.metricsSender.SendStartupEvent()
 		if err != nil {
@@ -73,6 +88,13 @@ func (node *Node) Start() error {
 		log.Info("Original country detected: ", originalLocation.Country)
 	}
 
+	if node.discoveryBackend != nil {
+		if err := node.discoveryBackend.Start(); err != nil {
+			return err
+		}
+		log.Info("Local proposal discovery started")
+	}
+
 	err = node.httpAPIServer.StartServing()
 	if err != nil {
 		return err
@@ -87,9 +109,32 @@ func (node *Node) Start() error {
 
 	go node.natPinger.Start()
 
+	if node.connectionOptions.AutoReconnect {
+		go node.restoreConnections()
+	}
+
 	return nil
 }
 
+// cleanupConnections removes kill switch rules, DNS configuration and interfaces left by unclean shutdown
+func (node *Node) cleanupConnections() {
+	if err := node.connectionManager.Cleanup(); err != nil {
+		log.Warn("Failed to clean up connection leftovers: ", err)
+	}
+	if err := node.connectionCleaner.Cleanup(); err != nil {
+		log.Warn("Failed to clean up connection interfaces: ", err)
+	}
+}
+
+// restoreConnections reconnects to providers which were connected when node was stopped
+func (node *Node) restoreConnections() {
+	if err := node.connectionManager.Restore(context.Background()); err != nil {
+		log.Warn("Failed to restore connections: ", err)
+		return
+	}
+	log.Info("Connections restored")
+}
+
 // Wait blocks until sdna node is stopped
 func (node *Node) Wait() error {
 	return node.httpAPIServer.Wait()
@@ -97,17 +142,16 @@ func (node *Node) Wait() error {
 
 // Kill stops sdna node
 func (node *Node) Kill() error {
-	err := node.connectionManager.Disconnect()
-	if err != nil {
-		switch err {
-		case connection.ErrNoConnection:
-			log.Info("No active connection - proceeding")
-		default:
-			return err
-		}
+	var err error
+	if node.connectionOptions.AutoReconnect {
+		err = node.connectionManager.Close()
 	} else {
-		log.Info("Connection closed")
+		err = node.connectionManager.DisconnectAll()
 	}
+	if err != nil {
+		return err
+	}
+	log.Info("Connections closed")
 
 	node.httpAPIServer.Stop()
 	log.Info("Api stopped")
@@ -115,5 +159,10 @@ func (node *Node) Kill() error {
 	node.natPinger.Stop()
 	log.Info("Nat pinger stopped")
 
+	if node.discoveryBackend != nil {
+		node.discoveryBackend.Stop()
+		log.Info("Local proposal discovery stopped")
+	}
+
 	return nil
 }
@@ -36,8 +36,9 @@ type Options struct {
 
 	Keystore OptionsKeystore
 
-	Openvpn  Openvpn
-	Location OptionsLocation
+	Openvpn    Openvpn
+	Location   OptionsLocation
+	Connection OptionsConnection
 	OptionsNetwork
 }
 
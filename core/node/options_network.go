@@ -17,6 +17,11 @@
 
 package node
 
+import (
+	"github.com/skytells-research/DNA/network/node/core/discovery"
+	"github.com/skytells-research/DNA/network/node/identity"
+)
+
 // OptionsNetwork describes possible parameters of network configuration
 type OptionsNetwork struct {
 	Testnet  bool
@@ -28,8 +33,19 @@ type OptionsNetwork struct {
 	DiscoveryAPIAddress string
 	BrokerAddress       string
 
+	// DiscoveryType selects where proposals are registered and listed: "api" (default), "multicast" or "file"
+	DiscoveryType             string
+	DiscoveryMulticastAddress string
+	DiscoveryFile             string
+
 	EtherClientRPC       string
 	EtherPaymentsAddress string
 
 	QualityOracle string
 }
+
+// DiscoveryBackend creates local discovery backend selected by options, nil backend means the central discovery API.
+// Proposals announced on the local network are signed by provider identities.
+func (options OptionsNetwork) DiscoveryBackend(signerFactory identity.SignerFactory) (*discovery.Backend, error) {
+	return discovery.NewBackend(options.DiscoveryType, options.DiscoveryMulticastAddress, options.DiscoveryFile, signerFactory)
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/identity"
+)
+
+var (
+	// ErrConsumerDenied indicates that session was rejected, because access policy does not allow the consumer
+	ErrConsumerDenied = errors.New("consumer identity is not allowed to use the service")
+	// ErrConsumerUnknown indicates that session was rejected, because access policy can not be checked without consumer identity
+	ErrConsumerUnknown = errors.New("consumer identity is required by service access policy")
+	// ErrInvalidInvite indicates that invite token is expired, issued for other consumer or not signed by an allowed issuer
+	ErrInvalidInvite = errors.New("invalid invite token")
+)
+
+// AccessPolicy decides which consumer identities may open sessions, empty policy allows everyone
+type AccessPolicy struct {
+	// Allow lists consumers allowed to open sessions, everyone who is not denied is allowed if it is empty
+	Allow []string `json:"allow"`
+	// Deny lists consumers which are never allowed, it takes precedence over allowlist and invites
+	Deny []string `json:"deny"`
+	// InviteIssuers lists identities, which signed invite tokens allow consumers missing from allowlist
+	InviteIssuers []string `json:"inviteIssuers"`
+}
+
+// ReadAccessPolicy reads access policy from JSON file
+func ReadAccessPolicy(path string) (AccessPolicy, error) {
+	var policy AccessPolicy
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+
+	err = json.Unmarshal(data, &policy)
+	return policy, err
+}
+
+// accessPolicyCheckInterval is how often access policy file is checked for changes
+const accessPolicyCheckInterval = 5 * time.Second
+
+func (policy AccessPolicy) restricted() bool {
+	return len(policy.Allow) > 0 || len(policy.Deny) > 0 || len(policy.InviteIssuers) > 0
+}
+
+// InviteToken allows consumer to open sessions until it expires.
+// Consumer passes it as "invite" field of session config.
+type InviteToken struct {
+	Consumer  string    `json:"consumer"`
+	Expires   time.Time `json:"expires"`
+	Signature string    `json:"signature"`
+}
+
+// NewInviteToken creates invite token of the consumer signed by the issuer
+func NewInviteToken(signer identity.Signer, consumerID identity.Identity, expires time.Time) (InviteToken, error) {
+	token := InviteToken{Consumer: consumerID.Address, Expires: expires.UTC()}
+	signature, err := signer.Sign(token.message())
+	if err != nil {
+		return token, err
+	}
+
+	token.Signature = signature.Base64()
+	return token, nil
+}
+
+func (token InviteToken) message() []byte {
+	return []byte(strings.ToLower(token.Consumer) + "|" + token.Expires.UTC().Format(time.RFC3339))
+}
+
+// accessControl enforces access policy, which can be replaced at runtime
+type accessControl struct {
+	policy    AccessPolicy
+	extractor identity.Extractor
+	lock      sync.RWMutex
+}
+
+func newAccessControl() *accessControl {
+	return &accessControl{extractor: identity.NewExtractor()}
+}
+
+func (ac *accessControl) setPolicy(policy AccessPolicy) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ac.policy = policy
+}
+
+// watchFile reloads policy from the file once it changes, until done is closed.
+// Policy which fails to load is logged and the previous one stays in use.
+func (ac *accessControl) watchFile(path, modified string, interval time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+		}
+
+		version := fileVersion(path)
+		if version == modified {
+			continue
+		}
+		modified = version
+
+		policy, err := ReadAccessPolicy(path)
+		if err != nil {
+			log.Warn("Failed to reload access policy from ", path, ", previous policy is in use: ", err)
+			continue
+		}
+		ac.setPolicy(policy)
+		logAccessPolicy("Access policy reloaded from "+path, policy)
+	}
+}
+
+// fileVersion describes file modification, it changes whenever the file is written or replaced
+func fileVersion(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return info.ModTime().String() + "|" + strconv.FormatInt(info.Size(), 10)
+}
+
+func (ac *accessControl) getPolicy() AccessPolicy {
+	ac.lock.RLock()
+	defer ac.lock.RUnlock()
+	return ac.policy
+}
+
+// authorize checks whether consumer, possibly with invite from the session config, may open a session of the service
+func (ac *accessControl) authorize(serviceID ID, consumerID string, config json.RawMessage) error {
+	err := ac.check(consumerID, config)
+	if err != nil {
+		log.Info("Service ", serviceID, " denied session to consumer ", consumerID, ": ", err)
+	} else if consumerID != "" {
+		log.Info("Service ", serviceID, " allowed session to consumer ", consumerID)
+	}
+	return err
+}
+
+func (ac *accessControl) check(consumerID string, config json.RawMessage) error {
+	policy := ac.getPolicy()
+	if !policy.restricted() {
+		return nil
+	}
+	if consumerID == "" {
+		return ErrConsumerUnknown
+	}
+
+	if containsAddress(policy.Deny, consumerID) {
+		return ErrConsumerDenied
+	}
+	if containsAddress(policy.Allow, consumerID) {
+		return nil
+	}
+
+	if len(policy.Allow) == 0 && len(policy.InviteIssuers) == 0 {
+		return nil
+	}
+
+	invite, ok := parseInvite(config)
+	if !ok {
+		return ErrConsumerDenied
+	}
+	return ac.checkInvite(policy, consumerID, invite)
+}
+
+func (ac *accessControl) checkInvite(policy AccessPolicy, consumerID string, invite InviteToken) error {
+	if !strings.EqualFold(invite.Consumer, consumerID) || time.Now().After(invite.Expires) {
+		return ErrInvalidInvite
+	}
+
+	issuer, err := ac.extractor.Extract(invite.message(), identity.SignatureBase64(invite.Signature))
+	if err != nil || !containsAddress(policy.InviteIssuers, issuer.Address) {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+func parseInvite(config json.RawMessage) (InviteToken, bool) {
+	var request struct {
+		Invite *InviteToken `json:"invite"`
+	}
+	if len(config) == 0 || json.Unmarshal(config, &request) != nil || request.Invite == nil {
+		return InviteToken{}, false
+	}
+	return *request.Invite, true
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, candidate := range addresses {
+		if strings.EqualFold(candidate, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAccessPolicy replaces access policy of all services, it applies to sessions requested afterwards.
+// Services started with access policy file keep enforcing the file.
+func (manager *Manager) SetAccessPolicy(policy AccessPolicy) {
+	manager.access.setPolicy(policy)
+	logAccessPolicy("Service access policy updated", policy)
+}
+
+func logAccessPolicy(message string, policy AccessPolicy) {
+	log.Info(message, ", allowed: ", len(policy.Allow), ", denied: ", len(policy.Deny), ", invite issuers: ", len(policy.InviteIssuers))
+}
+
+// serviceAccess returns access control of a service started with the given options.
+// Policy file of the options is loaded and watched for changes until done is closed, without it policy of all services applies.
+func (manager *Manager) serviceAccess(options Options, done <-chan struct{}) (*accessControl, error) {
+	limited, ok := options.(LimitedOptions)
+	if !ok || limited.SessionLimits().AccessPolicyFile == "" {
+		return manager.access, nil
+	}
+
+	path := limited.SessionLimits().AccessPolicyFile
+	modified := fileVersion(path)
+	policy, err := ReadAccessPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+
+	access := newAccessControl()
+	access.setPolicy(policy)
+	logAccessPolicy("Service access policy loaded from "+path, policy)
+	go access.watchFile(path, modified, manager.accessPolicyCheckInterval, done)
+	return access, nil
+}
+
+// AccessPolicy returns current access policy of all services.
+func (manager *Manager) AccessPolicy() AccessPolicy {
+	return manager.access.getPolicy()
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/stretchr/testify/assert"
+)
+
+type extractorFake struct {
+	issuer identity.Identity
+}
+
+func (ef *extractorFake) Extract(message []byte, signature identity.Signature) (identity.Identity, error) {
+	if !signature.EqualsTo(identity.SignatureBytes(append([]byte("signed"), message...))) {
+		return identity.Identity{}, errors.New("invalid signature")
+	}
+	return ef.issuer, nil
+}
+
+func newAccessControlFake(policy AccessPolicy, issuer string) *accessControl {
+	return &accessControl{policy: policy, extractor: &extractorFake{issuer: identity.FromAddress(issuer)}}
+}
+
+func inviteConfig(t *testing.T, consumer string, expires time.Time) json.RawMessage {
+	token, err := NewInviteToken(&identity.SignerFake{}, identity.FromAddress(consumer), expires)
+	assert.NoError(t, err)
+
+	config, err := json.Marshal(map[string]interface{}{"invite": token})
+	assert.NoError(t, err)
+	return config
+}
+
+func TestAccessControl_EmptyPolicyAllowsEveryone(t *testing.T) {
+	access := newAccessControlFake(AccessPolicy{}, "")
+
+	assert.NoError(t, access.authorize("service", "0x1", nil))
+	assert.NoError(t, access.authorize("service", "", nil))
+}
+
+func TestAccessControl_Denylist(t *testing.T) {
+	access := newAccessControlFake(AccessPolicy{Deny: []string{"0xDEAD"}}, "")
+
+	assert.Equal(t, ErrConsumerDenied, access.authorize("service", "0xdead", nil))
+	assert.NoError(t, access.authorize("service", "0x1", nil))
+	assert.Equal(t, ErrConsumerUnknown, access.authorize("service", "", nil))
+}
+
+func TestAccessControl_Allowlist(t *testing.T) {
+	access := newAccessControlFake(AccessPolicy{Allow: []string{"0x1", "0x2"}, Deny: []string{"0x2"}}, "")
+
+	assert.NoError(t, access.authorize("service", "0x1", nil))
+	assert.Equal(t, ErrConsumerDenied, access.authorize("service", "0x2", nil))
+	assert.Equal(t, ErrConsumerDenied, access.authorize("service", "0x3", nil))
+}
+
+func TestAccessControl_Invites(t *testing.T) {
+	access := newAccessControlFake(AccessPolicy{InviteIssuers: []string{"0xissuer"}}, "0xissuer")
+	expires := time.Now().Add(time.Hour)
+
+	assert.NoError(t, access.authorize("service", "0x1", inviteConfig(t, "0x1", expires)))
+	assert.Equal(t, ErrConsumerDenied, access.authorize("service", "0x1", json.RawMessage(`{"publicKey": "key"}`)))
+	assert.Equal(t, ErrInvalidInvite, access.authorize("service", "0x2", inviteConfig(t, "0x1", expires)))
+	assert.Equal(t, ErrInvalidInvite, access.authorize("service", "0x1", inviteConfig(t, "0x1", time.Now().Add(-time.Hour))))
+
+	forged := newAccessControlFake(AccessPolicy{InviteIssuers: []string{"0xissuer"}}, "0xother")
+	assert.Equal(t, ErrInvalidInvite, forged.authorize("service", "0x1", inviteConfig(t, "0x1", expires)))
+}
+
+func TestManager_AccessPolicyIsEnforcedBeforeProvideConfig(t *testing.T) {
+	manager := newSupervisedManager(&failingServices{}, &mockPublisher{})
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
+	assert.NoError(t, err)
+	instance := manager.Service(id)
+
+	manager.SetAccessPolicy(AccessPolicy{Allow: []string{"0x1"}})
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.Equal(t, ErrConsumerDenied, err)
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, instance.Sessions())
+
+	manager.SetAccessPolicy(AccessPolicy{})
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, manager.Stop(id))
+}
+
+func TestManager_AccessPolicyFileIsEnforcedAndReloaded(t *testing.T) {
+	file, err := ioutil.TempFile("", "access-policy")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	defer os.Remove(file.Name())
+	writePolicy := func(policy string, modified time.Time) {
+		assert.NoError(t, ioutil.WriteFile(file.Name(), []byte(policy), 0600))
+		assert.NoError(t, os.Chtimes(file.Name(), modified, modified))
+	}
+	writePolicy(`{"allow": ["0x1"]}`, time.Now().Add(-time.Hour))
+
+	manager := newSupervisedManager(&failingServices{}, &mockPublisher{})
+	manager.accessPolicyCheckInterval = time.Millisecond
+	options := limitedOptionsFake{limits: OptionsLimits{AccessPolicyFile: file.Name()}}
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, options, RestartPolicy{})
+	assert.NoError(t, err)
+	instance := manager.Service(id)
+
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.Equal(t, ErrConsumerDenied, err)
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.NoError(t, err)
+
+	manager.SetAccessPolicy(AccessPolicy{})
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.Equal(t, ErrConsumerDenied, err, "service keeps enforcing policy of its file")
+
+	writePolicy(`{"allow": ["0x2"]}`, time.Now().Add(-time.Minute))
+	waitForConsumerAllowed(t, instance, identity.FromAddress("0x2"))
+
+	writePolicy(`{"allow": [`, time.Now())
+	time.Sleep(10 * time.Millisecond)
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.NoError(t, err, "invalid policy keeps previous one in use")
+
+	assert.NoError(t, manager.Stop(id))
+}
+
+func TestManager_StartFailsWithoutAccessPolicyFile(t *testing.T) {
+	manager := newSupervisedManager(&failingServices{}, &mockPublisher{})
+	options := limitedOptionsFake{limits: OptionsLimits{AccessPolicyFile: "/nonexistent/access-policy.json"}}
+
+	_, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, options, RestartPolicy{})
+	assert.Error(t, err)
+	assert.Len(t, manager.List(), 0)
+}
+
+func waitForConsumerAllowed(t *testing.T, instance *Instance, consumerID identity.Identity) {
+	for i := 0; i < 100; i++ {
+		if _, _, err := instance.ProvideConfig(consumerID, json.RawMessage{}); err == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Fail(t, "Consumer expected to be allowed by reloaded access policy")
+}
+
+func TestReadAccessPolicy(t *testing.T) {
+	file, err := ioutil.TempFile("", "access-policy")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`{"allow": ["0x1"], "deny": ["0x2"], "inviteIssuers": ["0x3"]}`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	policy, err := ReadAccessPolicy(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, AccessPolicy{Allow: []string{"0x1"}, Deny: []string{"0x2"}, InviteIssuers: []string{"0x3"}}, policy)
+}
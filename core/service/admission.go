@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/session"
+)
+
+var (
+	// ErrMaxSessionsReached indicates that session was rejected, because service serves maximum number of sessions
+	ErrMaxSessionsReached = errors.New("service reached maximum number of sessions")
+	// ErrMaxConsumerSessionsReached indicates that session was rejected, because consumer has maximum number of sessions
+	ErrMaxConsumerSessionsReached = errors.New("consumer reached maximum number of sessions with the service")
+	// ErrBandwidthBudgetReached indicates that session was rejected, because service transferred all its bandwidth budget
+	ErrBandwidthBudgetReached = errors.New("service reached its bandwidth budget")
+	// ErrServiceDraining indicates that session was rejected, because service is being drained before stop
+	ErrServiceDraining = errors.New("service is draining and does not accept new sessions")
+	// ErrServiceNotRunning indicates that session was rejected, because failed service is being restarted or it was stopped
+	ErrServiceNotRunning = errors.New("service is not running")
+)
+
+// admission accepts sessions of a service within its limits
+type admission struct {
+	limits      OptionsLimits
+	sessions    int
+	consumers   map[string]int
+	transferred uint64
+	// idle is closed once draining admission has no sessions left
+	idle chan struct{}
+	lock sync.Mutex
+}
+
+func newAdmission(options Options) *admission {
+	a := &admission{consumers: make(map[string]int)}
+	if limited, ok := options.(LimitedOptions); ok {
+		a.limits = limited.SessionLimits()
+	}
+	return a
+}
+
+// admit reserves a session of the given consumer, empty consumer is not limited per consumer
+func (a *admission) admit(consumerID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.idle != nil {
+		return ErrServiceDraining
+	}
+	if a.limits.BandwidthBudget > 0 && datasize.BitSize(a.transferred)*datasize.Byte >= a.limits.BandwidthBudget {
+		return ErrBandwidthBudgetReached
+	}
+	if a.limits.MaxSessions > 0 && a.sessions >= a.limits.MaxSessions {
+		return ErrMaxSessionsReached
+	}
+	if consumerID != "" && a.limits.MaxSessionsPerConsumer > 0 && a.consumers[consumerID] >= a.limits.MaxSessionsPerConsumer {
+		return ErrMaxConsumerSessionsReached
+	}
+
+	a.sessions++
+	if consumerID != "" {
+		a.consumers[consumerID]++
+	}
+	return nil
+}
+
+func (a *admission) release(consumerID string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.sessions--
+	if a.idle != nil && a.sessions == 0 {
+		close(a.idle)
+	}
+	if consumerID == "" {
+		return
+	}
+	a.consumers[consumerID]--
+	if a.consumers[consumerID] <= 0 {
+		delete(a.consumers, consumerID)
+	}
+}
+
+// drain rejects new sessions, returned channel is closed once existing sessions end.
+// It fails if admission is draining already.
+func (a *admission) drain() (<-chan struct{}, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.idle != nil {
+		return nil, ErrAlreadyDraining
+	}
+	a.idle = make(chan struct{})
+	if a.sessions == 0 {
+		close(a.idle)
+	}
+	return a.idle, nil
+}
+
+func (a *admission) addTransferred(bytes uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.transferred += bytes
+}
+
+func (a *admission) count() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.sessions
+}
+
+// ProvideConfig provides session configuration to the given consumer by currently running service,
+// if access policy and service limits allow a new session. Rejection errors explain which check failed.
+func (i *Instance) ProvideConfig(consumerID identity.Identity, sessionConfig json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error) {
+	running := i.Service()
+	if running == nil {
+		return nil, nil, ErrServiceNotRunning
+	}
+	service, ok := running.(Service)
+	if !ok {
+		return nil, nil, ErrUnsupportedServiceType
+	}
+
+	if i.access != nil {
+		if err := i.access.authorize(i.id, consumerID.Address, sessionConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	admission := i.sessionAdmission()
+	if err := admission.admit(consumerID.Address); err != nil {
+		return nil, nil, err
+	}
+
+	config, destroy, err := provideServiceConfig(service, consumerID, sessionConfig)
+	if err != nil {
+		admission.release(consumerID.Address)
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return config, func() {
+		once.Do(func() {
+			if destroy != nil {
+				destroy()
+			}
+			admission.release(consumerID.Address)
+		})
+	}, nil
+}
+
+// provideServiceConfig negotiates session by the service, consumer is passed to services which record it
+func provideServiceConfig(service Service, consumerID identity.Identity, sessionConfig json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error) {
+	if consumerService, ok := service.(ConsumerService); ok {
+		return consumerService.ProvideConsumerConfig(consumerID, sessionConfig)
+	}
+	return service.ProvideConfig(sessionConfig)
+}
+
+// AddTransferred counts bytes transferred by sessions of the service towards its bandwidth budget.
+// Traffic recorded by the session recorder of the instance is counted already.
+func (i *Instance) AddTransferred(bytes uint64) {
+	i.sessionAdmission().addTransferred(bytes)
+}
+
+// Sessions returns number of sessions currently served by the service.
+func (i *Instance) Sessions() int {
+	return i.sessionAdmission().count()
+}
+
+func (i *Instance) sessionAdmission() *admission {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.admission == nil {
+		i.admission = newAdmission(i.options)
+	}
+	return i.admission
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+	"github.com/skytells-research/DNA/network/node/session"
+	"github.com/stretchr/testify/assert"
+)
+
+type limitedOptionsFake struct {
+	limits OptionsLimits
+}
+
+func (lof limitedOptionsFake) SessionLimits() OptionsLimits {
+	return lof.limits
+}
+
+func newLimitedInstance(limits OptionsLimits) *Instance {
+	return &Instance{
+		options: limitedOptionsFake{limits: limits},
+		service: &serviceFake{},
+	}
+}
+
+func TestInstance_ProvideConfigLimitsSessions(t *testing.T) {
+	instance := newLimitedInstance(OptionsLimits{MaxSessions: 2})
+
+	_, destroy, err := instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.NoError(t, err)
+	_, _, err = instance.ProvideConfig(identity.Identity{}, json.RawMessage{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, instance.Sessions())
+
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.Equal(t, ErrMaxSessionsReached, err)
+
+	destroy()
+	destroy()
+	assert.Equal(t, 1, instance.Sessions())
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.NoError(t, err)
+}
+
+func TestInstance_ProvideConfigLimitsConsumerSessions(t *testing.T) {
+	instance := newLimitedInstance(OptionsLimits{MaxSessionsPerConsumer: 1})
+	consumerID := identity.FromAddress("0x1")
+
+	_, destroy, err := instance.ProvideConfig(consumerID, json.RawMessage{})
+	assert.NoError(t, err)
+	_, _, err = instance.ProvideConfig(consumerID, json.RawMessage{})
+	assert.Equal(t, ErrMaxConsumerSessionsReached, err)
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.NoError(t, err)
+
+	destroy()
+	_, _, err = instance.ProvideConfig(consumerID, json.RawMessage{})
+	assert.NoError(t, err)
+}
+
+func TestInstance_ProvideConfigStopsAtBandwidthBudget(t *testing.T) {
+	instance := newLimitedInstance(OptionsLimits{BandwidthBudget: 1000 * datasize.Byte})
+
+	_, _, err := instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.NoError(t, err)
+
+	instance.AddTransferred(1000)
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.Equal(t, ErrBandwidthBudgetReached, err)
+}
+
+func TestInstance_ProvideConfigIsUnlimitedByDefault(t *testing.T) {
+	instance := &Instance{service: &serviceFake{}}
+
+	for i := 0; i < 10; i++ {
+		_, _, err := instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 10, instance.Sessions())
+}
+
+func TestManager_RecordedTrafficIsCountedTowardsBandwidthBudget(t *testing.T) {
+	service := &recordingServiceFake{serviceFake: serviceFake{mockProcess: make(chan struct{})}}
+	registry := NewRegistry()
+	registry.Register(serviceType, func(options Options) (Service, market.ServiceProposal, error) {
+		return service, proposalMock, nil
+	})
+	manager := NewManager(
+		registry,
+		MockDialogWaiterFactory,
+		MockDialogHandlerFactory,
+		MockDiscoveryFactoryFunc(&mockDiscovery{}),
+		&MockNATPinger{},
+		&mockPublisher{},
+		newStorageFake(),
+	)
+
+	options := limitedOptionsFake{limits: OptionsLimits{BandwidthBudget: 1000 * datasize.Byte, MaxSessionsPerConsumer: 1}}
+	id, err := manager.Start(identity.FromAddress("0x1"), serviceType, options, RestartPolicy{})
+	assert.NoError(t, err)
+	instance := manager.Service(id)
+
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.NoError(t, err)
+	service.recorder.Started("peer-key", "0x2")
+	service.recorder.Update("peer-key", SessionStats{BytesIn: 300, BytesOut: 400})
+
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.Equal(t, ErrMaxConsumerSessionsReached, err)
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x3"), json.RawMessage{})
+	assert.NoError(t, err)
+
+	service.recorder.Update("peer-key", SessionStats{BytesIn: 500, BytesOut: 500})
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x4"), json.RawMessage{})
+	assert.Equal(t, ErrBandwidthBudgetReached, err)
+
+	assert.NoError(t, manager.Stop(id))
+}
+
+func TestManager_BandwidthBudgetCountsPersistedTraffic(t *testing.T) {
+	storage := newStorageFake()
+	storage.transferred["service-1"] = Transferred{ServiceID: "service-1", Bytes: 900}
+	service := &recordingServiceFake{serviceFake: serviceFake{mockProcess: make(chan struct{})}}
+	registry := NewRegistry()
+	registry.Register(serviceType, func(options Options) (Service, market.ServiceProposal, error) {
+		return service, proposalMock, nil
+	})
+	manager := NewManager(
+		registry,
+		MockDialogWaiterFactory,
+		MockDialogHandlerFactory,
+		MockDiscoveryFactoryFunc(&mockDiscovery{}),
+		&MockNATPinger{},
+		&mockPublisher{},
+		storage,
+	)
+
+	options := limitedOptionsFake{limits: OptionsLimits{BandwidthBudget: 1000 * datasize.Byte}}
+	assert.NoError(t, manager.start("service-1", identity.FromAddress("0x1"), serviceType, options, RestartPolicy{}))
+	instance := manager.Service("service-1")
+
+	_, _, err := instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.NoError(t, err)
+	service.recorder.Started("peer-key", "0x2")
+	service.recorder.Update("peer-key", SessionStats{BytesIn: 60, BytesOut: 40})
+	assert.Equal(t, Transferred{ServiceID: "service-1", Bytes: 1000}, storage.transferred["service-1"])
+
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x3"), json.RawMessage{})
+	assert.Equal(t, ErrBandwidthBudgetReached, err)
+
+	assert.NoError(t, manager.Stop("service-1"))
+	assert.Empty(t, storage.transferred)
+}
+
+func TestSessionGrowth(t *testing.T) {
+	assert.Equal(t, uint64(300), sessionGrowth(700, 1000))
+	assert.Equal(t, uint64(0), sessionGrowth(1000, 1000))
+	assert.Equal(t, uint64(100), sessionGrowth(1000, 100), "reset counters are counted from zero")
+}
+
+type consumerServiceFake struct {
+	serviceFake
+	consumerID identity.Identity
+}
+
+func (service *consumerServiceFake) ProvideConsumerConfig(consumerID identity.Identity, sessionConfig json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error) {
+	service.consumerID = consumerID
+	return struct{}{}, func() {}, nil
+}
+
+func TestInstance_ProvideConfigPassesConsumerToService(t *testing.T) {
+	service := &consumerServiceFake{}
+	instance := &Instance{service: service}
+
+	_, _, err := instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.NoError(t, err)
+	assert.Equal(t, identity.FromAddress("0x1"), service.consumerID)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// ErrAlreadyDraining indicates that drain was requested for the service which is draining already
+var ErrAlreadyDraining = errors.New("service is already draining")
+
+// Drain withdraws service proposal from discovery and rejects new sessions,
+// service is stopped once existing sessions end or the deadline passes, whichever comes first.
+// Zero deadline waits for sessions to end without limit.
+// Drained service stays persisted, so that it is started again by Restore.
+func (manager *Manager) Drain(id ID, deadline time.Duration) error {
+	instance := manager.servicePool.Instance(id)
+	if instance == nil {
+		return ErrNoSuchInstance
+	}
+
+	idle, err := instance.sessionAdmission().drain()
+	if err != nil {
+		return err
+	}
+
+	instance.stopDiscovery()
+	manager.servicePool.setState(instance, Draining, nil)
+	log.Info("Service ", id, " is draining, sessions left: ", instance.Sessions())
+
+	go manager.stopDrained(instance, idle, deadline)
+	return nil
+}
+
+func (manager *Manager) stopDrained(instance *Instance, idle <-chan struct{}, deadline time.Duration) {
+	var timeout <-chan time.Time
+	if deadline > 0 {
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-idle:
+		log.Info("Service ", instance.id, " drained")
+	case <-timeout:
+		log.Warn("Service ", instance.id, " drain deadline passed, sessions left: ", instance.Sessions())
+	case <-instance.stopChannel():
+		return
+	}
+
+	if err := manager.servicePool.stop(instance); err != nil && err != ErrNoSuchInstance {
+		log.Error("Drained service stop failed: ", err)
+	}
+}
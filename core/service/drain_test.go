@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_DrainStopsServiceOnceSessionsEnd(t *testing.T) {
+	eventBus := &mockPublisher{}
+	manager := newSupervisedManager(&failingServices{}, eventBus)
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
+	assert.NoError(t, err)
+
+	instance := manager.Service(id)
+	_, destroy, err := instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, manager.Drain(id, 0))
+	assert.Equal(t, Draining, instance.State())
+	assert.Equal(t, ErrAlreadyDraining, manager.Drain(id, 0))
+
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x2"), json.RawMessage{})
+	assert.Equal(t, ErrServiceDraining, err)
+	assert.NotNil(t, manager.Service(id))
+
+	destroy()
+	assert.Equal(t, instance, waitForStop(t, eventBus))
+	assert.Len(t, manager.List(), 0)
+}
+
+func TestManager_DrainStopsServiceAfterDeadline(t *testing.T) {
+	eventBus := &mockPublisher{}
+	manager := newSupervisedManager(&failingServices{}, eventBus)
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
+	assert.NoError(t, err)
+
+	instance := manager.Service(id)
+	_, _, err = instance.ProvideConfig(identity.FromAddress("0x1"), json.RawMessage{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, manager.Drain(id, time.Millisecond))
+	assert.Equal(t, instance, waitForStop(t, eventBus))
+	assert.Equal(t, NotRunning, instance.State())
+}
+
+func TestManager_DrainKeepsServicePersisted(t *testing.T) {
+	eventBus := &mockPublisher{}
+	manager := newSupervisedManager(&failingServices{}, eventBus)
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, manager.Drain(id, 0))
+	waitForStop(t, eventBus)
+
+	definitions, err := manager.Definitions()
+	assert.NoError(t, err)
+	if assert.Len(t, definitions, 1) {
+		assert.Equal(t, id, definitions[0].ID)
+		assert.True(t, definitions[0].Enabled)
+	}
+}
+
+func TestManager_DrainFailsForUnknownService(t *testing.T) {
+	manager := newSupervisedManager(&failingServices{}, &mockPublisher{})
+	assert.Equal(t, ErrNoSuchInstance, manager.Drain("unknown", 0))
+}
@@ -20,6 +20,7 @@ package service
 import (
 	"encoding/json"
 	"errors"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/gofrs/uuid"
@@ -32,6 +33,19 @@ import (
 // StopTopic is used in event bus to announce that service was stopped
 const StopTopic = "Service stop"
 
+// StateTopic is used in event bus to announce every state change of the service lifecycle
+const StateTopic = "Service state"
+
+// StateEvent represents a state change of the service instance
+type StateEvent struct {
+	ID       ID
+	State    State
+	Time     time.Time
+	Restarts int
+	// Error is the failure which caused Failed state
+	Error error
+}
+
 var (
 	// ErrorLocation error indicates that action (i.e. disconnect)
 	ErrorLocation = errors.New("failed to detect service location")
@@ -46,6 +60,25 @@ type Service interface {
 	ProvideConfig(publicKey json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error)
 }
 
+// ConsumerService is implemented by services which record sessions of consumer identities,
+// sessions of such service are provided by it instead of ProvideConfig
+type ConsumerService interface {
+	ProvideConsumerConfig(consumerID identity.Identity, sessionConfig json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error)
+}
+
+// IdentifiedService is implemented by services which name their resources after the ID of their instance,
+// so that resources of several instances of the same service type don't clash
+type IdentifiedService interface {
+	SetInstanceID(id ID)
+}
+
+// identifyService gives the ID of the instance to the service, if it needs one
+func identifyService(service Service, id ID) {
+	if identified, ok := service.(IdentifiedService); ok {
+		identified.SetInstanceID(id)
+	}
+}
+
 // NATPinger defines Pinger interface for Provider
 type NATPinger interface {
 	BindPort(port int)
@@ -55,8 +88,13 @@ type NATPinger interface {
 // DialogWaiterFactory initiates communication channel which waits for incoming dialogs
 type DialogWaiterFactory func(providerID identity.Identity, serviceType string) (communication.DialogWaiter, error)
 
+// ConfigNegotiator provides session configuration to the consumer which requested the session
+type ConfigNegotiator interface {
+	ProvideConfig(consumerID identity.Identity, sessionConfig json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error)
+}
+
 // DialogHandlerFactory initiates instance which is able to handle incoming dialogs
-type DialogHandlerFactory func(market.ServiceProposal, session.ConfigNegotiator, string) communication.DialogHandler
+type DialogHandlerFactory func(market.ServiceProposal, ConfigNegotiator, string) communication.DialogHandler
 
 // DiscoveryFactory initiates instance which is able announce service discoverability
 type DiscoveryFactory func() Discovery
@@ -79,14 +117,18 @@ func NewManager(
 	discoveryFactory DiscoveryFactory,
 	natPinger NATPinger,
 	eventPublisher Publisher,
+	storage Storage,
 ) *Manager {
 	return &Manager{
-		serviceRegistry:      serviceRegistry,
-		servicePool:          NewPool(eventPublisher),
-		dialogWaiterFactory:  dialogWaiterFactory,
-		dialogHandlerFactory: dialogHandlerFactory,
-		discoveryFactory:     discoveryFactory,
-		natPinger:            natPinger,
+		storage:                   storage,
+		access:                    newAccessControl(),
+		accessPolicyCheckInterval: accessPolicyCheckInterval,
+		serviceRegistry:           serviceRegistry,
+		servicePool:               NewPool(eventPublisher),
+		dialogWaiterFactory:       dialogWaiterFactory,
+		dialogHandlerFactory:      dialogHandlerFactory,
+		discoveryFactory:          discoveryFactory,
+		natPinger:                 natPinger,
 	}
 }
 
@@ -101,71 +143,98 @@ type Manager struct {
 	discoveryFactory DiscoveryFactory
 
 	natPinger NATPinger
+
+	storage Storage
+	access  *accessControl
+	// accessPolicyCheckInterval is how often access policy files of services are checked for changes
+	accessPolicyCheckInterval time.Duration
 }
 
 // Start starts an instance of the given service type if knows one in service registry.
 // It passes the options to the start method of the service.
 // If an error occurs in the underlying service, the error is then returned.
-func (manager *Manager) Start(providerID identity.Identity, serviceType string, options Options) (id ID, err error) {
-	service, proposal, err := manager.serviceRegistry.Create(serviceType, options)
+// Failed service is restarted according to the given restart policy.
+// Started service is persisted and started again by Restore, unless it is stopped.
+func (manager *Manager) Start(providerID identity.Identity, serviceType string, options Options, policy RestartPolicy) (id ID, err error) {
+	id, err = generateID()
 	if err != nil {
 		return id, err
 	}
 
-	dialogWaiter, err := manager.dialogWaiterFactory(providerID, serviceType)
-	if err != nil {
+	if err = manager.start(id, providerID, serviceType, options, policy); err != nil {
 		return id, err
 	}
-	providerContact, err := dialogWaiter.Start()
+
+	definition, err := newDefinition(id, providerID, serviceType, options, policy)
+	if err == nil {
+		err = manager.storage.Store(definitionsBucket, &definition)
+	}
 	if err != nil {
-		return id, err
+		log.Warn("Failed to persist service ", id, ": ", err)
 	}
-	proposal.SetProviderContact(providerID, providerContact)
 
-	id, err = generateID()
+	return id, nil
+}
+
+func (manager *Manager) start(id ID, providerID identity.Identity, serviceType string, options Options, policy RestartPolicy) error {
+	service, proposal, err := manager.serviceRegistry.Create(serviceType, options)
 	if err != nil {
-		return id, err
+		return err
 	}
+	identifyService(service, id)
 
-	dialogHandler := manager.dialogHandlerFactory(proposal, service, string(id))
-	if err = dialogWaiter.ServeDialogs(dialogHandler); err != nil {
-		return id, err
+	dialogWaiter, err := manager.dialogWaiterFactory(providerID, serviceType)
+	if err != nil {
+		return err
+	}
+	providerContact, err := dialogWaiter.Start()
+	if err != nil {
+		return err
 	}
+	proposal.SetProviderContact(providerID, providerContact)
 
-	discovery := manager.discoveryFactory()
-	discovery.Start(providerID, proposal)
+	// access policy file of the service is watched until instance stops
+	stop := make(chan struct{})
+	access, err := manager.serviceAccess(options, stop)
+	if err != nil {
+		dialogWaiter.Stop()
+		return err
+	}
 
-	instance := Instance{
+	instance := &Instance{
 		id:           id,
-		state:        Starting,
+		serviceType:  serviceType,
 		options:      options,
 		service:      service,
 		proposal:     proposal,
 		dialogWaiter: dialogWaiter,
-		discovery:    discovery,
+		access:       access,
+		stop:         stop,
+		sessions:     NewSessionRecorder(manager.storage, manager.servicePool.eventPublisher, id, serviceType, options),
 	}
+	manager.countTransferred(instance)
+	recordSessions(service, instance.sessions)
 
-	manager.servicePool.Add(&instance)
+	manager.servicePool.setState(instance, Starting, nil)
 
-	go func() {
-		instance.state = Running
-		serveErr := service.Serve(providerID)
-		if serveErr != nil {
-			log.Error("Service serve failed: ", serveErr)
-		}
+	// instance negotiates configs by the service which is currently running, it changes on restarts
+	dialogHandler := manager.dialogHandlerFactory(proposal, instance, string(id))
+	if err = dialogWaiter.ServeDialogs(dialogHandler); err != nil {
+		instance.requestStop()
+		manager.servicePool.setState(instance, Failed, err)
+		manager.servicePool.setState(instance, NotRunning, nil)
+		return err
+	}
 
-		instance.state = NotRunning
+	instance.discovery = manager.discoveryFactory()
+	instance.discovery.Start(providerID, proposal)
 
-		// TODO: fix https://github.com/skytells-research/DNA/network/node/issues/855
-		stopErr := manager.servicePool.Stop(id)
-		if stopErr != nil {
-			log.Error("Service stop failed: ", stopErr)
-		}
+	manager.servicePool.Add(instance)
+	manager.servicePool.setState(instance, Running, nil)
 
-		discovery.Wait()
-	}()
+	go manager.supervise(providerID, instance, service, policy)
 
-	return id, nil
+	return nil
 }
 
 func generateID() (ID, error) {
@@ -181,18 +250,24 @@ func (manager *Manager) List() map[ID]*Instance {
 	return manager.servicePool.List()
 }
 
-// Kill stops all services.
+// Kill stops all services, they are kept persisted to be started again by Restore.
 func (manager *Manager) Kill() error {
 	return manager.servicePool.StopAll()
 }
 
-// Stop stops the service.
+// Stop stops the service, it is not started by Restore anymore.
 func (manager *Manager) Stop(id ID) error {
 	err := manager.servicePool.Stop(id)
 	if err != nil {
 		return err
 	}
 
+	if err := manager.storage.Delete(definitionsBucket, &Definition{ID: id}); err != nil {
+		log.Warn("Failed to remove persisted service ", id, ": ", err)
+	}
+	if err := manager.storage.Delete(transferredBucket, &Transferred{ServiceID: id}); err != nil {
+		log.Warn("Failed to remove data transferred by service ", id, ": ", err)
+	}
 	return nil
 }
 
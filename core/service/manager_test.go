@@ -19,7 +19,9 @@ package service
 
 import (
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -48,8 +50,9 @@ func TestManager_StartRemovesServiceFromPoolIfServiceCrashes(t *testing.T) {
 		discoveryFactory,
 		&MockNATPinger{},
 		&mockPublisher{},
+		newStorageFake(),
 	)
-	_, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{})
+	_, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
 	assert.Nil(t, err)
 
 	discovery.Wait()
@@ -73,8 +76,9 @@ func TestManager_StartDoesNotCrashIfStoppedByUser(t *testing.T) {
 		discoveryFactory,
 		&MockNATPinger{},
 		&mockPublisher{},
+		newStorageFake(),
 	)
-	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{})
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
 	assert.Nil(t, err)
 	err = manager.Stop(id)
 	assert.Nil(t, err)
@@ -82,6 +86,37 @@ func TestManager_StartDoesNotCrashIfStoppedByUser(t *testing.T) {
 	assert.Len(t, manager.servicePool.List(), 0)
 }
 
+func TestManager_StartGivesInstanceIDToService(t *testing.T) {
+	service := &identifiedServiceFake{serviceFake: serviceFake{mockProcess: make(chan struct{})}}
+	registry := NewRegistry()
+	registry.Register(serviceType, func(options Options) (Service, market.ServiceProposal, error) {
+		return service, proposalMock, nil
+	})
+
+	manager := NewManager(
+		registry,
+		MockDialogWaiterFactory,
+		MockDialogHandlerFactory,
+		MockDiscoveryFactoryFunc(&mockDiscovery{}),
+		&MockNATPinger{},
+		&mockPublisher{},
+		newStorageFake(),
+	)
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
+	assert.NoError(t, err)
+	assert.Equal(t, id, service.id)
+	assert.NoError(t, manager.Stop(id))
+}
+
+type identifiedServiceFake struct {
+	serviceFake
+	id ID
+}
+
+func (service *identifiedServiceFake) SetInstanceID(id ID) {
+	service.id = id
+}
+
 func TestManager_StopSendsEvent_SucceedsAndPublishesEvent(t *testing.T) {
 	registry := NewRegistry()
 	mockCopy := *serviceMock
@@ -100,9 +135,10 @@ func TestManager_StopSendsEvent_SucceedsAndPublishesEvent(t *testing.T) {
 		discoveryFactory,
 		&MockNATPinger{},
 		eventBus,
+		newStorageFake(),
 	)
 
-	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{})
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
 	assert.NoError(t, err)
 
 	err = manager.Stop(id)
@@ -112,3 +148,228 @@ func TestManager_StopSendsEvent_SucceedsAndPublishesEvent(t *testing.T) {
 	assert.Len(t, eventBus.publishedArgs, 1)
 	assert.Equal(t, &mockCopy, eventBus.publishedArgs[0].(*Instance).service)
 }
+
+func TestManager_PublishesLifecycleEvents(t *testing.T) {
+	eventBus := &mockPublisher{}
+	manager := newSupervisedManager(&failingServices{}, eventBus)
+
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, RestartPolicy{})
+	assert.NoError(t, err)
+	instance := manager.Service(id)
+	assert.Equal(t, Running, instance.State())
+
+	assert.NoError(t, manager.Stop(id))
+	assert.Equal(t, NotRunning, instance.State())
+
+	events := eventBus.StateEvents()
+	assert.Equal(t, []State{Starting, Running, Stopping, NotRunning}, states(events))
+	for _, event := range events {
+		assert.Equal(t, id, event.ID)
+		assert.False(t, event.Time.IsZero())
+		assert.NoError(t, event.Error)
+	}
+	assert.Equal(t, events[len(events)-1].Time, instance.StateChanged())
+}
+
+// failingServices creates services which fail to serve given number of times, the next ones serve until stopped
+type failingServices struct {
+	failures int
+	created  int
+	stops    int
+	lock     sync.Mutex
+}
+
+func (fs *failingServices) create(options Options) (Service, market.ServiceProposal, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.created++
+	if fs.failures < 0 || fs.created <= fs.failures {
+		return &stopCountingService{serviceFake{onStartReturnError: errors.New("serve failed")}, fs}, proposalMock, nil
+	}
+	return &stopCountingService{serviceFake{mockProcess: make(chan struct{})}, fs}, proposalMock, nil
+}
+
+func (fs *failingServices) counts() (created, stops int) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.created, fs.stops
+}
+
+type stopCountingService struct {
+	serviceFake
+	services *failingServices
+}
+
+func (service *stopCountingService) Stop() error {
+	service.services.lock.Lock()
+	service.services.stops++
+	service.services.lock.Unlock()
+	return service.serviceFake.Stop()
+}
+
+func newSupervisedManager(services *failingServices, eventBus *mockPublisher) *Manager {
+	registry := NewRegistry()
+	registry.Register(serviceType, services.create)
+
+	return NewManager(
+		registry,
+		MockDialogWaiterFactory,
+		MockDialogHandlerFactory,
+		MockDiscoveryFactoryFunc(&mockDiscovery{}),
+		&MockNATPinger{},
+		eventBus,
+		newStorageFake(),
+	)
+}
+
+func states(events []StateEvent) []State {
+	var states []State
+	for _, event := range events {
+		states = append(states, event.State)
+	}
+	return states
+}
+
+func waitForRestarts(t *testing.T, instance *Instance, expectedRestarts int) {
+	for i := 0; i < 100; i++ {
+		if instance.Restarts() == expectedRestarts && instance.State() == Running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Fail(t, "Service expected to be restarted")
+}
+
+func waitForStop(t *testing.T, eventBus *mockPublisher) *Instance {
+	for i := 0; i < 100; i++ {
+		if stopped := eventBus.Stopped(); len(stopped) > 0 {
+			return stopped[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.FailNow(t, "Service expected to be stopped")
+	return nil
+}
+
+func TestManager_StartRestartsFailedService(t *testing.T) {
+	eventBus := &mockPublisher{}
+	manager := newSupervisedManager(&failingServices{failures: 1}, eventBus)
+	policy := RestartPolicy{Enabled: true, InitialBackoff: time.Millisecond}
+
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, policy)
+	assert.NoError(t, err)
+
+	instance := manager.Service(id)
+	waitForRestarts(t, instance, 1)
+	assert.EqualError(t, instance.LastError(), "serve failed")
+
+	assert.NoError(t, manager.Stop(id))
+	assert.Len(t, manager.servicePool.List(), 0)
+	assert.Equal(t, []State{Starting, Running, Failed, Restarting, Running, Stopping, NotRunning}, states(eventBus.StateEvents()))
+}
+
+func TestManager_StartStopsCrashLoopingService(t *testing.T) {
+	eventBus := &mockPublisher{}
+	services := &failingServices{failures: -1}
+	manager := newSupervisedManager(services, eventBus)
+	policy := RestartPolicy{
+		Enabled:           true,
+		InitialBackoff:    time.Millisecond,
+		CrashLoopFailures: 3,
+	}
+
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, policy)
+	assert.NoError(t, err)
+
+	instance := waitForStop(t, eventBus)
+	assert.Len(t, manager.servicePool.List(), 0)
+	assert.Equal(t, 2, instance.Restarts())
+	created, stops := services.counts()
+	assert.Equal(t, created, stops)
+	assert.Equal(t, NotRunning, instance.State())
+
+	events := eventBus.StateEvents()
+	failure := events[len(events)-3]
+	assert.Equal(t, id, failure.ID)
+	assert.Equal(t, Failed, failure.State)
+	assert.Equal(t, 2, failure.Restarts)
+	assert.Equal(t, instance.LastError(), failure.Error)
+	assert.Equal(t, []State{Stopping, NotRunning}, states(events[len(events)-2:]))
+}
+
+func TestManager_StopDuringRestartBackoffDoesNotStopFailedServiceAgain(t *testing.T) {
+	eventBus := &mockPublisher{}
+	services := &failingServices{failures: -1}
+	manager := newSupervisedManager(services, eventBus)
+	policy := RestartPolicy{Enabled: true, InitialBackoff: time.Hour}
+
+	id, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, policy)
+	assert.NoError(t, err)
+
+	instance := manager.Service(id)
+	for i := 0; i < 100 && instance.State() != Restarting; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, Restarting, instance.State())
+	assert.Nil(t, instance.Service())
+	_, _, err = instance.ProvideConfig(identity.Identity{}, nil)
+	assert.Equal(t, ErrServiceNotRunning, err)
+
+	assert.NoError(t, manager.Stop(id))
+	created, stops := services.counts()
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 1, stops)
+}
+
+func TestManager_StartStopsServiceAfterMaxRestarts(t *testing.T) {
+	eventBus := &mockPublisher{}
+	manager := newSupervisedManager(&failingServices{failures: -1}, eventBus)
+	policy := RestartPolicy{
+		Enabled:        true,
+		MaxRestarts:    1,
+		InitialBackoff: time.Millisecond,
+	}
+
+	_, err := manager.Start(identity.FromAddress(proposalMock.ProviderID), serviceType, struct{}{}, policy)
+	assert.NoError(t, err)
+
+	instance := waitForStop(t, eventBus)
+	assert.Len(t, manager.servicePool.List(), 0)
+	assert.Equal(t, 1, instance.Restarts())
+}
+
+func TestRestartTracker_BacksOffAndDetectsCrashLoop(t *testing.T) {
+	tracker := newRestartTracker(RestartPolicy{
+		Enabled:           true,
+		InitialBackoff:    time.Second,
+		MaxBackoff:        3 * time.Second,
+		CrashLoopFailures: 4,
+		CrashLoopWindow:   time.Minute,
+	})
+	now := time.Now()
+
+	delay, err := tracker.failed(now, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, delay)
+
+	delay, err = tracker.failed(now.Add(time.Second), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, delay)
+
+	delay, err = tracker.failed(now.Add(2*time.Second), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*time.Second, delay)
+
+	// long running service resets backoff and old failures leave crash loop window
+	delay, err = tracker.failed(now.Add(2*time.Minute), 2*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, delay)
+
+	for i := 0; i < 2; i++ {
+		_, err = tracker.failed(now.Add(2*time.Minute), 0)
+		assert.NoError(t, err)
+	}
+	_, err = tracker.failed(now.Add(2*time.Minute), 0)
+	assert.Equal(t, ErrCrashLoop, err)
+}
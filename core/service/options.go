@@ -17,6 +17,8 @@
 
 package service
 
+import "github.com/skytells-research/DNA/network/node/datasize"
+
 // OptionsIdentity describes identity which is required to start a service
 type OptionsIdentity struct {
 	Identity   string
@@ -25,3 +27,31 @@ type OptionsIdentity struct {
 
 // Options represents any type of options for pluggable service
 type Options interface{}
+
+// OptionsLimits describes which sessions a service accepts, zero values mean no limit
+type OptionsLimits struct {
+	// MaxSessions limits number of concurrent sessions
+	MaxSessions int `json:"maxSessions"`
+	// MaxSessionsPerConsumer limits number of concurrent sessions of a single consumer identity
+	MaxSessionsPerConsumer int `json:"maxSessionsPerConsumer"`
+	// BandwidthBudget limits data transferred by all sessions, no new sessions are accepted once it is reached.
+	// It counts data transferred since the service was first started, across its restarts.
+	BandwidthBudget datasize.BitSize `json:"bandwidthBudget"`
+	// SessionBandwidth limits traffic of every session
+	SessionBandwidth OptionsBandwidth `json:"sessionBandwidth"`
+	// SessionQuota limits data transferred by every session in both directions, session is disconnected once it is reached
+	SessionQuota datasize.BitSize `json:"sessionQuota"`
+	// AccessPolicyFile is JSON file of access policy enforced instead of the policy of all services, it is reloaded once it changes
+	AccessPolicyFile string `json:"accessPolicyFile"`
+}
+
+// OptionsBandwidth describes bandwidth available to a session in bits per second, zero values mean no limit
+type OptionsBandwidth struct {
+	Upload   datasize.BitSize `json:"upload"`
+	Download datasize.BitSize `json:"download"`
+}
+
+// LimitedOptions is implemented by options of services which limit accepted sessions
+type LimitedOptions interface {
+	SessionLimits() OptionsLimits
+}
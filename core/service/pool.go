@@ -20,6 +20,7 @@ package service
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/skytells-research/DNA/network/node/communication"
 	"github.com/skytells-research/DNA/network/node/market"
@@ -67,62 +68,89 @@ func (p *Pool) Add(instance *Instance) {
 func (p *Pool) Del(id ID) {
 	p.Lock()
 	defer p.Unlock()
-	p.del(id)
-}
 
-func (p *Pool) del(id ID) {
 	delete(p.instances, id)
 }
 
 // ErrNoSuchInstance represents the error when we're stopping an instance that does not exist
 var ErrNoSuchInstance = errors.New("no such instance")
 
-// Stop kills all sub-resources of instance
+// Stop kills all sub-resources of instance.
+// Pool is not locked while the instance stops, so that subscribers of its events are able to list the pool.
 func (p *Pool) Stop(id ID) error {
-	p.Lock()
-	defer p.Unlock()
-	return p.stop(id)
+	instance := p.Instance(id)
+	if instance == nil {
+		return ErrNoSuchInstance
+	}
+	return p.stop(instance)
 }
 
-func (p *Pool) stop(id ID) error {
-	instance, ok := p.instances[id]
-	if !ok {
+func (p *Pool) stop(instance *Instance) error {
+	// concurrent stop of the same instance is reported as if it was stopped already
+	if !instance.requestStop() {
 		return ErrNoSuchInstance
 	}
+	p.setState(instance, Stopping, nil)
 
 	errStop := utils.ErrorCollection{}
-	if instance.discovery != nil {
-		instance.discovery.Stop()
-	}
+	instance.stopDiscovery()
 	if instance.dialogWaiter != nil {
 		errStop.Add(instance.dialogWaiter.Stop())
 	}
-	if instance.service != nil {
-		errStop.Add(instance.service.Stop())
+	if service := instance.takeService(); service != nil {
+		errStop.Add(service.Stop())
 	}
+	instance.sessions.endAll()
 
-	p.del(id)
+	p.Del(instance.id)
+	p.setState(instance, NotRunning, nil)
 	p.eventPublisher.Publish(StopTopic, instance)
 	return errStop.Errorf("ErrorCollection(%s)", ", ")
 }
 
 // StopAll kills all running instances
 func (p *Pool) StopAll() error {
-	p.Lock()
-	defer p.Unlock()
 	errStop := utils.ErrorCollection{}
-	for id := range p.instances {
-		errStop.Add(p.stop(id))
+	for _, instance := range p.List() {
+		errStop.Add(p.stop(instance))
 	}
 
 	return errStop.Errorf("Some instances did not stop: %v", ". ")
 }
 
+// setState changes state of the instance and announces it, announcements of an instance keep the order of changes
+func (p *Pool) setState(instance *Instance, state State, err error) {
+	instance.eventLock.Lock()
+	defer instance.eventLock.Unlock()
+
+	instance.lock.Lock()
+	instance.state = state
+	instance.stateChanged = time.Now().UTC()
+	if err != nil {
+		instance.lastErr = err
+	}
+	event := StateEvent{
+		ID:       instance.id,
+		State:    state,
+		Time:     instance.stateChanged,
+		Restarts: instance.restarts,
+		Error:    err,
+	}
+	instance.lock.Unlock()
+
+	p.eventPublisher.Publish(StateTopic, event)
+}
+
 // List returns all running service instances.
 func (p *Pool) List() map[ID]*Instance {
 	p.Lock()
 	defer p.Unlock()
-	return p.instances
+
+	instances := make(map[ID]*Instance, len(p.instances))
+	for id, instance := range p.instances {
+		instances[id] = instance
+	}
+	return instances
 }
 
 // Instance returns service instance by the requested id.
@@ -155,11 +183,25 @@ func NewInstance(
 type Instance struct {
 	id           ID
 	state        State
+	stateChanged time.Time
+	serviceType  string
 	options      Options
 	service      RunnableService
 	proposal     market.ServiceProposal
 	dialogWaiter communication.DialogWaiter
 	discovery    Discovery
+
+	admission        *admission
+	sessions         *SessionRecorder
+	access           *accessControl
+	discoveryStopped bool
+	restarts         int
+	lastErr          error
+	stop             chan struct{}
+	stopping         bool
+	lock             sync.Mutex
+	// eventLock orders state change announcements, lock is not held during them to let subscribers read the instance
+	eventLock sync.Mutex
 }
 
 // Options returns options used to start service
@@ -174,5 +216,104 @@ func (i *Instance) Proposal() market.ServiceProposal {
 
 // State returns the service instance state.
 func (i *Instance) State() State {
+	i.lock.Lock()
+	defer i.lock.Unlock()
 	return i.state
 }
+
+// StateChanged returns the time of the last state change.
+func (i *Instance) StateChanged() time.Time {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.stateChanged
+}
+
+// Service returns currently running service of the instance, it is replaced on restarts.
+// It is nil while failed service is being restarted and once the instance is stopped.
+func (i *Instance) Service() RunnableService {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.service
+}
+
+// takeService detaches the service from the instance, so that it is stopped only by the caller.
+// It returns nil if the service was taken already.
+func (i *Instance) takeService() RunnableService {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	service := i.service
+	i.service = nil
+	return service
+}
+
+// Restarts returns how many times the service was restarted after failure.
+func (i *Instance) Restarts() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.restarts
+}
+
+// LastError returns the last failure of the service, nil if it never failed.
+func (i *Instance) LastError() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.lastErr
+}
+
+// restarted replaces failed service by the new one, it returns false if instance was stopped meanwhile
+func (i *Instance) restarted(service Service) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.stopping {
+		return false
+	}
+	i.service = service
+	i.restarts++
+	return true
+}
+
+// stopDiscovery withdraws the proposal from discovery, it can be called repeatedly
+func (i *Instance) stopDiscovery() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.discovery == nil || i.discoveryStopped {
+		return
+	}
+	i.discoveryStopped = true
+	i.discovery.Stop()
+}
+
+// requestStop marks the instance as stopping, it returns false if stop was requested already
+func (i *Instance) requestStop() bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.stopping {
+		return false
+	}
+	i.stopping = true
+	close(i.stopChan())
+	return true
+}
+
+func (i *Instance) stopRequested() bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.stopping
+}
+
+func (i *Instance) stopChannel() <-chan struct{} {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.stopChan()
+}
+
+func (i *Instance) stopChan() chan struct{} {
+	if i.stop == nil {
+		i.stop = make(chan struct{})
+	}
+	return i.stop
+}
@@ -19,7 +19,9 @@ package service
 
 import (
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,11 +33,35 @@ type mockService struct {
 type mockPublisher struct {
 	publishedTopic string
 	publishedArgs  []interface{}
+	stateEvents    []StateEvent
+	stopped        []*Instance
+	lock           sync.Mutex
 }
 
 func (mockPublisher *mockPublisher) Publish(topic string, args ...interface{}) {
+	mockPublisher.lock.Lock()
+	defer mockPublisher.lock.Unlock()
+
 	mockPublisher.publishedTopic = topic
 	mockPublisher.publishedArgs = args
+	if topic == StateTopic {
+		mockPublisher.stateEvents = append(mockPublisher.stateEvents, args[0].(StateEvent))
+	}
+	if topic == StopTopic {
+		mockPublisher.stopped = append(mockPublisher.stopped, args[0].(*Instance))
+	}
+}
+
+func (mockPublisher *mockPublisher) Stopped() []*Instance {
+	mockPublisher.lock.Lock()
+	defer mockPublisher.lock.Unlock()
+	return append([]*Instance{}, mockPublisher.stopped...)
+}
+
+func (mockPublisher *mockPublisher) StateEvents() []StateEvent {
+	mockPublisher.lock.Lock()
+	defer mockPublisher.lock.Unlock()
+	return append([]StateEvent{}, mockPublisher.stateEvents...)
 }
 
 func (mr *mockService) Stop() error {
@@ -95,3 +121,38 @@ func Test_Pool_StopAllDoesNotStopOneInstance(t *testing.T) {
 	err := pool.StopAll()
 	assert.EqualError(t, err, "Some instances did not stop: ErrorCollection(I dont want to stop)")
 }
+
+type listingPublisher struct {
+	pool   *Pool
+	listed []int
+	lock   sync.Mutex
+}
+
+func (lp *listingPublisher) Publish(topic string, args ...interface{}) {
+	listed := len(lp.pool.List())
+
+	lp.lock.Lock()
+	defer lp.lock.Unlock()
+	lp.listed = append(lp.listed, listed)
+}
+
+func Test_Pool_SubscriberListsPoolWhileInstanceStops(t *testing.T) {
+	publisher := &listingPublisher{}
+	pool := NewPool(publisher)
+	publisher.pool = pool
+	pool.Add(&Instance{id: "test id", service: &mockService{}})
+
+	done := make(chan error)
+	go func() { done <- pool.StopAll() }()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("subscriber listing the pool blocked stop")
+	}
+
+	publisher.lock.Lock()
+	defer publisher.lock.Unlock()
+	assert.Equal(t, []int{1, 0, 0}, publisher.listed)
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/datasize"
+)
+
+// SessionQuotaTopic is used in event bus to announce that a session nears or exceeded its data transfer quota
+const SessionQuotaTopic = "Session quota"
+
+// SessionQuotaEvent announces data transferred by a session in relation to its quota
+type SessionQuotaEvent struct {
+	ServiceID   ID
+	SessionID   string
+	Peer        string
+	ConsumerID  string
+	Transferred datasize.BitSize
+	Quota       datasize.BitSize
+	// Exceeded is set once session transferred its quota, it is disconnected then
+	Exceeded bool
+}
+
+// DisconnectingService is implemented by services which are able to disconnect a session of the peer
+type DisconnectingService interface {
+	DisconnectPeer(peer string) error
+}
+
+// quotaWarnings are shares of the quota, reaching which is announced once per session
+var quotaWarnings = []float64{0.8, 0.9}
+
+// sessionQuota checks data transferred by sessions against their quota, it is guarded by the lock of the recorder
+type sessionQuota struct {
+	limit        datasize.BitSize
+	publisher    Publisher
+	disconnecter DisconnectingService
+	// warned counts warnings announced to the peer
+	warned map[string]int
+}
+
+func newSessionQuota(publisher Publisher) sessionQuota {
+	return sessionQuota{
+		publisher: publisher,
+		warned:    make(map[string]int),
+	}
+}
+
+// check returns the announcement due to data transferred by the session since the last check, nil if none is due
+func (q *sessionQuota) check(record *SessionRecord) *SessionQuotaEvent {
+	if q.limit <= 0 || record.QuotaExceeded {
+		return nil
+	}
+
+	transferred := datasize.BitSize(record.BytesIn+record.BytesOut) * datasize.Byte
+	event := &SessionQuotaEvent{
+		ServiceID:   record.ServiceID,
+		SessionID:   record.ID,
+		Peer:        record.Peer,
+		ConsumerID:  record.ConsumerID,
+		Transferred: transferred,
+		Quota:       q.limit,
+	}
+
+	if transferred >= q.limit {
+		record.QuotaExceeded = true
+		event.Exceeded = true
+		return event
+	}
+
+	// several warnings reached at once are announced once
+	warned := q.warned[record.Peer]
+	for warned < len(quotaWarnings) && transferred >= datasize.BitSize(quotaWarnings[warned])*q.limit {
+		warned++
+	}
+	if warned == q.warned[record.Peer] {
+		return nil
+	}
+	q.warned[record.Peer] = warned
+	return event
+}
+
+// enforce announces the event and disconnects the session if it exceeded its quota
+func (q *sessionQuota) enforce(event *SessionQuotaEvent, disconnecter DisconnectingService) {
+	if event == nil {
+		return
+	}
+	if q.publisher != nil {
+		q.publisher.Publish(SessionQuotaTopic, *event)
+	}
+	if !event.Exceeded {
+		log.Info("Session ", event.SessionID, " transferred ", event.Transferred, " of its ", event.Quota, " quota")
+		return
+	}
+
+	log.Warn("Session ", event.SessionID, " exceeded its ", event.Quota, " quota, disconnecting peer ", event.Peer)
+	if disconnecter == nil {
+		log.Warn("Service ", event.ServiceID, " is not able to disconnect sessions")
+		return
+	}
+	if err := disconnecter.DisconnectPeer(event.Peer); err != nil {
+		log.Error("Failed to disconnect session ", event.SessionID, ": ", err)
+	}
+}
+
+func (q *sessionQuota) forget(peer string) {
+	delete(q.warned, peer)
+}
+
+// DisconnectBy makes the recorder disconnect sessions exceeding their quota by the given service.
+// Services are given to the recorder of their instance by the manager.
+func (r *SessionRecorder) DisconnectBy(disconnecter DisconnectingService) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.quota.disconnecter = disconnecter
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"testing"
+
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/stretchr/testify/assert"
+)
+
+type quotaOptionsFake struct{}
+
+func (quotaOptionsFake) SessionLimits() OptionsLimits {
+	return OptionsLimits{SessionQuota: 100 * datasize.Byte}
+}
+
+type disconnectingServiceFake struct {
+	disconnected []string
+}
+
+func (service *disconnectingServiceFake) DisconnectPeer(peer string) error {
+	service.disconnected = append(service.disconnected, peer)
+	return nil
+}
+
+func quotaEvents(publisher *mockPublisher) []SessionQuotaEvent {
+	publisher.lock.Lock()
+	defer publisher.lock.Unlock()
+
+	if publisher.publishedTopic != SessionQuotaTopic {
+		return nil
+	}
+	return []SessionQuotaEvent{publisher.publishedArgs[0].(SessionQuotaEvent)}
+}
+
+func TestSessionRecorder_WarnsSessionNearingQuota(t *testing.T) {
+	publisher := &mockPublisher{}
+	disconnecter := &disconnectingServiceFake{}
+	recorder := NewSessionRecorder(newStorageFake(), publisher, ID("service"), "wireguard", quotaOptionsFake{})
+	recorder.DisconnectBy(disconnecter)
+
+	recorder.Started("peer-key", "0x1")
+	recorder.Update("peer-key", SessionStats{BytesIn: 40, BytesOut: 10})
+	assert.Empty(t, quotaEvents(publisher))
+
+	recorder.Update("peer-key", SessionStats{BytesIn: 70, BytesOut: 10})
+	events := quotaEvents(publisher)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "peer-key", events[0].Peer)
+		assert.Equal(t, "0x1", events[0].ConsumerID)
+		assert.Equal(t, 80*datasize.Byte, events[0].Transferred)
+		assert.Equal(t, 100*datasize.Byte, events[0].Quota)
+		assert.False(t, events[0].Exceeded)
+	}
+
+	// the same warning is not announced again
+	publisher.publishedTopic = ""
+	recorder.Update("peer-key", SessionStats{BytesIn: 75, BytesOut: 10})
+	assert.Empty(t, quotaEvents(publisher))
+	assert.Empty(t, disconnecter.disconnected)
+}
+
+func TestSessionRecorder_DisconnectsSessionExceedingQuota(t *testing.T) {
+	storage := newStorageFake()
+	publisher := &mockPublisher{}
+	disconnecter := &disconnectingServiceFake{}
+	recorder := NewSessionRecorder(storage, publisher, ID("service"), "openvpn", quotaOptionsFake{})
+	recorder.DisconnectBy(disconnecter)
+
+	recorder.Started("7", "")
+	recorder.Update("7", SessionStats{BytesIn: 60, BytesOut: 40})
+
+	events := quotaEvents(publisher)
+	if assert.Len(t, events, 1) {
+		assert.True(t, events[0].Exceeded)
+		assert.Equal(t, 100*datasize.Byte, events[0].Transferred)
+	}
+	assert.Equal(t, []string{"7"}, disconnecter.disconnected)
+
+	active := recorder.Active()
+	if assert.Len(t, active, 1) {
+		record := storage.sessions[active[0].ID]
+		assert.True(t, record.QuotaExceeded)
+		assert.Equal(t, 100*datasize.Byte, record.Quota)
+	}
+
+	// session is disconnected once
+	recorder.Update("7", SessionStats{BytesIn: 80, BytesOut: 40})
+	assert.Equal(t, []string{"7"}, disconnecter.disconnected)
+}
+
+func TestSessionRecorder_UnlimitedQuota(t *testing.T) {
+	publisher := &mockPublisher{}
+	disconnecter := &disconnectingServiceFake{}
+	recorder := NewSessionRecorder(newStorageFake(), publisher, ID("service"), "wireguard", nil)
+	recorder.DisconnectBy(disconnecter)
+
+	recorder.Started("peer-key", "")
+	recorder.Update("peer-key", SessionStats{BytesIn: 1 << 40, BytesOut: 1 << 40})
+	assert.Empty(t, quotaEvents(publisher))
+	assert.Empty(t, disconnecter.disconnected)
+}
@@ -18,21 +18,28 @@
 package service
 
 import (
+	"encoding/json"
+
 	"github.com/skytells-research/DNA/network/node/market"
 )
 
 // RegistryFactory initiates instance which is able to serve
 type RegistryFactory func(options Options) (Service, market.ServiceProposal, error)
 
+// OptionsParser restores service options from JSON
+type OptionsParser func(request *json.RawMessage) (Options, error)
+
 // Registry holds all pluggable services
 type Registry struct {
 	factories map[string]RegistryFactory
+	parsers   map[string]OptionsParser
 }
 
 // NewRegistry creates a registry of pluggable services
 func NewRegistry() *Registry {
 	return &Registry{
 		factories: make(map[string]RegistryFactory),
+		parsers:   make(map[string]OptionsParser),
 	}
 }
 
@@ -50,3 +57,18 @@ func (registry *Registry) Create(serviceType string, options Options) (Service,
 
 	return createService(options)
 }
+
+// RegisterOptionsParser registers parser of options of the pluggable service, it is required to restore persisted services
+func (registry *Registry) RegisterOptionsParser(serviceType string, parser OptionsParser) {
+	registry.parsers[serviceType] = parser
+}
+
+// ParseOptions restores options of pluggable service from JSON
+func (registry *Registry) ParseOptions(serviceType string, request *json.RawMessage) (Options, error) {
+	parseOptions, exists := registry.parsers[serviceType]
+	if !exists {
+		return nil, ErrUnsupportedServiceType
+	}
+
+	return parseOptions(request)
+}
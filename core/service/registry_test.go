@@ -18,6 +18,7 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
 	"testing"
 
@@ -88,6 +89,7 @@ func TestRegistry_Create_BubblesErrors(t *testing.T) {
 func mockRegistryEmpty() *Registry {
 	return &Registry{
 		factories: map[string]RegistryFactory{},
+		parsers:   map[string]OptionsParser{},
 	}
 }
 
@@ -96,5 +98,23 @@ func mockRegistryWith(serviceType string, serviceFactory RegistryFactory) *Regis
 		factories: map[string]RegistryFactory{
 			serviceType: serviceFactory,
 		},
+		parsers: map[string]OptionsParser{},
 	}
 }
+
+func TestRegistry_ParseOptions(t *testing.T) {
+	registry := mockRegistryEmpty()
+	registry.RegisterOptionsParser("fake-service", func(request *json.RawMessage) (Options, error) {
+		var options []string
+		err := json.Unmarshal(*request, &options)
+		return options, err
+	})
+
+	request := json.RawMessage(`["option"]`)
+	options, err := registry.ParseOptions("fake-service", &request)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"option"}, options)
+
+	_, err = registry.ParseOptions("missing-service", &request)
+	assert.Equal(t, ErrUnsupportedServiceType, err)
+}
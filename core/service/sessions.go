@@ -0,0 +1,360 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/datasize"
+)
+
+const (
+	sessionHistoryBucket = "provider-session-history"
+	// sessionPersistInterval limits how often traffic of active sessions is persisted
+	sessionPersistInterval = time.Minute
+	// sessionHistoryMaxAge is how long records of sessions are kept since their last update
+	sessionHistoryMaxAge = 90 * 24 * time.Hour
+	// sessionHistoryMaxRecords is how many latest records of sessions are kept
+	sessionHistoryMaxRecords = 10000
+	// sessionHistoryPruneInterval limits how often session history is pruned
+	sessionHistoryPruneInterval = time.Hour
+)
+
+// SessionStats describes traffic of a session served by the provider
+type SessionStats struct {
+	// BytesIn is traffic received from consumer
+	BytesIn uint64
+	// BytesOut is traffic sent to consumer
+	BytesOut uint64
+	// PeerEndpoint is the address consumer connects from, empty until it is known
+	PeerEndpoint string
+}
+
+// SessionRecord describes a session served by the provider, records of ended sessions are kept as session history
+type SessionRecord struct {
+	ID          string `storm:"id"`
+	ServiceID   ID
+	ServiceType string
+	// Peer identifies the session within its service, i.e. wireguard peer public key or openvpn client id
+	Peer       string
+	ConsumerID string
+	SessionStats
+	Bandwidth OptionsBandwidth
+	// Quota is the data transfer cap of the session, zero means no cap
+	Quota         datasize.BitSize
+	QuotaExceeded bool
+	Started       time.Time
+	Updated       time.Time
+	// Ended is zero while session is active
+	Ended time.Time
+}
+
+// Duration returns how long the session lasted, or lasts if it is still active
+func (r SessionRecord) Duration() time.Duration {
+	if r.Ended.IsZero() {
+		return r.Updated.Sub(r.Started)
+	}
+	return r.Ended.Sub(r.Started)
+}
+
+// RecordingService is implemented by services which account traffic of their sessions
+type RecordingService interface {
+	RecordSessions(recorder *SessionRecorder)
+}
+
+// SessionRecorder keeps statistics of sessions served by a service and persists them as session history.
+// History is pruned as sessions end, only records of the latest sessions are kept.
+// Nil recorder ignores all the calls.
+type SessionRecorder struct {
+	storage     Storage
+	serviceID   ID
+	serviceType string
+	bandwidth   OptionsBandwidth
+	active      map[string]*SessionRecord
+	persisted   map[string]time.Time
+	pruned      time.Time
+	quota       sessionQuota
+	// transferred counts traffic of sessions as it grows
+	transferred func(bytes uint64)
+	lock        sync.Mutex
+}
+
+// NewSessionRecorder creates recorder of sessions served by the given service.
+// Sessions nearing their quota are announced by the publisher, if there is one.
+func NewSessionRecorder(storage Storage, publisher Publisher, serviceID ID, serviceType string, options Options) *SessionRecorder {
+	recorder := &SessionRecorder{
+		storage:     storage,
+		serviceID:   serviceID,
+		serviceType: serviceType,
+		active:      make(map[string]*SessionRecord),
+		persisted:   make(map[string]time.Time),
+		quota:       newSessionQuota(publisher),
+	}
+	if limited, ok := options.(LimitedOptions); ok {
+		recorder.bandwidth = limited.SessionLimits().SessionBandwidth
+		recorder.quota.limit = limited.SessionLimits().SessionQuota
+	}
+	return recorder
+}
+
+// Started records a new session of the peer, consumer is empty if service does not know it.
+// Session which the peer had before is ended.
+func (r *SessionRecorder) Started(peer, consumerID string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now().UTC()
+	if previous, ok := r.active[peer]; ok {
+		r.end(previous, now)
+	}
+
+	id, err := generateID()
+	if err != nil {
+		log.Warn("Failed to record session of ", peer, ": ", err)
+		return
+	}
+	record := &SessionRecord{
+		ID:          string(id),
+		ServiceID:   r.serviceID,
+		ServiceType: r.serviceType,
+		Peer:        peer,
+		ConsumerID:  consumerID,
+		Bandwidth:   r.bandwidth,
+		Quota:       r.quota.limit,
+		Started:     now,
+		Updated:     now,
+	}
+	r.active[peer] = record
+	r.persist(record)
+}
+
+// Update records current traffic of the peer session, counters are totals of the session.
+// Traffic is persisted periodically, not on every update. Session exceeding its quota is disconnected.
+func (r *SessionRecorder) Update(peer string, stats SessionStats) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+
+	record, ok := r.active[peer]
+	if !ok {
+		r.lock.Unlock()
+		return
+	}
+	transferred := sessionGrowth(record.BytesIn+record.BytesOut, stats.BytesIn+stats.BytesOut)
+	record.BytesIn = stats.BytesIn
+	record.BytesOut = stats.BytesOut
+	if stats.PeerEndpoint != "" {
+		record.PeerEndpoint = stats.PeerEndpoint
+	}
+	record.Updated = time.Now().UTC()
+
+	event := r.quota.check(record)
+	disconnecter := r.quota.disconnecter
+	counter := r.transferred
+	if record.Updated.Sub(r.persisted[peer]) >= sessionPersistInterval || (event != nil && event.Exceeded) {
+		r.persist(record)
+	}
+	r.lock.Unlock()
+
+	if counter != nil && transferred > 0 {
+		counter(transferred)
+	}
+	// announcement and disconnect are made without the lock, they may end up recording the session
+	r.quota.enforce(event, disconnecter)
+}
+
+// sessionGrowth returns traffic made since previous totals of the session, totals lower than previous ones mean counters were reset
+func sessionGrowth(previous, total uint64) uint64 {
+	if total < previous {
+		return total
+	}
+	return total - previous
+}
+
+// countTransferred makes the recorder report traffic of sessions to the given counter as it grows
+func (r *SessionRecorder) countTransferred(counter func(bytes uint64)) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.transferred = counter
+}
+
+// Ended records the end of the peer session.
+func (r *SessionRecorder) Ended(peer string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if record, ok := r.active[peer]; ok {
+		r.end(record, time.Now().UTC())
+	}
+}
+
+// Active returns records of sessions which are currently served.
+func (r *SessionRecorder) Active() []SessionRecord {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	records := make([]SessionRecord, 0, len(r.active))
+	for _, record := range r.active {
+		records = append(records, *record)
+	}
+	return records
+}
+
+// endAll ends all active sessions, i.e. when their service stops
+func (r *SessionRecorder) endAll() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now().UTC()
+	for _, record := range r.active {
+		r.end(record, now)
+	}
+}
+
+func (r *SessionRecorder) end(record *SessionRecord, now time.Time) {
+	record.Updated = now
+	record.Ended = now
+	r.persist(record)
+	delete(r.active, record.Peer)
+	delete(r.persisted, record.Peer)
+	r.quota.forget(record.Peer)
+
+	if now.Sub(r.pruned) >= sessionHistoryPruneInterval {
+		r.pruned = now
+		r.prune(now)
+	}
+}
+
+// prune removes records of ended sessions which are too old or exceed the number of kept records.
+// History is shared by all services, so sessions active in any of them are kept.
+func (r *SessionRecorder) prune(now time.Time) {
+	var records []SessionRecord
+	if err := r.storage.GetAllFrom(sessionHistoryBucket, &records); err != nil {
+		log.Warn("Failed to prune session history: ", err)
+		return
+	}
+
+	for _, record := range expiredSessions(records, now) {
+		if err := r.storage.Delete(sessionHistoryBucket, &record); err != nil {
+			log.Warn("Failed to remove session ", record.ID, " from history: ", err)
+		}
+	}
+}
+
+// expiredSessions returns ended records not updated for sessionHistoryMaxAge and the oldest ended ones above sessionHistoryMaxRecords
+func expiredSessions(records []SessionRecord, now time.Time) []SessionRecord {
+	sort.Slice(records, func(i, j int) bool { return records[i].Updated.After(records[j].Updated) })
+
+	var expired []SessionRecord
+	kept := 0
+	for _, record := range records {
+		if record.Ended.IsZero() {
+			continue
+		}
+		if kept >= sessionHistoryMaxRecords || now.Sub(record.Updated) > sessionHistoryMaxAge {
+			expired = append(expired, record)
+			continue
+		}
+		kept++
+	}
+	return expired
+}
+
+func (r *SessionRecorder) persist(record *SessionRecord) {
+	r.persisted[record.Peer] = record.Updated
+	stored := *record
+	if err := r.storage.Store(sessionHistoryBucket, &stored); err != nil {
+		log.Warn("Failed to persist session ", record.ID, ": ", err)
+	}
+}
+
+// recordSessions gives the session recorder of the instance to the service, if it accounts traffic of its sessions.
+// Sessions exceeding their quota are disconnected by the service, if it is able to.
+func recordSessions(service Service, recorder *SessionRecorder) {
+	if recording, ok := service.(RecordingService); ok {
+		recording.RecordSessions(recorder)
+	}
+	disconnecting, _ := service.(DisconnectingService)
+	recorder.DisconnectBy(disconnecting)
+}
+
+// endOrphanedSessions ends recorded sessions of services which are not running, i.e. sessions left active by unclean shutdown.
+// They are ended at their last update.
+func (manager *Manager) endOrphanedSessions() error {
+	var records []SessionRecord
+	if err := manager.storage.GetAllFrom(sessionHistoryBucket, &records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if !record.Ended.IsZero() || manager.Service(record.ServiceID) != nil {
+			continue
+		}
+		record.Ended = record.Updated
+		if err := manager.storage.Store(sessionHistoryBucket, &record); err != nil {
+			return err
+		}
+		log.Info("Ended orphaned session ", record.ID, " of service ", record.ServiceID)
+	}
+	return nil
+}
+
+// SessionHistory returns sessions served by all services, latest statistics of active sessions included.
+func (manager *Manager) SessionHistory() ([]SessionRecord, error) {
+	var records []SessionRecord
+	if err := manager.storage.GetAllFrom(sessionHistoryBucket, &records); err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]SessionRecord)
+	for _, instance := range manager.List() {
+		for _, record := range instance.ActiveSessions() {
+			active[record.ID] = record
+		}
+	}
+	for i, record := range records {
+		if current, ok := active[record.ID]; ok {
+			records[i] = current
+		}
+	}
+	return records, nil
+}
+
+// ActiveSessions returns statistics of sessions currently served by the service.
+func (i *Instance) ActiveSessions() []SessionRecord {
+	return i.sessions.Active()
+}
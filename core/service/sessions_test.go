@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingServiceFake struct {
+	serviceFake
+	recorder *SessionRecorder
+}
+
+func (service *recordingServiceFake) RecordSessions(recorder *SessionRecorder) {
+	service.recorder = recorder
+}
+
+type bandwidthOptionsFake struct{}
+
+func (bandwidthOptionsFake) SessionLimits() OptionsLimits {
+	return OptionsLimits{SessionBandwidth: OptionsBandwidth{Upload: datasize.MB, Download: 8 * datasize.MB}}
+}
+
+func TestSessionRecorder_KeepsHistoryOfEndedSessions(t *testing.T) {
+	storage := newStorageFake()
+	recorder := NewSessionRecorder(storage, nil, ID("service"), "wireguard", bandwidthOptionsFake{})
+
+	recorder.Started("peer-key", "0x1")
+	recorder.Update("peer-key", SessionStats{BytesIn: 10, BytesOut: 20, PeerEndpoint: "1.2.3.4:5678"})
+
+	active := recorder.Active()
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, ID("service"), active[0].ServiceID)
+		assert.Equal(t, "wireguard", active[0].ServiceType)
+		assert.Equal(t, "peer-key", active[0].Peer)
+		assert.Equal(t, "0x1", active[0].ConsumerID)
+		assert.Equal(t, SessionStats{BytesIn: 10, BytesOut: 20, PeerEndpoint: "1.2.3.4:5678"}, active[0].SessionStats)
+		assert.Equal(t, OptionsBandwidth{Upload: datasize.MB, Download: 8 * datasize.MB}, active[0].Bandwidth)
+		assert.True(t, active[0].Ended.IsZero())
+	}
+	// traffic of active session is persisted periodically
+	assert.Equal(t, uint64(0), storage.sessions[active[0].ID].BytesIn)
+
+	recorder.Ended("peer-key")
+	assert.Empty(t, recorder.Active())
+
+	record := storage.sessions[active[0].ID]
+	assert.Equal(t, uint64(10), record.BytesIn)
+	assert.Equal(t, uint64(20), record.BytesOut)
+	assert.Equal(t, "1.2.3.4:5678", record.PeerEndpoint)
+	assert.False(t, record.Ended.IsZero())
+	assert.Equal(t, record.Ended.Sub(record.Started), record.Duration())
+}
+
+func TestSessionRecorder_NewSessionOfPeerEndsPreviousOne(t *testing.T) {
+	storage := newStorageFake()
+	recorder := NewSessionRecorder(storage, nil, ID("service"), "openvpn", nil)
+
+	recorder.Started("1", "")
+	recorder.Started("1", "")
+
+	assert.Len(t, recorder.Active(), 1)
+	assert.Len(t, storage.sessions, 2)
+}
+
+func TestSessionRecorder_NilRecorderIgnoresCalls(t *testing.T) {
+	var recorder *SessionRecorder
+
+	recorder.Started("peer", "")
+	recorder.Update("peer", SessionStats{BytesIn: 1})
+	recorder.Ended("peer")
+	recorder.endAll()
+	assert.Empty(t, recorder.Active())
+}
+
+func TestSessionRecord_DurationOfActiveSession(t *testing.T) {
+	started := time.Now()
+	record := SessionRecord{Started: started, Updated: started.Add(time.Minute)}
+
+	assert.Equal(t, time.Minute, record.Duration())
+}
+
+func TestManager_SessionHistoryOfRecordingService(t *testing.T) {
+	storage := newStorageFake()
+	service := &recordingServiceFake{serviceFake: serviceFake{mockProcess: make(chan struct{})}}
+	registry := NewRegistry()
+	registry.Register(serviceType, func(options Options) (Service, market.ServiceProposal, error) {
+		return service, proposalMock, nil
+	})
+	manager := NewManager(
+		registry,
+		MockDialogWaiterFactory,
+		MockDialogHandlerFactory,
+		MockDiscoveryFactoryFunc(&mockDiscovery{}),
+		&MockNATPinger{},
+		&mockPublisher{},
+		storage,
+	)
+
+	id, err := manager.Start(identity.FromAddress("0x1"), serviceType, struct{}{}, RestartPolicy{})
+	assert.NoError(t, err)
+	if !assert.NotNil(t, service.recorder) {
+		return
+	}
+
+	service.recorder.Started("peer-key", "")
+	service.recorder.Update("peer-key", SessionStats{BytesIn: 100, BytesOut: 200})
+
+	history, err := manager.SessionHistory()
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, id, history[0].ServiceID)
+		assert.Equal(t, uint64(100), history[0].BytesIn)
+		assert.True(t, history[0].Ended.IsZero())
+	}
+
+	assert.NoError(t, manager.Stop(id))
+	history, err = manager.SessionHistory()
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, uint64(200), history[0].BytesOut)
+		assert.False(t, history[0].Ended.IsZero())
+	}
+}
+
+func TestSessionRecorder_PrunesOldSessionsFromHistory(t *testing.T) {
+	storage := newStorageFake()
+	old := time.Now().UTC().Add(-sessionHistoryMaxAge - time.Hour)
+	storage.sessions["old"] = SessionRecord{ID: "old", Started: old, Updated: old, Ended: old}
+
+	recorder := NewSessionRecorder(storage, nil, ID("service"), "wireguard", nil)
+	recorder.Started("peer-key", "0x1")
+	recorder.Ended("peer-key")
+
+	assert.Len(t, storage.sessions, 1)
+	assert.NotContains(t, storage.sessions, "old")
+}
+
+func TestExpiredSessions(t *testing.T) {
+	now := time.Now().UTC()
+	records := make([]SessionRecord, 0, sessionHistoryMaxRecords+4)
+	for i := 0; i < sessionHistoryMaxRecords+2; i++ {
+		updated := now.Add(-time.Duration(i) * time.Second)
+		records = append(records, SessionRecord{ID: fmt.Sprint(i), Updated: updated, Ended: updated})
+	}
+	stale := now.Add(-sessionHistoryMaxAge - time.Second)
+	records = append(records, SessionRecord{ID: "stale", Updated: stale, Ended: stale})
+	// sessions active in any service are neither counted nor removed
+	records = append(records, SessionRecord{ID: "active", Updated: now.Add(time.Second)})
+	records = append(records, SessionRecord{ID: "active-stale", Updated: stale})
+
+	var expired []string
+	for _, record := range expiredSessions(records, now) {
+		expired = append(expired, record.ID)
+	}
+	assert.Equal(t, []string{fmt.Sprint(sessionHistoryMaxRecords), fmt.Sprint(sessionHistoryMaxRecords + 1), "stale"}, expired)
+}
+
+func TestSessionRecorder_PruneKeepsActiveSessionsOfOtherServices(t *testing.T) {
+	storage := newStorageFake()
+	old := time.Now().UTC().Add(-sessionHistoryMaxAge - time.Hour)
+	storage.sessions["other"] = SessionRecord{ID: "other", ServiceID: ID("other-service"), Started: old, Updated: old}
+
+	recorder := NewSessionRecorder(storage, nil, ID("service"), "wireguard", nil)
+	recorder.Started("peer-key", "0x1")
+	recorder.Ended("peer-key")
+
+	assert.Len(t, storage.sessions, 2)
+	assert.Contains(t, storage.sessions, "other")
+}
+
+func TestManager_RestoreEndsOrphanedSessions(t *testing.T) {
+	storage := newStorageFake()
+	updated := time.Now().UTC().Add(-time.Hour)
+	storage.sessions["orphaned"] = SessionRecord{ID: "orphaned", ServiceID: ID("stopped"), Started: updated, Updated: updated}
+	manager := newPersistingManager(storage)
+
+	assert.NoError(t, manager.Restore(identity.FromAddress(proposalMock.ProviderID)))
+	assert.Equal(t, updated, storage.sessions["orphaned"].Ended)
+}
@@ -27,4 +27,12 @@ const (
 	Starting = State("Starting")
 	// Running means that fully established service exists
 	Running = State("Running")
+	// Failed means that service serve failed, it is either restarted or stopped afterwards
+	Failed = State("Failed")
+	// Restarting means that service failed and is waiting to be started again
+	Restarting = State("Restarting")
+	// Draining means that service does not accept new sessions and is stopped once existing ones end
+	Draining = State("Draining")
+	// Stopping means that service is being stopped
+	Stopping = State("Stopping")
 )
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/identity"
+)
+
+const (
+	definitionsBucket = "service-definitions"
+	transferredBucket = "service-transferred"
+)
+
+// Storage keeps definitions of started services, so that they can be started again after restart
+type Storage interface {
+	Store(bucket string, data interface{}) error
+	GetAllFrom(bucket string, data interface{}) error
+	Delete(bucket string, data interface{}) error
+}
+
+// Definition describes a started service, which is started again by Restore if it is enabled
+type Definition struct {
+	ID          ID `storm:"id"`
+	ProviderID  string
+	ServiceType string
+	Options     json.RawMessage
+	Policy      RestartPolicy
+	Enabled     bool
+	Created     time.Time
+}
+
+func newDefinition(id ID, providerID identity.Identity, serviceType string, options Options, policy RestartPolicy) (Definition, error) {
+	serializedOptions, err := json.Marshal(options)
+	if err != nil {
+		return Definition{}, err
+	}
+
+	return Definition{
+		ID:          id,
+		ProviderID:  providerID.Address,
+		ServiceType: serviceType,
+		Options:     serializedOptions,
+		Policy:      policy,
+		Enabled:     true,
+		Created:     time.Now().UTC(),
+	}, nil
+}
+
+// Transferred is the total of data transferred by sessions of a persisted service, which counts towards its bandwidth budget.
+// It is kept apart from the definition, so that it survives restarts of the service and of the node.
+type Transferred struct {
+	ServiceID ID `storm:"id"`
+	Bytes     uint64
+}
+
+// countTransferred counts data transferred by sessions of the instance towards its bandwidth budget,
+// starting from the persisted total of the service. The total is persisted as it grows.
+func (manager *Manager) countTransferred(instance *Instance) {
+	total, err := manager.transferred(instance.id)
+	if err != nil {
+		log.Warn("Failed to load data transferred by service ", instance.id, ": ", err)
+	}
+	instance.AddTransferred(total.Bytes)
+
+	var lock sync.Mutex
+	instance.sessions.countTransferred(func(bytes uint64) {
+		instance.AddTransferred(bytes)
+
+		lock.Lock()
+		defer lock.Unlock()
+		total.Bytes += bytes
+		if err := manager.storage.Store(transferredBucket, &total); err != nil {
+			log.Warn("Failed to persist data transferred by service ", instance.id, ": ", err)
+		}
+	})
+}
+
+func (manager *Manager) transferred(id ID) (Transferred, error) {
+	var totals []Transferred
+	if err := manager.storage.GetAllFrom(transferredBucket, &totals); err != nil {
+		return Transferred{ServiceID: id}, err
+	}
+
+	for _, total := range totals {
+		if total.ServiceID == id {
+			return total, nil
+		}
+	}
+	return Transferred{ServiceID: id}, nil
+}
+
+// Definitions returns all persisted services, running and not.
+func (manager *Manager) Definitions() ([]Definition, error) {
+	var definitions []Definition
+	err := manager.storage.GetAllFrom(definitionsBucket, &definitions)
+	return definitions, err
+}
+
+// SetEnabled marks persisted service as enabled or disabled, only enabled services are started by Restore.
+// It does not start or stop the service.
+func (manager *Manager) SetEnabled(id ID, enabled bool) error {
+	definition, err := manager.definition(id)
+	if err != nil {
+		return err
+	}
+
+	definition.Enabled = enabled
+	return manager.storage.Store(definitionsBucket, &definition)
+}
+
+// Restore starts enabled persisted services of the given provider, which has to be unlocked already.
+// Services keep their IDs, the ones which are running already are skipped.
+// Sessions left active by services which are not running are ended first.
+func (manager *Manager) Restore(providerID identity.Identity) error {
+	if err := manager.endOrphanedSessions(); err != nil {
+		log.Warn("Failed to end orphaned sessions: ", err)
+	}
+
+	definitions, err := manager.Definitions()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, definition := range definitions {
+		if !definition.Enabled || definition.ProviderID != providerID.Address || manager.Service(definition.ID) != nil {
+			continue
+		}
+
+		options, err := manager.serviceRegistry.ParseOptions(definition.ServiceType, &definition.Options)
+		if err == nil {
+			err = manager.start(definition.ID, providerID, definition.ServiceType, options, definition.Policy)
+		}
+		if err != nil {
+			log.Error("Failed to restore service ", definition.ID, ": ", err)
+			lastErr = err
+			continue
+		}
+		log.Info("Service restored: ", definition.ID)
+	}
+	return lastErr
+}
+
+func (manager *Manager) definition(id ID) (Definition, error) {
+	definitions, err := manager.Definitions()
+	if err != nil {
+		return Definition{}, err
+	}
+
+	for _, definition := range definitions {
+		if definition.ID == id {
+			return definition, nil
+		}
+	}
+	return Definition{}, ErrNoSuchInstance
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/skytells-research/DNA/network/node/identity"
+	"github.com/skytells-research/DNA/network/node/market"
+	"github.com/stretchr/testify/assert"
+)
+
+type storageFake struct {
+	definitions map[ID]Definition
+	sessions    map[string]SessionRecord
+	transferred map[ID]Transferred
+	lock        sync.Mutex
+}
+
+func newStorageFake() *storageFake {
+	return &storageFake{
+		definitions: make(map[ID]Definition),
+		sessions:    make(map[string]SessionRecord),
+		transferred: make(map[ID]Transferred),
+	}
+}
+
+func (sf *storageFake) Store(bucket string, data interface{}) error {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	switch record := data.(type) {
+	case *Definition:
+		sf.definitions[record.ID] = *record
+	case *SessionRecord:
+		sf.sessions[record.ID] = *record
+	case *Transferred:
+		sf.transferred[record.ServiceID] = *record
+	}
+	return nil
+}
+
+func (sf *storageFake) GetAllFrom(bucket string, data interface{}) error {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	switch records := data.(type) {
+	case *[]Definition:
+		for _, definition := range sf.definitions {
+			*records = append(*records, definition)
+		}
+	case *[]SessionRecord:
+		for _, session := range sf.sessions {
+			*records = append(*records, session)
+		}
+	case *[]Transferred:
+		for _, total := range sf.transferred {
+			*records = append(*records, total)
+		}
+	}
+	return nil
+}
+
+func (sf *storageFake) Delete(bucket string, data interface{}) error {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	switch record := data.(type) {
+	case *Definition:
+		delete(sf.definitions, record.ID)
+	case *SessionRecord:
+		delete(sf.sessions, record.ID)
+	case *Transferred:
+		delete(sf.transferred, record.ServiceID)
+	}
+	return nil
+}
+
+type optionsFake struct {
+	Port int `json:"port"`
+}
+
+func newPersistingManager(storage Storage) *Manager {
+	registry := NewRegistry()
+	registry.Register(serviceType, func(options Options) (Service, market.ServiceProposal, error) {
+		return &serviceFake{mockProcess: make(chan struct{})}, proposalMock, nil
+	})
+	registry.RegisterOptionsParser(serviceType, func(request *json.RawMessage) (Options, error) {
+		var options optionsFake
+		err := json.Unmarshal(*request, &options)
+		return options, err
+	})
+
+	return NewManager(
+		registry,
+		MockDialogWaiterFactory,
+		MockDialogHandlerFactory,
+		MockDiscoveryFactoryFunc(&mockDiscovery{}),
+		&MockNATPinger{},
+		&mockPublisher{},
+		storage,
+	)
+}
+
+func TestManager_StartedServiceIsPersistedUntilStopped(t *testing.T) {
+	storage := newStorageFake()
+	manager := newPersistingManager(storage)
+	providerID := identity.FromAddress("0x1")
+	policy := RestartPolicy{Enabled: true, MaxRestarts: 3}
+
+	id, err := manager.Start(providerID, serviceType, optionsFake{Port: 1194}, policy)
+	assert.NoError(t, err)
+
+	definitions, err := manager.Definitions()
+	assert.NoError(t, err)
+	if assert.Len(t, definitions, 1) {
+		assert.Equal(t, id, definitions[0].ID)
+		assert.Equal(t, providerID.Address, definitions[0].ProviderID)
+		assert.Equal(t, serviceType, definitions[0].ServiceType)
+		assert.JSONEq(t, `{"port": 1194}`, string(definitions[0].Options))
+		assert.Equal(t, policy, definitions[0].Policy)
+		assert.True(t, definitions[0].Enabled)
+	}
+
+	assert.NoError(t, manager.Stop(id))
+	definitions, err = manager.Definitions()
+	assert.NoError(t, err)
+	assert.Empty(t, definitions)
+}
+
+func TestManager_RestoreStartsEnabledServices(t *testing.T) {
+	storage := newStorageFake()
+	providerID := identity.FromAddress("0x1")
+
+	manager := newPersistingManager(storage)
+	enabledID, err := manager.Start(providerID, serviceType, optionsFake{Port: 1194}, RestartPolicy{})
+	assert.NoError(t, err)
+	disabledID, err := manager.Start(providerID, serviceType, optionsFake{Port: 1195}, RestartPolicy{})
+	assert.NoError(t, err)
+	assert.NoError(t, manager.SetEnabled(disabledID, false))
+	assert.NoError(t, manager.Kill())
+
+	restarted := newPersistingManager(storage)
+	assert.NoError(t, restarted.Restore(providerID))
+
+	assert.Len(t, restarted.List(), 1)
+	instance := restarted.Service(enabledID)
+	if assert.NotNil(t, instance) {
+		assert.Equal(t, optionsFake{Port: 1194}, instance.Options())
+	}
+	assert.Len(t, storage.definitions, 2)
+
+	assert.NoError(t, restarted.Kill())
+}
+
+func TestManager_SetEnabledFailsForUnknownService(t *testing.T) {
+	manager := newPersistingManager(newStorageFake())
+	assert.Equal(t, ErrNoSuchInstance, manager.SetEnabled("unknown", true))
+}
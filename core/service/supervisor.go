@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/skytells-research/DNA/network/node/identity"
+)
+
+const (
+	defaultRestartInitialBackoff = time.Second
+	defaultRestartMaxBackoff     = time.Minute
+	defaultCrashLoopFailures     = 5
+	defaultCrashLoopWindow       = time.Minute
+)
+
+var (
+	// ErrMaxRestartsReached indicates that failed service was not restarted, because it used up all restarts
+	ErrMaxRestartsReached = errors.New("max service restarts reached")
+	// ErrCrashLoop indicates that failed service was not restarted, because it keeps failing right after start
+	ErrCrashLoop = errors.New("service is crash looping")
+)
+
+// RestartPolicy describes how a service is restarted when it fails
+type RestartPolicy struct {
+	// Enabled turns on restarts, failed service is stopped permanently otherwise
+	Enabled bool
+	// MaxRestarts limits total number of restarts, zero means no limit
+	MaxRestarts int
+	// InitialBackoff is the delay before the first restart, doubled after each consecutive failure
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between restarts
+	MaxBackoff time.Duration
+	// CrashLoopFailures is number of failures within CrashLoopWindow after which service is not restarted anymore
+	CrashLoopFailures int
+	// CrashLoopWindow is the period in which failures are counted, service which runs longer resets backoff
+	CrashLoopWindow time.Duration
+}
+
+// restartTracker decides whether and when a failed service is restarted
+type restartTracker struct {
+	policy      RestartPolicy
+	failures    []time.Time
+	consecutive uint
+	restarts    int
+}
+
+func newRestartTracker(policy RestartPolicy) *restartTracker {
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultRestartInitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultRestartMaxBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+	if policy.CrashLoopFailures <= 0 {
+		policy.CrashLoopFailures = defaultCrashLoopFailures
+	}
+	if policy.CrashLoopWindow <= 0 {
+		policy.CrashLoopWindow = defaultCrashLoopWindow
+	}
+	return &restartTracker{policy: policy}
+}
+
+// failed records a failure of the service which ran for given uptime,
+// it returns the delay before restart or an error if service must not be restarted
+func (t *restartTracker) failed(now time.Time, uptime time.Duration) (time.Duration, error) {
+	if uptime >= t.policy.CrashLoopWindow {
+		t.consecutive = 0
+	}
+
+	windowStart := now.Add(-t.policy.CrashLoopWindow)
+	failures := t.failures[:0]
+	for _, failure := range t.failures {
+		if failure.After(windowStart) {
+			failures = append(failures, failure)
+		}
+	}
+	t.failures = append(failures, now)
+
+	if len(t.failures) >= t.policy.CrashLoopFailures {
+		return 0, ErrCrashLoop
+	}
+	if t.policy.MaxRestarts > 0 && t.restarts >= t.policy.MaxRestarts {
+		return 0, ErrMaxRestartsReached
+	}
+
+	delay := t.policy.MaxBackoff
+	if t.consecutive < 32 && t.policy.InitialBackoff<<t.consecutive < t.policy.MaxBackoff {
+		delay = t.policy.InitialBackoff << t.consecutive
+	}
+	t.consecutive++
+	t.restarts++
+	return delay, nil
+}
+
+// supervise serves the instance until it is stopped, restarting it on failures according to its restart policy
+func (manager *Manager) supervise(providerID identity.Identity, instance *Instance, service Service, policy RestartPolicy) {
+	tracker := newRestartTracker(policy)
+
+	for {
+		started := time.Now()
+		serveErr := service.Serve(providerID)
+		if instance.stopRequested() {
+			break
+		}
+		if serveErr == nil {
+			log.Info("Service exited: ", instance.id)
+			manager.stopFailed(instance)
+			break
+		}
+		log.Error("Service serve failed: ", serveErr)
+		manager.servicePool.setState(instance, Failed, serveErr)
+
+		service = manager.restart(instance, service, policy, tracker, started)
+		if service == nil {
+			break
+		}
+	}
+
+	instance.discovery.Wait()
+}
+
+// restart waits for backoff and creates the service again, it returns nil if service is not restarted
+func (manager *Manager) restart(instance *Instance, failed Service, policy RestartPolicy, tracker *restartTracker, started time.Time) Service {
+	for {
+		if !policy.Enabled {
+			manager.stopFailed(instance)
+			return nil
+		}
+
+		delay, err := tracker.failed(time.Now(), time.Since(started))
+		if err != nil {
+			log.Error("Service ", instance.id, " is not restarted: ", err)
+			manager.stopFailed(instance)
+			return nil
+		}
+
+		manager.servicePool.setState(instance, Restarting, nil)
+		if failed != nil {
+			// stop of the pool may have taken and stopped the service already
+			if service := instance.takeService(); service != nil {
+				if err := service.Stop(); err != nil {
+					log.Warn("Failed service stop failed: ", err)
+				}
+			}
+			failed = nil
+			// sessions do not survive failure of their service
+			instance.sessions.endAll()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-instance.stopChannel():
+			return nil
+		}
+
+		service, _, err := manager.serviceRegistry.Create(instance.serviceType, instance.options)
+		if err != nil {
+			log.Error("Service ", instance.id, " restart failed: ", err)
+			manager.servicePool.setState(instance, Failed, err)
+			started = time.Now()
+			continue
+		}
+		identifyService(service, instance.id)
+		recordSessions(service, instance.sessions)
+		if !instance.restarted(service) {
+			// instance was stopped meanwhile, nobody else is going to stop the new service
+			if err := service.Stop(); err != nil {
+				log.Warn("Restarted service stop failed: ", err)
+			}
+			return nil
+		}
+
+		log.Info("Service ", instance.id, " restarted, restarts: ", instance.Restarts())
+		manager.servicePool.setState(instance, Running, nil)
+		return service
+	}
+}
+
+// stopFailed removes the instance which is not restarted anymore from the pool
+func (manager *Manager) stopFailed(instance *Instance) {
+	// TODO: fix https://github.com/skytells-research/DNA/network/node/issues/855
+	if err := manager.servicePool.Stop(instance.id); err != nil {
+		log.Error("Service stop failed: ", err)
+	}
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import "net"
+
+type fakeConfigurator struct {
+}
+
+// Set sets resolvers mock
+func (fc *fakeConfigurator) Set(iface string, servers []net.IP) error {
+	return nil
+}
+
+// Restore restores resolvers mock
+func (fc *fakeConfigurator) Restore(iface string) error {
+	return nil
+}
+
+// Cleanup cleans resolvers mock
+func (fc *fakeConfigurator) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/node/utils"
+)
+
+const (
+	logPrefix      = "[dns] "
+	resolvConfPath = "/etc/resolv.conf"
+	// resolvConfBackupPath keeps the original file until restore, its presence on start means that process has crashed
+	resolvConfBackupPath = "/etc/resolv.conf.sdna-backup"
+)
+
+// fileConfigurator replaces /etc/resolv.conf, only the last tunnel resolvers are in effect
+type fileConfigurator struct {
+	mu    sync.Mutex
+	iface string
+}
+
+func newFileConfigurator() *fileConfigurator {
+	return &fileConfigurator{}
+}
+
+// Set backs up the original file and writes tunnel resolvers instead
+func (fc *fileConfigurator) Set(iface string, servers []net.IP) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if _, err := os.Lstat(resolvConfBackupPath); os.IsNotExist(err) {
+		if err := utils.Sudo("mv "+resolvConfPath+" "+resolvConfBackupPath, ""); err != nil {
+			return errors.Wrap(err, "failed to back up "+resolvConfPath)
+		}
+	}
+
+	if err := utils.Sudo("tee "+resolvConfPath, resolvConf(servers)); err != nil {
+		return errors.Wrap(err, "failed to write "+resolvConfPath)
+	}
+
+	fc.iface = iface
+	return nil
+}
+
+// Restore puts the original file back, if resolvers of the given interface are in effect
+func (fc *fileConfigurator) Restore(iface string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.iface != iface {
+		return nil
+	}
+
+	fc.iface = ""
+	return restoreResolvConf()
+}
+
+// Cleanup puts back the original file left by crashed process
+func (fc *fileConfigurator) Cleanup() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.iface = ""
+	return restoreResolvConf()
+}
+
+func restoreResolvConf() error {
+	if _, err := os.Lstat(resolvConfBackupPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	log.Info(logPrefix, "Restoring ", resolvConfPath)
+	return utils.Sudo("mv "+resolvConfBackupPath+" "+resolvConfPath, "")
+}
+
+// resolvConf renders resolv.conf content with given servers
+func resolvConf(servers []net.IP) string {
+	var content strings.Builder
+	content.WriteString("# Generated by sdna node\n")
+	for _, server := range servers {
+		fmt.Fprintf(&content, "nameserver %s\n", server)
+	}
+	return content.String()
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import (
+	"net"
+
+	"github.com/skytells-research/DNA/network/node/utils"
+)
+
+// resolvconfConfigurator registers tunnel resolvers as a separate resolvconf record,
+// resolvconf puts records of tunnel interfaces in front of the other ones
+type resolvconfConfigurator struct {
+}
+
+// Set adds resolvconf record of the tunnel interface
+func (rc *resolvconfConfigurator) Set(iface string, servers []net.IP) error {
+	return utils.Sudo("resolvconf -a "+recordName(iface), resolvConf(servers))
+}
+
+// Restore removes resolvconf record of the tunnel interface
+func (rc *resolvconfConfigurator) Restore(iface string) error {
+	return utils.Sudo("resolvconf -d "+recordName(iface), "")
+}
+
+// Cleanup does nothing, resolvconf drops records of removed interfaces by itself
+func (rc *resolvconfConfigurator) Cleanup() error {
+	return nil
+}
+
+func recordName(iface string) string {
+	return iface + ".sdna"
+}
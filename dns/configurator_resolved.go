@@ -0,0 +1,53 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/skytells-research/DNA/network/node/utils"
+)
+
+// resolvedConfigurator configures per link resolvers of systemd-resolved,
+// configuration goes away together with the link, so nothing is left after a crash
+type resolvedConfigurator struct {
+}
+
+// Set sets link resolvers and routes all the queries to them
+func (rc *resolvedConfigurator) Set(iface string, servers []net.IP) error {
+	addresses := make([]string, 0, len(servers))
+	for _, server := range servers {
+		addresses = append(addresses, server.String())
+	}
+
+	if err := utils.Sudo("resolvectl dns "+iface+" "+strings.Join(addresses, " "), ""); err != nil {
+		return err
+	}
+	return utils.Sudo("resolvectl domain "+iface+" ~.", "")
+}
+
+// Restore reverts link configuration
+func (rc *resolvedConfigurator) Restore(iface string) error {
+	return utils.Sudo("resolvectl revert "+iface, "")
+}
+
+// Cleanup does nothing, since link configuration does not outlive the link
+func (rc *resolvedConfigurator) Cleanup() error {
+	return nil
+}
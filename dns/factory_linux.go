@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// NewConfigurator returns configurator of the resolvers manager used by the system:
+// systemd-resolved, resolvconf or plain /etc/resolv.conf file
+func NewConfigurator() Configurator {
+	if target, err := os.Readlink(resolvConfPath); err == nil && strings.Contains(target, "systemd/resolve") {
+		if _, err := exec.LookPath("resolvectl"); err == nil {
+			log.Info(logPrefix, "Using systemd-resolved")
+			return &resolvedConfigurator{}
+		}
+	}
+
+	if _, err := exec.LookPath("resolvconf"); err == nil {
+		log.Info(logPrefix, "Using resolvconf")
+		return &resolvconfConfigurator{}
+	}
+
+	log.Info(logPrefix, "Using ", resolvConfPath)
+	return newFileConfigurator()
+}
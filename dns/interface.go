@@ -0,0 +1,30 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import "net"
+
+// Configurator makes the system use tunnel resolvers, so that DNS queries do not leak outside of the tunnel
+type Configurator interface {
+	// Set makes given servers the resolvers used while the tunnel interface is up
+	Set(iface string, servers []net.IP) error
+	// Restore reverts resolvers configuration made for the tunnel interface
+	Restore(iface string) error
+	// Cleanup reverts configuration left by crashed process
+	Cleanup() error
+}
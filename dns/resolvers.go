@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+// resolvedUpstreamPath lists upstream resolvers of systemd-resolved, /etc/resolv.conf points to its local stub then
+const resolvedUpstreamPath = "/run/systemd/resolve/resolv.conf"
+
+// SystemResolvers returns the resolvers system sends DNS queries to, local stub resolvers are skipped
+func SystemResolvers() ([]net.IP, error) {
+	content, err := ioutil.ReadFile(resolvedUpstreamPath)
+	if os.IsNotExist(err) {
+		content, err = ioutil.ReadFile(resolvConfPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseResolvConf(string(content)), nil
+}
+
+func parseResolvConf(content string) []net.IP {
+	var servers []net.IP
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if server := net.ParseIP(fields[1]); server != nil && !server.IsLoopback() {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
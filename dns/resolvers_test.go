@@ -0,0 +1,35 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	content := "# comment\nnameserver 127.0.0.53\nnameserver 192.168.1.1\nsearch lan\nnameserver fd00::1\nnameserver\n"
+
+	assert.Equal(
+		t,
+		[]net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("fd00::1")},
+		parseResolvConf(content),
+	)
+}
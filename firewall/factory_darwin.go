@@ -21,3 +21,8 @@ package firewall
 func NewKillSwitch() KillSwitch {
 	return &pfCtlKillSwitch{}
 }
+
+// newInboundRules returns mocked inbound rules service
+func newInboundRules() InboundRules {
+	return &pfCtlInboundRules{}
+}
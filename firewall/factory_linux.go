@@ -17,7 +17,17 @@
 
 package firewall
 
-// NewKillSwitch returns mocked kill switch service
+// NewKillSwitch returns iptables based kill switch service
 func NewKillSwitch() KillSwitch {
-	return &iptablesKillSwitch{}
+	return newIptablesKillSwitch()
+}
+
+// newInboundRules returns inbound rules service backed by nftables, iptables is used if nftables is not available
+func newInboundRules() InboundRules {
+	return newTrackingInboundRules(func() inboundRulesBackend {
+		if nftablesAvailable() {
+			return nftablesInboundRules{}
+		}
+		return iptablesInboundRules{}
+	})
 }
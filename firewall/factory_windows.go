@@ -21,3 +21,8 @@ package firewall
 func NewKillSwitch() KillSwitch {
 	return &fakeKillSwitch{}
 }
+
+// newInboundRules returns netsh based inbound rules service
+func newInboundRules() InboundRules {
+	return &netshInboundRules{}
+}
@@ -1,5 +1,3 @@
-//+build !windows
-
 /*
  * Copyright (C) 2019 The "sdnaNetwork/node" Authors.
  *
@@ -19,14 +17,51 @@
 
 package firewall
 
+import (
+	"strings"
+	"sync"
+)
+
+const firewallLogPrefix = "[firewall] "
+
+// InboundRule describes incoming traffic allowed by firewall
+type InboundRule struct {
+	Protocol string
+	Port     int
+}
+
+// InboundRules manages inbound rules of the platform specific firewall.
+// Adding the same rule twice or removing unknown rule does nothing.
+type InboundRules interface {
+	Add(rule InboundRule) error
+	Remove(rule InboundRule) error
+}
+
+var (
+	inboundRules     = newInboundRules()
+	inboundRulesLock sync.RWMutex
+)
+
+// SetInboundRules replaces the platform specific firewall backend, i.e. with FakeInboundRules in tests.
+func SetInboundRules(rules InboundRules) {
+	inboundRulesLock.Lock()
+	defer inboundRulesLock.Unlock()
+
+	inboundRules = rules
+}
+
 // AddInboundRule adds new inbound rule to the platform specific firewall.
 func AddInboundRule(proto string, port int) error {
-	// TODO adding firewall rules should be implemented for every platform.
-	return nil
+	inboundRulesLock.RLock()
+	defer inboundRulesLock.RUnlock()
+
+	return inboundRules.Add(InboundRule{Protocol: strings.ToLower(proto), Port: port})
 }
 
 // RemoveInboundRule removes inbound rule from the platform specific firewall.
 func RemoveInboundRule(proto string, port int) error {
-	// TODO adding firewall rules should be implemented for every platform.
-	return nil
+	inboundRulesLock.RLock()
+	defer inboundRulesLock.RUnlock()
+
+	return inboundRules.Remove(InboundRule{Protocol: strings.ToLower(proto), Port: port})
 }
@@ -18,21 +18,18 @@
 package firewall
 
 import (
-	"errors"
 	"fmt"
 
 	log "github.com/cihub/seelog"
 	"github.com/skytells-research/DNA/network/node/utils"
 )
 
-const (
-	firewallLogPrefix = "[firewall] "
-)
+type netshInboundRules struct{}
 
-// AddInboundRule adds new inbound rule to the platform specific firewall.
-func AddInboundRule(proto string, port int) error {
-	name := fmt.Sprintf("myst-%d:%s", port, proto)
-	cmd := fmt.Sprintf(`netsh advfirewall firewall add rule name="%s" dir=in action=allow protocol=%s localport=%d`, name, proto, port)
+// Add adds new inbound rule to windows firewall.
+func (nir *netshInboundRules) Add(rule InboundRule) error {
+	name := fmt.Sprintf("myst-%d:%s", rule.Port, rule.Protocol)
+	cmd := fmt.Sprintf(`netsh advfirewall firewall add rule name="%s" dir=in action=allow protocol=%s localport=%d`, name, rule.Protocol, rule.Port)
 
 	if inboundRuleExists(name) {
 		return nil
@@ -47,13 +44,13 @@ func AddInboundRule(proto string, port int) error {
 	return nil
 }
 
-// RemoveInboundRule removes inbound rule from the platform specific firewall.
-func RemoveInboundRule(proto string, port int) error {
-	name := fmt.Sprintf("myst-%d:%s", port, proto)
+// Remove removes inbound rule from windows firewall.
+func (nir *netshInboundRules) Remove(rule InboundRule) error {
+	name := fmt.Sprintf("myst-%d:%s", rule.Port, rule.Protocol)
 	cmd := fmt.Sprintf(`netsh advfirewall firewall delete rule name="%s" dir=in`, name)
 
 	if !inboundRuleExists(name) {
-		return errors.New("firewall rule not found")
+		return nil
 	}
 
 	_, err := utils.PowerShell(cmd)
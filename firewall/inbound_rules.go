@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// inboundRulesBackend replaces the whole set of inbound rules in the firewall
+type inboundRulesBackend interface {
+	name() string
+	apply(rules []InboundRule) error
+}
+
+// trackingInboundRules keeps track of the rules it created and applies the whole set on every change,
+// so that rules left by a crashed process are dropped by the first change
+type trackingInboundRules struct {
+	mu      sync.Mutex
+	rules   map[InboundRule]struct{}
+	backend inboundRulesBackend
+	detect  func() inboundRulesBackend
+}
+
+func newTrackingInboundRules(detect func() inboundRulesBackend) *trackingInboundRules {
+	return &trackingInboundRules{
+		rules:  make(map[InboundRule]struct{}),
+		detect: detect,
+	}
+}
+
+// Add allows incoming traffic described by given rule
+func (tir *trackingInboundRules) Add(rule InboundRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	tir.mu.Lock()
+	defer tir.mu.Unlock()
+
+	if _, exists := tir.rules[rule]; exists {
+		return nil
+	}
+
+	tir.rules[rule] = struct{}{}
+	if err := tir.apply(); err != nil {
+		delete(tir.rules, rule)
+		return errors.Wrap(err, "failed to add inbound rule")
+	}
+
+	log.Info(firewallLogPrefix, "Allowed inbound ", rule)
+	return nil
+}
+
+// Remove removes rule created by Add
+func (tir *trackingInboundRules) Remove(rule InboundRule) error {
+	tir.mu.Lock()
+	defer tir.mu.Unlock()
+
+	if _, exists := tir.rules[rule]; !exists {
+		return nil
+	}
+
+	delete(tir.rules, rule)
+	if err := tir.apply(); err != nil {
+		tir.rules[rule] = struct{}{}
+		return errors.Wrap(err, "failed to remove inbound rule")
+	}
+
+	log.Info(firewallLogPrefix, "Removed inbound ", rule)
+	return nil
+}
+
+func (tir *trackingInboundRules) apply() error {
+	if tir.backend == nil {
+		tir.backend = tir.detect()
+		log.Info(firewallLogPrefix, "Using ", tir.backend.name(), " for inbound rules")
+	}
+
+	rules := make([]InboundRule, 0, len(tir.rules))
+	for rule := range tir.rules {
+		rules = append(rules, rule)
+	}
+	return tir.backend.apply(sortInboundRules(rules))
+}
+
+func (rule InboundRule) validate() error {
+	if rule.Protocol != "tcp" && rule.Protocol != "udp" {
+		return fmt.Errorf("unsupported protocol of inbound rule: %q", rule.Protocol)
+	}
+	if rule.Port <= 0 || rule.Port > 65535 {
+		return fmt.Errorf("invalid port of inbound rule: %d", rule.Port)
+	}
+	return nil
+}
+
+func (rule InboundRule) String() string {
+	return fmt.Sprintf("%s/%d", rule.Protocol, rule.Port)
+}
+
+func sortInboundRules(rules []InboundRule) []InboundRule {
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Protocol != rules[j].Protocol {
+			return rules[i].Protocol < rules[j].Protocol
+		}
+		return rules[i].Port < rules[j].Port
+	})
+	return rules
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package firewall
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type backendFake struct {
+	applied  [][]InboundRule
+	applyErr error
+}
+
+func (bf *backendFake) name() string {
+	return "fake"
+}
+
+func (bf *backendFake) apply(rules []InboundRule) error {
+	if bf.applyErr != nil {
+		return bf.applyErr
+	}
+	bf.applied = append(bf.applied, rules)
+	return nil
+}
+
+func newRulesWithBackend(backend *backendFake) *trackingInboundRules {
+	return newTrackingInboundRules(func() inboundRulesBackend { return backend })
+}
+
+func TestTrackingInboundRulesAreIdempotent(t *testing.T) {
+	backend := &backendFake{}
+	rules := newRulesWithBackend(backend)
+
+	assert.NoError(t, rules.Add(InboundRule{Protocol: "udp", Port: 1194}))
+	assert.NoError(t, rules.Add(InboundRule{Protocol: "udp", Port: 1194}))
+	assert.NoError(t, rules.Add(InboundRule{Protocol: "tcp", Port: 443}))
+	assert.NoError(t, rules.Remove(InboundRule{Protocol: "udp", Port: 1194}))
+	assert.NoError(t, rules.Remove(InboundRule{Protocol: "udp", Port: 1194}))
+	assert.NoError(t, rules.Remove(InboundRule{Protocol: "tcp", Port: 443}))
+
+	assert.Equal(
+		t,
+		[][]InboundRule{
+			{{Protocol: "udp", Port: 1194}},
+			{{Protocol: "tcp", Port: 443}, {Protocol: "udp", Port: 1194}},
+			{{Protocol: "tcp", Port: 443}},
+			{},
+		},
+		backend.applied,
+	)
+}
+
+func TestTrackingInboundRulesDoNotTouchFirewallOnUnknownRemove(t *testing.T) {
+	rules := newTrackingInboundRules(func() inboundRulesBackend {
+		t.Fatal("backend should not be detected")
+		return nil
+	})
+
+	assert.NoError(t, rules.Remove(InboundRule{Protocol: "udp", Port: 1194}))
+}
+
+func TestTrackingInboundRulesForgetRuleWhichFailedToApply(t *testing.T) {
+	backend := &backendFake{applyErr: errors.New("permission denied")}
+	rules := newRulesWithBackend(backend)
+
+	assert.Error(t, rules.Add(InboundRule{Protocol: "udp", Port: 1194}))
+
+	backend.applyErr = nil
+	assert.NoError(t, rules.Remove(InboundRule{Protocol: "udp", Port: 1194}))
+	assert.Empty(t, backend.applied)
+}
+
+func TestTrackingInboundRulesValidateRule(t *testing.T) {
+	rules := newRulesWithBackend(&backendFake{})
+
+	assert.Error(t, rules.Add(InboundRule{Protocol: "icmp", Port: 1194}))
+	assert.Error(t, rules.Add(InboundRule{Protocol: "udp", Port: 0}))
+}
@@ -17,8 +17,33 @@
 
 package firewall
 
+import "net"
+
 // KillSwitch enables fw rules restricting all communication except via VPN
 type KillSwitch interface {
-	Enable()
-	Disable()
+	// Enable blocks all traffic except the one allowed for the given tunnel, several tunnels may be enabled at once
+	Enable(tunnel Tunnel) error
+	// Disable removes rules of the given tunnel, traffic is unblocked once no tunnels are left
+	Disable(tunnel Tunnel) error
+	// Cleanup removes all kill switch rules, including the ones left by crashed process
+	Cleanup() error
+}
+
+// Tunnel describes traffic which stays allowed while kill switch is enabled
+type Tunnel struct {
+	// Interface is the name of tunnel network interface, trailing "+" matches any interface with given prefix
+	Interface string
+	// ProviderIP is the address tunnel is established with
+	ProviderIP net.IP
+	// AllowedNetworks are reachable outside of the tunnel, i.e. LAN ranges
+	AllowedNetworks []net.IPNet
+	// AllowedResolvers are reachable by DNS outside of the tunnel, while it is being re-established
+	AllowedResolvers []net.IP
+	// RoutedNetworks are the only destinations routed through split tunnel, only they are blocked outside of it.
+	// All traffic is blocked outside of the tunnel if empty.
+	RoutedNetworks []net.IPNet
+}
+
+func (tunnel Tunnel) key() string {
+	return tunnel.Interface + "/" + tunnel.ProviderIP.String()
 }
@@ -17,13 +17,65 @@
 
 package firewall
 
+import "sync"
+
 type fakeKillSwitch struct {
 }
 
 // Enable enables kill switch mock
-func (ks *fakeKillSwitch) Enable() {
+func (ks *fakeKillSwitch) Enable(tunnel Tunnel) error {
+	return nil
+}
+
+// Disable disables kill switch mock
+func (ks *fakeKillSwitch) Disable(tunnel Tunnel) error {
+	return nil
+}
+
+// Cleanup cleans kill switch mock
+func (ks *fakeKillSwitch) Cleanup() error {
+	return nil
+}
+
+// FakeInboundRules records requested inbound rules instead of changing the firewall
+type FakeInboundRules struct {
+	mu    sync.Mutex
+	rules map[InboundRule]struct{}
+}
+
+// NewFakeInboundRules creates fake inbound rules backend
+func NewFakeInboundRules() *FakeInboundRules {
+	return &FakeInboundRules{
+		rules: make(map[InboundRule]struct{}),
+	}
+}
+
+// Add records given rule
+func (fir *FakeInboundRules) Add(rule InboundRule) error {
+	fir.mu.Lock()
+	defer fir.mu.Unlock()
+
+	fir.rules[rule] = struct{}{}
+	return nil
 }
 
-// Enable disables kill switch mock
-func (ks *fakeKillSwitch) Disable() {
+// Remove forgets given rule
+func (fir *FakeInboundRules) Remove(rule InboundRule) error {
+	fir.mu.Lock()
+	defer fir.mu.Unlock()
+
+	delete(fir.rules, rule)
+	return nil
+}
+
+// Rules returns currently added rules sorted by protocol and port
+func (fir *FakeInboundRules) Rules() []InboundRule {
+	fir.mu.Lock()
+	defer fir.mu.Unlock()
+
+	rules := make([]InboundRule, 0, len(fir.rules))
+	for rule := range fir.rules {
+		rules = append(rules, rule)
+	}
+	return sortInboundRules(rules)
 }
@@ -17,13 +17,285 @@
 
 package firewall
 
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/node/utils"
+)
+
+const killSwitchLogPrefix = "[kill-switch] "
+
+var (
+	// killSwitchChain holds all kill switch rules, they are tagged so that leftovers can be found after a crash
+	killSwitchChain = iptablesChain{name: "SDNA_KILLSWITCH", hook: "OUTPUT", tag: "sdna-killswitch"}
+	// inboundChain holds all inbound rules
+	inboundChain = iptablesChain{name: "SDNA_INBOUND", hook: "INPUT", tag: "sdna-inbound"}
+)
+
+type ipFamily struct {
+	name     string
+	iptables string
+	restore  string
+	ipv6     bool
+}
+
+var (
+	familyIPv4 = ipFamily{"IPv4", "/sbin/iptables", "/sbin/iptables-restore", false}
+	familyIPv6 = ipFamily{"IPv6", "/sbin/ip6tables", "/sbin/ip6tables-restore", true}
+)
+
 type iptablesKillSwitch struct {
+	mu      sync.Mutex
+	tunnels map[string]Tunnel
+}
+
+func newIptablesKillSwitch() *iptablesKillSwitch {
+	return &iptablesKillSwitch{
+		tunnels: make(map[string]Tunnel),
+	}
+}
+
+// Enable blocks all outgoing traffic except loopback and the one allowed for enabled tunnels
+func (ks *iptablesKillSwitch) Enable(tunnel Tunnel) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	previous, existed := ks.tunnels[tunnel.key()]
+	ks.tunnels[tunnel.key()] = tunnel
+	if err := ks.apply(); err != nil {
+		if existed {
+			ks.tunnels[tunnel.key()] = previous
+		} else {
+			delete(ks.tunnels, tunnel.key())
+		}
+		return errors.Wrap(err, "failed to enable kill switch")
+	}
+
+	log.Info(killSwitchLogPrefix, "Enabled for interface ", tunnel.Interface)
+	return nil
+}
+
+// Disable removes rules of the given tunnel, kill switch chain is removed together with the last tunnel
+func (ks *iptablesKillSwitch) Disable(tunnel Tunnel) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	delete(ks.tunnels, tunnel.key())
+	if err := ks.apply(); err != nil {
+		return errors.Wrap(err, "failed to disable kill switch")
+	}
+
+	log.Info(killSwitchLogPrefix, "Disabled for interface ", tunnel.Interface)
+	return nil
+}
+
+// Cleanup removes everything tagged as kill switch rule
+func (ks *iptablesKillSwitch) Cleanup() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.tunnels = make(map[string]Tunnel)
+	return ks.apply()
+}
+
+func (ks *iptablesKillSwitch) apply() error {
+	tunnels := make([]Tunnel, 0, len(ks.tunnels))
+	for _, tunnel := range ks.tunnels {
+		tunnels = append(tunnels, tunnel)
+	}
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].key() < tunnels[j].key() })
+
+	// traffic of hosts with IPv6 leaks unless IPv6 rules are applied too
+	requireIPv6 := len(tunnels) > 0 && hostHasIPv6()
+	return forEachFamily(killSwitchLogPrefix, requireIPv6, func(family ipFamily) error {
+		if len(tunnels) == 0 {
+			return removeKillSwitch(family)
+		}
+		return restoreKillSwitch(family, tunnels)
+	})
+}
+
+// forEachFamily applies rules of both IP families, IPv6 failures are only logged unless IPv6 rules are required
+func forEachFamily(logPrefix string, requireIPv6 bool, apply func(family ipFamily) error) error {
+	for _, family := range []ipFamily{familyIPv4, familyIPv6} {
+		err := apply(family)
+		if err != nil && family.ipv6 && !requireIPv6 {
+			log.Warn(logPrefix, "Failed to apply IPv6 rules: ", err)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostHasIPv6 reports whether any interface except loopback has IPv6 address
+var hostHasIPv6 = func() bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		// not knowing is treated as having IPv6, so that it does not leak
+		return true
+	}
+	return hasIPv6(addrs)
+}
+
+func hasIPv6(addrs []net.Addr) bool {
+	for _, addr := range addrs {
+		network, ok := addr.(*net.IPNet)
+		if ok && network.IP.To4() == nil && !network.IP.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreKillSwitch atomically replaces the content of kill switch chain and hooks it into OUTPUT chain
+func restoreKillSwitch(family ipFamily, tunnels []Tunnel) error {
+	return killSwitchChain.restore(family, killSwitchRules(family, tunnels, !killSwitchChain.hooked(family)))
+}
+
+// removeKillSwitch removes all jumps to kill switch chain and the chain itself
+func removeKillSwitch(family ipFamily) error {
+	return killSwitchChain.remove(family)
+}
+
+// killSwitchRules renders iptables-restore input, declaring the chain flushes its previous content.
+// DNS is rejected unless it goes through the tunnel or to allowed resolvers, even to the provider or allowed networks.
+// If all tunnels are split ones, only their routed networks are rejected outside of tunnels and DNS is not restricted.
+func killSwitchRules(family ipFamily, tunnels []Tunnel, hook bool) string {
+	rules := []string{"-o lo -j RETURN"}
+	for _, tunnel := range tunnels {
+		if tunnel.Interface != "" {
+			rules = append(rules, "-o "+tunnel.Interface+" -j RETURN")
+		}
+	}
+
+	split := len(tunnels) > 0
+	for _, tunnel := range tunnels {
+		split = split && len(tunnel.RoutedNetworks) > 0
+	}
+
+	if !split {
+		for _, tunnel := range tunnels {
+			for _, resolver := range tunnel.AllowedResolvers {
+				if (resolver.To4() == nil) == family.ipv6 {
+					rules = append(rules,
+						"-d "+resolver.String()+" -p udp --dport 53 -j RETURN",
+						"-d "+resolver.String()+" -p tcp --dport 53 -j RETURN",
+					)
+				}
+			}
+		}
+		rules = append(rules, "-p udp --dport 53 -j REJECT", "-p tcp --dport 53 -j REJECT")
+	}
+	for _, tunnel := range tunnels {
+		if tunnel.ProviderIP != nil && (tunnel.ProviderIP.To4() == nil) == family.ipv6 {
+			rules = append(rules, "-d "+tunnel.ProviderIP.String()+" -j RETURN")
+		}
+		for _, network := range tunnel.AllowedNetworks {
+			if (network.IP.To4() == nil) == family.ipv6 {
+				rules = append(rules, "-d "+network.String()+" -j RETURN")
+			}
+		}
+	}
+
+	if !split {
+		rules = append(rules, "-j REJECT")
+		return killSwitchChain.render(rules, hook)
+	}
+	// the rest of traffic is not routed through split tunnels and leaves the chain
+	for _, tunnel := range tunnels {
+		for _, network := range tunnel.RoutedNetworks {
+			if (network.IP.To4() == nil) == family.ipv6 {
+				rules = append(rules, "-d "+network.String()+" -j REJECT")
+			}
+		}
+	}
+	return killSwitchChain.render(rules, hook)
+}
+
+type iptablesInboundRules struct{}
+
+func (iir iptablesInboundRules) name() string {
+	return "iptables"
+}
+
+// apply atomically replaces the content of inbound chain and hooks it into INPUT chain
+func (iir iptablesInboundRules) apply(rules []InboundRule) error {
+	// hosts without IPv6 support have nothing to serve over it
+	return forEachFamily(firewallLogPrefix, false, func(family ipFamily) error {
+		if len(rules) == 0 {
+			return inboundChain.remove(family)
+		}
+		return inboundChain.restore(family, iptablesInboundRulesInput(rules, !inboundChain.hooked(family)))
+	})
+}
+
+func iptablesInboundRulesInput(rules []InboundRule, hook bool) string {
+	accepts := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		accepts = append(accepts, fmt.Sprintf("-p %s --dport %d -j ACCEPT", rule.Protocol, rule.Port))
+	}
+	return inboundChain.render(accepts, hook)
+}
+
+// iptablesChain is a dedicated chain hooked into one of built-in chains, all its rules are tagged with a comment
+type iptablesChain struct {
+	name string
+	hook string
+	tag  string
+}
+
+func (chain iptablesChain) jumpRule() string {
+	return chain.hook + " --match comment --comment " + chain.tag + " --jump " + chain.name
 }
 
-// Enable enables kill switch mock
-func (ks *iptablesKillSwitch) Enable() {
+func (chain iptablesChain) hooked(family ipFamily) bool {
+	return utils.Sudo(family.iptables+" --check "+chain.jumpRule(), "") == nil
 }
 
-// Enable disables kill switch mock
-func (ks *iptablesKillSwitch) Disable() {
+// restore atomically replaces the content of the chain with given iptables-restore input
+func (chain iptablesChain) restore(family ipFamily, input string) error {
+	return utils.Sudo(family.restore+" --noflush", input)
+}
+
+// remove removes all jumps to the chain and the chain itself
+func (chain iptablesChain) remove(family ipFamily) error {
+	for utils.Sudo(family.iptables+" --delete "+chain.jumpRule(), "") == nil {
+		log.Info(firewallLogPrefix, "Removed ", family.name, " ", chain.name, " hook")
+	}
+
+	if utils.Sudo(family.iptables+" --numeric --list "+chain.name, "") != nil {
+		return nil
+	}
+	if err := utils.Sudo(family.iptables+" --flush "+chain.name, ""); err != nil {
+		return err
+	}
+	return utils.Sudo(family.iptables+" --delete-chain "+chain.name, "")
+}
+
+// render renders iptables-restore input of given "match -j target" rules, declaring the chain flushes its previous content
+func (chain iptablesChain) render(rules []string, hook bool) string {
+	var input strings.Builder
+	input.WriteString("*filter\n")
+	fmt.Fprintf(&input, ":%s - [0:0]\n", chain.name)
+
+	for _, rule := range rules {
+		match, target := "", rule
+		if i := strings.LastIndex(rule, "-j "); i >= 0 {
+			match, target = rule[:i], rule[i:]
+		}
+		fmt.Fprintf(&input, "-A %s %s-m comment --comment %s %s\n", chain.name, match, chain.tag, target)
+	}
+
+	if hook {
+		fmt.Fprintf(&input, "-I %s 1 -m comment --comment %s -j %s\n", chain.hook, chain.tag, chain.name)
+	}
+	input.WriteString("COMMIT\n")
+	return input.String()
 }
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package firewall
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillSwitchRules(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	_, lan6, _ := net.ParseCIDR("fd00::/8")
+	tunnels := []Tunnel{{
+		Interface:       "myst0",
+		ProviderIP:      net.ParseIP("1.2.3.4"),
+		AllowedNetworks: []net.IPNet{*lan, *lan6},
+	}}
+
+	assert.Equal(
+		t,
+		`*filter
+:SDNA_KILLSWITCH - [0:0]
+-A SDNA_KILLSWITCH -o lo -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -o myst0 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -p udp --dport 53 -m comment --comment sdna-killswitch -j REJECT
+-A SDNA_KILLSWITCH -p tcp --dport 53 -m comment --comment sdna-killswitch -j REJECT
+-A SDNA_KILLSWITCH -d 1.2.3.4 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -d 192.168.1.0/24 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -m comment --comment sdna-killswitch -j REJECT
+-I OUTPUT 1 -m comment --comment sdna-killswitch -j SDNA_KILLSWITCH
+COMMIT
+`,
+		killSwitchRules(familyIPv4, tunnels, true),
+	)
+
+	assert.Equal(
+		t,
+		`*filter
+:SDNA_KILLSWITCH - [0:0]
+-A SDNA_KILLSWITCH -o lo -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -o myst0 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -p udp --dport 53 -m comment --comment sdna-killswitch -j REJECT
+-A SDNA_KILLSWITCH -p tcp --dport 53 -m comment --comment sdna-killswitch -j REJECT
+-A SDNA_KILLSWITCH -d fd00::/8 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -m comment --comment sdna-killswitch -j REJECT
+COMMIT
+`,
+		killSwitchRules(familyIPv6, tunnels, false),
+	)
+}
+
+func TestKillSwitchRules_AllowedResolvers(t *testing.T) {
+	tunnels := []Tunnel{{
+		Interface:        "myst0",
+		ProviderIP:       net.ParseIP("1.2.3.4"),
+		AllowedResolvers: []net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("fd00::1")},
+	}}
+
+	assert.Equal(
+		t,
+		`*filter
+:SDNA_KILLSWITCH - [0:0]
+-A SDNA_KILLSWITCH -o lo -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -o myst0 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -d 192.168.1.1 -p udp --dport 53 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -d 192.168.1.1 -p tcp --dport 53 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -p udp --dport 53 -m comment --comment sdna-killswitch -j REJECT
+-A SDNA_KILLSWITCH -p tcp --dport 53 -m comment --comment sdna-killswitch -j REJECT
+-A SDNA_KILLSWITCH -d 1.2.3.4 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -m comment --comment sdna-killswitch -j REJECT
+COMMIT
+`,
+		killSwitchRules(familyIPv4, tunnels, false),
+	)
+}
+
+func TestKillSwitchRules_SplitTunnel(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	_, routed, _ := net.ParseCIDR("10.0.0.0/8")
+	tunnels := []Tunnel{{
+		Interface:       "myst0",
+		ProviderIP:      net.ParseIP("1.2.3.4"),
+		AllowedNetworks: []net.IPNet{*lan},
+		RoutedNetworks:  []net.IPNet{*routed},
+	}}
+
+	assert.Equal(
+		t,
+		`*filter
+:SDNA_KILLSWITCH - [0:0]
+-A SDNA_KILLSWITCH -o lo -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -o myst0 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -d 1.2.3.4 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -d 192.168.1.0/24 -m comment --comment sdna-killswitch -j RETURN
+-A SDNA_KILLSWITCH -d 10.0.0.0/8 -m comment --comment sdna-killswitch -j REJECT
+COMMIT
+`,
+		killSwitchRules(familyIPv4, tunnels, false),
+	)
+
+	// full tunnel blocks everything outside of tunnels
+	tunnels = append(tunnels, Tunnel{Interface: "myst1", ProviderIP: net.ParseIP("5.6.7.8")})
+	assert.Contains(t, killSwitchRules(familyIPv4, tunnels, false), "-A SDNA_KILLSWITCH -m comment --comment sdna-killswitch -j REJECT\n")
+}
+
+func TestIptablesInboundRulesInput(t *testing.T) {
+	rules := []InboundRule{{Protocol: "tcp", Port: 443}, {Protocol: "udp", Port: 1194}}
+
+	assert.Equal(
+		t,
+		`*filter
+:SDNA_INBOUND - [0:0]
+-A SDNA_INBOUND -p tcp --dport 443 -m comment --comment sdna-inbound -j ACCEPT
+-A SDNA_INBOUND -p udp --dport 1194 -m comment --comment sdna-inbound -j ACCEPT
+-I INPUT 1 -m comment --comment sdna-inbound -j SDNA_INBOUND
+COMMIT
+`,
+		iptablesInboundRulesInput(rules, true),
+	)
+}
+
+func TestForEachFamily_IPv6FailureFailsOnlyWhenRequired(t *testing.T) {
+	failIPv6 := func(family ipFamily) error {
+		if family.ipv6 {
+			return errors.New("ip6tables is not available")
+		}
+		return nil
+	}
+
+	assert.NoError(t, forEachFamily(killSwitchLogPrefix, false, failIPv6))
+	assert.EqualError(t, forEachFamily(killSwitchLogPrefix, true, failIPv6), "ip6tables is not available")
+}
+
+func TestHasIPv6(t *testing.T) {
+	network := func(cidr string) net.Addr {
+		ip, network, _ := net.ParseCIDR(cidr)
+		network.IP = ip
+		return network
+	}
+
+	assert.False(t, hasIPv6([]net.Addr{network("127.0.0.1/8"), network("::1/128"), network("192.168.1.2/24")}))
+	assert.True(t, hasIPv6([]net.Addr{network("192.168.1.2/24"), network("fe80::1/64")}))
+	assert.True(t, hasIPv6([]net.Addr{network("2001:db8::2/64")}))
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/node/utils"
+)
+
+const nftBinary = "/usr/sbin/nft"
+
+// nftablesInboundRules inserts inbound rules into every filter chain hooked into input.
+// Accept in a separate chain would not override drops of other chains hooked into the same place.
+type nftablesInboundRules struct{}
+
+func (nir nftablesInboundRules) name() string {
+	return "nftables"
+}
+
+// apply atomically replaces tagged rules of input chains, nothing is inserted if there are no input chains to bypass
+func (nir nftablesInboundRules) apply(rules []InboundRule) error {
+	output, err := utils.SudoOutput(nftBinary + " --json list ruleset")
+	if err != nil {
+		return errors.Wrap(err, "failed to list nftables ruleset")
+	}
+
+	var ruleset nftablesRuleset
+	if err := json.Unmarshal(output, &ruleset); err != nil {
+		return errors.Wrap(err, "failed to parse nftables ruleset")
+	}
+
+	input := nftablesInboundRulesInput(ruleset, rules)
+	if input == "" {
+		return nil
+	}
+	return utils.Sudo(nftBinary+" -f -", input)
+}
+
+// nftablesAvailable checks whether nftables is installed and usable
+func nftablesAvailable() bool {
+	return utils.Sudo(nftBinary+" list tables", "") == nil
+}
+
+// nftablesRuleset is the part of "nft --json list ruleset" output needed to find input chains and tagged rules
+type nftablesRuleset struct {
+	Nftables []struct {
+		Chain *nftablesChain `json:"chain"`
+		Rule  *nftablesRule  `json:"rule"`
+	} `json:"nftables"`
+}
+
+type nftablesChain struct {
+	Family string `json:"family"`
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Hook   string `json:"hook"`
+}
+
+type nftablesRule struct {
+	Family  string `json:"family"`
+	Table   string `json:"table"`
+	Chain   string `json:"chain"`
+	Handle  int    `json:"handle"`
+	Comment string `json:"comment"`
+}
+
+func (chain nftablesChain) String() string {
+	return chain.Family + " " + chain.Table + " " + chain.Name
+}
+
+// nftablesInboundRulesInput renders nft script deleting previously inserted rules and inserting given ones
+// on top of every input filter chain, rules of ip and ip6 families are inserted only for matching traffic
+func nftablesInboundRulesInput(ruleset nftablesRuleset, rules []InboundRule) string {
+	var input strings.Builder
+	for _, object := range ruleset.Nftables {
+		if rule := object.Rule; rule != nil && rule.Comment == inboundChain.tag {
+			fmt.Fprintf(&input, "delete rule %s %s %s handle %d\n", rule.Family, rule.Table, rule.Chain, rule.Handle)
+		}
+	}
+
+	for _, object := range ruleset.Nftables {
+		chain := object.Chain
+		if chain == nil || chain.Type != "filter" || chain.Hook != "input" {
+			continue
+		}
+		// insert puts the rule on top, so rules are inserted in reverse to keep their order
+		for i := len(rules) - 1; i >= 0; i-- {
+			fmt.Fprintf(&input, "insert rule %s %s dport %d accept comment \"%s\"\n", chain, rules[i].Protocol, rules[i].Port, inboundChain.tag)
+		}
+	}
+	return input.String()
+}
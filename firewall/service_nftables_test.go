@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package firewall
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nftablesRulesetJSON = `{"nftables": [
+	{"metainfo": {"version": "0.9.0", "release_name": "Fearless Fosdick", "json_schema_version": 1}},
+	{"table": {"family": "inet", "name": "filter", "handle": 1}},
+	{"chain": {"family": "inet", "table": "filter", "name": "input", "handle": 1, "type": "filter", "hook": "input", "prio": 0, "policy": "drop"}},
+	{"chain": {"family": "inet", "table": "filter", "name": "output", "handle": 2, "type": "filter", "hook": "output", "prio": 0, "policy": "accept"}},
+	{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 7, "comment": "sdna-inbound", "expr": []}},
+	{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 8, "expr": []}},
+	{"table": {"family": "ip", "name": "nat", "handle": 2}},
+	{"chain": {"family": "ip", "table": "nat", "name": "prerouting", "handle": 1, "type": "nat", "hook": "prerouting", "prio": -100, "policy": "accept"}}
+]}`
+
+func TestNftablesInboundRulesInput(t *testing.T) {
+	var ruleset nftablesRuleset
+	assert.NoError(t, json.Unmarshal([]byte(nftablesRulesetJSON), &ruleset))
+
+	assert.Equal(
+		t,
+		`delete rule inet filter input handle 7
+insert rule inet filter input udp dport 52820 accept comment "sdna-inbound"
+insert rule inet filter input udp dport 1194 accept comment "sdna-inbound"
+`,
+		nftablesInboundRulesInput(ruleset, []InboundRule{{Protocol: "udp", Port: 1194}, {Protocol: "udp", Port: 52820}}),
+	)
+
+	assert.Equal(
+		t,
+		`delete rule inet filter input handle 7
+`,
+		nftablesInboundRulesInput(ruleset, nil),
+	)
+}
+
+func TestNftablesInboundRulesInput_NoInputChains(t *testing.T) {
+	assert.Empty(t, nftablesInboundRulesInput(nftablesRuleset{}, []InboundRule{{Protocol: "udp", Port: 1194}}))
+}
@@ -21,9 +21,29 @@ type pfCtlKillSwitch struct {
 }
 
 // Enable enables kill switch mock
-func (ks *pfCtlKillSwitch) Enable() {
+func (ks *pfCtlKillSwitch) Enable(tunnel Tunnel) error {
+	return nil
 }
 
-// Enable disables kill switch mock
-func (ks *pfCtlKillSwitch) Disable() {
+// Disable disables kill switch mock
+func (ks *pfCtlKillSwitch) Disable(tunnel Tunnel) error {
+	return nil
+}
+
+// Cleanup cleans kill switch mock
+func (ks *pfCtlKillSwitch) Cleanup() error {
+	return nil
+}
+
+type pfCtlInboundRules struct {
+}
+
+// Add adds inbound rule mock
+func (ir *pfCtlInboundRules) Add(rule InboundRule) error {
+	return nil
+}
+
+// Remove removes inbound rule mock
+func (ir *pfCtlInboundRules) Remove(rule InboundRule) error {
+	return nil
 }
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/skytells-research/sdna/netlink"
 	"github.com/milosgajdos83/tenus"
+	"github.com/skytells-research/sdna/netlink"
 	"github.com/songgao/water"
 )
 
@@ -55,41 +61,314 @@ func ifaceSetup(localCIDR string) *water.Interface {
 	return iface
 }
 
-func routesThread(ifaceName string, refresh chan bool) {
-	currentRoutes := map[string]bool{}
-	for {
-		<-refresh
-		log.Println("Reloading routes...")
-		conf := config.Load().(VPNState)
-
-		routes2Del := map[string]bool{}
-
-		for r := range currentRoutes {
-			routes2Del[r] = true
-		}
-
-		for r := range conf.routes {
-			rs := r.String()
-			if _, exist := routes2Del[rs]; exist {
-				delete(routes2Del, rs)
-			} else {
-				// real add route
-				currentRoutes[rs] = true
-				log.Println("Adding route:", rs)
-				err := netlink.AddRoute(rs, "", "", ifaceName)
-				if nil != err {
-					log.Println("Adding route", rs, "failed:", err)
+// routeRetryInterval is how long to wait before adding failed routes again
+const routeRetryInterval = 10 * time.Second
+
+// routeKeeper keeps routes from config in routing table and policy
+// rules which select that table in sync with current config
+type routeKeeper struct {
+	ifaceName string
+	lock      sync.Mutex
+	routes    map[string]netlink.RouteParams
+	rules     map[string]netlink.Rule
+	linkDown  bool
+	done      chan struct{}
+}
+
+func newRouteKeeper(ifaceName string) *routeKeeper {
+	return &routeKeeper{
+		ifaceName: ifaceName,
+		routes:    map[string]netlink.RouteParams{},
+		rules:     map[string]netlink.Rule{},
+		done:      make(chan struct{}),
+	}
+}
+
+// ruleKey returns rule description usable as map key and in logs
+func ruleKey(r netlink.Rule) string {
+	key := "from all"
+	if nil != r.Src {
+		key = "from " + r.Src.String()
+	}
+	if 0 != r.Mark {
+		key += fmt.Sprintf(" fwmark %#x", r.Mark)
+	}
+	if 0 != r.Priority {
+		key += fmt.Sprintf(" priority %d", r.Priority)
+	}
+	return key + fmt.Sprintf(" table %d", r.Table)
+}
+
+func wantedRoutes(conf VPNState, ifaceName string) map[string]netlink.RouteParams {
+	result := make(map[string]netlink.RouteParams, len(conf.routes))
+	for r := range conf.routes {
+		rs := r.String()
+		result[rs] = netlink.RouteParams{
+			Destination: rs,
+			Source:      conf.Main.locIP,
+			Device:      ifaceName,
+			Table:       conf.Main.RouteTable,
+			Metric:      conf.Main.RouteMetric,
+		}
+	}
+	return result
+}
+
+func wantedRules(conf VPNState) map[string]netlink.Rule {
+	result := map[string]netlink.Rule{}
+	if 0 == conf.Main.RouteTable {
+		return result
+	}
+
+	add := func(r netlink.Rule) {
+		r.Table = conf.Main.RouteTable
+		r.Priority = conf.Main.RulePriority
+		result[ruleKey(r)] = r
+	}
+
+	if 0 != conf.Main.FwMark {
+		add(netlink.Rule{Mark: conf.Main.FwMark})
+	}
+	for _, from := range conf.Main.ruleFrom {
+		add(netlink.Rule{Src: from})
+	}
+
+	return result
+}
+
+// sync installs routes and rules of the config, removes the rest and
+// reports whether all of them were installed; failed ones are not
+// recorded, so they are added again on the next sync
+func (k *routeKeeper) sync(conf VPNState) bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	complete := true
+
+	routes := wantedRoutes(conf, k.ifaceName)
+
+	// routes with changed table, metric or source are re-added
+	for rs, p := range k.routes {
+		if w, exist := routes[rs]; exist && reflect.DeepEqual(w, p) {
+			continue
+		}
+		delete(k.routes, rs)
+		log.Println("Removing route:", rs)
+		err := netlink.DelRouteParams(p)
+		if nil != err {
+			log.Printf("Error removeing route \"%s\": %s", rs, err.Error())
+		}
+	}
+
+	for rs, p := range routes {
+		if _, exist := k.routes[rs]; exist {
+			continue
+		}
+		log.Println("Adding route:", rs)
+		err := netlink.AddRouteParams(p)
+		if nil != err && !os.IsExist(err) {
+			log.Println("Adding route", rs, "failed:", err)
+			complete = false
+			continue
+		}
+		k.routes[rs] = p
+	}
+
+	rules := wantedRules(conf)
+	if 0 != conf.Main.RouteTable && 0 == len(rules) {
+		log.Println("Routes are in table", conf.Main.RouteTable,
+			"but no fwmark or rulefrom is set to use it")
+	}
+
+	for key, r := range k.rules {
+		if _, exist := rules[key]; exist {
+			continue
+		}
+		delete(k.rules, key)
+		log.Println("Removing rule:", key)
+		err := netlink.DelRule(r)
+		if nil != err {
+			log.Printf("Error removing rule \"%s\": %s", key, err.Error())
+		}
+	}
+
+	for key, r := range rules {
+		if _, exist := k.rules[key]; exist {
+			continue
+		}
+		log.Println("Adding rule:", key)
+		err := netlink.AddRule(r)
+		if nil != err && !os.IsExist(err) {
+			log.Println("Adding rule", key, "failed:", err)
+			complete = false
+			continue
+		}
+		k.rules[key] = r
+	}
+
+	return complete
+}
+
+// heal watches kernel route and link changes: routes removed by someone
+// else are added back, and all routes are restored when interface comes
+// up again (kernel flushes routes of the link which goes down) or when
+// changes were lost, since the lost ones may be removals
+func (k *routeKeeper) heal() error {
+	iface, err := net.InterfaceByName(k.ifaceName)
+	if nil != err {
+		return err
+	}
+
+	routeUpdates := make(chan netlink.RouteUpdate, 64)
+	if err := netlink.RouteSubscribe(routeUpdates, k.done); nil != err {
+		return err
+	}
+
+	linkUpdates := make(chan netlink.LinkUpdate, 16)
+	if err := netlink.LinkSubscribe(linkUpdates, k.done); nil != err {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case u, ok := <-routeUpdates:
+				if !ok {
+					log.Println("Route changes monitoring stopped")
+					return
+				}
+				if u.Resync {
+					k.resync()
+				} else if u.Deleted && u.LinkIndex == iface.Index && nil != u.IPNet {
+					k.restoreRoute(u.Route)
+				}
+			case u, ok := <-linkUpdates:
+				if !ok {
+					log.Println("Interface changes monitoring stopped")
+					return
+				}
+				if u.Resync {
+					k.resync()
+				} else if u.Index == iface.Index {
+					k.linkChanged(u)
 				}
 			}
 		}
+	}()
 
-		for r := range routes2Del {
-			delete(currentRoutes, r)
-			log.Println("Removing route:", r)
-			err := netlink.DelRoute(r, "", "", ifaceName)
-			if nil != err {
-				log.Printf("Error removeing route \"%s\": %s", r, err.Error())
-			}
+	return nil
+}
+
+func routeTable(p netlink.RouteParams) int {
+	if 0 == p.Table {
+		return syscall.RT_TABLE_MAIN
+	}
+	return p.Table
+}
+
+func (k *routeKeeper) restoreRoute(r netlink.Route) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	rs := r.IPNet.String()
+	p, exist := k.routes[rs]
+	if !exist || routeTable(p) != r.Table || k.linkDown {
+		return
+	}
+
+	log.Println("Route", rs, "was removed, restoring")
+	err := netlink.AddRouteParams(p)
+	if nil != err && !os.IsExist(err) {
+		log.Println("Restoring route", rs, "failed:", err)
+	}
+}
+
+func (k *routeKeeper) linkChanged(u netlink.LinkUpdate) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if !u.IsUp() {
+		if !k.linkDown {
+			log.Println("Interface", k.ifaceName, "is down, routes will be restored when it's up")
+		}
+		k.linkDown = true
+		return
+	}
+
+	if !k.linkDown {
+		return
+	}
+	k.linkDown = false
+
+	log.Println("Interface", k.ifaceName, "is up again, restoring routes")
+	k.restoreRoutes()
+}
+
+// resync checks interface state and adds back all routes, it is called
+// when route or link changes were lost
+func (k *routeKeeper) resync() {
+	iface, err := net.InterfaceByName(k.ifaceName)
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.linkDown = nil != err || 0 == iface.Flags&net.FlagUp
+	if k.linkDown {
+		return
+	}
+
+	log.Println("Route changes were lost, restoring routes")
+	k.restoreRoutes()
+}
+
+// restoreRoutes adds all routes which are not in routing table, it is
+// called with lock held
+func (k *routeKeeper) restoreRoutes() {
+	for rs, p := range k.routes {
+		err := netlink.AddRouteParams(p)
+		if nil != err && !os.IsExist(err) {
+			log.Println("Restoring route", rs, "failed:", err)
+		}
+	}
+}
+
+// cleanup removes installed rules and routes, rules outlive
+// interface so they must be removed before exit
+func (k *routeKeeper) cleanup() {
+	close(k.done)
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	for key, r := range k.rules {
+		delete(k.rules, key)
+		log.Println("Removing rule:", key)
+		if err := netlink.DelRule(r); nil != err {
+			log.Printf("Error removing rule \"%s\": %s", key, err.Error())
+		}
+	}
+
+	for rs, p := range k.routes {
+		delete(k.routes, rs)
+		if err := netlink.DelRouteParams(p); nil != err {
+			log.Printf("Error removeing route \"%s\": %s", rs, err.Error())
+		}
+	}
+}
+
+func routesThread(keeper *routeKeeper, refresh chan bool) {
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-refresh:
+			log.Println("Reloading routes...")
+		case <-retry:
+			log.Println("Retrying failed routes...")
+		}
+
+		retry = nil
+		if !keeper.sync(config.Load().(VPNState)) {
+			retry = time.After(routeRetryInterval)
 		}
 	}
 }
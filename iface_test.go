@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWantedRules(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.1.0.0/16")
+
+	tests := []struct {
+		name     string
+		table    int
+		mark     int
+		priority int
+		from     []*net.IPNet
+		want     []string
+	}{
+		{
+			name: "main table",
+			mark: 0x10,
+			want: []string{},
+		},
+		{
+			name:  "table without selectors",
+			table: 100,
+			want:  []string{},
+		},
+		{
+			name:     "fwmark and source",
+			table:    100,
+			mark:     0x10,
+			priority: 1000,
+			from:     []*net.IPNet{lan},
+			want: []string{
+				"from 10.1.0.0/16 priority 1000 table 100",
+				"from all fwmark 0x10 priority 1000 table 100",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conf VPNState
+			conf.Main.RouteTable = tt.table
+			conf.Main.FwMark = tt.mark
+			conf.Main.RulePriority = tt.priority
+			conf.Main.ruleFrom = tt.from
+
+			got := []string{}
+			for key, r := range wantedRules(conf) {
+				if key != ruleKey(r) {
+					t.Errorf("rule key %q doesn't match rule %q", key, ruleKey(r))
+				}
+				got = append(got, key)
+			}
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wantedRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWantedRoutes(t *testing.T) {
+	conf := testState(
+		map[string]string{"prague": "10.0.0.1"},
+		map[string]string{"192.168.10.0/24": "10.0.0.1"},
+	)
+	conf.Main.locIP = "192.168.3.8"
+	conf.Main.RouteTable = 100
+	conf.Main.RouteMetric = 50
+
+	routes := wantedRoutes(conf, "tun0")
+	if 1 != len(routes) {
+		t.Fatalf("wantedRoutes() returned %d routes, want 1", len(routes))
+	}
+
+	r := routes["192.168.10.0/24"]
+	if r.Source != "192.168.3.8" || r.Device != "tun0" || r.Table != 100 || r.Metric != 50 {
+		t.Errorf("wantedRoutes() = %+v", r)
+	}
+}
@@ -191,7 +191,13 @@ func main() {
 	iface := ifaceSetup(conf.Main.local)
 
 	// start routes changes in config monitoring
-	go routesThread(iface.Name(), routeReload)
+	routes := newRouteKeeper(iface.Name())
+	go routesThread(routes, routeReload)
+
+	// restore routes removed by someone else
+	if err := routes.heal(); nil != err {
+		log.Println("Routes self-healing disabled:", err)
+	}
 
 	log.Println("Interface parameters configured")
 
@@ -223,6 +229,8 @@ func main() {
 
 	<-exitChan
 
+	routes.cleanup()
+
 	err = writeConn.Close()
 	if nil != err {
 		log.Println("Error closing UDP connection: ", err)
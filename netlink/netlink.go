@@ -19,8 +19,51 @@ var (
 // A Route is a subnet associated with the interface to reach it.
 type Route struct {
 	*net.IPNet
-	Iface   *net.Interface
-	Default bool
+	Iface     *net.Interface
+	LinkIndex int
+	Default   bool
+	Src       net.IP
+	Gateway   net.IP
+	Table     int
+	Metric    int
+	MultiPath []NextHop
+}
+
+// RouteParams describes a route table entry.
+// Zero Table means main table, zero Metric means kernel default.
+// Link-local gateways need Device (or Device of next hop) to be set.
+// Route with MultiPath balances traffic between next hops (ECMP),
+// Gateway and Device are not used for such routes.
+type RouteParams struct {
+	Destination string
+	Source      string
+	Gateway     string
+	Device      string
+	Table       int
+	Metric      int
+	MultiPath   []NextHop
+}
+
+// A NextHop is one of the gateways of multipath route.
+// Zero Weight means weight of 1, the maximum is 256.
+type NextHop struct {
+	Gateway string
+	Device  string
+	Weight  int
+}
+
+// A Rule is a policy routing rule which selects routing table for
+// the matching traffic. Zero Family means ipv4, zero Priority lets kernel
+// choose one, zero Mark and nil Src/Dst match any traffic.
+type Rule struct {
+	Family   int
+	Priority int
+	Table    int
+	Mark     int
+	Mask     int
+	Src      *net.IPNet
+	Dst      *net.IPNet
+	Invert   bool
 }
 
 // An IfAddr defines IP network settings for a given network interface
@@ -28,4 +71,96 @@ type IfAddr struct {
 	Iface *net.Interface
 	IP    net.IP
 	IPNet *net.IPNet
+	Flags int
+}
+
+// A LinkUpdate is received when network link is created, changed or removed.
+// Update with Resync set carries no link, it is received when kernel dropped
+// updates which were not read in time, so current links have to be listed again.
+type LinkUpdate struct {
+	Index   int
+	Name    string
+	MTU     int
+	Flags   net.Flags
+	Deleted bool
+	Resync  bool
+}
+
+// IsUp reports whether link is administratively up
+func (u LinkUpdate) IsUp() bool {
+	return !u.Deleted && u.Flags&net.FlagUp != 0
+}
+
+// An Addr is IP address assigned to link, Flags are IFA_F_* flags
+type Addr struct {
+	LinkIndex int
+	IP        net.IP
+	IPNet     *net.IPNet
+	Flags     int
+	Scope     int
+}
+
+// An AddrUpdate is received when IP address is added to or removed from link.
+// Update with Resync set carries no address, see LinkUpdate.
+type AddrUpdate struct {
+	Addr
+	Deleted bool
+	Resync  bool
+}
+
+// A RouteUpdate is received when route is added or removed.
+// Update with Resync set carries no route, see LinkUpdate.
+type RouteUpdate struct {
+	Route
+	Deleted bool
+	Resync  bool
+}
+
+// A VxlanLink describes VXLAN tunnel. Group is multicast group or unicast
+// remote, Device is underlying device. Zero Port means kernel default.
+type VxlanLink struct {
+	Name   string
+	VNI    int
+	Group  net.IP
+	Local  net.IP
+	Port   int
+	Device string
+	TTL    int
+}
+
+// A GreLink describes GRE tunnel, Tap selects ethernet (gretap) tunnel.
+// ipv6 Local/Remote addresses create ip6gre/ip6gretap link.
+// Zero keys are not used.
+type GreLink struct {
+	Name   string
+	Tap    bool
+	Local  net.IP
+	Remote net.IP
+	Device string
+	TTL    int
+	IKey   uint32
+	OKey   uint32
+}
+
+// An IptunLink describes ipip or sit (ipv6 over ipv4) tunnel
+type IptunLink struct {
+	Name   string
+	Sit    bool
+	Local  net.IP
+	Remote net.IP
+	Device string
+	TTL    int
+}
+
+// A LinkInfo describes network link, tunnel attributes are set
+// for links of the corresponding kind only
+type LinkInfo struct {
+	Index int
+	Name  string
+	MTU   int
+	Flags net.Flags
+	Kind  string
+	Vxlan *VxlanLink
+	Gre   *GreLink
+	Iptun *IptunLink
 }
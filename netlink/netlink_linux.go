@@ -28,6 +28,20 @@ const (
 	SIOC_BRDELBR      = 0x89a1
 	SIOC_BRADDIF      = 0x89a2
 	SIOC_BRDELIF      = 0x89a3
+	FRA_DST           = 1
+	FRA_SRC           = 2
+	FRA_PRIORITY      = 6
+	FRA_FWMARK        = 10
+	FRA_TABLE         = 15
+	FRA_FWMASK        = 16
+	FR_ACT_TO_TBL     = 1
+	FIB_RULE_INVERT   = 0x2
+	IFA_FLAGS         = 8
+)
+
+const (
+	IFA_F_MANAGETEMPADDR = 0x100
+	IFA_F_NOPREFIXROUTE  = 0x200
 )
 
 const (
@@ -210,7 +224,7 @@ func (a *RtAttr) Len() int {
 
 	l := 0
 	for _, child := range a.children {
-		l += child.Len()
+		l += rtaAlignOf(child.Len())
 	}
 	l += syscall.SizeofRtAttr
 	return rtaAlignOf(l + len(a.Data))
@@ -885,21 +899,22 @@ func networkLinkIpAction(action, flags int, ifa IfAddr) error {
 	msg.Index = uint32(ifa.Iface.Index)
 	prefixLen, _ := ifa.IPNet.Mask.Size()
 	msg.Prefixlen = uint8(prefixLen)
+	msg.Flags = uint8(ifa.Flags)
 	wb.AddData(msg)
 
-	var ipData []byte
-	if family == syscall.AF_INET {
-		ipData = ifa.IP.To4()
-	} else {
-		ipData = ifa.IP.To16()
-	}
+	addr := ipData(ifa.IP, family)
 
-	localData := newRtAttr(syscall.IFA_LOCAL, ipData)
+	localData := newRtAttr(syscall.IFA_LOCAL, addr)
 	wb.AddData(localData)
 
-	addrData := newRtAttr(syscall.IFA_ADDRESS, ipData)
+	addrData := newRtAttr(syscall.IFA_ADDRESS, addr)
 	wb.AddData(addrData)
 
+	// flags above 0xff don't fit into header
+	if ifa.Flags > 0xff {
+		wb.AddData(uint32Attr(IFA_FLAGS, uint32(ifa.Flags)))
+	}
+
 	if err := s.Send(wb); err != nil {
 		return err
 	}
@@ -913,23 +928,268 @@ func NetworkLinkDelIp(iface *net.Interface, ip net.IP, ipNet *net.IPNet) error {
 	return networkLinkIpAction(
 		syscall.RTM_DELADDR,
 		syscall.NLM_F_ACK,
-		IfAddr{iface, ip, ipNet},
+		IfAddr{Iface: iface, IP: ip, IPNet: ipNet},
 	)
 }
 
 // Add an Ip address to an interface. This is identical to:
 // ip addr add $ip/$ipNet dev $iface
 func NetworkLinkAddIp(iface *net.Interface, ip net.IP, ipNet *net.IPNet) error {
+	return NetworkLinkAddIpFlags(iface, ip, ipNet, 0)
+}
+
+// Add an Ip address with IFA_F_* flags to an interface. This is identical to:
+// ip addr add $ip/$ipNet dev $iface nodad noprefixroute
+func NetworkLinkAddIpFlags(iface *net.Interface, ip net.IP, ipNet *net.IPNet, flags int) error {
 	return networkLinkIpAction(
 		syscall.RTM_NEWADDR,
 		syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK,
-		IfAddr{iface, ip, ipNet},
+		IfAddr{Iface: iface, IP: ip, IPNet: ipNet, Flags: flags},
 	)
 }
 
+// Returns addresses of all links for the given family (AF_UNSPEC for all).
+// This is similar to "ip addr show" output
+func NetworkGetAddrs(family int) ([]Addr, error) {
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_GETADDR, syscall.NLM_F_DUMP)
+	wb.AddData(newIfAddrmsg(family))
+
+	if err := s.Send(wb); err != nil {
+		return nil, err
+	}
+
+	pid, err := s.GetPid()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Addr, 0)
+
+outer:
+	for {
+		msgs, err := s.Receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if err := s.CheckMessage(m, wb.Seq, pid); err != nil {
+				if err == io.EOF {
+					break outer
+				}
+				return nil, err
+			}
+			if m.Header.Type != syscall.RTM_NEWADDR {
+				continue
+			}
+
+			a, err := parseAddr(m)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, a)
+		}
+	}
+
+	return res, nil
+}
+
 // Returns an array of IPNet for all the currently routed subnets on ipv4
 // This is similar to the first column of "ip route" output
 func NetworkGetRoutes() ([]Route, error) {
+	return NetworkGetTableRoutes(syscall.RT_TABLE_MAIN)
+}
+
+// Returns ipv4 routes from the given routing table.
+// This is similar to "ip route show table $table" output
+func NetworkGetTableRoutes(table int) ([]Route, error) {
+	return NetworkGetFamilyRoutes(syscall.AF_INET, table)
+}
+
+// Parse route message (new or deleted route) into Route
+func parseRoute(m syscall.NetlinkMessage) (Route, error) {
+	var r Route
+
+	if len(m.Data) < syscall.SizeofRtMsg {
+		return r, ErrShortResponse
+	}
+	msg := (*RtMsg)(unsafe.Pointer(&m.Data[0:syscall.SizeofRtMsg][0]))
+
+	if msg.Dst_len == 0 {
+		// Default routes
+		r.Default = true
+	}
+
+	r.Table = int(msg.Table)
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return r, err
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.RTA_DST:
+			ip := attr.Value
+			r.IPNet = &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(int(msg.Dst_len), 8*len(ip)),
+			}
+		case syscall.RTA_OIF:
+			r.LinkIndex = int(native.Uint32(attr.Value[0:4]))
+			r.Iface, _ = net.InterfaceByIndex(r.LinkIndex)
+		case syscall.RTA_PREFSRC:
+			r.Src = net.IP(attr.Value)
+		case syscall.RTA_GATEWAY:
+			r.Gateway = net.IP(attr.Value)
+		case syscall.RTA_PRIORITY:
+			r.Metric = int(native.Uint32(attr.Value[0:4]))
+		case syscall.RTA_TABLE:
+			r.Table = int(native.Uint32(attr.Value[0:4]))
+		case syscall.RTA_MULTIPATH:
+			r.MultiPath, err = parseMultiPath(attr.Value)
+			if err != nil {
+				return r, err
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// Parse attributes without message header, e.g. nested ones
+func parseRtAttrs(b []byte) ([]syscall.NetlinkRouteAttr, error) {
+	var attrs []syscall.NetlinkRouteAttr
+
+	for len(b) >= syscall.SizeofRtAttr {
+		alen := int(native.Uint16(b[0:2]))
+		if alen < syscall.SizeofRtAttr || alen > len(b) {
+			return nil, ErrShortResponse
+		}
+		attrs = append(attrs, syscall.NetlinkRouteAttr{
+			Attr: syscall.RtAttr{
+				Len: uint16(alen),
+				// nested attributes can have NLA_F_NESTED flag set
+				Type: native.Uint16(b[2:4]) & ^uint16(syscall.NLA_F_NESTED),
+			},
+			Value: b[syscall.SizeofRtAttr:alen],
+		})
+		b = b[minInt(rtaAlignOf(alen), len(b)):]
+	}
+
+	return attrs, nil
+}
+
+// Each next hop is rtnexthop header (len, flags, hops, ifindex)
+// followed by its own attributes
+const sizeofRtNexthop = 8
+
+func rtnhAlignOf(l int) int {
+	return (l + syscall.RTNH_ALIGNTO - 1) & ^(syscall.RTNH_ALIGNTO - 1)
+}
+
+func parseMultiPath(b []byte) ([]NextHop, error) {
+	var res []NextHop
+
+	for len(b) >= sizeofRtNexthop {
+		l := int(native.Uint16(b[0:2]))
+		if l < sizeofRtNexthop || l > len(b) {
+			return nil, ErrShortResponse
+		}
+
+		nh := NextHop{
+			Weight: int(b[3]) + 1,
+		}
+		index := int(native.Uint32(b[4:8]))
+		if iface, err := net.InterfaceByIndex(index); err == nil {
+			nh.Device = iface.Name
+		}
+
+		attrs, err := parseRtAttrs(b[sizeofRtNexthop:l])
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.RTA_GATEWAY {
+				nh.Gateway = net.IP(attr.Value).String()
+			}
+		}
+
+		res = append(res, nh)
+		b = b[minInt(rtnhAlignOf(l), len(b)):]
+	}
+
+	return res, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func multiPathData(hops []NextHop, family int) ([]byte, int, error) {
+	var res []byte
+
+	for _, hop := range hops {
+		if hop.Weight < 0 || hop.Weight > 256 {
+			return nil, family, fmt.Errorf("next hop weight %d is out of 1-256 range", hop.Weight)
+		}
+
+		var attrs []byte
+		if hop.Gateway != "" {
+			gwIP := net.ParseIP(hop.Gateway)
+			if gwIP == nil {
+				return nil, family, fmt.Errorf("gateway IP %s couldn't be parsed", hop.Gateway)
+			}
+			gwFamily := getIpFamily(gwIP)
+			if family != -1 && family != gwFamily {
+				return nil, family, fmt.Errorf("next hop gateways and destination ip were not the same IP family")
+			}
+			family = gwFamily
+			if gwIP.IsLinkLocalUnicast() && hop.Device == "" {
+				return nil, family, fmt.Errorf("link-local gateway %s needs device", hop.Gateway)
+			}
+			attrs = newRtAttr(syscall.RTA_GATEWAY, ipData(gwIP, gwFamily)).ToWireFormat()
+		}
+
+		index := 0
+		if hop.Device != "" {
+			iface, err := net.InterfaceByName(hop.Device)
+			if err != nil {
+				return nil, family, err
+			}
+			index = iface.Index
+		}
+
+		hops := 0
+		if hop.Weight > 0 {
+			hops = hop.Weight - 1
+		}
+
+		nh := make([]byte, sizeofRtNexthop)
+		native.PutUint16(nh[0:2], uint16(sizeofRtNexthop+len(attrs)))
+		nh[3] = uint8(hops)
+		native.PutUint32(nh[4:8], uint32(index))
+
+		nh = append(nh, attrs...)
+		res = append(res, nh...)
+		if pad := rtnhAlignOf(len(nh)) - len(nh); pad > 0 {
+			res = append(res, make([]byte, pad)...)
+		}
+	}
+
+	return res, family, nil
+}
+
+// Returns routes of the given family (AF_INET or AF_INET6) from the given
+// routing table. This is similar to "ip -6 route show table $table" output
+func NetworkGetFamilyRoutes(family, table int) ([]Route, error) {
 	s, err := getNetlinkSocket()
 	if err != nil {
 		return nil, err
@@ -969,8 +1229,6 @@ outer:
 				continue
 			}
 
-			var r Route
-
 			msg := (*RtMsg)(unsafe.Pointer(&m.Data[0:syscall.SizeofRtMsg][0]))
 
 			if msg.Flags&syscall.RTM_F_CLONED != 0 {
@@ -978,38 +1236,21 @@ outer:
 				continue
 			}
 
-			if msg.Table != syscall.RT_TABLE_MAIN {
-				// Ignore non-main tables
+			if int(msg.Family) != family {
+				// Ignore routes of other family
 				continue
 			}
 
-			if msg.Family != syscall.AF_INET {
-				// Ignore non-ipv4 routes
-				continue
-			}
-
-			if msg.Dst_len == 0 {
-				// Default routes
-				r.Default = true
-			}
-
-			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			r, err := parseRoute(m)
 			if err != nil {
 				return nil, err
 			}
-			for _, attr := range attrs {
-				switch attr.Attr.Type {
-				case syscall.RTA_DST:
-					ip := attr.Value
-					r.IPNet = &net.IPNet{
-						IP:   ip,
-						Mask: net.CIDRMask(int(msg.Dst_len), 8*len(ip)),
-					}
-				case syscall.RTA_OIF:
-					index := int(native.Uint32(attr.Value[0:4]))
-					r.Iface, _ = net.InterfaceByIndex(index)
-				}
+
+			if r.Table != table {
+				// Ignore other tables
+				continue
 			}
+
 			if r.Default || r.IPNet != nil {
 				res = append(res, r)
 			}
@@ -1019,11 +1260,34 @@ outer:
 	return res, nil
 }
 
-// Add a new route table entry.
-func AddRoute(destination, source, gateway, device string) error {
-	if destination == "" && source == "" && gateway == "" {
+func ipData(ip net.IP, family int) []byte {
+	if family == syscall.AF_INET {
+		return ip.To4()
+	}
+	return ip.To16()
+}
+
+// Attach table id to a route or rule message. Table ids which
+// don't fit into header byte are passed as separate attribute.
+func setTable(msg *RtMsg, req *NetlinkRequest, table int) {
+	if table == 0 {
+		return
+	}
+	if table < 256 {
+		msg.Table = uint8(table)
+		return
+	}
+	msg.Table = syscall.RT_TABLE_UNSPEC
+	req.AddData(uint32Attr(syscall.RTA_TABLE, uint32(table)))
+}
+
+func networkRouteAction(action, flags int, p RouteParams) error {
+	if p.Destination == "" && p.Source == "" && p.Gateway == "" && len(p.MultiPath) == 0 {
 		return fmt.Errorf("one of destination, source or gateway must not be blank")
 	}
+	if p.Table < 0 || p.Metric < 0 {
+		return fmt.Errorf("route table and metric can't be negative")
+	}
 
 	s, err := getNetlinkSocket()
 	if err != nil {
@@ -1031,37 +1295,31 @@ func AddRoute(destination, source, gateway, device string) error {
 	}
 	defer s.Close()
 
-	wb := newNetlinkRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	wb := newNetlinkRequest(action, flags)
 	msg := newRtMsg()
 	currentFamily := -1
 	var rtAttrs []*RtAttr
 
-	if destination != "" {
-		destIP, destNet, err := net.ParseCIDR(destination)
+	if p.Destination != "" {
+		destIP, destNet, err := net.ParseCIDR(p.Destination)
 		if err != nil {
-			return fmt.Errorf("destination CIDR %s couldn't be parsed", destination)
+			return fmt.Errorf("destination CIDR %s couldn't be parsed", p.Destination)
 		}
 		destFamily := getIpFamily(destIP)
 		currentFamily = destFamily
 		destLen, bits := destNet.Mask.Size()
 		if destLen == 0 && bits == 0 {
-			return fmt.Errorf("destination CIDR %s generated a non-canonical Mask", destination)
+			return fmt.Errorf("destination CIDR %s generated a non-canonical Mask", p.Destination)
 		}
 		msg.Family = uint8(destFamily)
 		msg.Dst_len = uint8(destLen)
-		var destData []byte
-		if destFamily == syscall.AF_INET {
-			destData = destIP.To4()
-		} else {
-			destData = destIP.To16()
-		}
-		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_DST, destData))
+		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_DST, ipData(destIP, destFamily)))
 	}
 
-	if source != "" {
-		srcIP := net.ParseIP(source)
+	if p.Source != "" {
+		srcIP := net.ParseIP(p.Source)
 		if srcIP == nil {
-			return fmt.Errorf("source IP %s couldn't be parsed", source)
+			return fmt.Errorf("source IP %s couldn't be parsed", p.Source)
 		}
 		srcFamily := getIpFamily(srcIP)
 		if currentFamily != -1 && currentFamily != srcFamily {
@@ -1069,44 +1327,55 @@ func AddRoute(destination, source, gateway, device string) error {
 		}
 		currentFamily = srcFamily
 		msg.Family = uint8(srcFamily)
-		var srcData []byte
-		if srcFamily == syscall.AF_INET {
-			srcData = srcIP.To4()
-		} else {
-			srcData = srcIP.To16()
-		}
-		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_PREFSRC, srcData))
+		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_PREFSRC, ipData(srcIP, srcFamily)))
 	}
 
-	if gateway != "" {
-		gwIP := net.ParseIP(gateway)
+	if p.Gateway != "" {
+		gwIP := net.ParseIP(p.Gateway)
 		if gwIP == nil {
-			return fmt.Errorf("gateway IP %s couldn't be parsed", gateway)
+			return fmt.Errorf("gateway IP %s couldn't be parsed", p.Gateway)
 		}
 		gwFamily := getIpFamily(gwIP)
 		if currentFamily != -1 && currentFamily != gwFamily {
 			return fmt.Errorf("gateway, source, and destination ip were not the same IP family")
 		}
+		if gwIP.IsLinkLocalUnicast() && p.Device == "" {
+			return fmt.Errorf("link-local gateway %s needs device", p.Gateway)
+		}
 		msg.Family = uint8(gwFamily)
-		var gwData []byte
-		if gwFamily == syscall.AF_INET {
-			gwData = gwIP.To4()
-		} else {
-			gwData = gwIP.To16()
+		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_GATEWAY, ipData(gwIP, gwFamily)))
+	}
+
+	if len(p.MultiPath) != 0 {
+		data, mpFamily, err := multiPathData(p.MultiPath, currentFamily)
+		if err != nil {
+			return err
+		}
+		if mpFamily == -1 {
+			mpFamily = syscall.AF_INET
 		}
-		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_GATEWAY, gwData))
+		msg.Family = uint8(mpFamily)
+		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_MULTIPATH, data))
 	}
 
 	wb.AddData(msg)
 	for _, attr := range rtAttrs {
 		wb.AddData(attr)
 	}
+	setTable(msg, wb, p.Table)
+	if p.Metric != 0 {
+		wb.AddData(uint32Attr(syscall.RTA_PRIORITY, uint32(p.Metric)))
+	}
 
-	iface, err := net.InterfaceByName(device)
-	if err != nil {
-		return err
+	if p.Device != "" {
+		iface, err := net.InterfaceByName(p.Device)
+		if err != nil {
+			return err
+		}
+		wb.AddData(uint32Attr(syscall.RTA_OIF, uint32(iface.Index)))
+	} else if len(p.MultiPath) == 0 && p.Gateway == "" {
+		return fmt.Errorf("route device must not be blank")
 	}
-	wb.AddData(uint32Attr(syscall.RTA_OIF, uint32(iface.Index)))
 
 	if err := s.Send(wb); err != nil {
 		return err
@@ -1114,10 +1383,56 @@ func AddRoute(destination, source, gateway, device string) error {
 	return s.HandleAck(wb.Seq)
 }
 
+// Add a new route table entry.
+func AddRoute(destination, source, gateway, device string) error {
+	return AddRouteParams(RouteParams{
+		Destination: destination,
+		Source:      source,
+		Gateway:     gateway,
+		Device:      device,
+	})
+}
+
 // Delete route table entry.
 func DelRoute(destination, source, gateway, device string) error {
-	if destination == "" && source == "" && gateway == "" {
-		return fmt.Errorf("one of destination, source or gateway must not be blank")
+	return DelRouteParams(RouteParams{
+		Destination: destination,
+		Source:      source,
+		Gateway:     gateway,
+		Device:      device,
+	})
+}
+
+// Add a new route table entry with table and metric. Identical to:
+// ip route add $dst via $gw dev $device src $src table $table metric $metric
+func AddRouteParams(p RouteParams) error {
+	return networkRouteAction(
+		syscall.RTM_NEWROUTE,
+		syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK,
+		p,
+	)
+}
+
+// Delete route table entry. Identical to:
+// ip route del $dst via $gw dev $device src $src table $table metric $metric
+func DelRouteParams(p RouteParams) error {
+	return networkRouteAction(
+		syscall.RTM_DELROUTE,
+		syscall.NLM_F_ACK,
+		p,
+	)
+}
+
+func ruleFamily(r Rule) int {
+	if r.Family == 0 {
+		return syscall.AF_INET
+	}
+	return r.Family
+}
+
+func networkRuleAction(action, flags int, r Rule) error {
+	if r.Table < 0 || r.Priority < 0 {
+		return fmt.Errorf("rule table and priority can't be negative")
 	}
 
 	s, err := getNetlinkSocket()
@@ -1126,87 +1441,146 @@ func DelRoute(destination, source, gateway, device string) error {
 	}
 	defer s.Close()
 
-	wb := newNetlinkRequest(syscall.RTM_DELROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
-	msg := newRtMsg()
-	currentFamily := -1
-	var rtAttrs []*RtAttr
+	wb := newNetlinkRequest(action, flags)
 
-	if destination != "" {
-		destIP, destNet, err := net.ParseCIDR(destination)
-		if err != nil {
-			return fmt.Errorf("destination CIDR %s couldn't be parsed", destination)
-		}
-		destFamily := getIpFamily(destIP)
-		currentFamily = destFamily
-		destLen, bits := destNet.Mask.Size()
-		if destLen == 0 && bits == 0 {
-			return fmt.Errorf("destination CIDR %s generated a non-canonical Mask", destination)
-		}
-		msg.Family = uint8(destFamily)
-		msg.Dst_len = uint8(destLen)
-		var destData []byte
-		if destFamily == syscall.AF_INET {
-			destData = destIP.To4()
-		} else {
-			destData = destIP.To16()
-		}
-		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_DST, destData))
+	// fib_rule_hdr has the same layout as rtmsg,
+	// with action in place of route type
+	family := ruleFamily(r)
+	msg := &RtMsg{}
+	msg.Family = uint8(family)
+	msg.Type = FR_ACT_TO_TBL
+	if r.Invert {
+		msg.Flags |= FIB_RULE_INVERT
 	}
+	wb.AddData(msg)
 
-	if source != "" {
-		srcIP := net.ParseIP(source)
-		if srcIP == nil {
-			return fmt.Errorf("source IP %s couldn't be parsed", source)
-		}
-		srcFamily := getIpFamily(srcIP)
-		if currentFamily != -1 && currentFamily != srcFamily {
-			return fmt.Errorf("source and destination ip were not the same IP family")
-		}
-		currentFamily = srcFamily
-		msg.Family = uint8(srcFamily)
-		var srcData []byte
-		if srcFamily == syscall.AF_INET {
-			srcData = srcIP.To4()
-		} else {
-			srcData = srcIP.To16()
-		}
-		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_PREFSRC, srcData))
+	if r.Src != nil {
+		srcLen, _ := r.Src.Mask.Size()
+		msg.Src_len = uint8(srcLen)
+		wb.AddData(newRtAttr(FRA_SRC, ipData(r.Src.IP, family)))
+	}
+	if r.Dst != nil {
+		dstLen, _ := r.Dst.Mask.Size()
+		msg.Dst_len = uint8(dstLen)
+		wb.AddData(newRtAttr(FRA_DST, ipData(r.Dst.IP, family)))
 	}
+	if r.Priority != 0 {
+		wb.AddData(uint32Attr(FRA_PRIORITY, uint32(r.Priority)))
+	}
+	if r.Mark != 0 {
+		wb.AddData(uint32Attr(FRA_FWMARK, uint32(r.Mark)))
+	}
+	if r.Mask != 0 {
+		wb.AddData(uint32Attr(FRA_FWMASK, uint32(r.Mask)))
+	}
+	setTable(msg, wb, r.Table)
 
-	if gateway != "" {
-		gwIP := net.ParseIP(gateway)
-		if gwIP == nil {
-			return fmt.Errorf("gateway IP %s couldn't be parsed", gateway)
-		}
-		gwFamily := getIpFamily(gwIP)
-		if currentFamily != -1 && currentFamily != gwFamily {
-			return fmt.Errorf("gateway, source, and destination ip were not the same IP family")
-		}
-		msg.Family = uint8(gwFamily)
-		var gwData []byte
-		if gwFamily == syscall.AF_INET {
-			gwData = gwIP.To4()
-		} else {
-			gwData = gwIP.To16()
-		}
-		rtAttrs = append(rtAttrs, newRtAttr(syscall.RTA_GATEWAY, gwData))
+	if err := s.Send(wb); err != nil {
+		return err
 	}
+	return s.HandleAck(wb.Seq)
+}
 
+// Add a new policy routing rule. Identical to:
+// ip rule add from $src to $dst fwmark $mark/$mask priority $prio table $table
+func AddRule(r Rule) error {
+	return networkRuleAction(
+		syscall.RTM_NEWRULE,
+		syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK,
+		r,
+	)
+}
+
+// Delete a policy routing rule. Identical to:
+// ip rule del from $src to $dst fwmark $mark/$mask priority $prio table $table
+func DelRule(r Rule) error {
+	return networkRuleAction(syscall.RTM_DELRULE, syscall.NLM_F_ACK, r)
+}
+
+// Returns policy routing rules of the given family.
+// This is similar to "ip rule show" output
+func NetworkGetRules(family int) ([]Rule, error) {
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_GETRULE, syscall.NLM_F_DUMP)
+
+	msg := &RtMsg{}
+	msg.Family = uint8(family)
 	wb.AddData(msg)
-	for _, attr := range rtAttrs {
-		wb.AddData(attr)
+
+	if err := s.Send(wb); err != nil {
+		return nil, err
 	}
 
-	iface, err := net.InterfaceByName(device)
+	pid, err := s.GetPid()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	wb.AddData(uint32Attr(syscall.RTA_OIF, uint32(iface.Index)))
 
-	if err := s.Send(wb); err != nil {
-		return err
+	res := make([]Rule, 0)
+
+outer:
+	for {
+		msgs, err := s.Receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if err := s.CheckMessage(m, wb.Seq, pid); err != nil {
+				if err == io.EOF {
+					break outer
+				}
+				return nil, err
+			}
+			if m.Header.Type != syscall.RTM_NEWRULE {
+				continue
+			}
+
+			msg := (*RtMsg)(unsafe.Pointer(&m.Data[0:syscall.SizeofRtMsg][0]))
+			r := Rule{
+				Family: int(msg.Family),
+				Table:  int(msg.Table),
+				Invert: msg.Flags&FIB_RULE_INVERT != 0,
+			}
+
+			// syscall can't parse rule messages, but header of
+			// the rule has the same size as the route one
+			m.Header.Type = syscall.RTM_NEWROUTE
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return nil, err
+			}
+			for _, attr := range attrs {
+				switch attr.Attr.Type {
+				case FRA_SRC:
+					r.Src = &net.IPNet{
+						IP:   net.IP(attr.Value),
+						Mask: net.CIDRMask(int(msg.Src_len), 8*len(attr.Value)),
+					}
+				case FRA_DST:
+					r.Dst = &net.IPNet{
+						IP:   net.IP(attr.Value),
+						Mask: net.CIDRMask(int(msg.Dst_len), 8*len(attr.Value)),
+					}
+				case FRA_PRIORITY:
+					r.Priority = int(native.Uint32(attr.Value[0:4]))
+				case FRA_FWMARK:
+					r.Mark = int(native.Uint32(attr.Value[0:4]))
+				case FRA_FWMASK:
+					r.Mask = int(native.Uint32(attr.Value[0:4]))
+				case FRA_TABLE:
+					r.Table = int(native.Uint32(attr.Value[0:4]))
+				}
+			}
+			res = append(res, r)
+		}
 	}
-	return s.HandleAck(wb.Seq)
+
+	return res, nil
 }
 
 // Add a new default gateway. Identical to:
@@ -1215,6 +1589,12 @@ func AddDefaultGw(ip, device string) error {
 	return AddRoute("", "", ip, device)
 }
 
+// Add a new default route balanced between several gateways. Identical to:
+// ip route add default nexthop via $ip1 dev $dev1 nexthop via $ip2 dev $dev2
+func AddDefaultMultiPathGw(hops []NextHop) error {
+	return AddRouteParams(RouteParams{MultiPath: hops})
+}
+
 // THIS CODE DOES NOT COMMUNICATE WITH KERNEL VIA RTNETLINK INTERFACE
 // IT IS HERE FOR BACKWARDS COMPATIBILITY WITH OLDER LINUX KERNELS
 // WHICH SHIP WITH OLDER NOT ENTIRELY FUNCTIONAL VERSION OF NETLINK
@@ -0,0 +1,671 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	netnsEnv  = "NETLINK_TEST_NETNS"
+	testLink  = "nltest0"
+	testPeer  = "nltest1"
+	testAddr4 = "10.9.0.1/24"
+	testAddr6 = "fd00:1::1/64"
+)
+
+// TestMain re-runs tests in a new user and network namespace, so they can
+// change links and routes without privileges and without touching the host
+func TestMain(m *testing.M) {
+	if os.Getenv(netnsEnv) == "" {
+		cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+		cmd.Env = append(os.Environ(), netnsEnv+"=1")
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		}
+
+		err := cmd.Run()
+		if err == nil {
+			os.Exit(0)
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+
+		// namespaces are not available, tests which need one are skipped
+		os.Setenv(netnsEnv, "unavailable")
+	}
+
+	os.Exit(m.Run())
+}
+
+func requireNetns(t *testing.T) {
+	if os.Getenv(netnsEnv) != "1" {
+		t.Skip("network namespace is not available")
+	}
+}
+
+// setupLink creates veth pair with ipv4 and ipv6 addresses
+func setupLink(t *testing.T) *net.Interface {
+	requireNetns(t)
+
+	if err := NetworkCreateVethPair(testLink, testPeer, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{testPeer, testLink} {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NetworkLinkUp(iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iface, err := net.InterfaceByName(testLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, ipNet, _ := net.ParseCIDR(testAddr4)
+	if err := NetworkLinkAddIp(iface, ip, ipNet); err != nil {
+		t.Fatal(err)
+	}
+	ip, ipNet, _ = net.ParseCIDR(testAddr6)
+	if err := NetworkLinkAddIpFlags(iface, ip, ipNet, syscall.IFA_F_NODAD); err != nil {
+		t.Fatal(err)
+	}
+
+	return iface
+}
+
+func teardownLink(t *testing.T) {
+	if err := NetworkLinkDel(testLink); err != nil {
+		t.Error(err)
+	}
+}
+
+func findAddr(t *testing.T, family int, ip net.IP) *Addr {
+	addrs, err := NetworkGetAddrs(family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return &a
+		}
+	}
+	return nil
+}
+
+func findRoute(t *testing.T, family, table int, dst string) *Route {
+	routes, err := NetworkGetFamilyRoutes(family, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dstNet, _ := net.ParseCIDR(dst)
+	defaultDst := dstNet != nil && dstNet.IP.IsUnspecified()
+	for _, r := range routes {
+		// default routes have no destination
+		if r.Default && defaultDst || r.IPNet != nil && r.IPNet.String() == dst {
+			return &r
+		}
+	}
+	return nil
+}
+
+func TestNetworkLinkAddIpFlags(t *testing.T) {
+	iface := setupLink(t)
+	defer teardownLink(t)
+
+	tests := []struct {
+		name      string
+		addr      string
+		flags     int
+		wantFlags int
+		noFlags   int
+	}{
+		{
+			name:      "ipv6 nodad",
+			addr:      "fd00:2::1/64",
+			flags:     syscall.IFA_F_NODAD,
+			wantFlags: syscall.IFA_F_NODAD | syscall.IFA_F_PERMANENT,
+			noFlags:   syscall.IFA_F_TENTATIVE,
+		},
+		{
+			name:      "ipv6 with dad",
+			addr:      "fd00:3::1/64",
+			wantFlags: syscall.IFA_F_TENTATIVE,
+		},
+		{
+			name:      "ipv6 noprefixroute",
+			addr:      "fd00:4::1/64",
+			flags:     syscall.IFA_F_NODAD | IFA_F_NOPREFIXROUTE,
+			wantFlags: syscall.IFA_F_NODAD | IFA_F_NOPREFIXROUTE,
+		},
+		{
+			name:      "ipv4 noprefixroute",
+			addr:      "10.10.0.1/24",
+			flags:     IFA_F_NOPREFIXROUTE,
+			wantFlags: IFA_F_NOPREFIXROUTE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, ipNet, _ := net.ParseCIDR(tt.addr)
+			if err := NetworkLinkAddIpFlags(iface, ip, ipNet, tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			defer NetworkLinkDelIp(iface, ip, ipNet)
+
+			a := findAddr(t, getIpFamily(ip), ip)
+			if a == nil {
+				t.Fatalf("address %s not found", tt.addr)
+			}
+			if a.LinkIndex != iface.Index || a.IPNet.String() != ipNet.String() {
+				t.Errorf("address %+v doesn't match %s on %s", a, tt.addr, testLink)
+			}
+			if a.Flags&tt.wantFlags != tt.wantFlags {
+				t.Errorf("address flags %#x, want %#x set", a.Flags, tt.wantFlags)
+			}
+			if a.Flags&tt.noFlags != 0 {
+				t.Errorf("address flags %#x, want %#x unset", a.Flags, tt.noFlags)
+			}
+
+			if tt.flags&IFA_F_NOPREFIXROUTE != 0 {
+				if r := findRoute(t, getIpFamily(ip), syscall.RT_TABLE_MAIN, ipNet.String()); r != nil {
+					t.Errorf("unexpected prefix route %s", ipNet)
+				}
+			}
+		})
+	}
+}
+
+func TestAddRouteParams(t *testing.T) {
+	setupLink(t)
+	defer teardownLink(t)
+
+	tests := []struct {
+		name    string
+		family  int
+		params  RouteParams
+		wantErr bool
+		check   func(t *testing.T, r *Route)
+	}{
+		{
+			name:   "ipv6 with metric",
+			family: syscall.AF_INET6,
+			params: RouteParams{Destination: "fd00:10::/64", Device: testLink, Metric: 300},
+			check: func(t *testing.T, r *Route) {
+				if r.Metric != 300 {
+					t.Errorf("metric = %d, want 300", r.Metric)
+				}
+			},
+		},
+		{
+			name:   "ipv6 in table",
+			family: syscall.AF_INET6,
+			params: RouteParams{Destination: "fd00:11::/64", Device: testLink, Table: 1000},
+		},
+		{
+			name:   "ipv4 in table",
+			family: syscall.AF_INET,
+			params: RouteParams{Destination: "10.22.0.0/16", Source: "10.9.0.1", Device: testLink, Table: 1000, Metric: 50},
+			check: func(t *testing.T, r *Route) {
+				if r.Metric != 50 {
+					t.Errorf("metric = %d, want 50", r.Metric)
+				}
+			},
+		},
+		{
+			name:   "ipv6 link-local gateway",
+			family: syscall.AF_INET6,
+			params: RouteParams{Destination: "fd00:12::/64", Gateway: "fe80::2", Device: testLink},
+			check: func(t *testing.T, r *Route) {
+				if !r.Gateway.Equal(net.ParseIP("fe80::2")) {
+					t.Errorf("gateway = %s, want fe80::2", r.Gateway)
+				}
+			},
+		},
+		{
+			name:    "ipv6 link-local gateway without device",
+			family:  syscall.AF_INET6,
+			params:  RouteParams{Destination: "fd00:13::/64", Gateway: "fe80::2"},
+			wantErr: true,
+		},
+		{
+			name:   "ipv6 default gateway",
+			family: syscall.AF_INET6,
+			params: RouteParams{Destination: "::/0", Gateway: "fd00:1::2", Device: testLink, Table: 1001},
+			check: func(t *testing.T, r *Route) {
+				if !r.Default {
+					t.Error("route is not default")
+				}
+			},
+		},
+		{
+			name:   "ipv6 multipath",
+			family: syscall.AF_INET6,
+			params: RouteParams{Destination: "fd00:14::/64", MultiPath: []NextHop{
+				{Gateway: "fd00:1::2", Device: testLink},
+				{Gateway: "fe80::3", Device: testLink, Weight: 3},
+			}},
+			check: func(t *testing.T, r *Route) {
+				checkNextHops(t, r, []NextHop{
+					{Gateway: "fd00:1::2", Device: testLink, Weight: 1},
+					{Gateway: "fe80::3", Device: testLink, Weight: 3},
+				})
+			},
+		},
+		{
+			name:   "ipv4 multipath",
+			family: syscall.AF_INET,
+			params: RouteParams{Destination: "10.20.0.0/16", Metric: 10, MultiPath: []NextHop{
+				{Gateway: "10.9.0.2", Device: testLink, Weight: 2},
+				{Gateway: "10.9.0.3", Device: testLink},
+			}},
+			check: func(t *testing.T, r *Route) {
+				if r.Metric != 10 {
+					t.Errorf("metric = %d, want 10", r.Metric)
+				}
+				checkNextHops(t, r, []NextHop{
+					{Gateway: "10.9.0.2", Device: testLink, Weight: 2},
+					{Gateway: "10.9.0.3", Device: testLink, Weight: 1},
+				})
+			},
+		},
+		{
+			name:   "mixed family multipath",
+			family: syscall.AF_INET6,
+			params: RouteParams{Destination: "fd00:15::/64", MultiPath: []NextHop{
+				{Gateway: "fd00:1::2", Device: testLink},
+				{Gateway: "10.9.0.3", Device: testLink},
+			}},
+			wantErr: true,
+		},
+		{
+			name:   "invalid weight",
+			family: syscall.AF_INET,
+			params: RouteParams{Destination: "10.21.0.0/16", MultiPath: []NextHop{
+				{Gateway: "10.9.0.2", Device: testLink, Weight: 300},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AddRouteParams(tt.params)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("AddRouteParams() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			table := tt.params.Table
+			if table == 0 {
+				table = syscall.RT_TABLE_MAIN
+			}
+
+			r := findRoute(t, tt.family, table, tt.params.Destination)
+			if r == nil {
+				t.Fatalf("route %s not found in table %d", tt.params.Destination, table)
+			}
+			if r.Table != table {
+				t.Errorf("table = %d, want %d", r.Table, table)
+			}
+			if tt.check != nil {
+				tt.check(t, r)
+			}
+
+			if err := DelRouteParams(tt.params); err != nil {
+				t.Fatal(err)
+			}
+			if r := findRoute(t, tt.family, table, tt.params.Destination); r != nil {
+				t.Errorf("route %s is not deleted", tt.params.Destination)
+			}
+		})
+	}
+}
+
+func checkNextHops(t *testing.T, r *Route, want []NextHop) {
+	if len(r.MultiPath) != len(want) {
+		t.Fatalf("next hops = %+v, want %+v", r.MultiPath, want)
+	}
+	for i := range want {
+		if r.MultiPath[i] != want[i] {
+			t.Errorf("next hop %d = %+v, want %+v", i, r.MultiPath[i], want[i])
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	requireNetns(t)
+
+	_, src4, _ := net.ParseCIDR("10.9.0.0/24")
+	_, dst6, _ := net.ParseCIDR("fd00:20::/64")
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"ipv4 fwmark", Rule{Priority: 1000, Table: 1000, Mark: 0x10, Mask: 0xff}},
+		{"ipv4 source", Rule{Priority: 1001, Table: 1000, Src: src4}},
+		{"ipv4 inverted", Rule{Priority: 1002, Table: 100, Mark: 0x20, Mask: 0xf0, Invert: true}},
+		{"ipv6 destination", Rule{Family: syscall.AF_INET6, Priority: 1000, Table: 1000, Dst: dst6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := AddRule(tt.rule); err != nil {
+				t.Fatal(err)
+			}
+			if err := AddRule(tt.rule); !os.IsExist(err) {
+				t.Errorf("second AddRule() = %v, want exist error", err)
+			}
+
+			found := findRule(t, tt.rule)
+			if found == nil {
+				t.Fatalf("rule %+v not found", tt.rule)
+			}
+			if found.Table != tt.rule.Table {
+				t.Errorf("table = %d, want %d", found.Table, tt.rule.Table)
+			}
+
+			if err := DelRule(tt.rule); err != nil {
+				t.Fatal(err)
+			}
+			if findRule(t, tt.rule) != nil {
+				t.Errorf("rule %+v is not deleted", tt.rule)
+			}
+		})
+	}
+}
+
+func findRule(t *testing.T, want Rule) *Rule {
+	family := want.Family
+	if family == 0 {
+		family = syscall.AF_INET
+	}
+	rules, err := NetworkGetRules(family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameNet := func(a, b *net.IPNet) bool {
+		return a == nil && b == nil || a != nil && b != nil && a.String() == b.String()
+	}
+	for _, r := range rules {
+		if r.Priority == want.Priority && r.Table == want.Table && r.Mark == want.Mark && r.Mask == want.Mask &&
+			r.Invert == want.Invert && sameNet(r.Src, want.Src) && sameNet(r.Dst, want.Dst) {
+			return &r
+		}
+	}
+	return nil
+}
+
+func TestRouteSubscribe(t *testing.T) {
+	setupLink(t)
+	defer teardownLink(t)
+
+	updates := make(chan RouteUpdate, 64)
+	done := make(chan struct{})
+	if err := RouteSubscribe(updates, done); err != nil {
+		t.Fatal(err)
+	}
+
+	params := []RouteParams{
+		{Destination: "10.30.0.0/16", Device: testLink, Table: 100},
+		{Destination: "fd00:30::/64", Device: testLink, Table: 100},
+	}
+	for _, p := range params {
+		if err := AddRouteParams(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := DelRouteParams(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range params {
+		for _, deleted := range []bool{false, true} {
+			waitRouteUpdate(t, updates, p.Destination, deleted)
+		}
+	}
+
+	close(done)
+	for range updates {
+	}
+}
+
+func waitRouteUpdate(t *testing.T, updates chan RouteUpdate, dst string, deleted bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if u.IPNet != nil && u.IPNet.String() == dst && u.Deleted == deleted {
+				if u.Table != 100 {
+					t.Errorf("route %s update table = %d, want 100", dst, u.Table)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no update for route %s (deleted: %v)", dst, deleted)
+		}
+	}
+}
+
+func TestLinkSubscribe(t *testing.T) {
+	requireNetns(t)
+
+	updates := make(chan LinkUpdate, 64)
+	done := make(chan struct{})
+	if err := LinkSubscribe(updates, done); err != nil {
+		t.Fatal(err)
+	}
+
+	iface := setupLink(t)
+	waitLinkUpdate(t, updates, func(u LinkUpdate) bool { return u.Name == testLink && u.IsUp() })
+
+	if err := NetworkLinkDown(iface); err != nil {
+		t.Fatal(err)
+	}
+	waitLinkUpdate(t, updates, func(u LinkUpdate) bool {
+		return u.Index == iface.Index && !u.Deleted && !u.IsUp()
+	})
+
+	teardownLink(t)
+	waitLinkUpdate(t, updates, func(u LinkUpdate) bool { return u.Index == iface.Index && u.Deleted })
+
+	close(done)
+	for range updates {
+	}
+}
+
+func waitLinkUpdate(t *testing.T, updates chan LinkUpdate, match func(LinkUpdate) bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if match(u) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no expected link update")
+		}
+	}
+}
+
+func TestAddrSubscribe(t *testing.T) {
+	iface := setupLink(t)
+	defer teardownLink(t)
+
+	updates := make(chan AddrUpdate, 64)
+	done := make(chan struct{})
+	if err := AddrSubscribe(updates, done); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []string{"10.9.1.1/24", "fd00:2::1/64"} {
+		ip, ipNet, _ := net.ParseCIDR(addr)
+		if err := NetworkLinkAddIpFlags(iface, ip, ipNet, syscall.IFA_F_NODAD); err != nil {
+			t.Fatal(err)
+		}
+		if err := NetworkLinkDelIp(iface, ip, ipNet); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, deleted := range []bool{false, true} {
+			waitAddrUpdate(t, updates, iface.Index, ip, deleted)
+		}
+	}
+
+	close(done)
+	for range updates {
+	}
+}
+
+func waitAddrUpdate(t *testing.T, updates chan AddrUpdate, index int, ip net.IP, deleted bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if u.IP.Equal(ip) && u.Deleted == deleted {
+				if u.LinkIndex != index {
+					t.Errorf("address %s update link = %d, want %d", ip, u.LinkIndex, index)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no update for address %s (deleted: %v)", ip, deleted)
+		}
+	}
+}
+
+func TestNetworkLinkAddTunnels(t *testing.T) {
+	setupLink(t)
+	defer teardownLink(t)
+
+	local4 := net.ParseIP("10.9.0.1").To4()
+	remote4 := net.ParseIP("10.9.0.2").To4()
+
+	tests := []struct {
+		name string
+		kind string
+		add  func() error
+		want LinkInfo
+	}{
+		{
+			name: "nltwg",
+			kind: "wireguard",
+			add:  func() error { return NetworkLinkAddWireguard("nltwg") },
+		},
+		{
+			name: "nltvxlan",
+			kind: "vxlan",
+			add: func() error {
+				return NetworkLinkAddVxlan(&VxlanLink{
+					Name: "nltvxlan", VNI: 42, Group: remote4, Local: local4,
+					Port: 4789, Device: testLink, TTL: 16,
+				})
+			},
+			want: LinkInfo{Vxlan: &VxlanLink{
+				Name: "nltvxlan", VNI: 42, Group: remote4, Local: local4,
+				Port: 4789, Device: testLink, TTL: 16,
+			}},
+		},
+		{
+			name: "nltgre",
+			kind: "gre",
+			add: func() error {
+				return NetworkLinkAddGre(&GreLink{
+					Name: "nltgre", Local: local4, Remote: remote4, TTL: 64, IKey: 7, OKey: 8,
+				})
+			},
+			want: LinkInfo{Gre: &GreLink{
+				Name: "nltgre", Local: local4, Remote: remote4, TTL: 64, IKey: 7, OKey: 8,
+			}},
+		},
+		{
+			name: "nltgretap",
+			kind: "gretap",
+			add: func() error {
+				return NetworkLinkAddGre(&GreLink{
+					Name: "nltgretap", Tap: true, Local: local4, Remote: remote4,
+				})
+			},
+			want: LinkInfo{Gre: &GreLink{
+				Name: "nltgretap", Tap: true, Local: local4, Remote: remote4,
+			}},
+		},
+		{
+			name: "nltipip",
+			kind: "ipip",
+			add: func() error {
+				return NetworkLinkAddIptun(&IptunLink{
+					Name: "nltipip", Local: local4, Remote: remote4, Device: testLink,
+				})
+			},
+			want: LinkInfo{Iptun: &IptunLink{
+				Name: "nltipip", Local: local4, Remote: remote4, Device: testLink,
+			}},
+		},
+		{
+			name: "nltsit",
+			kind: "sit",
+			add: func() error {
+				return NetworkLinkAddIptun(&IptunLink{
+					Name: "nltsit", Sit: true, Local: local4, Remote: remote4, TTL: 32,
+				})
+			},
+			want: LinkInfo{Iptun: &IptunLink{
+				Name: "nltsit", Sit: true, Local: local4, Remote: remote4, TTL: 32,
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			if err := tt.add(); err != nil {
+				if err == syscall.EOPNOTSUPP {
+					t.Skipf("%s links are not supported by kernel", tt.kind)
+				}
+				t.Fatal(err)
+			}
+			defer NetworkLinkDel(tt.name)
+
+			if err := tt.add(); err != ErrInterfaceExists {
+				t.Errorf("second add error = %v, want %v", err, ErrInterfaceExists)
+			}
+
+			info, err := NetworkLinkGet(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Name != tt.name || info.Kind != tt.kind {
+				t.Errorf("link %s of kind %s, want %s of kind %s", info.Name, info.Kind, tt.name, tt.kind)
+			}
+			if !reflect.DeepEqual(info.Vxlan, tt.want.Vxlan) {
+				t.Errorf("vxlan = %+v, want %+v", info.Vxlan, tt.want.Vxlan)
+			}
+			if !reflect.DeepEqual(info.Gre, tt.want.Gre) {
+				t.Errorf("gre = %+v, want %+v", info.Gre, tt.want.Gre)
+			}
+			if !reflect.DeepEqual(info.Iptun, tt.want.Iptun) {
+				t.Errorf("iptun = %+v, want %+v", info.Iptun, tt.want.Iptun)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// How often receiving goroutine wakes up to check if subscription is stopped
+var subscribeCheckInterval = time.Second
+
+func getNetlinkSubscribeSocket(groups ...uint) (*NetlinkSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	s := &NetlinkSocket{
+		fd: fd,
+	}
+	s.lsa.Family = syscall.AF_NETLINK
+	for _, g := range groups {
+		s.lsa.Groups |= 1 << (g - 1)
+	}
+	if err := syscall.Bind(fd, &s.lsa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	tv := syscall.NsecToTimeval(subscribeCheckInterval.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Receive multicast messages of the given groups until done is closed
+// or socket fails. handle returns false to stop receiving, resync is
+// called when kernel dropped messages and returns false to stop
+// receiving too, finish is called once receiving is stopped.
+func subscribe(groups []uint, done <-chan struct{}, handle func(syscall.NetlinkMessage) bool, resync func() bool, finish func()) error {
+	s, err := getNetlinkSubscribeSocket(groups...)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer finish()
+		defer s.Close()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			msgs, err := s.Receive()
+			if err != nil {
+				// timeout to check done
+				if err == syscall.EAGAIN || err == syscall.EINTR {
+					continue
+				}
+				// kernel dropped some messages because we were too slow
+				if err == syscall.ENOBUFS {
+					if !resync() {
+						return
+					}
+					continue
+				}
+				return
+			}
+			for _, m := range msgs {
+				if !handle(m) {
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func parseLinkUpdate(m syscall.NetlinkMessage) (LinkUpdate, error) {
+	var u LinkUpdate
+
+	if len(m.Data) < syscall.SizeofIfInfomsg {
+		return u, ErrShortResponse
+	}
+	msg := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0:syscall.SizeofIfInfomsg][0]))
+
+	u.Index = int(msg.Index)
+	u.Deleted = m.Header.Type == syscall.RTM_DELLINK
+	u.Flags = linkFlags(msg.Flags)
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return u, err
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.IFLA_IFNAME:
+			u.Name = string(trimZero(attr.Value))
+		case syscall.IFLA_MTU:
+			u.MTU = int(native.Uint32(attr.Value[0:4]))
+		}
+	}
+
+	return u, nil
+}
+
+func linkFlags(rawFlags uint32) net.Flags {
+	var f net.Flags
+	if rawFlags&syscall.IFF_UP != 0 {
+		f |= net.FlagUp
+	}
+	if rawFlags&syscall.IFF_BROADCAST != 0 {
+		f |= net.FlagBroadcast
+	}
+	if rawFlags&syscall.IFF_LOOPBACK != 0 {
+		f |= net.FlagLoopback
+	}
+	if rawFlags&syscall.IFF_POINTOPOINT != 0 {
+		f |= net.FlagPointToPoint
+	}
+	if rawFlags&syscall.IFF_MULTICAST != 0 {
+		f |= net.FlagMulticast
+	}
+	return f
+}
+
+func parseAddr(m syscall.NetlinkMessage) (Addr, error) {
+	var a Addr
+
+	if len(m.Data) < syscall.SizeofIfAddrmsg {
+		return a, ErrShortResponse
+	}
+	msg := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0:syscall.SizeofIfAddrmsg][0]))
+
+	a.LinkIndex = int(msg.Index)
+	a.Flags = int(msg.Flags)
+	a.Scope = int(msg.Scope)
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return a, err
+	}
+
+	var local, address net.IP
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.IFA_LOCAL:
+			local = net.IP(attr.Value)
+		case syscall.IFA_ADDRESS:
+			address = net.IP(attr.Value)
+		case IFA_FLAGS:
+			a.Flags = int(native.Uint32(attr.Value[0:4]))
+		}
+	}
+
+	// on point-to-point links IFA_ADDRESS is address of the peer
+	a.IP = local
+	if a.IP == nil {
+		a.IP = address
+	}
+	if a.IP != nil {
+		a.IPNet = &net.IPNet{
+			IP:   a.IP.Mask(net.CIDRMask(int(msg.Prefixlen), 8*len(a.IP))),
+			Mask: net.CIDRMask(int(msg.Prefixlen), 8*len(a.IP)),
+		}
+	}
+
+	return a, nil
+}
+
+// Subscribe to link changes. Updates are sent to ch until done is closed,
+// then ch is closed. This is similar to running: ip monitor link
+// Update with Resync set means some changes were lost.
+func LinkSubscribe(ch chan<- LinkUpdate, done <-chan struct{}) error {
+	return subscribe(
+		[]uint{syscall.RTNLGRP_LINK},
+		done,
+		func(m syscall.NetlinkMessage) bool {
+			if m.Header.Type != syscall.RTM_NEWLINK && m.Header.Type != syscall.RTM_DELLINK {
+				return true
+			}
+			u, err := parseLinkUpdate(m)
+			if err != nil {
+				return true
+			}
+			select {
+			case ch <- u:
+				return true
+			case <-done:
+				return false
+			}
+		},
+		func() bool {
+			select {
+			case ch <- LinkUpdate{Resync: true}:
+				return true
+			case <-done:
+				return false
+			}
+		},
+		func() { close(ch) },
+	)
+}
+
+// Subscribe to ipv4 and ipv6 address changes. Updates are sent to ch until
+// done is closed, then ch is closed. This is similar to running: ip monitor address
+// Update with Resync set means some changes were lost.
+func AddrSubscribe(ch chan<- AddrUpdate, done <-chan struct{}) error {
+	return subscribe(
+		[]uint{syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR},
+		done,
+		func(m syscall.NetlinkMessage) bool {
+			if m.Header.Type != syscall.RTM_NEWADDR && m.Header.Type != syscall.RTM_DELADDR {
+				return true
+			}
+			a, err := parseAddr(m)
+			if err != nil {
+				return true
+			}
+			select {
+			case ch <- AddrUpdate{Addr: a, Deleted: m.Header.Type == syscall.RTM_DELADDR}:
+				return true
+			case <-done:
+				return false
+			}
+		},
+		func() bool {
+			select {
+			case ch <- AddrUpdate{Resync: true}:
+				return true
+			case <-done:
+				return false
+			}
+		},
+		func() { close(ch) },
+	)
+}
+
+// Subscribe to ipv4 and ipv6 route changes in all tables. Updates are sent
+// to ch until done is closed, then ch is closed. This is similar to running:
+// ip monitor route
+// Update with Resync set means some changes were lost.
+func RouteSubscribe(ch chan<- RouteUpdate, done <-chan struct{}) error {
+	return subscribe(
+		[]uint{syscall.RTNLGRP_IPV4_ROUTE, syscall.RTNLGRP_IPV6_ROUTE},
+		done,
+		func(m syscall.NetlinkMessage) bool {
+			if m.Header.Type != syscall.RTM_NEWROUTE && m.Header.Type != syscall.RTM_DELROUTE {
+				return true
+			}
+			r, err := parseRoute(m)
+			if err != nil {
+				return true
+			}
+			select {
+			case ch <- RouteUpdate{Route: r, Deleted: m.Header.Type == syscall.RTM_DELROUTE}:
+				return true
+			case <-done:
+				return false
+			}
+		},
+		func() bool {
+			select {
+			case ch <- RouteUpdate{Resync: true}:
+				return true
+			case <-done:
+				return false
+			}
+		},
+		func() { close(ch) },
+	)
+}
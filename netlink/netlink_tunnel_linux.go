@@ -0,0 +1,442 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	IFLA_VXLAN_ID     = 1
+	IFLA_VXLAN_GROUP  = 2
+	IFLA_VXLAN_LINK   = 3
+	IFLA_VXLAN_LOCAL  = 4
+	IFLA_VXLAN_TTL    = 5
+	IFLA_VXLAN_PORT   = 15
+	IFLA_VXLAN_GROUP6 = 16
+	IFLA_VXLAN_LOCAL6 = 17
+	IFLA_GRE_LINK     = 1
+	IFLA_GRE_IFLAGS   = 2
+	IFLA_GRE_OFLAGS   = 3
+	IFLA_GRE_IKEY     = 4
+	IFLA_GRE_OKEY     = 5
+	IFLA_GRE_LOCAL    = 6
+	IFLA_GRE_REMOTE   = 7
+	IFLA_GRE_TTL      = 8
+	IFLA_IPTUN_LINK   = 1
+	IFLA_IPTUN_LOCAL  = 2
+	IFLA_IPTUN_REMOTE = 3
+	IFLA_IPTUN_TTL    = 4
+	GRE_KEY           = 0x2000
+)
+
+func uint8Attr(t int, n uint8) *RtAttr {
+	return newRtAttr(t, []byte{n})
+}
+
+func uint16BEAttr(t int, n uint16) *RtAttr {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, n)
+	return newRtAttr(t, buf)
+}
+
+func uint32BEAttr(t int, n uint32) *RtAttr {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, n)
+	return newRtAttr(t, buf)
+}
+
+// Add a new link of linkType with type specific attributes in
+// IFLA_INFO_DATA and generic ones (e.g. IFLA_LINK) in the top level
+func networkLinkAddKind(name, linkType string, data []*RtAttr, attrs ...*RtAttr) error {
+	if name == "" {
+		return fmt.Errorf("Network link name can not be empty!")
+	}
+	if len(name) >= IFNAMSIZ {
+		return fmt.Errorf("Interface name %s too long", name)
+	}
+
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+
+	msg := newIfInfomsg(syscall.AF_UNSPEC)
+	wb.AddData(msg)
+
+	linkInfo := newRtAttr(syscall.IFLA_LINKINFO, nil)
+	newRtAttrChild(linkInfo, IFLA_INFO_KIND, nonZeroTerminated(linkType))
+	if len(data) != 0 {
+		infoData := newRtAttrChild(linkInfo, IFLA_INFO_DATA, nil)
+		for _, attr := range data {
+			infoData.children = append(infoData.children, attr)
+		}
+	}
+	wb.AddData(linkInfo)
+
+	wb.AddData(newRtAttr(syscall.IFLA_IFNAME, zeroTerminated(name)))
+	for _, attr := range attrs {
+		wb.AddData(attr)
+	}
+
+	if err := s.Send(wb); err != nil {
+		return err
+	}
+
+	if err := s.HandleAck(wb.Seq); err != nil {
+		if os.IsExist(err) {
+			return ErrInterfaceExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+func linkIndex(device string) (int, error) {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return 0, err
+	}
+	return iface.Index, nil
+}
+
+// Add a new WireGuard link. This is identical to running:
+// ip link add $name type wireguard
+func NetworkLinkAddWireguard(name string) error {
+	return networkLinkAddKind(name, "wireguard", nil)
+}
+
+// Add a new VXLAN link. This is identical to running:
+// ip link add $name type vxlan id $vni group $group local $local dstport $port dev $device ttl $ttl
+func NetworkLinkAddVxlan(link *VxlanLink) error {
+	if link.VNI < 0 || link.VNI >= 1<<24 {
+		return fmt.Errorf("VXLAN id %d is out of range", link.VNI)
+	}
+	if link.Port < 0 || link.Port > 65535 {
+		return fmt.Errorf("VXLAN port %d is out of range", link.Port)
+	}
+
+	data := []*RtAttr{uint32Attr(IFLA_VXLAN_ID, uint32(link.VNI))}
+
+	if link.Group != nil {
+		if ip := link.Group.To4(); ip != nil {
+			data = append(data, newRtAttr(IFLA_VXLAN_GROUP, ip))
+		} else {
+			data = append(data, newRtAttr(IFLA_VXLAN_GROUP6, link.Group.To16()))
+		}
+	}
+	if link.Local != nil {
+		if ip := link.Local.To4(); ip != nil {
+			data = append(data, newRtAttr(IFLA_VXLAN_LOCAL, ip))
+		} else {
+			data = append(data, newRtAttr(IFLA_VXLAN_LOCAL6, link.Local.To16()))
+		}
+	}
+	if link.Device != "" {
+		index, err := linkIndex(link.Device)
+		if err != nil {
+			return err
+		}
+		data = append(data, uint32Attr(IFLA_VXLAN_LINK, uint32(index)))
+	}
+	if link.TTL != 0 {
+		data = append(data, uint8Attr(IFLA_VXLAN_TTL, uint8(link.TTL)))
+	}
+	if link.Port != 0 {
+		data = append(data, uint16BEAttr(IFLA_VXLAN_PORT, uint16(link.Port)))
+	}
+
+	return networkLinkAddKind(link.Name, "vxlan", data)
+}
+
+func tunnelFamily(local, remote net.IP) (int, error) {
+	family := -1
+	for _, ip := range []net.IP{local, remote} {
+		if ip == nil {
+			continue
+		}
+		ipFamily := getIpFamily(ip)
+		if family != -1 && family != ipFamily {
+			return family, fmt.Errorf("local and remote ip were not the same IP family")
+		}
+		family = ipFamily
+	}
+	if family == -1 {
+		family = syscall.AF_INET
+	}
+	return family, nil
+}
+
+// Add a new GRE link. This is identical to running:
+// ip link add $name type gre|gretap|ip6gre|ip6gretap local $local remote $remote dev $device ttl $ttl ikey $ikey okey $okey
+func NetworkLinkAddGre(link *GreLink) error {
+	family, err := tunnelFamily(link.Local, link.Remote)
+	if err != nil {
+		return err
+	}
+
+	linkType := "gre"
+	if family == syscall.AF_INET6 {
+		linkType = "ip6gre"
+	}
+	if link.Tap {
+		linkType += "tap"
+	}
+
+	var data []*RtAttr
+	if link.Local != nil {
+		data = append(data, newRtAttr(IFLA_GRE_LOCAL, ipData(link.Local, family)))
+	}
+	if link.Remote != nil {
+		data = append(data, newRtAttr(IFLA_GRE_REMOTE, ipData(link.Remote, family)))
+	}
+	if link.Device != "" {
+		index, err := linkIndex(link.Device)
+		if err != nil {
+			return err
+		}
+		data = append(data, uint32Attr(IFLA_GRE_LINK, uint32(index)))
+	}
+	if link.TTL != 0 {
+		data = append(data, uint8Attr(IFLA_GRE_TTL, uint8(link.TTL)))
+	}
+	if link.IKey != 0 {
+		data = append(data,
+			uint16BEAttr(IFLA_GRE_IFLAGS, GRE_KEY),
+			uint32BEAttr(IFLA_GRE_IKEY, link.IKey))
+	}
+	if link.OKey != 0 {
+		data = append(data,
+			uint16BEAttr(IFLA_GRE_OFLAGS, GRE_KEY),
+			uint32BEAttr(IFLA_GRE_OKEY, link.OKey))
+	}
+
+	return networkLinkAddKind(link.Name, linkType, data)
+}
+
+// Add a new ipip or sit link. This is identical to running:
+// ip link add $name type ipip|sit local $local remote $remote dev $device ttl $ttl
+func NetworkLinkAddIptun(link *IptunLink) error {
+	family, err := tunnelFamily(link.Local, link.Remote)
+	if err != nil {
+		return err
+	}
+	if family != syscall.AF_INET {
+		return fmt.Errorf("ipip and sit tunnels need ipv4 local and remote addresses")
+	}
+
+	linkType := "ipip"
+	if link.Sit {
+		linkType = "sit"
+	}
+
+	var data []*RtAttr
+	if link.Local != nil {
+		data = append(data, newRtAttr(IFLA_IPTUN_LOCAL, link.Local.To4()))
+	}
+	if link.Remote != nil {
+		data = append(data, newRtAttr(IFLA_IPTUN_REMOTE, link.Remote.To4()))
+	}
+	if link.Device != "" {
+		index, err := linkIndex(link.Device)
+		if err != nil {
+			return err
+		}
+		data = append(data, uint32Attr(IFLA_IPTUN_LINK, uint32(index)))
+	}
+	if link.TTL != 0 {
+		data = append(data, uint8Attr(IFLA_IPTUN_TTL, uint8(link.TTL)))
+	}
+
+	return networkLinkAddKind(link.Name, linkType, data)
+}
+
+func deviceName(b []byte) string {
+	if iface, err := net.InterfaceByIndex(int(native.Uint32(b[0:4]))); err == nil {
+		return iface.Name
+	}
+	return ""
+}
+
+func parseVxlan(name string, attrs []syscall.NetlinkRouteAttr) *VxlanLink {
+	link := &VxlanLink{Name: name}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case IFLA_VXLAN_ID:
+			link.VNI = int(native.Uint32(attr.Value[0:4]))
+		case IFLA_VXLAN_GROUP, IFLA_VXLAN_GROUP6:
+			link.Group = net.IP(attr.Value)
+		case IFLA_VXLAN_LOCAL, IFLA_VXLAN_LOCAL6:
+			link.Local = net.IP(attr.Value)
+		case IFLA_VXLAN_LINK:
+			link.Device = deviceName(attr.Value)
+		case IFLA_VXLAN_TTL:
+			link.TTL = int(attr.Value[0])
+		case IFLA_VXLAN_PORT:
+			link.Port = int(binary.BigEndian.Uint16(attr.Value[0:2]))
+		}
+	}
+	return link
+}
+
+func parseGre(name, kind string, attrs []syscall.NetlinkRouteAttr) *GreLink {
+	link := &GreLink{
+		Name: name,
+		Tap:  kind == "gretap" || kind == "ip6gretap",
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case IFLA_GRE_LOCAL:
+			link.Local = net.IP(attr.Value)
+		case IFLA_GRE_REMOTE:
+			link.Remote = net.IP(attr.Value)
+		case IFLA_GRE_LINK:
+			link.Device = deviceName(attr.Value)
+		case IFLA_GRE_TTL:
+			link.TTL = int(attr.Value[0])
+		case IFLA_GRE_IKEY:
+			link.IKey = binary.BigEndian.Uint32(attr.Value[0:4])
+		case IFLA_GRE_OKEY:
+			link.OKey = binary.BigEndian.Uint32(attr.Value[0:4])
+		}
+	}
+	return link
+}
+
+func parseIptun(name, kind string, attrs []syscall.NetlinkRouteAttr) *IptunLink {
+	link := &IptunLink{
+		Name: name,
+		Sit:  kind == "sit",
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case IFLA_IPTUN_LOCAL:
+			link.Local = net.IP(attr.Value)
+		case IFLA_IPTUN_REMOTE:
+			link.Remote = net.IP(attr.Value)
+		case IFLA_IPTUN_LINK:
+			link.Device = deviceName(attr.Value)
+		case IFLA_IPTUN_TTL:
+			link.TTL = int(attr.Value[0])
+		}
+	}
+	return link
+}
+
+func parseLinkInfo(m syscall.NetlinkMessage) (*LinkInfo, error) {
+	if len(m.Data) < syscall.SizeofIfInfomsg {
+		return nil, ErrShortResponse
+	}
+	msg := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0:syscall.SizeofIfInfomsg][0]))
+
+	info := &LinkInfo{
+		Index: int(msg.Index),
+		Flags: linkFlags(msg.Flags),
+	}
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []syscall.NetlinkRouteAttr
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.IFLA_IFNAME:
+			info.Name = string(trimZero(attr.Value))
+		case syscall.IFLA_MTU:
+			info.MTU = int(native.Uint32(attr.Value[0:4]))
+		case syscall.IFLA_LINKINFO:
+			nested, err := parseRtAttrs(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range nested {
+				switch n.Attr.Type {
+				case IFLA_INFO_KIND:
+					info.Kind = string(trimZero(n.Value))
+				case IFLA_INFO_DATA:
+					if data, err = parseRtAttrs(n.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	switch info.Kind {
+	case "vxlan":
+		info.Vxlan = parseVxlan(info.Name, data)
+	case "gre", "gretap", "ip6gre", "ip6gretap":
+		info.Gre = parseGre(info.Name, info.Kind, data)
+	case "ipip", "sit":
+		info.Iptun = parseIptun(info.Name, info.Kind, data)
+	}
+
+	return info, nil
+}
+
+func trimZero(b []byte) []byte {
+	if len(b) > 0 && b[len(b)-1] == 0 {
+		return b[:len(b)-1]
+	}
+	return b
+}
+
+// Get link with its kind and tunnel attributes. This is identical to running:
+// ip -details link show $name
+func NetworkLinkGet(name string) (*LinkInfo, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_GETLINK, 0)
+	msg := newIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(iface.Index)
+	wb.AddData(msg)
+
+	if err := s.Send(wb); err != nil {
+		return nil, err
+	}
+
+	pid, err := s.GetPid()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		msgs, err := s.Receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if err := s.CheckMessage(m, wb.Seq, pid); err != nil {
+				if err == io.EOF {
+					return nil, ErrShortResponse
+				}
+				return nil, err
+			}
+			if m.Header.Type == syscall.RTM_NEWLINK {
+				return parseLinkInfo(m)
+			}
+		}
+	}
+}
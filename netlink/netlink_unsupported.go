@@ -1,3 +1,4 @@
+//go:build !linux
 // +build !linux
 
 package netlink
@@ -31,6 +32,22 @@ func NetworkLinkAddIp(iface *net.Interface, ip net.IP, ipNet *net.IPNet) error {
 	return ErrNotImplemented
 }
 
+func NetworkLinkAddIpFlags(iface *net.Interface, ip net.IP, ipNet *net.IPNet, flags int) error {
+	return ErrNotImplemented
+}
+
+func NetworkGetAddrs(family int) ([]Addr, error) {
+	return nil, ErrNotImplemented
+}
+
+func NetworkGetFamilyRoutes(family, table int) ([]Route, error) {
+	return nil, ErrNotImplemented
+}
+
+func AddDefaultMultiPathGw(hops []NextHop) error {
+	return ErrNotImplemented
+}
+
 func NetworkLinkDelIp(iface *net.Interface, ip net.IP, ipNet *net.IPNet) error {
 	return ErrNotImplemented
 }
@@ -39,6 +56,34 @@ func AddRoute(destination, source, gateway, device string) error {
 	return ErrNotImplemented
 }
 
+func DelRoute(destination, source, gateway, device string) error {
+	return ErrNotImplemented
+}
+
+func AddRouteParams(p RouteParams) error {
+	return ErrNotImplemented
+}
+
+func DelRouteParams(p RouteParams) error {
+	return ErrNotImplemented
+}
+
+func NetworkGetTableRoutes(table int) ([]Route, error) {
+	return nil, ErrNotImplemented
+}
+
+func AddRule(r Rule) error {
+	return ErrNotImplemented
+}
+
+func DelRule(r Rule) error {
+	return ErrNotImplemented
+}
+
+func NetworkGetRules(family int) ([]Rule, error) {
+	return nil, ErrNotImplemented
+}
+
 func AddDefaultGw(ip, device string) error {
 	return ErrNotImplemented
 }
@@ -86,3 +131,35 @@ func DeleteBridge(name string) error {
 func AddToBridge(iface, master *net.Interface) error {
 	return ErrNotImplemented
 }
+
+func LinkSubscribe(ch chan<- LinkUpdate, done <-chan struct{}) error {
+	return ErrNotImplemented
+}
+
+func AddrSubscribe(ch chan<- AddrUpdate, done <-chan struct{}) error {
+	return ErrNotImplemented
+}
+
+func RouteSubscribe(ch chan<- RouteUpdate, done <-chan struct{}) error {
+	return ErrNotImplemented
+}
+
+func NetworkLinkAddWireguard(name string) error {
+	return ErrNotImplemented
+}
+
+func NetworkLinkAddVxlan(link *VxlanLink) error {
+	return ErrNotImplemented
+}
+
+func NetworkLinkAddGre(link *GreLink) error {
+	return ErrNotImplemented
+}
+
+func NetworkLinkAddIptun(link *IptunLink) error {
+	return ErrNotImplemented
+}
+
+func NetworkLinkGet(name string) (*LinkInfo, error) {
+	return nil, ErrNotImplemented
+}
@@ -28,7 +28,7 @@ import (
 )
 
 const (
-	sdnaAgentName       = "goclient-v0.1"
+	sdnaAgentName            = "goclient-v0.1"
 	authenticationHeaderName = "Authorization"
 	authenticationSchemaName = "Signature"
 )
@@ -48,6 +48,15 @@ func NewPostRequest(apiURI, path string, requestBody interface{}) (*http.Request
 	return newRequest(http.MethodPost, apiURI, path, encodedBody)
 }
 
+// NewPutRequest generates http Put request
+func NewPutRequest(apiURI, path string, requestBody interface{}) (*http.Request, error) {
+	encodedBody, err := encodeToJSON(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	return newRequest(http.MethodPut, apiURI, path, encodedBody)
+}
+
 // NewSignedRequest signs payload and generates http request
 func NewSignedRequest(httpMethod, apiURI, path string, requestBody interface{}, signer identity.Signer) (*http.Request, error) {
 	var encodedBody []byte = nil
@@ -18,6 +18,7 @@
 package noop
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -32,13 +33,19 @@ type Connection struct {
 }
 
 // Start implements the connection.Connection interface
-func (c *Connection) Start(params connection.ConnectOptions) error {
+func (c *Connection) Start(ctx context.Context, params connection.ConnectOptions) error {
 	c.noopConnection.Add(1)
 	c.isRunning = true
 
 	c.stateChannel <- connection.Connecting
 
-	time.Sleep(5 * time.Second)
+	select {
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+		c.isRunning = false
+		c.noopConnection.Done()
+		return ctx.Err()
+	}
 	c.stateChannel <- connection.Connected
 	return nil
 }
@@ -18,11 +18,15 @@
 package openvpn
 
 import (
+	"context"
+	"net"
+
 	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn"
 	"github.com/skytells-research/DNA/network/node/core/connection"
 	"github.com/skytells-research/DNA/network/node/core/ip"
-	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/node/firewall"
 )
 
 // ErrProcessNotStarted represents the error we return when the process is not started yet
@@ -45,10 +49,12 @@ type Client struct {
 	ipResolver     ip.Resolver
 	natPinger      NATPinger
 	publicIP       string
+	vpnConfig      *VPNConfig
 }
 
-// Start starts the connection
-func (c *Client) Start(options connection.ConnectOptions) error {
+// Start starts the connection, openvpn process is not started once context is done.
+// Process reports states on its own, so the connection manager stops it if it does not connect in time.
+func (c *Client) Start(ctx context.Context, options connection.ConnectOptions) error {
 	log.Info("starting connection")
 	proc, clientConfig, err := c.processFactory(options)
 	log.Info("client config factory error: ", err)
@@ -56,6 +62,7 @@ func (c *Client) Start(options connection.ConnectOptions) error {
 		return err
 	}
 	c.process = proc
+	c.vpnConfig = clientConfig.vpnConfig
 	log.Infof("client config: %v", clientConfig)
 
 	c.natPinger.BindPort(clientConfig.LocalPort)
@@ -63,6 +70,9 @@ func (c *Client) Start(options connection.ConnectOptions) error {
 	if err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	return c.process.Start()
 }
@@ -82,6 +92,16 @@ func (c *Client) Stop() {
 	}
 }
 
+// Tunnel describes openvpn tun device and provider endpoint for kill switch.
+// Device is allocated by openvpn itself, so any tun device is allowed.
+func (c *Client) Tunnel() firewall.Tunnel {
+	tunnel := firewall.Tunnel{Interface: "tun+"}
+	if c.vpnConfig != nil {
+		tunnel.ProviderIP = net.ParseIP(c.vpnConfig.RemoteIP)
+	}
+	return tunnel
+}
+
 // GetConfig returns the consumer-side configuration.
 func (c *Client) GetConfig() (connection.ConsumerConfig, error) {
 	ip, err := c.ipResolver.GetPublicIP()
@@ -98,12 +118,13 @@ func (c *Client) GetConfig() (connection.ConsumerConfig, error) {
 	}, nil
 }
 
-//VPNConfig structure represents VPN configuration options for given session
+// VPNConfig structure represents VPN configuration options for given session
 type VPNConfig struct {
-	RemoteIP        string `json:"remote"`
-	RemotePort      int    `json:"port"`
-	LocalPort       int    `json:"lport"`
-	RemoteProtocol  string `json:"protocol"`
-	TLSPresharedKey string `json:"TLSPresharedKey"`
-	CACertificate   string `json:"CACertificate"`
+	RemoteIP        string   `json:"remote"`
+	RemotePort      int      `json:"port"`
+	LocalPort       int      `json:"lport"`
+	RemoteProtocol  string   `json:"protocol"`
+	TLSPresharedKey string   `json:"TLSPresharedKey"`
+	CACertificate   string   `json:"CACertificate"`
+	DNS             []string `json:"dns,omitempty"`
 }
@@ -19,11 +19,16 @@ package openvpn
 
 import (
 	"encoding/json"
+	"net"
 	"strconv"
 
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/config"
+	"github.com/skytells-research/DNA/network/node/core/connection"
 )
 
+// defaultDNS is used with providers which do not advertise DNS servers
+var defaultDNS = []net.IP{net.ParseIP("208.67.222.222"), net.ParseIP("208.67.220.220")}
+
 // ClientConfig represents specific "openvpn as client" configuration
 type ClientConfig struct {
 	*config.GenericConfig
@@ -60,6 +65,34 @@ func (c *ClientConfig) SetProtocol(protocol string) {
 	}
 }
 
+// SetDNS makes openvpn configure given DNS servers while the tunnel is up
+func (c *ClientConfig) SetDNS(servers []net.IP) {
+	for _, server := range servers {
+		c.SetParam("dhcp-option", "DNS", server.String())
+	}
+}
+
+// SetRoutes routes included networks through the tunnel, or all the traffic if none included,
+// excluded networks are routed via the original default gateway
+func (c *ClientConfig) SetRoutes(routes connection.Routes) {
+	if len(routes.Include) == 0 {
+		c.SetParam("redirect-gateway", "def1", "bypass-dhcp")
+	}
+	for _, network := range routes.Include {
+		if network.IP.To4() == nil {
+			c.SetParam("route-ipv6", network.String())
+		} else {
+			c.SetParam("route", network.IP.String(), net.IP(network.Mask).String())
+		}
+	}
+	for _, network := range routes.Exclude {
+		// only IPv4 traffic is redirected through the tunnel by default
+		if network.IP.To4() != nil {
+			c.SetParam("route", network.IP.String(), net.IP(network.Mask).String(), "net_gateway")
+		}
+	}
+}
+
 func defaultClientConfig(runtimeDir string, scriptSearchPath string) *ClientConfig {
 	clientConfig := ClientConfig{config.NewConfig(runtimeDir, scriptSearchPath), 50221, nil}
 
@@ -73,9 +106,6 @@ func defaultClientConfig(runtimeDir string, scriptSearchPath string) *ClientConf
 
 	clientConfig.SetParam("reneg-sec", "60")
 	clientConfig.SetParam("resolv-retry", "infinite")
-	clientConfig.SetParam("redirect-gateway", "def1", "bypass-dhcp")
-	clientConfig.SetParam("dhcp-option", "DNS", "208.67.222.222")
-	clientConfig.SetParam("dhcp-option", "DNS", "208.67.220.220")
 
 	return &clientConfig
 }
@@ -83,7 +113,7 @@ func defaultClientConfig(runtimeDir string, scriptSearchPath string) *ClientConf
 // NewClientConfigFromSession creates client configuration structure for given VPNConfig, configuration dir to store serialized file args, and
 // configuration filename to store other args
 // TODO this will become the part of openvpn service consumer separate package
-func NewClientConfigFromSession(sessionConfig []byte, configDir string, runtimeDir string) (*ClientConfig, error) {
+func NewClientConfigFromSession(sessionConfig []byte, configDir string, runtimeDir string, options connection.ConnectOptions) (*ClientConfig, error) {
 	vpnConfig := &VPNConfig{}
 	err := json.Unmarshal(sessionConfig, vpnConfig)
 	if err != nil {
@@ -95,6 +125,18 @@ func NewClientConfigFromSession(sessionConfig []byte, configDir string, runtimeD
 		return nil, err
 	}
 
+	providerDNS := defaultDNS
+	if len(vpnConfig.DNS) > 0 {
+		providerDNS = make([]net.IP, 0, len(vpnConfig.DNS))
+		for _, server := range vpnConfig.DNS {
+			providerDNS = append(providerDNS, net.ParseIP(server))
+		}
+	}
+	dnsServers, err := options.DNS.Servers(providerDNS)
+	if err != nil {
+		return nil, err
+	}
+
 	clientFileConfig := newClientConfig(runtimeDir, configDir)
 	clientFileConfig.vpnConfig = vpnConfig
 	clientFileConfig.SetReconnectRetry(2)
@@ -102,6 +144,8 @@ func NewClientConfigFromSession(sessionConfig []byte, configDir string, runtimeD
 	clientFileConfig.SetProtocol(vpnConfig.RemoteProtocol)
 	clientFileConfig.SetTLSCACertificate(vpnConfig.CACertificate)
 	clientFileConfig.SetTLSCrypt(vpnConfig.TLSPresharedKey)
+	clientFileConfig.SetDNS(dnsServers)
+	clientFileConfig.SetRoutes(options.Routes)
 
 	return clientFileConfig, nil
 }
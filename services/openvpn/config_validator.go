@@ -43,6 +43,7 @@ func NewDefaultValidator() *ConfigValidator {
 			validProtocol,
 			validPort,
 			validIPFormat,
+			validDNS,
 			validTLSPresharedKey,
 			validCACertificate,
 		},
@@ -87,6 +88,15 @@ func validIPFormat(config *VPNConfig) error {
 	return nil
 }
 
+func validDNS(config *VPNConfig) error {
+	for _, server := range config.DNS {
+		if net.ParseIP(server) == nil {
+			return errors.New("unable to parse DNS server address " + server)
+		}
+	}
+	return nil
+}
+
 // preshared key format (PEM blocks with data encoded to hex) are taken from
 // openvpn --genkey --secret static.key, which is openvpn specific
 // side effect: it reformats key from single line to multiline fixed length strings
@@ -18,6 +18,7 @@
 package openvpn
 
 import (
+	"context"
 	"testing"
 
 	"github.com/skytells-research/DNA/network/node/consumer"
@@ -61,7 +62,7 @@ func TestConnectionFactory_ErrorsOnInvalidConfig(t *testing.T) {
 	connectionOptions := connection.ConnectOptions{}
 	conn, err := factory.Create(channel, statisticsChannel)
 	assert.Nil(t, err)
-	err = conn.Start(connectionOptions)
+	err = conn.Start(context.Background(), connectionOptions)
 	assert.EqualError(t, err, "unexpected end of JSON input")
 }
 
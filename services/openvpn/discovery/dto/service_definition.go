@@ -28,9 +28,12 @@ type ServiceDefinition struct {
 	// This is used by providers having their own means of setting tunnels to other remote exit points.
 	LocationOriginate market.Location `json:"location_originate"`
 
-	// Available per session bandwidth
+	// Available per session download bandwidth, unlimited if not set
 	SessionBandwidth Bandwidth `json:"session_bandwidth,omitempty"`
 
+	// Available per session upload bandwidth, unlimited if not set
+	SessionUploadBandwidth Bandwidth `json:"session_upload_bandwidth,omitempty"`
+
 	// Transport protocol used by service
 	Protocol string `json:"protocol,omitempty"`
 }
@@ -40,10 +40,11 @@ func TestServiceDefinitionSerialize(t *testing.T) {
 	}{
 		{
 			ServiceDefinition{
-				Location:          locationUS,
-				LocationOriginate: locationUS,
-				SessionBandwidth:  Bandwidth(10 * datasize.Bit),
-				Protocol:          protocol,
+				Location:               locationUS,
+				LocationOriginate:      locationUS,
+				SessionBandwidth:       Bandwidth(10 * datasize.Bit),
+				SessionUploadBandwidth: Bandwidth(2 * datasize.Bit),
+				Protocol:               protocol,
 			},
 			`{
 				"location": {
@@ -53,6 +54,7 @@ func TestServiceDefinitionSerialize(t *testing.T) {
 					"country": "US"
 				},
 				"session_bandwidth": 10,
+				"session_upload_bandwidth": 2,
 				"protocol": "tcp"
 			}`,
 		},
@@ -20,25 +20,28 @@ package discovery
 import (
 	"time"
 
-	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/core/service"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/money"
 	"github.com/skytells-research/DNA/network/node/services/openvpn"
 	"github.com/skytells-research/DNA/network/node/services/openvpn/discovery/dto"
 )
 
-// NewServiceProposalWithLocation creates service proposal description for openvpn service
+// NewServiceProposalWithLocation creates service proposal description for openvpn service,
+// advertising bandwidth available to every session
 func NewServiceProposalWithLocation(
 	serviceLocation market.Location,
 	protocol string,
+	bandwidth service.OptionsBandwidth,
 ) market.ServiceProposal {
 	return market.ServiceProposal{
 		ServiceType: openvpn.ServiceType,
 		ServiceDefinition: dto.ServiceDefinition{
-			Location:          serviceLocation,
-			LocationOriginate: serviceLocation,
-			SessionBandwidth:  dto.Bandwidth(10 * datasize.MB),
-			Protocol:          protocol,
+			Location:               serviceLocation,
+			LocationOriginate:      serviceLocation,
+			SessionBandwidth:       dto.Bandwidth(bandwidth.Download),
+			SessionUploadBandwidth: dto.Bandwidth(bandwidth.Upload),
+			Protocol:               protocol,
 		},
 		PaymentMethodType: dto.PaymentMethodPerTime,
 		PaymentMethod: dto.PaymentPerTime{
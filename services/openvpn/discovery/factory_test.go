@@ -21,6 +21,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/datasize"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/money"
 	"github.com/skytells-research/DNA/network/node/services/openvpn/discovery/dto"
@@ -33,17 +35,18 @@ var (
 )
 
 func Test_NewServiceProposalWithLocation(t *testing.T) {
-	proposal := NewServiceProposalWithLocation(locationLTTelia, protocol)
+	proposal := NewServiceProposalWithLocation(locationLTTelia, protocol, service.OptionsBandwidth{Upload: datasize.MB, Download: 10 * datasize.MB})
 
 	assert.Exactly(
 		t,
 		market.ServiceProposal{
 			ServiceType: "openvpn",
 			ServiceDefinition: dto.ServiceDefinition{
-				Location:          locationLTTelia,
-				LocationOriginate: locationLTTelia,
-				SessionBandwidth:  83886080,
-				Protocol:          "tcp",
+				Location:               locationLTTelia,
+				LocationOriginate:      locationLTTelia,
+				SessionBandwidth:       83886080,
+				SessionUploadBandwidth: 8388608,
+				Protocol:               "tcp",
 			},
 
 			PaymentMethodType: "PER_TIME",
@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sessionstats
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/management"
+	"github.com/skytells-research/DNA/network/node/core/service"
+)
+
+const (
+	bytecountPrefix   = ">BYTECOUNT_CLI:"
+	establishedPrefix = ">CLIENT:ESTABLISHED,"
+	disconnectPrefix  = ">CLIENT:DISCONNECT,"
+	envPrefix         = ">CLIENT:ENV,"
+)
+
+// ConsumerFinder returns consumer of the given session, empty if it is not known
+type ConsumerFinder func(sessionID string) string
+
+// Middleware records sessions of openvpn server clients and their traffic, reported by management interface.
+// Client lines are left for other middlewares to consume.
+type Middleware struct {
+	interval  time.Duration
+	consumers ConsumerFinder
+	recorder  *service.SessionRecorder
+	// clients are ids of connected clients
+	clients map[int]struct{}
+	// established collects environment of the client which has just connected
+	established *clientEnv
+	// commandWriter sends commands to the running server, nil when it is not running
+	commandWriter management.CommandWriter
+	lock          sync.Mutex
+}
+
+type clientEnv struct {
+	id  int
+	env map[string]string
+}
+
+// NewMiddleware creates middleware which makes openvpn report traffic of clients every interval
+func NewMiddleware(consumers ConsumerFinder, interval time.Duration) *Middleware {
+	return &Middleware{
+		interval:  interval,
+		consumers: consumers,
+		clients:   make(map[int]struct{}),
+	}
+}
+
+// RecordSessions makes the middleware record client sessions by the given recorder
+func (m *Middleware) RecordSessions(recorder *service.SessionRecorder) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.recorder = recorder
+}
+
+// Start enables traffic reports of clients
+func (m *Middleware) Start(commandWriter management.CommandWriter) error {
+	m.lock.Lock()
+	m.commandWriter = commandWriter
+	m.lock.Unlock()
+
+	_, err := commandWriter.SingleLineCommand("bytecount %d", int(m.interval.Seconds()))
+	return err
+}
+
+// Stop ends sessions of all clients, they are disconnected once server stops
+func (m *Middleware) Stop(commandWriter management.CommandWriter) error {
+	m.lock.Lock()
+	for id := range m.clients {
+		m.recorder.Ended(strconv.Itoa(id))
+		delete(m.clients, id)
+	}
+	m.established = nil
+	m.commandWriter = nil
+	m.lock.Unlock()
+
+	_, err := commandWriter.SingleLineCommand("bytecount 0")
+	return err
+}
+
+// KillClient disconnects the client from the running server, its session ends once openvpn reports the disconnect.
+// Command is sent in the background, because clients are killed while their traffic reports are consumed,
+// and the reply is read by the same reader which consumes the reports.
+func (m *Middleware) KillClient(id int) error {
+	m.lock.Lock()
+	commandWriter := m.commandWriter
+	m.lock.Unlock()
+
+	if commandWriter == nil {
+		return errors.New("server is not running")
+	}
+	go func() {
+		if _, err := commandWriter.SingleLineCommand("client-kill %d", id); err != nil {
+			log.Error("Failed to kill openvpn client ", id, ": ", err)
+		}
+	}()
+	return nil
+}
+
+// ConsumeLine records client sessions and traffic, only traffic reports are consumed
+func (m *Middleware) ConsumeLine(line string) (bool, error) {
+	// traffic is recorded without the lock, recorder kills clients which exceed their quota
+	if strings.HasPrefix(line, bytecountPrefix) {
+		return true, m.bytecount(strings.TrimPrefix(line, bytecountPrefix))
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch {
+	case strings.HasPrefix(line, establishedPrefix):
+		id, err := parseClientID(strings.TrimPrefix(line, establishedPrefix))
+		if err != nil {
+			return false, err
+		}
+		m.established = &clientEnv{id: id, env: make(map[string]string)}
+	case strings.HasPrefix(line, envPrefix) && m.established != nil:
+		variable := strings.TrimPrefix(line, envPrefix)
+		if variable == "END" {
+			m.start(*m.established)
+			m.established = nil
+			break
+		}
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			m.established.env[parts[0]] = parts[1]
+		}
+	case strings.HasPrefix(line, disconnectPrefix):
+		id, err := parseClientID(strings.TrimPrefix(line, disconnectPrefix))
+		if err != nil {
+			return false, err
+		}
+		if _, ok := m.clients[id]; ok {
+			m.recorder.Ended(strconv.Itoa(id))
+			delete(m.clients, id)
+		}
+	}
+	return false, nil
+}
+
+func (m *Middleware) start(client clientEnv) {
+	peer := strconv.Itoa(client.id)
+	m.clients[client.id] = struct{}{}
+	m.recorder.Started(peer, m.consumers(client.env["username"]))
+
+	if ip, ok := client.env["untrusted_ip"]; ok {
+		m.recorder.Update(peer, service.SessionStats{PeerEndpoint: net.JoinHostPort(ip, client.env["untrusted_port"])})
+	}
+}
+
+// bytecount records traffic reported as {CID},{BYTES_IN},{BYTES_OUT}
+func (m *Middleware) bytecount(report string) error {
+	values := strings.Split(report, ",")
+	if len(values) != 3 {
+		return errors.New("unexpected bytecount report: " + report)
+	}
+
+	id, err := strconv.Atoi(values[0])
+	if err != nil {
+		return errors.Wrap(err, "unexpected client id in bytecount report")
+	}
+	bytesIn, err := strconv.ParseUint(values[1], 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "unexpected bytes in of bytecount report")
+	}
+	bytesOut, err := strconv.ParseUint(values[2], 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "unexpected bytes out of bytecount report")
+	}
+
+	m.lock.Lock()
+	_, ok := m.clients[id]
+	recorder := m.recorder
+	m.lock.Unlock()
+
+	if ok {
+		recorder.Update(strconv.Itoa(id), service.SessionStats{BytesIn: bytesIn, BytesOut: bytesOut})
+	}
+	return nil
+}
+
+// parseClientID parses the client id, which is followed by other arguments in some lines
+func parseClientID(arguments string) (int, error) {
+	id, err := strconv.Atoi(strings.SplitN(arguments, ",", 2)[0])
+	return id, errors.Wrap(err, "unexpected client id")
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sessionstats
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/management"
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/stretchr/testify/assert"
+)
+
+type storageFake struct {
+	records map[string]service.SessionRecord
+}
+
+func (sf *storageFake) Store(_ string, data interface{}) error {
+	record := data.(*service.SessionRecord)
+	sf.records[record.ID] = *record
+	return nil
+}
+
+func (sf *storageFake) GetAllFrom(_ string, _ interface{}) error { return nil }
+func (sf *storageFake) Delete(_ string, _ interface{}) error     { return nil }
+
+func consumerOf(sessionID string) string {
+	if sessionID == "session-1" {
+		return "0x1"
+	}
+	return ""
+}
+
+// commandWriterFake passes commands to the test, so that commands sent in the background can be awaited
+type commandWriterFake struct {
+	*management.MockConnection
+	commands chan string
+}
+
+func newCommandWriterFake() *commandWriterFake {
+	return &commandWriterFake{
+		MockConnection: &management.MockConnection{CommandResult: "SUCCESS"},
+		commands:       make(chan string, 10),
+	}
+}
+
+func (cwf *commandWriterFake) SingleLineCommand(template string, args ...interface{}) (string, error) {
+	cwf.commands <- fmt.Sprintf(template, args...)
+	return "SUCCESS", nil
+}
+
+func (cwf *commandWriterFake) waitForCommand(t *testing.T) string {
+	select {
+	case command := <-cwf.commands:
+		return command
+	case <-time.After(time.Second):
+		assert.FailNow(t, "Command expected to be sent")
+		return ""
+	}
+}
+
+// clientKillerFake disconnects peers by killing their clients, as openvpn service does
+type clientKillerFake struct {
+	middleware *Middleware
+}
+
+func (ckf *clientKillerFake) DisconnectPeer(peer string) error {
+	id, err := strconv.Atoi(peer)
+	if err != nil {
+		return err
+	}
+	return ckf.middleware.KillClient(id)
+}
+
+type quotaOptionsFake struct{}
+
+func (quotaOptionsFake) SessionLimits() service.OptionsLimits {
+	return service.OptionsLimits{SessionQuota: 1000 * datasize.Byte}
+}
+
+func feedLines(middleware *Middleware, lines ...string) {
+	for _, line := range lines {
+		middleware.ConsumeLine(line)
+	}
+}
+
+func TestMiddleware_RecordsClientSessions(t *testing.T) {
+	storage := &storageFake{records: make(map[string]service.SessionRecord)}
+	recorder := service.NewSessionRecorder(storage, nil, service.ID("service"), "openvpn", nil)
+	middleware := NewMiddleware(consumerOf, 5*time.Second)
+	middleware.RecordSessions(recorder)
+
+	connection := &management.MockConnection{CommandResult: "SUCCESS"}
+	assert.NoError(t, middleware.Start(connection))
+	assert.Equal(t, "bytecount 5", connection.LastLine)
+
+	feedLines(middleware,
+		">CLIENT:ESTABLISHED,7",
+		">CLIENT:ENV,username=session-1",
+		">CLIENT:ENV,untrusted_ip=1.2.3.4",
+		">CLIENT:ENV,untrusted_port=50221",
+		">CLIENT:ENV,END",
+	)
+	consumed, err := middleware.ConsumeLine(">BYTECOUNT_CLI:7,100,200")
+	assert.NoError(t, err)
+	assert.True(t, consumed)
+
+	active := recorder.Active()
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, "7", active[0].Peer)
+		assert.Equal(t, "0x1", active[0].ConsumerID)
+		assert.Equal(t, service.SessionStats{BytesIn: 100, BytesOut: 200, PeerEndpoint: "1.2.3.4:50221"}, active[0].SessionStats)
+	}
+
+	feedLines(middleware, ">CLIENT:DISCONNECT,7", ">CLIENT:ENV,END")
+	assert.Empty(t, recorder.Active())
+	record := storage.records[active[0].ID]
+	assert.Equal(t, uint64(200), record.BytesOut)
+	assert.False(t, record.Ended.IsZero())
+}
+
+func TestMiddleware_LeavesClientLinesForOtherMiddlewares(t *testing.T) {
+	middleware := NewMiddleware(consumerOf, time.Second)
+
+	for _, line := range []string{">CLIENT:CONNECT,1,4", ">CLIENT:ESTABLISHED,1", ">CLIENT:ENV,END", ">CLIENT:DISCONNECT,1"} {
+		consumed, err := middleware.ConsumeLine(line)
+		assert.NoError(t, err, line)
+		assert.False(t, consumed, line)
+	}
+}
+
+func TestMiddleware_StopEndsAllSessions(t *testing.T) {
+	storage := &storageFake{records: make(map[string]service.SessionRecord)}
+	recorder := service.NewSessionRecorder(storage, nil, service.ID("service"), "openvpn", nil)
+	middleware := NewMiddleware(consumerOf, time.Second)
+	middleware.RecordSessions(recorder)
+
+	feedLines(middleware, ">CLIENT:ESTABLISHED,1", ">CLIENT:ENV,END", ">CLIENT:ESTABLISHED,2", ">CLIENT:ENV,END")
+	assert.Len(t, recorder.Active(), 2)
+
+	connection := &management.MockConnection{CommandResult: "SUCCESS"}
+	assert.NoError(t, middleware.Stop(connection))
+	assert.Equal(t, "bytecount 0", connection.LastLine)
+	assert.Empty(t, recorder.Active())
+}
+
+func TestMiddleware_KillClient(t *testing.T) {
+	middleware := NewMiddleware(consumerOf, time.Second)
+	assert.Error(t, middleware.KillClient(7))
+
+	connection := newCommandWriterFake()
+	assert.NoError(t, middleware.Start(connection))
+	assert.Equal(t, "bytecount 1", connection.waitForCommand(t))
+	assert.NoError(t, middleware.KillClient(7))
+	assert.Equal(t, "client-kill 7", connection.waitForCommand(t))
+
+	assert.NoError(t, middleware.Stop(connection))
+	assert.Error(t, middleware.KillClient(7))
+}
+
+func TestMiddleware_KillsClientExceedingQuota(t *testing.T) {
+	storage := &storageFake{records: make(map[string]service.SessionRecord)}
+	recorder := service.NewSessionRecorder(storage, nil, service.ID("service"), "openvpn", quotaOptionsFake{})
+	middleware := NewMiddleware(consumerOf, time.Second)
+	middleware.RecordSessions(recorder)
+	recorder.DisconnectBy(&clientKillerFake{middleware: middleware})
+
+	connection := newCommandWriterFake()
+	assert.NoError(t, middleware.Start(connection))
+	assert.Equal(t, "bytecount 1", connection.waitForCommand(t))
+	feedLines(middleware, ">CLIENT:ESTABLISHED,7", ">CLIENT:ENV,END")
+
+	consumed := make(chan struct{})
+	go func() {
+		feedLines(middleware, ">BYTECOUNT_CLI:7,400,400", ">BYTECOUNT_CLI:7,600,600")
+		close(consumed)
+	}()
+	select {
+	case <-consumed:
+	case <-time.After(time.Second):
+		assert.FailNow(t, "Traffic reports expected to be consumed")
+	}
+
+	assert.Equal(t, "client-kill 7", connection.waitForCommand(t))
+	if active := recorder.Active(); assert.Len(t, active, 1) {
+		assert.True(t, active[0].QuotaExceeded)
+	}
+}
+
+func TestMiddleware_RejectsInvalidBytecount(t *testing.T) {
+	middleware := NewMiddleware(consumerOf, time.Second)
+
+	consumed, err := middleware.ConsumeLine(">BYTECOUNT_CLI:1,abc")
+	assert.True(t, consumed)
+	assert.Error(t, err)
+}
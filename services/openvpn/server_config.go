@@ -18,10 +18,28 @@
 package openvpn
 
 import (
+	"strings"
+
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/config"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/tls"
 )
 
+// DefaultServerDevice is the tunnel interface of openvpn server which is not named after its service instance
+const DefaultServerDevice = "tunsdna0"
+
+// ServerDevice names the tunnel interface of openvpn server after its service instance, all sessions of the server share it.
+// Interface names are limited to 15 characters, so only the beginning of the instance ID is used.
+func ServerDevice(instanceID string) string {
+	name := strings.Replace(instanceID, "-", "", -1)
+	if name == "" {
+		return DefaultServerDevice
+	}
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	return "tunsdna" + name
+}
+
 // ServerConfig defines openvpn in server mode configuration structure
 type ServerConfig struct {
 	*config.GenericConfig
@@ -53,12 +71,14 @@ func (c *ServerConfig) SetProtocol(protocol string) {
 func NewServerConfig(
 	runtimeDir string,
 	configDir string,
+	device string,
 	network, netmask string,
 	secPrimitives *tls.Primitives,
 	port int,
 	protocol string,
 ) *ServerConfig {
 	serverConfig := ServerConfig{config.NewConfig(runtimeDir, configDir)}
+	serverConfig.SetDevice(device)
 	serverConfig.SetServerMode(port, network, netmask)
 	serverConfig.SetTLSServer()
 	serverConfig.SetProtocol(protocol)
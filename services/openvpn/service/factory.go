@@ -20,9 +20,11 @@ package service
 import (
 	"crypto/x509/pkix"
 	"encoding/json"
+	"net"
 
 	log "github.com/cihub/seelog"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn"
+	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/management"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/middlewares/server/auth"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/middlewares/state"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/tls"
@@ -32,8 +34,10 @@ import (
 	"github.com/skytells-research/DNA/network/node/nat"
 	"github.com/skytells-research/DNA/network/node/nat/traversal"
 	openvpn_service "github.com/skytells-research/DNA/network/node/services/openvpn"
+	"github.com/skytells-research/DNA/network/node/services/openvpn/middlewares/server/sessionstats"
 	openvpn_session "github.com/skytells-research/DNA/network/node/services/openvpn/session"
 	"github.com/skytells-research/DNA/network/node/session"
+	"github.com/skytells-research/DNA/network/node/shaper"
 )
 
 // NewManager creates new instance of Openvpn service
@@ -50,34 +54,45 @@ func NewManager(
 ) *Manager {
 	sessionValidator := openvpn_session.NewValidator(sessionMap, identity.NewExtractor())
 
-	serverFactory := newServerFactory(nodeOptions, sessionValidator)
-	if lastSessionShutdown != nil {
-		serverFactory = newRestartingServerFactory(nodeOptions, sessionValidator, natPinger, lastSessionShutdown)
-	}
-
-	return &Manager{
+	manager := &Manager{
+		sessionStats:                   sessionstats.NewMiddleware(sessionValidator.ConsumerID, statsInterval),
 		publicIP:                       location.PubIP,
 		outboundIP:                     location.OutIP,
 		currentLocation:                location.Country,
 		natService:                     natService,
 		sessionConfigNegotiatorFactory: newSessionConfigNegotiatorFactory(nodeOptions.OptionsNetwork, serviceOptions, natEventGetter),
 		vpnServerConfigFactory:         newServerConfigFactory(nodeOptions, serviceOptions),
-		vpnServerFactory:               serverFactory,
 		natPinger:                      natPinger,
 		serviceOptions:                 serviceOptions,
 		mapPort:                        mapPort,
 		natEventGetter:                 natEventGetter,
+		shaper:                         shaper.NewShaper(),
+		sessionShaping:                 serviceOptions.sessionShaping(),
+		device:                         openvpn_service.DefaultServerDevice,
+	}
+
+	manager.vpnServerFactory = newServerFactory(nodeOptions, sessionValidator, manager.sessionStats, manager.serverStateCallback)
+	if lastSessionShutdown != nil {
+		manager.vpnServerFactory = newRestartingServerFactory(nodeOptions, sessionValidator, manager.sessionStats, natPinger, lastSessionShutdown, manager.serverStateCallback)
 	}
+	return manager
+}
+
+// serverNetwork is the subnet which consumers get their addresses from
+var serverNetwork = net.IPNet{
+	IP:   net.ParseIP("10.8.0.0").To4(),
+	Mask: net.IPv4Mask(255, 255, 255, 0),
 }
 
 // newServerConfigFactory returns function generating server config and generates required security primitives
 func newServerConfigFactory(nodeOptions node.Options, serviceOptions Options) ServerConfigFactory {
-	return func(secPrimitives *tls.Primitives) *openvpn_service.ServerConfig {
+	return func(secPrimitives *tls.Primitives, device string) *openvpn_service.ServerConfig {
 		// TODO: check nodeOptions for --openvpn-transport option
 		return openvpn_service.NewServerConfig(
 			nodeOptions.Directories.Runtime,
 			nodeOptions.Directories.Config,
-			"10.8.0.0", "255.255.255.0",
+			device,
+			serverNetwork.IP.String(), net.IP(serverNetwork.Mask).String(),
 			secPrimitives,
 			serviceOptions.Port,
 			serviceOptions.Protocol,
@@ -85,18 +100,20 @@ func newServerConfigFactory(nodeOptions node.Options, serviceOptions Options) Se
 	}
 }
 
-func newServerFactory(nodeOptions node.Options, sessionValidator *openvpn_session.Validator) ServerFactory {
+func newServerFactory(nodeOptions node.Options, sessionValidator *openvpn_session.Validator, sessionStats management.Middleware, stateCallback func(openvpn.State)) ServerFactory {
 	return func(config *openvpn_service.ServerConfig) openvpn.Process {
 		return openvpn.CreateNewProcess(
 			nodeOptions.Openvpn.BinaryPath(),
 			config.GenericConfig,
+			// session statistics are collected before auth middleware consumes client lines
+			sessionStats,
 			auth.NewMiddleware(sessionValidator.Validate),
-			state.NewMiddleware(vpnStateCallback),
+			state.NewMiddleware(stateCallback),
 		)
 	}
 }
 
-func newRestartingServerFactory(nodeOptions node.Options, sessionValidator *openvpn_session.Validator, natPinger NATPinger, lastSessionShutdown chan struct{}) ServerFactory {
+func newRestartingServerFactory(nodeOptions node.Options, sessionValidator *openvpn_session.Validator, sessionStats management.Middleware, natPinger NATPinger, lastSessionShutdown chan struct{}, stateCallback func(openvpn.State)) ServerFactory {
 	return func(config *openvpn_service.ServerConfig) openvpn.Process {
 		return &restartingServer{
 			stop:   make(chan struct{}),
@@ -105,8 +122,9 @@ func newRestartingServerFactory(nodeOptions node.Options, sessionValidator *open
 				return openvpn.CreateNewProcess(
 					nodeOptions.Openvpn.BinaryPath(),
 					config.GenericConfig,
+					sessionStats,
 					auth.NewMiddleware(sessionValidator.Validate),
-					state.NewMiddleware(vpnStateCallback),
+					state.NewMiddleware(stateCallback),
 				)
 			},
 			natPinger:           natPinger,
@@ -127,6 +145,7 @@ func newSessionConfigNegotiatorFactory(networkOptions node.OptionsNetwork, servi
 				RemoteProtocol:  serviceOptions.Protocol,
 				TLSPresharedKey: secPrimitives.PresharedKey.ToPEMFormat(),
 				CACertificate:   secPrimitives.CertificateAuthority.ToPEMFormat(),
+				DNS:             serviceOptions.DNS,
 			},
 		}
 	}
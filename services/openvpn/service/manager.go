@@ -19,24 +19,32 @@ package service
 
 import (
 	"encoding/json"
+	"strconv"
+	"time"
 
 	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn"
 	"github.com/skytells-research/DNA/network/go-openvpn/openvpn/tls"
+	"github.com/skytells-research/DNA/network/node/core/service"
 	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/nat"
 	"github.com/skytells-research/DNA/network/node/nat/traversal"
 	openvpn_service "github.com/skytells-research/DNA/network/node/services/openvpn"
+	"github.com/skytells-research/DNA/network/node/services/openvpn/middlewares/server/sessionstats"
 	"github.com/skytells-research/DNA/network/node/session"
-	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/node/shaper"
 )
 
 const logPrefix = "[service-openvpn] "
 
+// statsInterval is how often openvpn reports traffic of sessions
+const statsInterval = 5 * time.Second
+
 // ServerConfigFactory callback generates session config for remote client
-type ServerConfigFactory func(*tls.Primitives) *openvpn_service.ServerConfig
+type ServerConfigFactory func(primitives *tls.Primitives, device string) *openvpn_service.ServerConfig
 
 // ServerFactory initiates Openvpn server instance during runtime
 type ServerFactory func(*openvpn_service.ServerConfig) openvpn.Process
@@ -79,12 +87,42 @@ type Manager struct {
 	outboundIP      string
 	currentLocation string
 	serviceOptions  Options
+
+	shaper         shaper.Shaper
+	sessionShaping shaper.Limits
+	device         string
+
+	sessionStats *sessionstats.Middleware
+}
+
+// SetInstanceID names the tunnel interface of the server after the service instance, so that servers of several instances don't share it
+func (m *Manager) SetInstanceID(id service.ID) {
+	m.device = openvpn_service.ServerDevice(string(id))
+}
+
+// RecordSessions makes the service account traffic of its sessions by the given recorder
+func (m *Manager) RecordSessions(recorder *service.SessionRecorder) {
+	if m.sessionStats != nil {
+		m.sessionStats.RecordSessions(recorder)
+	}
+}
+
+// DisconnectPeer kills the openvpn client of the session peer
+func (m *Manager) DisconnectPeer(peer string) error {
+	clientID, err := strconv.Atoi(peer)
+	if err != nil {
+		return errors.Wrap(err, "unexpected openvpn client id")
+	}
+	if m.sessionStats == nil {
+		return errors.New("sessions are not recorded")
+	}
+	return m.sessionStats.KillClient(clientID)
 }
 
 // Serve starts service - does block
 func (m *Manager) Serve(providerID identity.Identity) (err error) {
 	err = m.natService.Add(nat.RuleForwarding{
-		SourceAddress: "10.8.0.0/24",
+		SourceAddress: serverNetwork.String(),
 		TargetIP:      m.outboundIP,
 	})
 	if err != nil {
@@ -100,7 +138,7 @@ func (m *Manager) Serve(providerID identity.Identity) (err error) {
 
 	m.vpnServiceConfigProvider = m.sessionConfigNegotiatorFactory(primitives, m.outboundIP, m.publicIP)
 
-	vpnServerConfig := m.vpnServerConfigFactory(primitives)
+	vpnServerConfig := m.vpnServerConfigFactory(primitives, m.device)
 	m.vpnServer = m.vpnServerFactory(vpnServerConfig)
 
 	// block until NATPinger punches the hole in NAT for first incoming connect or continues if service not behind NAT
@@ -133,6 +171,12 @@ func (m *Manager) Stop() (err error) {
 		m.vpnServer.Stop()
 	}
 
+	if !m.sessionShaping.Unlimited() {
+		if err := m.shaper.Clear(m.device); err != nil {
+			log.Error(logPrefix, "Failed to clear session bandwidth shaping: ", err)
+		}
+	}
+
 	return nil
 }
 
@@ -155,6 +199,24 @@ func (m *Manager) ProvideConfig(config json.RawMessage) (session.ServiceConfigur
 	return m.vpnServiceConfigProvider.ProvideConfig(config)
 }
 
+// serverStateCallback shapes sessions every time the tunnel interface of the (re)started server comes up
+func (m *Manager) serverStateCallback(state openvpn.State) {
+	vpnStateCallback(state)
+	if state == openvpn.ConnectedState {
+		m.shapeSessions()
+	}
+}
+
+// shapeSessions limits bandwidth of every consumer address, sessions share the tunnel interface of the server
+func (m *Manager) shapeSessions() {
+	if m.sessionShaping.Unlimited() {
+		return
+	}
+	if err := m.shaper.ShapePerAddress(m.device, serverNetwork, m.sessionShaping); err != nil {
+		log.Error(logPrefix, "Failed to shape session bandwidth: ", err)
+	}
+}
+
 func vpnStateCallback(state openvpn.State) {
 	switch state {
 	case openvpn.ProcessStarted:
@@ -19,15 +19,20 @@ package service
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/shaper"
 	"github.com/urfave/cli"
 )
 
 // Options describes options which are required to start Openvpn service
 type Options struct {
-	Protocol string `json:"protocol"`
-	Port     int    `json:"port"`
+	Protocol string                `json:"protocol"`
+	Port     int                   `json:"port"`
+	DNS      []string              `json:"dns"`
+	Limits   service.OptionsLimits `json:"limits"`
 }
 
 var (
@@ -41,15 +46,49 @@ var (
 		Usage: "Openvpn port to use. Default 1194",
 		Value: defaultOptions.Port,
 	}
+	dnsFlag = cli.StringFlag{
+		Name:  "openvpn.dns",
+		Usage: "Comma separated list of DNS servers advertised to consumers",
+		Value: strings.Join(defaultOptions.DNS, ","),
+	}
+	maxSessionsFlag = cli.IntFlag{
+		Name:  "openvpn.sessions.max",
+		Usage: "Maximum number of concurrent sessions, 0 means no limit",
+	}
+	maxConsumerSessionsFlag = cli.IntFlag{
+		Name:  "openvpn.sessions.max-per-consumer",
+		Usage: "Maximum number of concurrent sessions of a single consumer, 0 means no limit",
+	}
+	bandwidthBudgetFlag = cli.Uint64Flag{
+		Name:  "openvpn.bandwidth.budget",
+		Usage: "Bytes transferred by all sessions after which new sessions are rejected, 0 means no limit",
+	}
+	sessionUploadFlag = cli.Uint64Flag{
+		Name:  "openvpn.session.upload",
+		Usage: "Upload bandwidth of every session in bits per second, 0 means no limit",
+	}
+	sessionDownloadFlag = cli.Uint64Flag{
+		Name:  "openvpn.session.download",
+		Usage: "Download bandwidth of every session in bits per second, 0 means no limit",
+	}
+	sessionQuotaFlag = cli.Uint64Flag{
+		Name:  "openvpn.session.quota",
+		Usage: "Bytes transferred by a session after which it is disconnected, 0 means no limit",
+	}
+	accessPolicyFlag = cli.StringFlag{
+		Name:  "openvpn.access-policy",
+		Usage: "JSON file with allowed and denied consumer identities, it is reloaded once it changes",
+	}
 	defaultOptions = Options{
 		Protocol: "udp",
 		Port:     1194,
+		DNS:      []string{"208.67.222.222", "208.67.220.220"},
 	}
 )
 
 // RegisterFlags function register Openvpn flags to flag list
 func RegisterFlags(flags *[]cli.Flag) {
-	*flags = append(*flags, protocolFlag, portFlag)
+	*flags = append(*flags, protocolFlag, portFlag, dnsFlag, maxSessionsFlag, maxConsumerSessionsFlag, bandwidthBudgetFlag, sessionUploadFlag, sessionDownloadFlag, sessionQuotaFlag, accessPolicyFlag)
 }
 
 // ParseFlags function fills in Openvpn options from CLI context
@@ -57,7 +96,42 @@ func ParseFlags(ctx *cli.Context) service.Options {
 	return Options{
 		Protocol: ctx.String(protocolFlag.Name),
 		Port:     ctx.Int(portFlag.Name),
+		DNS:      splitList(ctx.String(dnsFlag.Name)),
+		Limits: service.OptionsLimits{
+			MaxSessions:            ctx.Int(maxSessionsFlag.Name),
+			MaxSessionsPerConsumer: ctx.Int(maxConsumerSessionsFlag.Name),
+			BandwidthBudget:        datasize.BitSize(ctx.Uint64(bandwidthBudgetFlag.Name)) * datasize.Byte,
+			SessionBandwidth: service.OptionsBandwidth{
+				Upload:   datasize.BitSize(ctx.Uint64(sessionUploadFlag.Name)),
+				Download: datasize.BitSize(ctx.Uint64(sessionDownloadFlag.Name)),
+			},
+			SessionQuota:     datasize.BitSize(ctx.Uint64(sessionQuotaFlag.Name)) * datasize.Byte,
+			AccessPolicyFile: ctx.String(accessPolicyFlag.Name),
+		},
+	}
+}
+
+// SessionLimits returns limits of sessions accepted by the service
+func (o Options) SessionLimits() service.OptionsLimits {
+	return o.Limits
+}
+
+// sessionShaping returns bandwidth limits enforced on every session
+func (o Options) sessionShaping() shaper.Limits {
+	return shaper.Limits{
+		Upload:   o.Limits.SessionBandwidth.Upload,
+		Download: o.Limits.SessionBandwidth.Download,
+	}
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
 
 // ParseJSONOptions function fills in Openvpn options from JSON request
@@ -21,6 +21,8 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/shaper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,5 +46,24 @@ func Test_ParseJSONOptions_ValidRequest(t *testing.T) {
 	options, err := ParseJSONOptions(&request)
 
 	assert.NoError(t, err)
-	assert.Equal(t, Options{"udp", 1123}, options)
+	assert.Equal(t, Options{Protocol: "udp", Port: 1123, DNS: defaultOptions.DNS}, options)
+}
+
+func Test_ParseJSONOptions_Limits(t *testing.T) {
+	request := json.RawMessage(`{"limits": {"maxSessions": 10, "maxSessionsPerConsumer": 2, "bandwidthBudget": 1024}}`)
+	options, err := ParseJSONOptions(&request)
+
+	assert.NoError(t, err)
+	expected := service.OptionsLimits{MaxSessions: 10, MaxSessionsPerConsumer: 2, BandwidthBudget: 1024}
+	assert.Equal(t, expected, options.(service.LimitedOptions).SessionLimits())
+}
+
+func Test_ParseJSONOptions_SessionBandwidth(t *testing.T) {
+	request := json.RawMessage(`{"limits": {"sessionBandwidth": {"upload": 1000000, "download": 8000000}}}`)
+	options, err := ParseJSONOptions(&request)
+
+	assert.NoError(t, err)
+	expected := service.OptionsBandwidth{Upload: 1000000, Download: 8000000}
+	assert.Equal(t, expected, options.(service.LimitedOptions).SessionLimits().SessionBandwidth)
+	assert.Equal(t, shaper.Limits{Upload: 1000000, Download: 8000000}, options.(Options).sessionShaping())
 }
@@ -34,7 +34,7 @@ type SessionMap interface {
 // clientMap extends current sessions with client id metadata from Openvpn
 type clientMap struct {
 	sessions SessionMap
-	// TODO: use clientID to kill OpenVPN session (client-kill {clientID}) when promise processor instructs so
+	// client-kill of sessions exceeding their quota is sent by the session statistics middleware
 	sessionClientIDs map[session.ID]int
 	sessionMapLock   sync.Mutex
 }
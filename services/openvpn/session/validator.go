@@ -67,6 +67,15 @@ func (v *Validator) Validate(clientID int, sessionString, signatureString string
 	return currentSession.ConsumerID == extractedIdentity, nil
 }
 
+// ConsumerID returns consumer of the session, empty if session does not exist
+func (v *Validator) ConsumerID(sessionString string) string {
+	currentSession, found := v.clientMap.sessions.Find(session.ID(sessionString))
+	if !found {
+		return ""
+	}
+	return currentSession.ConsumerID.Address
+}
+
 // Cleanup removes session from underlying session managers
 func (v *Validator) Cleanup(sessionString string) error {
 	sessionID := session.ID(sessionString)
@@ -102,3 +102,15 @@ func TestCleanupReturnsErrorIfSessionNotExists(t *testing.T) {
 
 	assert.Errorf(t, err, "no underlying session exists: nonexistent_session")
 }
+
+func TestConsumerIDReturnsConsumerOfExistingSession(t *testing.T) {
+	validator := mockValidatorWithSession(identityExisting, sessionExisting)
+
+	assert.Equal(t, identityExisting.Address, validator.ConsumerID(sessionExistingString))
+}
+
+func TestConsumerIDReturnsEmptyIfSessionNotExists(t *testing.T) {
+	validator := mockValidator(identity.Identity{})
+
+	assert.Empty(t, validator.ConsumerID("not important"))
+}
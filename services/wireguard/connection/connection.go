@@ -18,19 +18,22 @@
 package connection
 
 import (
+	"context"
 	"encoding/json"
 	"net"
 	"sync"
 	"time"
 
 	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
 	"github.com/skytells-research/DNA/network/node/consumer"
 	"github.com/skytells-research/DNA/network/node/core/connection"
 	"github.com/skytells-research/DNA/network/node/core/location"
+	"github.com/skytells-research/DNA/network/node/dns"
+	"github.com/skytells-research/DNA/network/node/firewall"
 	wg "github.com/skytells-research/DNA/network/node/services/wireguard"
 	endpoint "github.com/skytells-research/DNA/network/node/services/wireguard/endpoint"
 	"github.com/skytells-research/DNA/network/node/services/wireguard/key"
-	"github.com/pkg/errors"
 )
 
 const logPrefix = "[connection-wireguard] "
@@ -45,10 +48,12 @@ type Connection struct {
 
 	config             wg.ServiceConfig
 	connectionEndpoint wg.ConnectionEndpoint
+	dnsConfigurator    dns.Configurator
+	dnsConfigured      bool
 }
 
 // Start establish wireguard connection to the service provider.
-func (c *Connection) Start(options connection.ConnectOptions) (err error) {
+func (c *Connection) Start(ctx context.Context, options connection.ConnectOptions) (err error) {
 	var config wg.ServiceConfig
 	if err := json.Unmarshal(options.SessionConfig, &config); err != nil {
 		return errors.Wrap(err, "failed to unmarshal connection config")
@@ -79,7 +84,16 @@ func (c *Connection) Start(options connection.ConnectOptions) (err error) {
 	// Provider requests to delay consumer connection since it might be in a process of setting up NAT traversal for given consumer
 	if config.Consumer.ConnectDelay > 0 {
 		log.Infof("%s delaying connect for %v milliseconds", logPrefix, config.Consumer.ConnectDelay)
-		time.Sleep(time.Duration(config.Consumer.ConnectDelay) * time.Millisecond)
+		select {
+		case <-time.After(time.Duration(config.Consumer.ConnectDelay) * time.Millisecond):
+		case <-ctx.Done():
+			if err := c.connectionEndpoint.Stop(); err != nil {
+				log.Error(logPrefix, "Failed to close wireguard connection: ", err)
+			}
+			c.stateChannel <- connection.NotConnected
+			c.connection.Done()
+			return ctx.Err()
+		}
 	}
 
 	if err := c.connectionEndpoint.AddPeer(c.config.Provider.PublicKey, &c.config.Provider.Endpoint); err != nil {
@@ -88,18 +102,31 @@ func (c *Connection) Start(options connection.ConnectOptions) (err error) {
 		return errors.Wrap(err, "failed to add peer to the connection endpoint")
 	}
 
-	if err := c.connectionEndpoint.ConfigureRoutes(c.config.Provider.Endpoint.IP); err != nil {
+	if err := c.connectionEndpoint.ConfigureRoutes(c.config.Provider.Endpoint.IP, options.Routes.Include, options.Routes.Exclude); err != nil {
+		if stopErr := c.connectionEndpoint.Stop(); stopErr != nil {
+			log.Error(logPrefix, "Failed to close wireguard connection: ", stopErr)
+		}
 		c.stateChannel <- connection.NotConnected
 		c.connection.Done()
 		return errors.Wrap(err, "failed to configure routes for connection endpoint")
 	}
 
-	if err := c.waitHandshake(); err != nil {
+	if err := c.waitHandshake(ctx); err != nil {
+		// excluded routes are removed by the endpoint, the rest go along with the interface
+		if stopErr := c.connectionEndpoint.Stop(); stopErr != nil {
+			log.Error(logPrefix, "Failed to close wireguard connection: ", stopErr)
+		}
 		c.stateChannel <- connection.NotConnected
 		c.connection.Done()
 		return errors.Wrap(err, "failed while waiting for a peer handshake")
 	}
 
+	if err := c.configureDNS(options.DNSConfigurator, options.DNS, config.Consumer.DNS); err != nil {
+		c.stateChannel <- connection.NotConnected
+		c.connection.Done()
+		return errors.Wrap(err, "failed to configure DNS")
+	}
+
 	go c.runPeriodically(time.Second)
 
 	c.stateChannel <- connection.Connected
@@ -123,11 +150,39 @@ func (c *Connection) GetConfig() (connection.ConsumerConfig, error) {
 	}, nil
 }
 
+// UpdateRoutes adds routes of newly resolved split tunnel destinations and removes the dropped ones.
+func (c *Connection) UpdateRoutes(routes connection.Routes) error {
+	return c.connectionEndpoint.ConfigureRoutes(c.config.Provider.Endpoint.IP, routes.Include, routes.Exclude)
+}
+
+// Tunnel describes wireguard interface and provider endpoint for kill switch.
+func (c *Connection) Tunnel() firewall.Tunnel {
+	return firewall.Tunnel{
+		Interface:  c.connectionEndpoint.InterfaceName(),
+		ProviderIP: c.config.Provider.Endpoint.IP,
+	}
+}
+
+// LastHandshake returns the time of the latest handshake with provider.
+func (c *Connection) LastHandshake() (time.Time, error) {
+	stats, err := c.connectionEndpoint.PeerStats()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stats.LastHandshake, nil
+}
+
 // Stop stops wireguard connection and closes connection endpoint.
 func (c *Connection) Stop() {
 	c.stateChannel <- connection.Disconnecting
 	c.sendStats()
 
+	if c.dnsConfigured {
+		if err := c.dnsConfigurator.Restore(c.connectionEndpoint.InterfaceName()); err != nil {
+			log.Error(logPrefix, "Failed to restore DNS configuration: ", err)
+		}
+	}
+
 	if err := c.connectionEndpoint.Stop(); err != nil {
 		log.Error(logPrefix, "Failed to close wireguard connection: ", err)
 	}
@@ -139,6 +194,24 @@ func (c *Connection) Stop() {
 	close(c.statisticsChannel)
 }
 
+// configureDNS makes queries go to resolvers selected by DNS option through the tunnel, by the configurator of connection manager
+func (c *Connection) configureDNS(configurator dns.Configurator, option connection.DNSOption, providerServers []net.IP) error {
+	servers, err := option.Servers(providerServers)
+	if err != nil || len(servers) == 0 {
+		return err
+	}
+	if configurator == nil {
+		return errors.New("DNS configurator is not given")
+	}
+
+	if err := configurator.Set(c.connectionEndpoint.InterfaceName(), servers); err != nil {
+		return err
+	}
+	c.dnsConfigurator = configurator
+	c.dnsConfigured = true
+	return nil
+}
+
 func (c *Connection) runPeriodically(duration time.Duration) {
 	for {
 		select {
@@ -163,7 +236,7 @@ func (c *Connection) sendStats() {
 	}
 }
 
-func (c *Connection) waitHandshake() error {
+func (c *Connection) waitHandshake(ctx context.Context) error {
 	// We need to send any packet to initialize handshake process
 	_, _ = net.DialTimeout("tcp", "8.8.8.8:53", 100*time.Millisecond)
 	for {
@@ -179,6 +252,8 @@ func (c *Connection) waitHandshake() error {
 
 		case <-c.stopChannel:
 			return errors.New("stop received")
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
@@ -20,6 +20,7 @@ package connection
 import (
 	"github.com/skytells-research/DNA/network/node/core/connection"
 	wg "github.com/skytells-research/DNA/network/node/services/wireguard"
+	endpoint "github.com/skytells-research/DNA/network/node/services/wireguard/endpoint"
 	"github.com/skytells-research/DNA/network/node/services/wireguard/key"
 )
 
@@ -43,6 +44,12 @@ func (f *Factory) Create(stateChannel connection.StateChannel, statisticsChannel
 	}, nil
 }
 
+// Cleanup destroys wireguard interfaces left by crashed process, along with routes through them.
+// It has to be called before any wireguard connection or service is started.
+func (f *Factory) Cleanup() error {
+	return endpoint.CleanAbandonedInterfaces(connectionResourceAllocator())
+}
+
 // NewConnectionCreator creates wireguard connections
 func NewConnectionCreator() connection.Factory {
 	return &Factory{}
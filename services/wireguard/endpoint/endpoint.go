@@ -20,6 +20,7 @@ package endpoint
 import (
 	"fmt"
 	"net"
+	"sync"
 
 	log "github.com/cihub/seelog"
 	"github.com/skytells-research/DNA/network/node/core/location"
@@ -32,7 +33,10 @@ const logPrefix = "[wireguard-connection-endpoint] "
 
 type wgClient interface {
 	ConfigureDevice(name string, config wg.DeviceConfig, subnet net.IPNet) error
-	ConfigureRoutes(iface string, ip net.IP) error
+	AddDefaultRoute(iface string) error
+	AddRoute(iface string, network net.IPNet) error
+	ExcludeRoute(network net.IPNet) error
+	DeleteRoute(network net.IPNet) error
 	DestroyDevice(name string) error
 	AddPeer(name string, peer wg.PeerInfo, allowedIP ...string) error
 	RemovePeer(name string, publicKey string) error
@@ -51,6 +55,10 @@ type connectionEndpoint struct {
 	releasePortMapping func()
 	mapPort            func(port int) (releasePortMapping func())
 	connectDelay       int // connect delay in milliseconds
+	// routes are configured included and excluded networks by their keys
+	routes        map[string]net.IPNet
+	defaultRouted bool
+	routesLock    sync.Mutex
 }
 
 // Start starts and configure wireguard network interface for providing service.
@@ -132,13 +140,108 @@ func (ce *connectionEndpoint) Config() (wg.ServiceConfig, error) {
 	return config, nil
 }
 
-func (ce *connectionEndpoint) ConfigureRoutes(ip net.IP) error {
-	return ce.wgClient.ConfigureRoutes(ce.iface, ip)
+// ConfigureRoutes routes included networks through the tunnel, or all the traffic if none included,
+// except the provider ip and excluded networks. Routes configured by previous calls which are not given anymore are removed.
+func (ce *connectionEndpoint) ConfigureRoutes(ip net.IP, include, exclude []net.IPNet) error {
+	ce.routesLock.Lock()
+	defer ce.routesLock.Unlock()
+
+	if ce.routes == nil {
+		ce.routes = make(map[string]net.IPNet)
+	}
+
+	// provider ip is excluded even if included network covers it, otherwise tunnel would be routed into itself
+	var excluded []net.IPNet
+	for _, network := range append([]net.IPNet{hostNetwork(ip)}, exclude...) {
+		// only IPv4 traffic is routed through the tunnel by default
+		if network.IP.To4() != nil {
+			excluded = append(excluded, network)
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, network := range excluded {
+		wanted[routeKey("exclude", network)] = true
+	}
+	for _, network := range include {
+		wanted[routeKey("include", network)] = true
+	}
+	ce.removeRoutes(wanted)
+
+	for _, network := range excluded {
+		if err := ce.route("exclude", network, ce.wgClient.ExcludeRoute); err != nil {
+			return err
+		}
+	}
+
+	if len(include) == 0 && !ce.defaultRouted {
+		if err := ce.wgClient.AddDefaultRoute(ce.iface); err != nil {
+			return err
+		}
+		ce.defaultRouted = true
+	}
+
+	for _, network := range include {
+		err := ce.route("include", network, func(network net.IPNet) error {
+			return ce.wgClient.AddRoute(ce.iface, network)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hostNetwork(ip net.IP) net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func routeKey(kind string, network net.IPNet) string {
+	return kind + " " + network.String()
+}
+
+// route configures the route once, skipping routes configured by previous calls
+func (ce *connectionEndpoint) route(kind string, network net.IPNet, configure func(net.IPNet) error) error {
+	key := routeKey(kind, network)
+	if _, ok := ce.routes[key]; ok {
+		return nil
+	}
+	if err := configure(network); err != nil {
+		return err
+	}
+	ce.routes[key] = network
+	return nil
 }
 
-// Stop closes wireguard client and destroys wireguard network interface.
+// removeRoutes removes configured routes which are not wanted, failures are only logged.
+// It is called with routes lock held.
+func (ce *connectionEndpoint) removeRoutes(wanted map[string]bool) {
+	for key, network := range ce.routes {
+		if wanted[key] {
+			continue
+		}
+		if err := ce.wgClient.DeleteRoute(network); err != nil {
+			log.Warn(logPrefix, "Failed to remove route ", key, ": ", err)
+		}
+		delete(ce.routes, key)
+	}
+}
+
+// InterfaceName returns the name of wireguard network interface, empty until endpoint is started.
+func (ce *connectionEndpoint) InterfaceName() string {
+	return ce.iface
+}
+
+// Stop removes configured routes, closes wireguard client and destroys wireguard network interface.
 func (ce *connectionEndpoint) Stop() error {
 	ce.releasePortMapping()
+	// excluded routes go via the gateway, so they are not removed together with the interface
+	ce.routesLock.Lock()
+	ce.removeRoutes(nil)
+	ce.routesLock.Unlock()
 
 	if err := ce.wgClient.Close(); err != nil {
 		return err
@@ -155,6 +258,18 @@ func (ce *connectionEndpoint) Stop() error {
 	return ce.resourceAllocator.ReleaseInterface(ce.iface)
 }
 
+// CleanAbandonedInterfaces destroys wireguard interfaces which were not allocated by given allocator, i.e. left by crashed process.
+func CleanAbandonedInterfaces(resourceAllocator *resources.Allocator) error {
+	endpoint, err := NewConnectionEndpoint(location.ServiceLocationInfo{}, resourceAllocator, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	ce := endpoint.(*connectionEndpoint)
+	defer ce.wgClient.Close()
+	return ce.cleanAbandonedInterfaces()
+}
+
 func (ce *connectionEndpoint) cleanAbandonedInterfaces() error {
 	ifaces, err := ce.resourceAllocator.AbandonedInterfaces()
 	if err != nil {
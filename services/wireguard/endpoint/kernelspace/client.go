@@ -106,11 +106,15 @@ func (c *client) PeerStats() (wg.Stats, error) {
 		return wg.Stats{}, errors.New("exactly 1 peer expected")
 	}
 
-	return wg.Stats{
+	stats := wg.Stats{
 		BytesReceived: uint64(d.Peers[0].ReceiveBytes),
 		BytesSent:     uint64(d.Peers[0].TransmitBytes),
 		LastHandshake: d.Peers[0].LastHandshakeTime,
-	}, nil
+	}
+	if d.Peers[0].Endpoint != nil {
+		stats.Endpoint = d.Peers[0].Endpoint.String()
+	}
+	return stats, nil
 }
 
 func (c *client) DestroyDevice(name string) error {
@@ -131,20 +135,25 @@ func (c *client) up(iface string, ipAddr net.IPNet) error {
 	return utils.SudoExec("ip", "link", "set", "dev", iface, "up")
 }
 
-func (c *client) ConfigureRoutes(iface string, ip net.IP) error {
-	if err := excludeRoute(ip); err != nil {
-		return err
-	}
+func (c *client) AddDefaultRoute(iface string) error {
 	return addDefaultRoute(iface)
 }
 
-func excludeRoute(ip net.IP) error {
+func (c *client) AddRoute(iface string, network net.IPNet) error {
+	return utils.SudoExec("ip", "route", "replace", network.String(), "dev", iface)
+}
+
+func (c *client) ExcludeRoute(network net.IPNet) error {
 	gw, err := gateway.DiscoverGateway()
 	if err != nil {
 		return err
 	}
 
-	return utils.SudoExec("ip", "route", "replace", ip.String(), "via", gw.String())
+	return utils.SudoExec("ip", "route", "replace", network.String(), "via", gw.String())
+}
+
+func (c *client) DeleteRoute(network net.IPNet) error {
+	return utils.SudoExec("ip", "route", "del", network.String())
 }
 
 func addDefaultRoute(iface string) error {
@@ -22,10 +22,10 @@ import (
 	"net"
 	"time"
 
+	"github.com/pkg/errors"
 	wg "github.com/skytells-research/DNA/network/node/services/wireguard"
 	"github.com/skytells-research/DNA/network/wireguard-go/device"
 	"github.com/skytells-research/DNA/network/wireguard-go/tun"
-	"github.com/pkg/errors"
 )
 
 type client struct {
@@ -102,13 +102,22 @@ func (c *client) Close() error {
 	return nil
 }
 
-func (c *client) ConfigureRoutes(iface string, ip net.IP) error {
-	if err := excludeRoute(ip); err != nil {
-		return err
-	}
+func (c *client) AddDefaultRoute(iface string) error {
 	return addDefaultRoute(iface)
 }
 
+func (c *client) AddRoute(iface string, network net.IPNet) error {
+	return addRoute(iface, network)
+}
+
+func (c *client) ExcludeRoute(network net.IPNet) error {
+	return excludeNetwork(network)
+}
+
+func (c *client) DeleteRoute(network net.IPNet) error {
+	return deleteRoute(network)
+}
+
 func (c *client) PeerStats() (wg.Stats, error) {
 	peers, err := c.devAPI.Peers()
 	if err != nil {
@@ -119,11 +128,15 @@ func (c *client) PeerStats() (wg.Stats, error) {
 		return wg.Stats{}, errors.New("exactly 1 peer expected")
 	}
 
-	return wg.Stats{
+	stats := wg.Stats{
 		BytesSent:     peers[0].Stats.Sent,
 		BytesReceived: peers[0].Stats.Received,
 		LastHandshake: time.Unix(int64(peers[0].LastHanshake), 0),
-	}, nil
+	}
+	if peers[0].RemoteEndpoint != nil {
+		stats.Endpoint = peers[0].RemoteEndpoint.DstToString()
+	}
+	return stats, nil
 }
 
 func (c *client) DestroyDevice(name string) error {
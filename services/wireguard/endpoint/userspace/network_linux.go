@@ -31,21 +31,29 @@ func assignIP(iface string, subnet net.IPNet) error {
 	return utils.SudoExec("ip", "link", "set", "dev", iface, "up")
 }
 
-func excludeRoute(ip net.IP) error {
-	gw, err := gateway.DiscoverGateway()
-	if err != nil {
+func addDefaultRoute(iface string) error {
+	if err := utils.SudoExec("route", "add", "-net", "0.0.0.0/1", "-interface", iface); err != nil {
 		return err
 	}
 
-	return utils.SudoExec("route", "add", "-host", ip.String(), gw.String())
+	return utils.SudoExec("route", "add", "-net", "128.0.0.0/1", "-interface", iface)
 }
 
-func addDefaultRoute(iface string) error {
-	if err := utils.SudoExec("route", "add", "-net", "0.0.0.0/1", "-interface", iface); err != nil {
+func addRoute(iface string, network net.IPNet) error {
+	return utils.SudoExec("route", "add", "-net", network.String(), "-interface", iface)
+}
+
+func excludeNetwork(network net.IPNet) error {
+	gw, err := gateway.DiscoverGateway()
+	if err != nil {
 		return err
 	}
 
-	return utils.SudoExec("route", "add", "-net", "128.0.0.0/1", "-interface", iface)
+	return utils.SudoExec("route", "add", "-net", network.String(), gw.String())
+}
+
+func deleteRoute(network net.IPNet) error {
+	return utils.SudoExec("route", "delete", "-net", network.String())
 }
 
 func destroyDevice(name string) error {
@@ -39,27 +39,42 @@ func renameInterface(name, newname string) error {
 	return errors.Wrap(err, string(out))
 }
 
-func excludeRoute(ip net.IP) error {
-	gw, err := gateway.DiscoverGateway()
+func addDefaultRoute(name string) error {
+	id, gw, err := interfaceInfo(name)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "failed to get info of interface: "+name)
+	}
+
+	if out, err := exec.Command("powershell", "-Command", "route add 0.0.0.0/1 "+gw+" if "+id).CombinedOutput(); err != nil {
+		return errors.Wrap(err, string(out))
 	}
 
-	out, err := exec.Command("powershell", "-Command", "route add "+ip.String()+"/32 "+gw.String()).CombinedOutput()
+	out, err := exec.Command("powershell", "-Command", "route add 128.0.0.0/1 "+gw+" if "+id).CombinedOutput()
 	return errors.Wrap(err, string(out))
 }
 
-func addDefaultRoute(name string) error {
+func addRoute(name string, network net.IPNet) error {
 	id, gw, err := interfaceInfo(name)
 	if err != nil {
 		return errors.Wrap(err, "failed to get info of interface: "+name)
 	}
 
-	if out, err := exec.Command("powershell", "-Command", "route add 0.0.0.0/1 "+gw+" if "+id).CombinedOutput(); err != nil {
-		return errors.Wrap(err, string(out))
+	out, err := exec.Command("powershell", "-Command", "route add "+network.String()+" "+gw+" if "+id).CombinedOutput()
+	return errors.Wrap(err, string(out))
+}
+
+func excludeNetwork(network net.IPNet) error {
+	gw, err := gateway.DiscoverGateway()
+	if err != nil {
+		return err
 	}
 
-	out, err := exec.Command("powershell", "-Command", "route add 128.0.0.0/1 "+gw+" if "+id).CombinedOutput()
+	out, err := exec.Command("powershell", "-Command", "route add "+network.String()+" "+gw.String()).CombinedOutput()
+	return errors.Wrap(err, string(out))
+}
+
+func deleteRoute(network net.IPNet) error {
+	out, err := exec.Command("powershell", "-Command", "route delete "+network.String()).CombinedOutput()
 	return errors.Wrap(err, string(out))
 }
 
@@ -20,18 +20,24 @@ package service
 import (
 	"encoding/json"
 	"net"
+	"strings"
 
 	log "github.com/cihub/seelog"
 	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/services/wireguard/resources"
+	"github.com/skytells-research/DNA/network/node/shaper"
 	"github.com/urfave/cli"
 )
 
 // Options describes options which are required to start Wireguard service
 type Options struct {
-	ConnectDelay int       `json:"connectDelay"`
-	PortMin      int       `json:"portMin"`
-	PortMax      int       `json:"portMax"`
-	Subnet       net.IPNet `json:"subnet"`
+	ConnectDelay int                   `json:"connectDelay"`
+	PortMin      int                   `json:"portMin"`
+	PortMax      int                   `json:"portMax"`
+	Subnet       net.IPNet             `json:"subnet"`
+	DNS          []string              `json:"dns"`
+	Limits       service.OptionsLimits `json:"limits"`
 }
 
 var (
@@ -55,6 +61,40 @@ var (
 		Usage: "Subnet allowed for using by the wireguard services",
 		Value: DefaultOptions.Subnet.String(),
 	}
+	dnsFlag = cli.StringFlag{
+		Name:  "wireguard.dns",
+		Usage: "Comma separated list of DNS servers advertised to consumers",
+		Value: strings.Join(DefaultOptions.DNS, ","),
+	}
+
+	maxSessionsFlag = cli.IntFlag{
+		Name:  "wireguard.sessions.max",
+		Usage: "Maximum number of concurrent sessions, 0 means no limit",
+	}
+	maxConsumerSessionsFlag = cli.IntFlag{
+		Name:  "wireguard.sessions.max-per-consumer",
+		Usage: "Maximum number of concurrent sessions of a single consumer, 0 means no limit",
+	}
+	bandwidthBudgetFlag = cli.Uint64Flag{
+		Name:  "wireguard.bandwidth.budget",
+		Usage: "Bytes transferred by all sessions after which new sessions are rejected, 0 means no limit",
+	}
+	sessionUploadFlag = cli.Uint64Flag{
+		Name:  "wireguard.session.upload",
+		Usage: "Upload bandwidth of every session in bits per second, 0 means no limit",
+	}
+	sessionDownloadFlag = cli.Uint64Flag{
+		Name:  "wireguard.session.download",
+		Usage: "Download bandwidth of every session in bits per second, 0 means no limit",
+	}
+	sessionQuotaFlag = cli.Uint64Flag{
+		Name:  "wireguard.session.quota",
+		Usage: "Bytes transferred by a session after which it is disconnected, 0 means no limit",
+	}
+	accessPolicyFlag = cli.StringFlag{
+		Name:  "wireguard.access-policy",
+		Usage: "JSON file with allowed and denied consumer identities, it is reloaded once it changes",
+	}
 
 	// DefaultOptions is a wireguard service configuration that will be used if no options provided.
 	DefaultOptions = Options{
@@ -64,12 +104,14 @@ var (
 		Subnet: net.IPNet{
 			IP:   net.ParseIP("10.182.0.0"),
 			Mask: net.IPv4Mask(255, 255, 0, 0),
-		}}
+		},
+		DNS: []string{"208.67.222.222", "208.67.220.220"},
+	}
 )
 
 // RegisterFlags function register Wireguard flags to flag list
 func RegisterFlags(flags *[]cli.Flag) {
-	*flags = append(*flags, delayFlag, portMin, portMax, subnet)
+	*flags = append(*flags, delayFlag, portMin, portMax, subnet, dnsFlag, maxSessionsFlag, maxConsumerSessionsFlag, bandwidthBudgetFlag, sessionUploadFlag, sessionDownloadFlag, sessionQuotaFlag, accessPolicyFlag)
 }
 
 // ParseFlags function fills in Wireguard options from CLI context
@@ -85,7 +127,61 @@ func ParseFlags(ctx *cli.Context) service.Options {
 		PortMin:      ctx.Int(portMin.Name),
 		PortMax:      ctx.Int(portMax.Name),
 		Subnet:       *ipnet,
+		DNS:          splitList(ctx.String(dnsFlag.Name)),
+		Limits: service.OptionsLimits{
+			MaxSessions:            ctx.Int(maxSessionsFlag.Name),
+			MaxSessionsPerConsumer: ctx.Int(maxConsumerSessionsFlag.Name),
+			BandwidthBudget:        datasize.BitSize(ctx.Uint64(bandwidthBudgetFlag.Name)) * datasize.Byte,
+			SessionBandwidth: service.OptionsBandwidth{
+				Upload:   datasize.BitSize(ctx.Uint64(sessionUploadFlag.Name)),
+				Download: datasize.BitSize(ctx.Uint64(sessionDownloadFlag.Name)),
+			},
+			SessionQuota:     datasize.BitSize(ctx.Uint64(sessionQuotaFlag.Name)) * datasize.Byte,
+			AccessPolicyFile: ctx.String(accessPolicyFlag.Name),
+		},
+	}
+}
+
+// SessionLimits returns limits of sessions accepted by the service,
+// number of sessions never exceeds number of wireguard resources available
+func (o Options) SessionLimits() service.OptionsLimits {
+	limits := o.Limits
+	if limits.MaxSessions <= 0 || limits.MaxSessions > resources.MaxResources {
+		limits.MaxSessions = resources.MaxResources
 	}
+	return limits
+}
+
+// sessionShaping returns bandwidth limits enforced on every session
+func (o Options) sessionShaping() shaper.Limits {
+	return shaper.Limits{
+		Upload:   o.Limits.SessionBandwidth.Upload,
+		Download: o.Limits.SessionBandwidth.Download,
+	}
+}
+
+// parseDNS parses DNS servers advertised to consumers, invalid ones are skipped
+func parseDNS(servers []string) []net.IP {
+	var ips []net.IP
+	for _, server := range servers {
+		ip := net.ParseIP(server)
+		if ip == nil {
+			log.Warn(logPrefix, "Skipping invalid DNS server: ", server)
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // ParseJSONOptions function fills in Openvpn options from JSON request
@@ -102,25 +198,31 @@ func ParseJSONOptions(request *json.RawMessage) (service.Options, error) {
 // MarshalJSON implements json.Marshaler interface to provide human readable configuration.
 func (o Options) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		ConnectDelay int    `json:"connectDelay"`
-		PortMin      int    `json:"portMin"`
-		PortMax      int    `json:"portMax"`
-		Subnet       string `json:"subnet"`
+		ConnectDelay int                   `json:"connectDelay"`
+		PortMin      int                   `json:"portMin"`
+		PortMax      int                   `json:"portMax"`
+		Subnet       string                `json:"subnet"`
+		DNS          []string              `json:"dns"`
+		Limits       service.OptionsLimits `json:"limits"`
 	}{
 		ConnectDelay: o.ConnectDelay,
 		PortMin:      o.PortMin,
 		PortMax:      o.PortMax,
 		Subnet:       o.Subnet.String(),
+		DNS:          o.DNS,
+		Limits:       o.Limits,
 	})
 }
 
 // UnmarshalJSON implements json.Unmarshaler interface to receive human readable configuration.
 func (o *Options) UnmarshalJSON(data []byte) error {
 	var options struct {
-		ConnectDelay int    `json:"connectDelay"`
-		PortMin      int    `json:"portMin"`
-		PortMax      int    `json:"portMax"`
-		Subnet       string `json:"subnet"`
+		ConnectDelay int                    `json:"connectDelay"`
+		PortMin      int                    `json:"portMin"`
+		PortMax      int                    `json:"portMax"`
+		Subnet       string                 `json:"subnet"`
+		DNS          []string               `json:"dns"`
+		Limits       *service.OptionsLimits `json:"limits"`
 	}
 
 	if err := json.Unmarshal(data, &options); err != nil {
@@ -143,6 +245,12 @@ func (o *Options) UnmarshalJSON(data []byte) error {
 		}
 		o.Subnet = *ipnet
 	}
+	if options.DNS != nil {
+		o.DNS = options.DNS
+	}
+	if options.Limits != nil {
+		o.Limits = *options.Limits
+	}
 
 	return nil
 }
@@ -22,6 +22,8 @@ import (
 	"net"
 	"testing"
 
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/services/wireguard/resources"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -53,5 +55,22 @@ func Test_ParseJSONOptions_ValidRequest(t *testing.T) {
 			IP:   net.ParseIP("10.10.0.0").To4(),
 			Mask: net.IPv4Mask(255, 255, 0, 0),
 		},
+		DNS: DefaultOptions.DNS,
 	}, options)
 }
+
+func Test_ParseJSONOptions_Limits(t *testing.T) {
+	request := json.RawMessage(`{"limits": {"maxSessions": 10, "maxSessionsPerConsumer": 2, "bandwidthBudget": 1024}}`)
+	options, err := ParseJSONOptions(&request)
+
+	assert.NoError(t, err)
+	expected := service.OptionsLimits{MaxSessions: 10, MaxSessionsPerConsumer: 2, BandwidthBudget: 1024}
+	assert.Equal(t, expected, options.(service.LimitedOptions).SessionLimits())
+
+	unlimited := DefaultOptions.SessionLimits()
+	assert.Equal(t, resources.MaxResources, unlimited.MaxSessions)
+
+	serialized, err := json.Marshal(options)
+	assert.NoError(t, err)
+	assert.Contains(t, string(serialized), `"limits":{"maxSessions":10,"maxSessionsPerConsumer":2,"bandwidthBudget":1024,"sessionBandwidth":{"upload":0,"download":0},"sessionQuota":0}`)
+}
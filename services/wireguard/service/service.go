@@ -18,6 +18,9 @@
 package service
 
 import (
+	"time"
+
+	"github.com/skytells-research/DNA/network/node/core/service"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/money"
 	wg "github.com/skytells-research/DNA/network/node/services/wireguard"
@@ -25,13 +28,18 @@ import (
 
 const logPrefix = "[service-wireguard] "
 
-// GetProposal returns the proposal for wireguard service
-func GetProposal(country string) market.ServiceProposal {
+// statsInterval is how often traffic of sessions is recorded
+const statsInterval = 5 * time.Second
+
+// GetProposal returns the proposal for wireguard service, advertising bandwidth available to every session
+func GetProposal(country string, bandwidth service.OptionsBandwidth) market.ServiceProposal {
 	return market.ServiceProposal{
 		ServiceType: wg.ServiceType,
 		ServiceDefinition: wg.ServiceDefinition{
-			Location:          market.Location{Country: country},
-			LocationOriginate: market.Location{Country: country},
+			Location:               market.Location{Country: country},
+			LocationOriginate:      market.Location{Country: country},
+			SessionBandwidth:       bandwidth.Download,
+			SessionUploadBandwidth: bandwidth.Upload,
 		},
 		PaymentMethodType: wg.PaymentMethod,
 		PaymentMethod: wg.Payment{
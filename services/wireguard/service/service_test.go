@@ -19,15 +19,20 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/money"
 	"github.com/skytells-research/DNA/network/node/nat"
 	wg "github.com/skytells-research/DNA/network/node/services/wireguard"
+	"github.com/skytells-research/DNA/network/node/shaper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -46,8 +51,10 @@ func Test_GetProposal(t *testing.T) {
 		market.ServiceProposal{
 			ServiceType: "wireguard",
 			ServiceDefinition: wg.ServiceDefinition{
-				Location:          market.Location{Country: country},
-				LocationOriginate: market.Location{Country: country},
+				Location:               market.Location{Country: country},
+				LocationOriginate:      market.Location{Country: country},
+				SessionBandwidth:       datasize.MB,
+				SessionUploadBandwidth: 512 * datasize.KB,
 			},
 			PaymentMethodType: "WG",
 			PaymentMethod: wg.Payment{
@@ -57,11 +64,13 @@ func Test_GetProposal(t *testing.T) {
 				},
 			},
 		},
-		GetProposal(country),
+		GetProposal(country, service.OptionsBandwidth{Upload: 512 * datasize.KB, Download: datasize.MB}),
 	)
 }
 
 func Test_Manager_Serve(t *testing.T) {
+	firewallRules := firewall.NewFakeInboundRules()
+	firewall.SetInboundRules(firewallRules)
 	manager := newManagerStub(pubIP, outIP, country)
 
 	go func() {
@@ -69,9 +78,86 @@ func Test_Manager_Serve(t *testing.T) {
 		assert.NoError(t, err)
 	}()
 
-	sessionConfig, _, err := manager.ProvideConfig(json.RawMessage(`{"PublicKey": "gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="}`))
+	sessionConfig, destroy, err := manager.ProvideConfig(json.RawMessage(`{"PublicKey": "gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="}`))
 	assert.NoError(t, err)
 	assert.NotNil(t, sessionConfig)
+	assert.Equal(t, []firewall.InboundRule{{Protocol: "udp", Port: 52820}}, firewallRules.Rules())
+
+	destroy()
+	assert.Empty(t, firewallRules.Rules())
+}
+
+func Test_Manager_ProvideConfig_ShapesSession(t *testing.T) {
+	firewall.SetInboundRules(firewall.NewFakeInboundRules())
+	limits := shaper.Limits{Upload: 512 * datasize.KB, Download: datasize.MB}
+	sessionShaper := &shaperFake{shaped: make(map[string]shaper.Limits)}
+	manager := newManagerStub(pubIP, outIP, country)
+	manager.shaper = sessionShaper
+	manager.sessionShaping = limits
+
+	_, destroy, err := manager.ProvideConfig(json.RawMessage(`{"PublicKey": "gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]shaper.Limits{"wg0": limits}, sessionShaper.shaped)
+
+	destroy()
+	assert.Empty(t, sessionShaper.shaped)
+}
+
+func Test_Manager_ProvideConfig_UndoesStepsOnFailure(t *testing.T) {
+	inboundRules := firewall.NewFakeInboundRules()
+	firewall.SetInboundRules(inboundRules)
+	sessionShaper := &shaperFake{shaped: make(map[string]shaper.Limits)}
+	endpoint := &mockConnectionEndpoint{}
+	manager := newManagerStub(pubIP, outIP, country)
+	manager.natService = &serviceFake{addErr: errors.New("NAT failed")}
+	manager.shaper = sessionShaper
+	manager.sessionShaping = shaper.Limits{Download: datasize.MB}
+	manager.connectionEndpointFactory = func() (wg.ConnectionEndpoint, error) {
+		return endpoint, nil
+	}
+
+	_, _, err := manager.ProvideConfig(json.RawMessage(`{"PublicKey": "gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="}`))
+	assert.Error(t, err)
+	assert.Empty(t, inboundRules.Rules())
+	assert.Empty(t, sessionShaper.shaped)
+	assert.True(t, endpoint.stopped)
+	assert.False(t, manager.connected("gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="))
+}
+
+func Test_Manager_ProvideConfig_RecordsSession(t *testing.T) {
+	firewall.SetInboundRules(firewall.NewFakeInboundRules())
+	storage := &sessionStorageFake{}
+	manager := newManagerStub(pubIP, outIP, country)
+	manager.RecordSessions(service.NewSessionRecorder(storage, nil, service.ID("service"), wg.ServiceType, nil))
+
+	_, destroy, err := manager.ProvideConsumerConfig(identity.FromAddress("0x1"), json.RawMessage(`{"PublicKey": "gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="}`))
+	assert.NoError(t, err)
+	if assert.Len(t, manager.sessions.Active(), 1) {
+		assert.Equal(t, "0x1", manager.sessions.Active()[0].ConsumerID)
+	}
+
+	destroy()
+	assert.Empty(t, manager.sessions.Active())
+	if assert.NotNil(t, storage.last) {
+		assert.Equal(t, "gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk=", storage.last.Peer)
+		assert.Equal(t, "0x1", storage.last.ConsumerID)
+		assert.Equal(t, uint64(10), storage.last.BytesIn)
+		assert.Equal(t, uint64(20), storage.last.BytesOut)
+		assert.Equal(t, "1.2.3.4:51820", storage.last.PeerEndpoint)
+		assert.False(t, storage.last.Ended.IsZero())
+	}
+}
+
+func Test_Manager_DisconnectPeer(t *testing.T) {
+	firewall.SetInboundRules(firewall.NewFakeInboundRules())
+	manager := newManagerStub(pubIP, outIP, country)
+
+	_, destroy, err := manager.ProvideConfig(json.RawMessage(`{"PublicKey": "gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="}`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, manager.DisconnectPeer("gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="))
+	assert.Error(t, manager.DisconnectPeer("gZfkZArbw9lqfl4Yzr1Kv3nqGlhe/ynH9KKRbzPFMGk="))
+	destroy()
 }
 
 func Test_Manager_Stop(t *testing.T) {
@@ -92,16 +178,26 @@ func waitABit() {
 	time.Sleep(10 * time.Millisecond)
 }
 
-type mockConnectionEndpoint struct{}
+type mockConnectionEndpoint struct {
+	stopped bool
+}
 
-func (mce *mockConnectionEndpoint) Stop() error                                         { return nil }
+func (mce *mockConnectionEndpoint) Stop() error {
+	mce.stopped = true
+	return nil
+}
 func (mce *mockConnectionEndpoint) Start(_ *wg.ServiceConfig) error                     { return nil }
-func (mce *mockConnectionEndpoint) Config() (wg.ServiceConfig, error)                   { return wg.ServiceConfig{}, nil }
 func (mce *mockConnectionEndpoint) AddPeer(_ string, _ *net.UDPAddr, _ ...string) error { return nil }
 func (mce *mockConnectionEndpoint) RemovePeer(_ string) error                           { return nil }
-func (mce *mockConnectionEndpoint) ConfigureRoutes(_ net.IP) error                      { return nil }
+func (mce *mockConnectionEndpoint) ConfigureRoutes(_ net.IP, _, _ []net.IPNet) error    { return nil }
+func (mce *mockConnectionEndpoint) InterfaceName() string                               { return "wg0" }
+func (mce *mockConnectionEndpoint) Config() (wg.ServiceConfig, error) {
+	var config wg.ServiceConfig
+	config.Provider.Endpoint.Port = 52820
+	return config, nil
+}
 func (mce *mockConnectionEndpoint) PeerStats() (wg.Stats, error) {
-	return wg.Stats{LastHandshake: time.Now()}, nil
+	return wg.Stats{BytesReceived: 10, BytesSent: 20, LastHandshake: time.Now(), Endpoint: "1.2.3.4:51820"}, nil
 }
 
 func newManagerStub(pub, out, country string) *Manager {
@@ -116,9 +212,42 @@ func newManagerStub(pub, out, country string) *Manager {
 	}
 }
 
-type serviceFake struct{}
+type serviceFake struct {
+	addErr error
+}
 
-func (service *serviceFake) Add(rule nat.RuleForwarding) error { return nil }
+func (service *serviceFake) Add(rule nat.RuleForwarding) error { return service.addErr }
 func (service *serviceFake) Del(rule nat.RuleForwarding) error { return nil }
 func (service *serviceFake) Enable() error                     { return nil }
 func (service *serviceFake) Disable() error                    { return nil }
+
+type shaperFake struct {
+	shaped map[string]shaper.Limits
+}
+
+func (sf *shaperFake) Shape(iface string, limits shaper.Limits) error {
+	sf.shaped[iface] = limits
+	return nil
+}
+
+func (sf *shaperFake) ShapePerAddress(iface string, _ net.IPNet, limits shaper.Limits) error {
+	sf.shaped[iface] = limits
+	return nil
+}
+
+func (sf *shaperFake) Clear(iface string) error {
+	delete(sf.shaped, iface)
+	return nil
+}
+
+type sessionStorageFake struct {
+	last *service.SessionRecord
+}
+
+func (ssf *sessionStorageFake) Store(_ string, data interface{}) error {
+	ssf.last = data.(*service.SessionRecord)
+	return nil
+}
+
+func (ssf *sessionStorageFake) GetAllFrom(_ string, _ interface{}) error { return nil }
+func (ssf *sessionStorageFake) Delete(_ string, _ interface{}) error     { return nil }
@@ -1,4 +1,5 @@
-//+build !windows
+//go:build !windows
+// +build !windows
 
 /*
  * Copyright (C) 2019 2019 Skytells, Inc.
@@ -21,17 +22,22 @@ package service
 
 import (
 	"encoding/json"
+	"net"
 	"sync"
+	"time"
 
 	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
 	"github.com/skytells-research/DNA/network/node/core/location"
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
 	"github.com/skytells-research/DNA/network/node/nat"
 	wg "github.com/skytells-research/DNA/network/node/services/wireguard"
 	"github.com/skytells-research/DNA/network/node/services/wireguard/endpoint"
 	"github.com/skytells-research/DNA/network/node/services/wireguard/resources"
 	"github.com/skytells-research/DNA/network/node/session"
-	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/node/shaper"
 )
 
 // NewManager creates new instance of Wireguard service
@@ -48,6 +54,10 @@ func NewManager(
 		publicIP:        location.PubIP,
 		outboundIP:      location.OutIP,
 		currentLocation: location.Country,
+		dns:             parseDNS(options.DNS),
+
+		shaper:         shaper.NewShaper(),
+		sessionShaping: options.sessionShaping(),
 
 		connectionEndpointFactory: func() (wg.ConnectionEndpoint, error) {
 			return endpoint.NewConnectionEndpoint(location, resourceAllocator, portMap, options.ConnectDelay)
@@ -65,10 +75,30 @@ type Manager struct {
 	publicIP        string
 	outboundIP      string
 	currentLocation string
+	dns             []net.IP
+
+	shaper         shaper.Shaper
+	sessionShaping shaper.Limits
+
+	sessions *service.SessionRecorder
+
+	// peers are connection endpoints of the connected peers by their public keys
+	peers     map[string]wg.ConnectionEndpoint
+	peersLock sync.Mutex
+}
+
+// RecordSessions makes the service account traffic of its sessions by the given recorder
+func (manager *Manager) RecordSessions(recorder *service.SessionRecorder) {
+	manager.sessions = recorder
 }
 
-// ProvideConfig provides the config for consumer
+// ProvideConfig provides the config for unknown consumer
 func (manager *Manager) ProvideConfig(publicKey json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error) {
+	return manager.ProvideConsumerConfig(identity.Identity{}, publicKey)
+}
+
+// ProvideConsumerConfig provides the config for consumer, the session is recorded as session of the consumer
+func (manager *Manager) ProvideConsumerConfig(consumerID identity.Identity, publicKey json.RawMessage) (session.ServiceConfiguration, session.DestroyCallback, error) {
 	key := &wg.ConsumerConfig{}
 	err := json.Unmarshal(publicKey, key)
 	if err != nil {
@@ -80,36 +110,158 @@ func (manager *Manager) ProvideConfig(publicKey json.RawMessage) (session.Servic
 		return nil, nil, err
 	}
 
+	// completed steps are undone in reverse order by destroy, or right away if a later step fails
+	var undo []func()
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	provided := false
+	defer func() {
+		if !provided {
+			rollback()
+		}
+	}()
+
 	if err := connectionEndpoint.Start(nil); err != nil {
 		return nil, nil, err
 	}
+	undo = append(undo, func() {
+		if err := connectionEndpoint.Stop(); err != nil {
+			log.Error(logPrefix, "failed to stop connection endpoint: ", err)
+		}
+	})
 
 	if err := connectionEndpoint.AddPeer(key.PublicKey, nil); err != nil {
 		return nil, nil, err
 	}
 
+	// every session has its own interface, so all of its traffic is shaped
+	iface := connectionEndpoint.InterfaceName()
+	if !manager.sessionShaping.Unlimited() {
+		if err := manager.shaper.Shape(iface, manager.sessionShaping); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to shape session bandwidth")
+		}
+		undo = append(undo, func() {
+			if err := manager.shaper.Clear(iface); err != nil {
+				log.Error(logPrefix, "failed to clear session bandwidth shaping: ", err)
+			}
+		})
+	}
+
 	config, err := connectionEndpoint.Config()
 	if err != nil {
 		return nil, nil, err
 	}
+	config.Consumer.DNS = manager.dns
+
+	if err := firewall.AddInboundRule("UDP", config.Provider.Endpoint.Port); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to add firewall rule")
+	}
+	undo = append(undo, func() {
+		if err := firewall.RemoveInboundRule("UDP", config.Provider.Endpoint.Port); err != nil {
+			log.Error(logPrefix, "failed to delete firewall rule for Wireguard: ", err)
+		}
+	})
 
 	natRule := nat.RuleForwarding{SourceAddress: config.Consumer.IPAddress.String(), TargetIP: manager.outboundIP}
 	if err := manager.natService.Add(natRule); err != nil {
 		return nil, nil, errors.Wrap(err, "failed to add NAT forwarding rule")
 	}
-
-	destroy := func() {
+	undo = append(undo, func() {
 		if err := manager.natService.Del(natRule); err != nil {
 			log.Error(logPrefix, "failed to delete NAT forwarding rule: ", err)
 		}
-		if err := connectionEndpoint.Stop(); err != nil {
-			log.Error(logPrefix, "failed to stop connection endpoint: ", err)
-		}
+	})
+
+	manager.addPeer(key.PublicKey, connectionEndpoint)
+	manager.sessions.Started(key.PublicKey, consumerID.Address)
+	stopStats := make(chan struct{})
+	statsDone := make(chan struct{})
+	go manager.recordStats(key.PublicKey, connectionEndpoint, stopStats, statsDone)
+
+	destroy := func() {
+		close(stopStats)
+		<-statsDone
+		manager.sessions.Ended(key.PublicKey)
+		manager.removePeer(key.PublicKey)
+		rollback()
 	}
 
+	provided = true
 	return config, destroy, nil
 }
 
+// recordStats records traffic of the session peer periodically until stopped, the last traffic is recorded on stop
+func (manager *Manager) recordStats(peer string, connectionEndpoint wg.ConnectionEndpoint, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	record := func() {
+		if !manager.connected(peer) {
+			return
+		}
+		stats, err := connectionEndpoint.PeerStats()
+		if err != nil {
+			log.Warn(logPrefix, "failed to get peer stats: ", err)
+			return
+		}
+		manager.sessions.Update(peer, service.SessionStats{
+			BytesIn:      stats.BytesReceived,
+			BytesOut:     stats.BytesSent,
+			PeerEndpoint: stats.Endpoint,
+		})
+	}
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			record()
+		case <-stop:
+			record()
+			return
+		}
+	}
+}
+
+// DisconnectPeer removes the peer from its interface, so that its session stops transferring data
+func (manager *Manager) DisconnectPeer(peer string) error {
+	connectionEndpoint, ok := manager.removePeer(peer)
+	if !ok {
+		return errors.New("unknown peer: " + peer)
+	}
+	return connectionEndpoint.RemovePeer(peer)
+}
+
+func (manager *Manager) addPeer(peer string, connectionEndpoint wg.ConnectionEndpoint) {
+	manager.peersLock.Lock()
+	defer manager.peersLock.Unlock()
+
+	if manager.peers == nil {
+		manager.peers = make(map[string]wg.ConnectionEndpoint)
+	}
+	manager.peers[peer] = connectionEndpoint
+}
+
+func (manager *Manager) removePeer(peer string) (wg.ConnectionEndpoint, bool) {
+	manager.peersLock.Lock()
+	defer manager.peersLock.Unlock()
+
+	connectionEndpoint, ok := manager.peers[peer]
+	delete(manager.peers, peer)
+	return connectionEndpoint, ok
+}
+
+func (manager *Manager) connected(peer string) bool {
+	manager.peersLock.Lock()
+	defer manager.peersLock.Unlock()
+
+	_, ok := manager.peers[peer]
+	return ok
+}
+
 // Serve starts service - does block
 func (manager *Manager) Serve(providerID identity.Identity) error {
 	manager.wg.Add(1)
@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
 	"github.com/skytells-research/DNA/network/node/core/location"
 	"github.com/skytells-research/DNA/network/node/firewall"
 	"github.com/skytells-research/DNA/network/node/identity"
@@ -30,7 +31,6 @@ import (
 	"github.com/skytells-research/DNA/network/node/services/wireguard/endpoint"
 	"github.com/skytells-research/DNA/network/node/services/wireguard/resources"
 	"github.com/skytells-research/DNA/network/node/session"
-	"github.com/pkg/errors"
 )
 
 // NewManager creates new instance of Wireguard service
@@ -76,6 +76,7 @@ func (manager *Manager) ProvideConfig(publicKey json.RawMessage) (session.Servic
 	if err != nil {
 		return nil, nil, err
 	}
+	config.Consumer.DNS = parseDNS(manager.options.DNS)
 
 	if err := manager.connectionEndpoint.AddPeer(key.PublicKey, nil, config.Consumer.IPAddress.IP.String()+"/32"); err != nil {
 		return nil, nil, err
@@ -19,9 +19,11 @@ package wireguard
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"time"
 
+	"github.com/skytells-research/DNA/network/node/datasize"
 	"github.com/skytells-research/DNA/network/node/market"
 	"github.com/skytells-research/DNA/network/node/money"
 )
@@ -37,6 +39,12 @@ type ServiceDefinition struct {
 	// Approximate information on location where the actual tunnelled traffic will originate from.
 	// This is used by providers having their own means of setting tunnels to other remote exit points.
 	LocationOriginate market.Location `json:"location_originate"`
+
+	// Available per session download bandwidth in bits per second, unlimited if not set
+	SessionBandwidth datasize.BitSize `json:"session_bandwidth,omitempty"`
+
+	// Available per session upload bandwidth in bits per second, unlimited if not set
+	SessionUploadBandwidth datasize.BitSize `json:"session_upload_bandwidth,omitempty"`
 }
 
 // GetLocation returns geographic location of service definition provider
@@ -64,8 +72,9 @@ type ConnectionEndpoint interface {
 	AddPeer(publicKey string, endpoint *net.UDPAddr, allowedIPs ...string) error
 	RemovePeer(publicKey string) error
 	PeerStats() (Stats, error)
-	ConfigureRoutes(ip net.IP) error
+	ConfigureRoutes(ip net.IP, include, exclude []net.IPNet) error
 	Config() (ServiceConfig, error)
+	InterfaceName() string
 	Stop() error
 }
 
@@ -86,6 +95,8 @@ type Stats struct {
 	BytesSent     uint64
 	BytesReceived uint64
 	LastHandshake time.Time
+	// Endpoint is the address of the peer, empty until peer connects
+	Endpoint string
 }
 
 // ConsumerConfig is used for sending the public key from consumer to provider
@@ -108,6 +119,7 @@ type ServiceConfig struct {
 		PrivateKey   string `json:"-"`
 		IPAddress    net.IPNet
 		ConnectDelay int
+		DNS          []net.IP
 	}
 }
 
@@ -118,9 +130,15 @@ func (s ServiceConfig) MarshalJSON() ([]byte, error) {
 		Endpoint  string `json:"endpoint"`
 	}
 	type consumer struct {
-		PrivateKey   string `json:"private_key"`
-		IPAddress    string `json:"ip_address"`
-		ConnectDelay int    `json:"connect_delay"`
+		PrivateKey   string   `json:"private_key"`
+		IPAddress    string   `json:"ip_address"`
+		ConnectDelay int      `json:"connect_delay"`
+		DNS          []string `json:"dns,omitempty"`
+	}
+
+	dns := make([]string, 0, len(s.Consumer.DNS))
+	for _, server := range s.Consumer.DNS {
+		dns = append(dns, server.String())
 	}
 
 	return json.Marshal(&struct {
@@ -134,6 +152,7 @@ func (s ServiceConfig) MarshalJSON() ([]byte, error) {
 		consumer{
 			IPAddress:    s.Consumer.IPAddress.String(),
 			ConnectDelay: s.Consumer.ConnectDelay,
+			DNS:          dns,
 		},
 	})
 }
@@ -145,9 +164,10 @@ func (s *ServiceConfig) UnmarshalJSON(data []byte) error {
 		Endpoint  string `json:"endpoint"`
 	}
 	type consumer struct {
-		PrivateKey   string `json:"private_key"`
-		IPAddress    string `json:"ip_address"`
-		ConnectDelay int    `json:"connect_delay"`
+		PrivateKey   string   `json:"private_key"`
+		IPAddress    string   `json:"ip_address"`
+		ConnectDelay int      `json:"connect_delay"`
+		DNS          []string `json:"dns"`
 	}
 	var config struct {
 		Provider provider `json:"provider"`
@@ -167,11 +187,21 @@ func (s *ServiceConfig) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	var dns []net.IP
+	for _, server := range config.Consumer.DNS {
+		ip := net.ParseIP(server)
+		if ip == nil {
+			return fmt.Errorf("invalid DNS server: %q", server)
+		}
+		dns = append(dns, ip)
+	}
+
 	s.Provider.Endpoint = *endpoint
 	s.Provider.PublicKey = config.Provider.PublicKey
 	s.Consumer.IPAddress = *ipnet
 	s.Consumer.IPAddress.IP = ip
 	s.Consumer.ConnectDelay = config.Consumer.ConnectDelay
+	s.Consumer.DNS = dns
 
 	return nil
 }
@@ -19,6 +19,7 @@ package wireguard
 
 import (
 	"encoding/json"
+	"net"
 	"testing"
 
 	"github.com/skytells-research/DNA/network/node/money"
@@ -101,3 +102,20 @@ func Test_PaymentMethod_Unserialize(t *testing.T) {
 		assert.Equal(t, test.expectedError, err)
 	}
 }
+
+func Test_ServiceConfig_SerializesDNS(t *testing.T) {
+	var config ServiceConfig
+	config.Provider.Endpoint = net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 52820}
+	config.Consumer.IPAddress = net.IPNet{IP: net.ParseIP("10.182.0.2").To4(), Mask: net.CIDRMask(24, 32)}
+	config.Consumer.DNS = []net.IP{net.ParseIP("10.182.0.1")}
+
+	jsonBytes, err := json.Marshal(config)
+	assert.NoError(t, err)
+
+	var model ServiceConfig
+	assert.NoError(t, json.Unmarshal(jsonBytes, &model))
+	assert.Equal(t, config.Consumer.DNS, model.Consumer.DNS)
+
+	err = json.Unmarshal([]byte(`{"provider": {"endpoint": "1.2.3.4:52820"}, "consumer": {"ip_address": "10.182.0.2/24", "dns": ["resolver"]}}`), &model)
+	assert.Error(t, err)
+}
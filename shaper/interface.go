@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaper
+
+import (
+	"net"
+
+	"github.com/skytells-research/DNA/network/node/datasize"
+)
+
+// Shaper limits bandwidth of sessions on tunnel interfaces of the provider
+type Shaper interface {
+	// Shape limits all the traffic of the interface which carries a single session
+	Shape(iface string, limits Limits) error
+	// ShapePerAddress limits traffic of every consumer address of the subnet, when sessions share the interface
+	ShapePerAddress(iface string, subnet net.IPNet, limits Limits) error
+	// Clear removes limits of the interface
+	Clear(iface string) error
+}
+
+// Limits describes bandwidth available to a session in bits per second, zero values mean no limit
+type Limits struct {
+	// Upload limits traffic sent by consumer
+	Upload datasize.BitSize
+	// Download limits traffic received by consumer
+	Download datasize.BitSize
+}
+
+// Unlimited checks whether limits leave the traffic unrestricted
+func (l Limits) Unlimited() bool {
+	return l.Upload <= 0 && l.Download <= 0
+}
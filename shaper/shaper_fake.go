@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaper
+
+import (
+	"net"
+
+	log "github.com/cihub/seelog"
+)
+
+const logPrefix = "[shaper] "
+
+type fakeShaper struct {
+}
+
+// Shape warns that limits are not enforced
+func (fs *fakeShaper) Shape(iface string, limits Limits) error {
+	fs.warn(iface, limits)
+	return nil
+}
+
+// ShapePerAddress warns that limits are not enforced
+func (fs *fakeShaper) ShapePerAddress(iface string, subnet net.IPNet, limits Limits) error {
+	fs.warn(iface, limits)
+	return nil
+}
+
+// Clear clears limits mock
+func (fs *fakeShaper) Clear(iface string) error {
+	return nil
+}
+
+func (fs *fakeShaper) warn(iface string, limits Limits) {
+	if !limits.Unlimited() {
+		log.Warn(logPrefix, "bandwidth shaping is not supported on this platform, limits of ", iface, " are not enforced")
+	}
+}
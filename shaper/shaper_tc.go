@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/skytells-research/DNA/network/node/utils"
+)
+
+const (
+	tcBinary = "/sbin/tc"
+	// tcMaxAddresses limits size of the subnet shaped per address, every address takes a class and filters
+	tcMaxAddresses = 4096
+	// tcMinBurst allows at least a single full sized packet to pass
+	tcMinBurst = 1600
+)
+
+// tcShaper limits download by HTB classes on the egress of the tunnel interface
+// and upload by policing the ingress of the tunnel interface
+type tcShaper struct{}
+
+// Shape limits all the traffic of the interface
+func (ts tcShaper) Shape(iface string, limits Limits) error {
+	return ts.apply(iface, tcShapeInput(iface, limits))
+}
+
+// ShapePerAddress limits traffic of every address of the subnet separately
+func (ts tcShaper) ShapePerAddress(iface string, subnet net.IPNet, limits Limits) error {
+	input, err := tcShapePerAddressInput(iface, subnet, limits)
+	if err != nil {
+		return err
+	}
+	return ts.apply(iface, input)
+}
+
+// Clear removes qdiscs of the interface, missing interface is not considered an error
+func (ts tcShaper) Clear(iface string) error {
+	output, err := utils.SudoOutput(fmt.Sprintf("%s qdisc show dev %s", tcBinary, iface))
+	if err != nil {
+		if strings.Contains(err.Error(), "Cannot find device") {
+			return nil
+		}
+		return errors.Wrap(err, "failed to list qdiscs of "+iface)
+	}
+
+	for _, qdisc := range tcConfiguredQdiscs(string(output)) {
+		if err := utils.Sudo(fmt.Sprintf("%s qdisc del dev %s %s", tcBinary, iface, qdisc), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply replaces limits of the interface by the given tc batch
+func (ts tcShaper) apply(iface, input string) error {
+	if err := ts.Clear(iface); err != nil {
+		return err
+	}
+	if input == "" {
+		return nil
+	}
+	return errors.Wrap(utils.Sudo(tcBinary+" -batch -", input), "failed to shape "+iface)
+}
+
+// tcConfiguredQdiscs returns parents of qdiscs listed by "tc qdisc show", which are to be deleted.
+// Default root qdisc has zero handle and can not be deleted, so it is skipped.
+func tcConfiguredQdiscs(output string) []string {
+	var qdiscs []string
+	for _, line := range strings.Split(output, "\n") {
+		// i.e. "qdisc htb 1: root refcnt 2 r2q 10 default 0x10 direct_packets_stat 0"
+		// or "qdisc ingress ffff: parent ffff:fff1 ----------------"
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "qdisc" {
+			continue
+		}
+		switch {
+		case fields[1] == "ingress":
+			qdiscs = append(qdiscs, "ingress")
+		case fields[3] == "root" && fields[2] != "0:":
+			qdiscs = append(qdiscs, "root")
+		}
+	}
+	return qdiscs
+}
+
+// tcShapeInput renders tc batch limiting all the traffic of the interface
+func tcShapeInput(iface string, limits Limits) string {
+	var input strings.Builder
+	if limits.Download > 0 {
+		fmt.Fprintf(&input, "qdisc add dev %s root handle 1: htb default 1\n", iface)
+		fmt.Fprintf(&input, "class add dev %s parent 1: classid 1:1 htb rate %s\n", iface, tcRate(limits.Download))
+	}
+	if limits.Upload > 0 {
+		fmt.Fprintf(&input, "qdisc add dev %s handle ffff: ingress\n", iface)
+		fmt.Fprintf(&input, "filter add dev %s parent ffff: protocol all u32 match u32 0 0 %s\n", iface, tcPolice(limits.Upload))
+	}
+	return input.String()
+}
+
+// tcShapePerAddressInput renders tc batch limiting traffic of every host address of the subnet,
+// traffic of other addresses is not limited
+func tcShapePerAddressInput(iface string, subnet net.IPNet, limits Limits) (string, error) {
+	network := subnet.IP.Mask(subnet.Mask).To4()
+	ones, bits := subnet.Mask.Size()
+	if network == nil || bits != 32 {
+		return "", errors.Errorf("only IPv4 subnet can be shaped per address: %s", subnet.String())
+	}
+	addresses := 1 << uint(bits-ones)
+	if addresses > tcMaxAddresses {
+		return "", errors.Errorf("subnet is too large to be shaped per address: %s", subnet.String())
+	}
+
+	var input strings.Builder
+	if limits.Download > 0 {
+		fmt.Fprintf(&input, "qdisc add dev %s root handle 1: htb default 0\n", iface)
+	}
+	if limits.Upload > 0 {
+		fmt.Fprintf(&input, "qdisc add dev %s handle ffff: ingress\n", iface)
+	}
+
+	// network and broadcast addresses are skipped
+	first := binary.BigEndian.Uint32(network)
+	for host := 1; host < addresses-1; host++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, first+uint32(host))
+
+		if limits.Download > 0 {
+			fmt.Fprintf(&input, "class add dev %s parent 1: classid 1:%x htb rate %s\n", iface, host, tcRate(limits.Download))
+			fmt.Fprintf(&input, "filter add dev %s parent 1: protocol ip prio 1 u32 match ip dst %s/32 flowid 1:%x\n", iface, ip, host)
+		}
+		if limits.Upload > 0 {
+			fmt.Fprintf(&input, "filter add dev %s parent ffff: protocol ip prio 1 u32 match ip src %s/32 %s\n", iface, ip, tcPolice(limits.Upload))
+		}
+	}
+	return input.String(), nil
+}
+
+func tcRate(rate datasize.BitSize) string {
+	return fmt.Sprintf("%dbit", rate.Bits())
+}
+
+// tcPolice drops traffic exceeding the rate, burst allows traffic of 100ms at the rate
+func tcPolice(rate datasize.BitSize) string {
+	burst := uint64(rate.Bytes() / 10)
+	if burst < tcMinBurst {
+		burst = tcMinBurst
+	}
+	return fmt.Sprintf("police rate %s burst %d drop flowid :1", tcRate(rate), burst)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaper
+
+import (
+	"net"
+	"testing"
+
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTcShapeInput(t *testing.T) {
+	assert.Equal(
+		t,
+		`qdisc add dev wg0 root handle 1: htb default 1
+class add dev wg0 parent 1: classid 1:1 htb rate 8388608bit
+qdisc add dev wg0 handle ffff: ingress
+filter add dev wg0 parent ffff: protocol all u32 match u32 0 0 police rate 80000bit burst 1600 drop flowid :1
+`,
+		tcShapeInput("wg0", Limits{Upload: 80000 * datasize.Bit, Download: datasize.MB}),
+	)
+
+	assert.Equal(
+		t,
+		`qdisc add dev wg0 root handle 1: htb default 1
+class add dev wg0 parent 1: classid 1:1 htb rate 8388608bit
+`,
+		tcShapeInput("wg0", Limits{Download: datasize.MB}),
+	)
+
+	assert.Empty(t, tcShapeInput("wg0", Limits{}))
+}
+
+func TestTcShapePerAddressInput(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.8.0.0/30")
+	input, err := tcShapePerAddressInput("tun0", *subnet, Limits{Upload: 10 * datasize.MB, Download: datasize.MB})
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		`qdisc add dev tun0 root handle 1: htb default 0
+qdisc add dev tun0 handle ffff: ingress
+class add dev tun0 parent 1: classid 1:1 htb rate 8388608bit
+filter add dev tun0 parent 1: protocol ip prio 1 u32 match ip dst 10.8.0.1/32 flowid 1:1
+filter add dev tun0 parent ffff: protocol ip prio 1 u32 match ip src 10.8.0.1/32 police rate 83886080bit burst 1048576 drop flowid :1
+class add dev tun0 parent 1: classid 1:2 htb rate 8388608bit
+filter add dev tun0 parent 1: protocol ip prio 1 u32 match ip dst 10.8.0.2/32 flowid 1:2
+filter add dev tun0 parent ffff: protocol ip prio 1 u32 match ip src 10.8.0.2/32 police rate 83886080bit burst 1048576 drop flowid :1
+`,
+		input,
+	)
+}
+
+func TestTcShapePerAddressInputRejectsLargeSubnet(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/16")
+	_, err := tcShapePerAddressInput("tun0", *subnet, Limits{Download: datasize.MB})
+	assert.EqualError(t, err, "subnet is too large to be shaped per address: 10.0.0.0/16")
+}
+
+func TestLimitsUnlimited(t *testing.T) {
+	assert.True(t, Limits{}.Unlimited())
+	assert.False(t, Limits{Upload: datasize.KB}.Unlimited())
+	assert.False(t, Limits{Download: datasize.KB}.Unlimited())
+}
+
+func TestTcConfiguredQdiscs(t *testing.T) {
+	assert.Empty(t, tcConfiguredQdiscs("qdisc noqueue 0: root refcnt 2 \n"))
+	assert.Empty(t, tcConfiguredQdiscs(""))
+
+	output := "qdisc htb 1: root refcnt 2 r2q 10 default 0x10 direct_packets_stat 0\n" +
+		"qdisc sfq 10: parent 1:10 limit 127p quantum 1514b depth 127 divisor 1024\n" +
+		"qdisc ingress ffff: parent ffff:fff1 ----------------\n"
+	assert.Equal(t, []string{"root", "ingress"}, tcConfiguredQdiscs(output))
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/tequilapi/utils"
+)
+
+// EnabledDTO marks persisted service as enabled or disabled
+//
+// swagger:model ServiceEnabledDTO
+type EnabledDTO struct {
+	// enabled services are started again after node restart
+	// example: true
+	Enabled bool `json:"enabled"`
+}
+
+// DrainDTO describes how long draining service waits for its sessions to end
+//
+// swagger:model ServiceDrainDTO
+type DrainDTO struct {
+	// duration to wait for sessions to end before service is stopped, empty or zero waits without limit
+	// example: 10m
+	Deadline string `json:"deadline"`
+}
+
+// SessionRecordDTO describes a session served by the provider
+//
+// swagger:model SessionRecordDTO
+type SessionRecordDTO struct {
+	// example: 4cfb0324-daf6-4ad8-448b-e61fe0a1f918
+	ID string `json:"id"`
+	// example: 6ba7b810-9dad-11d1-80b4-00c04fd430c8
+	ServiceID   string `json:"serviceId"`
+	ServiceType string `json:"serviceType"`
+	// wireguard peer public key or openvpn client id
+	Peer       string `json:"peer"`
+	ConsumerID string `json:"consumerId"`
+	// address consumer connects from, empty until it is known
+	PeerEndpoint string `json:"peerEndpoint"`
+	// bytes received from consumer
+	BytesIn uint64 `json:"bytesIn"`
+	// bytes sent to consumer
+	BytesOut uint64 `json:"bytesOut"`
+	// upload limit of the session in bits per second, zero means no limit
+	// example: 10485760
+	UploadLimit uint64 `json:"uploadLimit"`
+	// download limit of the session in bits per second, zero means no limit
+	// example: 10485760
+	DownloadLimit uint64 `json:"downloadLimit"`
+	// bytes the session may transfer in both directions, zero means no limit
+	// example: 1073741824
+	Quota         uint64 `json:"quota"`
+	QuotaExceeded bool   `json:"quotaExceeded"`
+	// example: 2019-06-06T11:04:43.910035Z
+	Started string `json:"started"`
+	// empty while session is active
+	// example: 2019-06-06T11:54:43.910035Z
+	Ended string `json:"ended,omitempty"`
+	// duration in seconds
+	// example: 3000
+	Duration uint64 `json:"duration"`
+}
+
+// SessionHistoryDTO lists sessions served by the provider
+//
+// swagger:model SessionHistoryDTO
+type SessionHistoryDTO struct {
+	Sessions []SessionRecordDTO `json:"sessions"`
+}
+
+// serviceManagement is the part of the service manager exposed through Tequilapi
+type serviceManagement interface {
+	SetEnabled(id service.ID, enabled bool) error
+	Drain(id service.ID, deadline time.Duration) error
+	SessionHistory() ([]service.SessionRecord, error)
+}
+
+type managementEndpoint struct {
+	manager serviceManagement
+}
+
+func newManagementEndpoint(manager serviceManagement) *managementEndpoint {
+	return &managementEndpoint{manager: manager}
+}
+
+// swagger:operation PUT /services/{id}/enabled Service serviceSetEnabled
+// ---
+// summary: Enables or disables persisted service
+// description: Only enabled services are started again after node restart, running service is not started or stopped
+// parameters:
+//   - in: path
+//     name: id
+//     description: Service ID
+//     type: string
+//     required: true
+//   - in: body
+//     name: body
+//     required: true
+//     schema: {$ref: "#/definitions/ServiceEnabledDTO"}
+//
+// responses:
+//
+//	200:
+//	  description: Service is enabled or disabled
+//	  schema:
+//	    "$ref": "#/definitions/ServiceEnabledDTO"
+//	400:
+//	  description: Bad request
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+//	404:
+//	  description: Service not found
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+//	500:
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+func (endpoint *managementEndpoint) SetEnabled(resp http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	var enabled EnabledDTO
+	if err := json.NewDecoder(request.Body).Decode(&enabled); err != nil {
+		utils.SendError(resp, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := endpoint.manager.SetEnabled(service.ID(params.ByName("id")), enabled.Enabled); err != nil {
+		sendManagementError(resp, err)
+		return
+	}
+	utils.WriteAsJSON(enabled, resp)
+}
+
+// swagger:operation PUT /services/{id}/drain Service serviceDrain
+// ---
+// summary: Drains running service
+// description: Service proposal is withdrawn and new sessions are rejected, service is stopped once existing sessions end or the deadline passes
+// parameters:
+//   - in: path
+//     name: id
+//     description: Service ID
+//     type: string
+//     required: true
+//   - in: body
+//     name: body
+//     required: true
+//     schema: {$ref: "#/definitions/ServiceDrainDTO"}
+//
+// responses:
+//
+//	202:
+//	  description: Service is draining
+//	400:
+//	  description: Bad request
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+//	404:
+//	  description: Service not found
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+//	409:
+//	  description: Service is already draining
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+//	500:
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+func (endpoint *managementEndpoint) Drain(resp http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	var drain DrainDTO
+	if err := json.NewDecoder(request.Body).Decode(&drain); err != nil {
+		utils.SendError(resp, err, http.StatusBadRequest)
+		return
+	}
+	var deadline time.Duration
+	if drain.Deadline != "" {
+		var err error
+		if deadline, err = time.ParseDuration(drain.Deadline); err != nil {
+			utils.SendError(resp, err, http.StatusBadRequest)
+			return
+		}
+	}
+	if deadline < 0 {
+		utils.SendError(resp, errors.New("deadline can not be negative"), http.StatusBadRequest)
+		return
+	}
+
+	if err := endpoint.manager.Drain(service.ID(params.ByName("id")), deadline); err != nil {
+		sendManagementError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusAccepted)
+}
+
+// swagger:operation GET /service-sessions/history Service serviceSessionHistory
+// ---
+// summary: Returns sessions served by the provider
+// description: Ended sessions are kept for a limited time, active sessions include their latest statistics
+// responses:
+//
+//	200:
+//	  description: Session history
+//	  schema:
+//	    "$ref": "#/definitions/SessionHistoryDTO"
+//	500:
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/ErrorMessageDTO"
+func (endpoint *managementEndpoint) SessionHistory(resp http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	records, err := endpoint.manager.SessionHistory()
+	if err != nil {
+		utils.SendError(resp, err, http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Started.After(records[j].Started) })
+	history := SessionHistoryDTO{Sessions: make([]SessionRecordDTO, 0, len(records))}
+	for _, record := range records {
+		history.Sessions = append(history.Sessions, toSessionRecordDTO(record))
+	}
+	utils.WriteAsJSON(history, resp)
+}
+
+func toSessionRecordDTO(record service.SessionRecord) SessionRecordDTO {
+	dto := SessionRecordDTO{
+		ID:            record.ID,
+		ServiceID:     string(record.ServiceID),
+		ServiceType:   record.ServiceType,
+		Peer:          record.Peer,
+		ConsumerID:    record.ConsumerID,
+		PeerEndpoint:  record.PeerEndpoint,
+		BytesIn:       record.BytesIn,
+		BytesOut:      record.BytesOut,
+		UploadLimit:   record.Bandwidth.Upload.Bits(),
+		DownloadLimit: record.Bandwidth.Download.Bits(),
+		Quota:         uint64(record.Quota.Bytes()),
+		QuotaExceeded: record.QuotaExceeded,
+		Started:       record.Started.UTC().Format(time.RFC3339),
+		Duration:      uint64(record.Duration().Seconds()),
+	}
+	if !record.Ended.IsZero() {
+		dto.Ended = record.Ended.UTC().Format(time.RFC3339)
+	}
+	return dto
+}
+
+func sendManagementError(resp http.ResponseWriter, err error) {
+	switch err {
+	case service.ErrNoSuchInstance:
+		utils.SendError(resp, err, http.StatusNotFound)
+	case service.ErrAlreadyDraining:
+		utils.SendError(resp, err, http.StatusConflict)
+	default:
+		utils.SendError(resp, err, http.StatusInternalServerError)
+	}
+}
+
+// AddRoutesForServiceManagement adds endpoints managing persisted and running services to given http router
+func AddRoutesForServiceManagement(router *httprouter.Router, manager *service.Manager) {
+	endpoint := newManagementEndpoint(manager)
+
+	router.PUT("/services/:id/enabled", endpoint.SetEnabled)
+	router.PUT("/services/:id/drain", endpoint.Drain)
+	router.GET("/service-sessions/history", endpoint.SessionHistory)
+}
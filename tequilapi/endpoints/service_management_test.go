@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/skytells-research/DNA/network/node/core/service"
+	"github.com/skytells-research/DNA/network/node/datasize"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockServiceManagement struct {
+	enabled  map[service.ID]bool
+	draining map[service.ID]time.Duration
+	sessions []service.SessionRecord
+}
+
+func (m *mockServiceManagement) SetEnabled(id service.ID, enabled bool) error {
+	if _, ok := m.enabled[id]; !ok {
+		return service.ErrNoSuchInstance
+	}
+	m.enabled[id] = enabled
+	return nil
+}
+
+func (m *mockServiceManagement) Drain(id service.ID, deadline time.Duration) error {
+	if _, ok := m.enabled[id]; !ok {
+		return service.ErrNoSuchInstance
+	}
+	if _, ok := m.draining[id]; ok {
+		return service.ErrAlreadyDraining
+	}
+	m.draining[id] = deadline
+	return nil
+}
+
+func (m *mockServiceManagement) SessionHistory() ([]service.SessionRecord, error) {
+	return m.sessions, nil
+}
+
+func serveManagement(endpoint httprouter.Handle, method, body string, id service.ID) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/notimportant", strings.NewReader(body))
+	resp := httptest.NewRecorder()
+	endpoint(resp, req, httprouter.Params{httprouter.Param{Key: "id", Value: string(id)}})
+	return resp
+}
+
+func TestManagementEndpoint_SetEnabled(t *testing.T) {
+	manager := &mockServiceManagement{enabled: map[service.ID]bool{"service-1": true}}
+	endpoint := newManagementEndpoint(manager)
+
+	resp := serveManagement(endpoint.SetEnabled, http.MethodPut, `{"enabled": false}`, "service-1")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"enabled": false}`, resp.Body.String())
+	assert.False(t, manager.enabled["service-1"])
+
+	resp = serveManagement(endpoint.SetEnabled, http.MethodPut, `{"enabled": true}`, "unknown")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = serveManagement(endpoint.SetEnabled, http.MethodPut, `enabled`, "service-1")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.False(t, manager.enabled["service-1"])
+}
+
+func TestManagementEndpoint_Drain(t *testing.T) {
+	manager := &mockServiceManagement{enabled: map[service.ID]bool{"service-1": true}, draining: make(map[service.ID]time.Duration)}
+	endpoint := newManagementEndpoint(manager)
+
+	resp := serveManagement(endpoint.Drain, http.MethodPut, `{"deadline": "-1s"}`, "service-1")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = serveManagement(endpoint.Drain, http.MethodPut, `{"deadline": "600"}`, "service-1")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = serveManagement(endpoint.Drain, http.MethodPut, `{"deadline": "500ms"}`, "service-1")
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+	assert.Equal(t, map[service.ID]time.Duration{"service-1": 500 * time.Millisecond}, manager.draining)
+
+	resp = serveManagement(endpoint.Drain, http.MethodPut, `{"deadline": "10m"}`, "service-1")
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = serveManagement(endpoint.Drain, http.MethodPut, `{}`, "unknown")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestManagementEndpoint_SessionHistory(t *testing.T) {
+	started := time.Date(2019, 6, 6, 11, 0, 0, 0, time.UTC)
+	manager := &mockServiceManagement{sessions: []service.SessionRecord{
+		{
+			ID: "ended", ServiceID: "service-1", ServiceType: "wireguard", Peer: "key", ConsumerID: "0x1",
+			SessionStats:  service.SessionStats{BytesIn: 10, BytesOut: 20, PeerEndpoint: "1.2.3.4:51820"},
+			Bandwidth:     service.OptionsBandwidth{Upload: 10 * datasize.MB, Download: 20 * datasize.MB},
+			Quota:         datasize.GB,
+			QuotaExceeded: true,
+			Started:       started, Updated: started.Add(time.Minute), Ended: started.Add(time.Minute),
+		},
+		{
+			ID: "active", ServiceID: "service-1", ServiceType: "wireguard", Peer: "other-key", ConsumerID: "0x2",
+			Started: started.Add(time.Hour), Updated: started.Add(time.Hour + time.Second),
+		},
+	}}
+	endpoint := newManagementEndpoint(manager)
+
+	resp := serveManagement(endpoint.SessionHistory, http.MethodGet, "", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(
+		t,
+		`{
+			"sessions": [
+				{
+					"id": "active", "serviceId": "service-1", "serviceType": "wireguard", "peer": "other-key", "consumerId": "0x2",
+					"peerEndpoint": "", "bytesIn": 0, "bytesOut": 0,
+					"uploadLimit": 0, "downloadLimit": 0, "quota": 0, "quotaExceeded": false,
+					"started": "2019-06-06T12:00:00Z", "duration": 1
+				},
+				{
+					"id": "ended", "serviceId": "service-1", "serviceType": "wireguard", "peer": "key", "consumerId": "0x1",
+					"peerEndpoint": "1.2.3.4:51820", "bytesIn": 10, "bytesOut": 20,
+					"uploadLimit": 83886080, "downloadLimit": 167772160, "quota": 1073741824, "quotaExceeded": true,
+					"started": "2019-06-06T11:00:00Z", "ended": "2019-06-06T11:01:00Z", "duration": 60
+				}
+			]
+		}`,
+		resp.Body.String(),
+	)
+}
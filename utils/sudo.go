@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2019 Skytells, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Sudo runs the command with sudo, non empty input is passed to its standard input.
+// Output of the failed command is attached to the error.
+func Sudo(arguments, input string) error {
+	cmd := SplitCommand("sudo", arguments)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// SudoOutput runs the command with sudo and returns its output, error output of the failed command is attached to the error
+func SudoOutput(arguments string) ([]byte, error) {
+	output, err := SplitCommand("sudo", arguments).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, errors.Wrap(err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return output, err
+}